@@ -0,0 +1,241 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nnk/97-aic/backend/logger"
+	"github.com/nnk/97-aic/backend/provider"
+	"github.com/nnk/97-aic/backend/storage"
+	"github.com/nnk/97-aic/backend/tokenizer"
+)
+
+// defaultMaxSteps сколько раз модель может вызвать инструменты до принудительного ответа
+const defaultMaxSteps = 6
+
+// Agent цикл «модель → вызов инструмента → результат → модель» с ограничением шагов
+type Agent struct {
+	Tools    *Registry
+	Storage  storage.Store
+	MaxSteps int
+}
+
+// New создает агента
+func New(tools *Registry, store storage.Store, maxSteps int) *Agent {
+	if maxSteps <= 0 {
+		maxSteps = defaultMaxSteps
+	}
+	return &Agent{
+		Tools:    tools,
+		Storage:  store,
+		MaxSteps: maxSteps,
+	}
+}
+
+// RunRequest параметры запуска агента
+type RunRequest struct {
+	RunID        string
+	SessionID    string
+	Message      string
+	SystemPrompt string
+	History      []provider.Message
+	Tools        []string // подмножество инструментов (пусто — все)
+	MaxSteps     int      // не больше Agent.MaxSteps (0 — Agent.MaxSteps)
+	MaxTokens    int
+	Temperature  float64
+	Tokenizer    tokenizer.Tokenizer // для оценки токенов, если провайдер не вернул usage
+}
+
+// События запуска, которые получает Emitter
+const (
+	EventStep       = "step"        // StepEvent
+	EventContent    = "content"     // фрагмент текста ответа (string)
+	EventToolCall   = "tool_call"   // ToolCallEvent
+	EventToolResult = "tool_result" // ToolResultEvent
+)
+
+// Emitter получает события запуска: step, content, tool_call, tool_result
+type Emitter func(event string, data interface{})
+
+// StepEvent начало шага
+type StepEvent struct {
+	Step int `json:"step"`
+}
+
+// ToolCallEvent фрагмент вызова инструмента на шаге
+type ToolCallEvent struct {
+	Step int `json:"step"`
+	provider.ToolCallDelta
+}
+
+// ToolResultEvent результат выполнения инструмента
+type ToolResultEvent struct {
+	Step       int    `json:"step"`
+	ToolCallID string `json:"tool_call_id"`
+	Name       string `json:"name"`
+	Content    string `json:"content,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// RunResult итог запуска агента
+type RunResult struct {
+	RunID        string         `json:"run_id"`
+	Steps        int            `json:"steps"`
+	ToolCalls    int            `json:"tool_calls"`
+	Content      string         `json:"content"`
+	FinishReason string         `json:"finish_reason,omitempty"`
+	Usage        provider.Usage `json:"usage"`
+	StepLimitHit bool           `json:"step_limit_hit,omitempty"` // ответ получен принудительно без инструментов
+}
+
+// addUsage суммирует usage шага; источник — наименее точный из источников шагов
+func (r *RunResult) addUsage(u provider.Usage) {
+	r.Usage.InputTokens += u.InputTokens
+	r.Usage.OutputTokens += u.OutputTokens
+	r.Usage.TotalTokens += u.TotalTokens
+	switch {
+	case r.Usage.Source == "" || u.Source == provider.UsageSourceEstimate:
+		r.Usage.Source = u.Source
+	case u.Source == provider.UsageSourceTokenizer && r.Usage.Source == provider.UsageSourceProvider:
+		r.Usage.Source = u.Source
+	}
+}
+
+// Run выполняет цикл агента. На каждом шаге модель получает историю с результатами
+// предыдущих инструментов; когда шаги заканчиваются, модель отвечает без инструментов.
+// Шаги сохраняются в agent_steps. Частичный результат возвращается и при ошибке.
+func (a *Agent) Run(ctx context.Context, p provider.Provider, req RunRequest, emit Emitter) (*RunResult, error) {
+	defs, err := a.Tools.Definitions(req.Tools)
+	if err != nil {
+		return nil, err
+	}
+
+	maxSteps := a.MaxSteps
+	if req.MaxSteps > 0 && req.MaxSteps < maxSteps {
+		maxSteps = req.MaxSteps
+	}
+
+	history := append([]provider.Message(nil), req.History...)
+	history = append(history, provider.Message{Role: "user", Content: req.Message})
+
+	res := &RunResult{RunID: req.RunID}
+	env := Env{SessionID: req.SessionID}
+
+	for step := 1; ; step++ {
+		final := step > maxSteps
+		emit(EventStep, StepEvent{Step: step})
+
+		var content strings.Builder
+		opts := &provider.ChatOptions{
+			SystemPrompt: req.SystemPrompt,
+			History:      history,
+			MaxTokens:    req.MaxTokens,
+			Temperature:  req.Temperature,
+			Tools:        defs,
+			OnToolCallDelta: func(d provider.ToolCallDelta) error {
+				emit(EventToolCall, ToolCallEvent{Step: step, ToolCallDelta: d})
+				return nil
+			},
+		}
+		if final {
+			opts.ToolChoice = "none"
+		}
+
+		startTime := time.Now()
+		estimatedInput := provider.CountTokensForMessages(req.Tokenizer, req.SystemPrompt, history, "")
+		result, err := p.Chat(ctx, "", opts, func(chunk string) error {
+			content.WriteString(chunk)
+			emit(EventContent, chunk)
+			return nil
+		})
+		res.addUsage(provider.ResolveUsage(result, req.Tokenizer, estimatedInput, content.String()))
+		res.Steps = step
+
+		modelStep := &storage.AgentStep{
+			Kind:       storage.AgentStepModel,
+			Content:    content.String(),
+			DurationMs: time.Since(startTime).Milliseconds(),
+		}
+		if err != nil {
+			modelStep.Error = err.Error()
+			a.saveStep(req, step, modelStep)
+			res.Content = content.String()
+			return res, err
+		}
+		a.saveStep(req, step, modelStep)
+
+		res.FinishReason = result.FinishReason
+		if final || len(result.ToolCalls) == 0 {
+			res.Content = content.String()
+			res.StepLimitHit = final
+			return res, nil
+		}
+
+		history = append(history, provider.Message{
+			Role:      provider.RoleAssistant,
+			Content:   content.String(),
+			ToolCalls: result.ToolCalls,
+		})
+
+		for _, call := range result.ToolCalls {
+			toolStart := time.Now()
+			out, execErr := a.Tools.Execute(ctx, env, call)
+			ev := ToolResultEvent{
+				Step:       step,
+				ToolCallID: call.ID,
+				Name:       call.Name,
+				Content:    out,
+				DurationMs: time.Since(toolStart).Milliseconds(),
+			}
+			if execErr != nil {
+				ev.Error = execErr.Error()
+				// Ошибку отдаем модели как результат: она может исправить аргументы
+				out = "ошибка: " + execErr.Error()
+				logger.Warn("ошибка инструмента агента",
+					"run_id", req.RunID,
+					"tool", call.Name,
+					"error", execErr,
+				)
+			}
+			res.ToolCalls++
+			emit(EventToolResult, ev)
+
+			a.saveStep(req, step, &storage.AgentStep{
+				Kind:       storage.AgentStepTool,
+				ToolName:   call.Name,
+				ToolCallID: call.ID,
+				Arguments:  call.Arguments,
+				Content:    ev.Content,
+				Error:      ev.Error,
+				DurationMs: ev.DurationMs,
+			})
+
+			history = append(history, provider.Message{
+				Role:       provider.RoleTool,
+				Content:    out,
+				ToolCallID: call.ID,
+				Name:       call.Name,
+			})
+		}
+
+		if err := ctx.Err(); err != nil {
+			return res, fmt.Errorf("агент остановлен: %w", err)
+		}
+	}
+}
+
+// saveStep сохраняет шаг в storage (ошибки только логируются)
+func (a *Agent) saveStep(req RunRequest, step int, st *storage.AgentStep) {
+	if a.Storage == nil {
+		return
+	}
+	st.RunID = req.RunID
+	st.SessionID = req.SessionID
+	st.Step = step
+	if err := a.Storage.SaveAgentStep(st); err != nil {
+		logger.Warn("ошибка сохранения шага агента", "run_id", req.RunID, "step", step, "error", err)
+	}
+}
@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nnk/97-aic/backend/provider"
+)
+
+// loopProvider на каждом шаге просит вызвать калькулятор, пока инструменты доступны
+type loopProvider struct {
+	choices []string // tool_choice каждого запроса
+}
+
+func (p *loopProvider) Name() string     { return "loop" }
+func (p *loopProvider) Models() []string { return []string{"m"} }
+func (p *loopProvider) Chat(ctx context.Context, message string, opts *provider.ChatOptions, onChunk func(string) error) (*provider.ChatResult, error) {
+	p.choices = append(p.choices, opts.ToolChoice)
+	if opts.ToolChoice == "none" {
+		return &provider.ChatResult{FinishReason: "stop"}, onChunk("готово")
+	}
+	return &provider.ChatResult{
+		FinishReason: provider.FinishReasonToolCalls,
+		ToolCalls:    []provider.ToolCall{{ID: "c", Name: "calculator", Arguments: `{"expression":"1+1"}`}},
+	}, nil
+}
+func (p *loopProvider) SetModel(string)                   {}
+func (p *loopProvider) GetModel() string                  { return "m" }
+func (p *loopProvider) GetMaxTokens() int                 { return 8192 }
+func (p *loopProvider) GetMaxTokensForModel(string) int   { return 8192 }
+func (p *loopProvider) CalculateCost(in, out int) float64 { return 0 }
+
+func TestRunStopsAtStepLimit(t *testing.T) {
+	tools := NewRegistry(0)
+	if err := tools.Register(CalculatorTool{}); err != nil {
+		t.Fatal(err)
+	}
+	a := New(tools, nil, 5)
+	p := &loopProvider{}
+
+	var results []ToolResultEvent
+	res, err := a.Run(context.Background(), p, RunRequest{RunID: "r", Message: "посчитай", MaxSteps: 2}, func(event string, data interface{}) {
+		if ev, ok := data.(ToolResultEvent); ok {
+			results = append(results, ev)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Два шага с инструментами, третий — принудительный ответ без них
+	if res.Steps != 3 || res.ToolCalls != 2 || !res.StepLimitHit || res.Content != "готово" {
+		t.Errorf("результат = %+v", res)
+	}
+	if len(p.choices) != 3 || p.choices[2] != "none" {
+		t.Errorf("tool_choice по шагам = %q", p.choices)
+	}
+	if len(results) != 2 || results[0].Content != "2" || results[0].Error != "" {
+		t.Errorf("результаты инструментов = %+v", results)
+	}
+}
@@ -0,0 +1,245 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// evalExpression вычисляет арифметическое выражение:
+// числа, + - * / % ^, скобки, унарный минус, константы pi и e,
+// функции sqrt, abs, ln, log10, sin, cos, tan, round, floor, ceil
+func evalExpression(expr string) (float64, error) {
+	p := &calcParser{input: []rune(expr)}
+	value, err := p.parseExpr()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("неожиданный символ %q в позиции %d", p.input[p.pos], p.pos+1)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("результат не является конечным числом")
+	}
+	return value, nil
+}
+
+// calcParser рекурсивный спуск:
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = factor { ("*" | "/" | "%") factor }
+//	factor = ("-" | "+") factor | power
+//	power  = primary [ "^" factor ]
+//	primary = number | name | name "(" expr ")" | "(" expr ")"
+type calcParser struct {
+	input []rune
+	pos   int
+	depth int
+}
+
+// maxCalcDepth ограничивает вложенность, чтобы не переполнить стек
+const maxCalcDepth = 100
+
+func (p *calcParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *calcParser) peek() rune {
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+func (p *calcParser) parseExpr() (float64, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch p.peek() {
+		case '+':
+			p.pos++
+			right, err := p.parseTerm()
+			if err != nil {
+				return 0, err
+			}
+			left += right
+		case '-':
+			p.pos++
+			right, err := p.parseTerm()
+			if err != nil {
+				return 0, err
+			}
+			left -= right
+		default:
+			return left, nil
+		}
+	}
+}
+
+func (p *calcParser) parseTerm() (float64, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '*':
+			left *= right
+		case '/':
+			if right == 0 {
+				return 0, fmt.Errorf("деление на ноль")
+			}
+			left /= right
+		case '%':
+			if right == 0 {
+				return 0, fmt.Errorf("деление на ноль")
+			}
+			left = math.Mod(left, right)
+		}
+	}
+}
+
+func (p *calcParser) parseFactor() (float64, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxCalcDepth {
+		return 0, fmt.Errorf("слишком глубокая вложенность выражения")
+	}
+
+	// Унарный минус слабее степени: -2^2 = -4
+	switch p.peek() {
+	case '-':
+		p.pos++
+		v, err := p.parseFactor()
+		return -v, err
+	case '+':
+		p.pos++
+		return p.parseFactor()
+	}
+	return p.parsePower()
+}
+
+func (p *calcParser) parsePower() (float64, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() == '^' {
+		p.pos++
+		// Возведение в степень правоассоциативно, показатель может быть отрицательным
+		exp, err := p.parseFactor()
+		if err != nil {
+			return 0, err
+		}
+		return math.Pow(base, exp), nil
+	}
+	return base, nil
+}
+
+func (p *calcParser) parsePrimary() (float64, error) {
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		v, err := p.parseExpr()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("ожидается ')'")
+		}
+		p.pos++
+		return v, nil
+
+	case unicode.IsDigit(c) || c == '.':
+		start := p.pos
+		for p.pos < len(p.input) && (unicode.IsDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		// Экспоненциальная запись: 1e6, 2.5E-3
+		if p.pos < len(p.input) && (p.input[p.pos] == 'e' || p.input[p.pos] == 'E') {
+			next := p.pos + 1
+			if next < len(p.input) && (p.input[next] == '+' || p.input[next] == '-') {
+				next++
+			}
+			if next < len(p.input) && unicode.IsDigit(p.input[next]) {
+				p.pos = next
+				for p.pos < len(p.input) && unicode.IsDigit(p.input[p.pos]) {
+					p.pos++
+				}
+			}
+		}
+		v, err := strconv.ParseFloat(string(p.input[start:p.pos]), 64)
+		if err != nil {
+			return 0, fmt.Errorf("некорректное число %q", string(p.input[start:p.pos]))
+		}
+		return v, nil
+
+	case unicode.IsLetter(c):
+		start := p.pos
+		for p.pos < len(p.input) && (unicode.IsLetter(p.input[p.pos]) || unicode.IsDigit(p.input[p.pos])) {
+			p.pos++
+		}
+		name := strings.ToLower(string(p.input[start:p.pos]))
+
+		switch name {
+		case "pi":
+			return math.Pi, nil
+		case "e":
+			return math.E, nil
+		}
+
+		fn, ok := calcFunctions[name]
+		if !ok {
+			return 0, fmt.Errorf("неизвестная функция %q", name)
+		}
+		if p.peek() != '(' {
+			return 0, fmt.Errorf("ожидается '(' после %s", name)
+		}
+		p.pos++
+		arg, err := p.parseExpr()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("ожидается ')'")
+		}
+		p.pos++
+		return fn(arg), nil
+
+	case c == 0:
+		return 0, fmt.Errorf("неожиданный конец выражения")
+	}
+	return 0, fmt.Errorf("неожиданный символ %q в позиции %d", c, p.pos+1)
+}
+
+// calcFunctions функции одного аргумента
+var calcFunctions = map[string]func(float64) float64{
+	"sqrt":  math.Sqrt,
+	"abs":   math.Abs,
+	"ln":    math.Log,
+	"log10": math.Log10,
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"round": math.Round,
+	"floor": math.Floor,
+	"ceil":  math.Ceil,
+}
@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nnk/97-aic/backend/jsonschema"
+	"github.com/nnk/97-aic/backend/provider"
+)
+
+// defaultToolTimeout таймаут инструмента, если он не задал свой
+const defaultToolTimeout = 10 * time.Second
+
+// Env контекст запуска агента, доступный инструментам
+type Env struct {
+	SessionID string
+}
+
+// Tool локальный инструмент, который может вызвать модель
+type Tool interface {
+	// Name возвращает имя инструмента (латиница, цифры, _)
+	Name() string
+
+	// Description возвращает описание для модели
+	Description() string
+
+	// Parameters возвращает JSON Schema аргументов
+	Parameters() json.RawMessage
+
+	// Timeout возвращает максимальное время выполнения (0 — таймаут по умолчанию)
+	Timeout() time.Duration
+
+	// Execute выполняет инструмент; args уже проверены по схеме Parameters
+	Execute(ctx context.Context, env Env, args json.RawMessage) (string, error)
+}
+
+// registeredTool инструмент с разобранной схемой аргументов
+type registeredTool struct {
+	tool   Tool
+	schema *jsonschema.Schema
+}
+
+// Registry реестр инструментов агента
+type Registry struct {
+	mu         sync.RWMutex
+	tools      map[string]registeredTool
+	maxTimeout time.Duration
+}
+
+// NewRegistry создает пустой реестр. maxTimeout ограничивает сверху таймаут любого
+// инструмента (agent.tool_timeout_sec); 0 — каждый инструмент работает со своим таймаутом.
+func NewRegistry(maxTimeout time.Duration) *Registry {
+	if maxTimeout < 0 {
+		maxTimeout = 0
+	}
+	return &Registry{
+		tools:      make(map[string]registeredTool),
+		maxTimeout: maxTimeout,
+	}
+}
+
+// Timeout возвращает таймаут инструмента с учетом ограничения реестра
+func (r *Registry) Timeout(t Tool) time.Duration {
+	timeout := t.Timeout()
+	if timeout <= 0 {
+		timeout = defaultToolTimeout
+	}
+	if r.maxTimeout > 0 && timeout > r.maxTimeout {
+		timeout = r.maxTimeout
+	}
+	return timeout
+}
+
+// Register регистрирует инструмент
+func (r *Registry) Register(t Tool) error {
+	schema, err := jsonschema.Parse(t.Parameters())
+	if err != nil {
+		return fmt.Errorf("инструмент %s: %w", t.Name(), err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.tools[t.Name()]; exists {
+		return fmt.Errorf("инструмент %s уже зарегистрирован", t.Name())
+	}
+	r.tools[t.Name()] = registeredTool{tool: t, schema: schema}
+	return nil
+}
+
+// List возвращает имена зарегистрированных инструментов
+func (r *Registry) List() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.tools))
+	for name := range r.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Definitions возвращает описания инструментов для модели.
+// Пустой only означает все инструменты, иначе только перечисленные.
+func (r *Registry) Definitions(only []string) ([]provider.Tool, error) {
+	names := only
+	if len(names) == 0 {
+		names = r.List()
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	defs := make([]provider.Tool, 0, len(names))
+	for _, name := range names {
+		rt, ok := r.tools[name]
+		if !ok {
+			return nil, fmt.Errorf("инструмент %s не найден", name)
+		}
+		defs = append(defs, provider.Tool{
+			Name:        rt.tool.Name(),
+			Description: rt.tool.Description(),
+			Parameters:  rt.tool.Parameters(),
+		})
+	}
+	return defs, nil
+}
+
+// Execute проверяет аргументы вызова по схеме и выполняет инструмент с его таймаутом
+func (r *Registry) Execute(ctx context.Context, env Env, call provider.ToolCall) (string, error) {
+	r.mu.RLock()
+	rt, ok := r.tools[call.Name]
+	r.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("инструмент %s не найден", call.Name)
+	}
+
+	args := json.RawMessage(call.Arguments)
+	if len(args) == 0 {
+		args = json.RawMessage(`{}`)
+	}
+	if err := rt.schema.ValidateJSON(args); err != nil {
+		return "", fmt.Errorf("некорректные аргументы: %w", err)
+	}
+
+	timeout := r.Timeout(rt.tool)
+	tctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Инструмент может не уважать контекст — не ждем его дольше таймаута
+	type outcome struct {
+		out string
+		err error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		out, err := rt.tool.Execute(tctx, env, args)
+		done <- outcome{out: out, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.out, res.err
+	case <-tctx.Done():
+		return "", fmt.Errorf("инструмент %s не уложился в %s: %w", call.Name, timeout, tctx.Err())
+	}
+}
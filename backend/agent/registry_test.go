@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nnk/97-aic/backend/provider"
+)
+
+// sleepTool инструмент с заданным таймаутом, который не уважает контекст
+type sleepTool struct {
+	timeout time.Duration
+	sleep   time.Duration
+}
+
+func (sleepTool) Name() string                { return "sleep" }
+func (sleepTool) Description() string         { return "Спит" }
+func (sleepTool) Parameters() json.RawMessage { return json.RawMessage(`{"type":"object"}`) }
+func (t sleepTool) Timeout() time.Duration    { return t.timeout }
+func (t sleepTool) Execute(ctx context.Context, env Env, args json.RawMessage) (string, error) {
+	time.Sleep(t.sleep)
+	return "проснулся", nil
+}
+
+func TestRegistryTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxTimeout time.Duration
+		tool       time.Duration
+		want       time.Duration
+	}{
+		{"свой таймаут без ограничения", 0, 15 * time.Second, 15 * time.Second},
+		{"таймаут по умолчанию", 0, 0, defaultToolTimeout},
+		{"ограничение конфига", 2 * time.Second, 15 * time.Second, 2 * time.Second},
+		{"свой таймаут меньше ограничения", 2 * time.Second, time.Second, time.Second},
+		{"ограничение и таймаут по умолчанию", 3 * time.Second, 0, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry(tt.maxTimeout)
+			if got := r.Timeout(sleepTool{timeout: tt.tool}); got != tt.want {
+				t.Errorf("Timeout = %s, ожидалось %s", got, tt.want)
+			}
+		})
+	}
+
+	// Ограничение действует на встроенные инструменты со своими таймаутами
+	r := NewRegistry(5 * time.Second)
+	if got := r.Timeout(HTTPFetchTool{}); got != 5*time.Second {
+		t.Errorf("Timeout(http_fetch) = %s", got)
+	}
+}
+
+func TestRegistryExecuteStopsAtTimeout(t *testing.T) {
+	r := NewRegistry(50 * time.Millisecond)
+	if err := r.Register(sleepTool{timeout: time.Minute, sleep: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err := r.Execute(context.Background(), Env{}, provider.ToolCall{Name: "sleep"})
+	if err == nil || !strings.Contains(err.Error(), "не уложился") {
+		t.Fatalf("ошибка = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Execute ждал инструмент %s", elapsed)
+	}
+}
+
+func TestRegistryExecute(t *testing.T) {
+	r := NewRegistry(0)
+	if err := r.Register(CalculatorTool{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(CalculatorTool{}); err == nil {
+		t.Error("повторная регистрация должна завершаться ошибкой")
+	}
+
+	out, err := r.Execute(context.Background(), Env{}, provider.ToolCall{Name: "calculator", Arguments: `{"expression":"(2+3)*4"}`})
+	if err != nil || out != "20" {
+		t.Errorf("Execute = %q, %v", out, err)
+	}
+
+	for name, call := range map[string]provider.ToolCall{
+		"неизвестный инструмент": {Name: "rm_rf", Arguments: `{}`},
+		"лишнее поле":            {Name: "calculator", Arguments: `{"expression":"1","x":1}`},
+		"нет обязательного поля": {Name: "calculator", Arguments: `{}`},
+		"неверный тип":           {Name: "calculator", Arguments: `{"expression":42}`},
+		"не JSON":                {Name: "calculator", Arguments: `expression=1`},
+	} {
+		if _, err := r.Execute(context.Background(), Env{}, call); err == nil {
+			t.Errorf("%s: ожидалась ошибка", name)
+		}
+	}
+
+	defs, err := r.Definitions([]string{"calculator"})
+	if err != nil || len(defs) != 1 || defs[0].Name != "calculator" {
+		t.Errorf("Definitions = %+v, %v", defs, err)
+	}
+	if _, err := r.Definitions([]string{"unknown"}); err == nil {
+		t.Error("Definitions с неизвестным инструментом должен завершаться ошибкой")
+	}
+}
@@ -0,0 +1,288 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+// CalculatorTool вычисляет арифметические выражения
+type CalculatorTool struct{}
+
+// Name возвращает имя инструмента
+func (CalculatorTool) Name() string { return "calculator" }
+
+// Description возвращает описание для модели
+func (CalculatorTool) Description() string {
+	return "Вычисляет арифметическое выражение: + - * / % ^, скобки, pi, e, функции sqrt, abs, ln, log10, sin, cos, tan, round, floor, ceil."
+}
+
+// Parameters возвращает JSON Schema аргументов
+func (CalculatorTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"expression": {"type": "string", "minLength": 1, "maxLength": 1000, "description": "Выражение, например (2+3)*sqrt(16)"}
+		},
+		"required": ["expression"],
+		"additionalProperties": false
+	}`)
+}
+
+// Timeout возвращает максимальное время выполнения
+func (CalculatorTool) Timeout() time.Duration { return time.Second }
+
+// Execute вычисляет выражение
+func (CalculatorTool) Execute(ctx context.Context, env Env, args json.RawMessage) (string, error) {
+	var in struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", fmt.Errorf("ошибка разбора аргументов: %w", err)
+	}
+	value, err := evalExpression(in.Expression)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64), nil
+}
+
+// CurrentTimeTool возвращает текущие дату и время
+type CurrentTimeTool struct{}
+
+// Name возвращает имя инструмента
+func (CurrentTimeTool) Name() string { return "current_time" }
+
+// Description возвращает описание для модели
+func (CurrentTimeTool) Description() string {
+	return "Возвращает текущие дату и время (RFC 3339) и день недели. Можно указать часовой пояс IANA, например Europe/Moscow."
+}
+
+// Parameters возвращает JSON Schema аргументов
+func (CurrentTimeTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"timezone": {"type": "string", "description": "Часовой пояс IANA (по умолчанию UTC)"}
+		},
+		"additionalProperties": false
+	}`)
+}
+
+// Timeout возвращает максимальное время выполнения
+func (CurrentTimeTool) Timeout() time.Duration { return time.Second }
+
+// Execute возвращает время
+func (CurrentTimeTool) Execute(ctx context.Context, env Env, args json.RawMessage) (string, error) {
+	var in struct {
+		Timezone string `json:"timezone"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", fmt.Errorf("ошибка разбора аргументов: %w", err)
+	}
+
+	loc := time.UTC
+	if in.Timezone != "" {
+		l, err := time.LoadLocation(in.Timezone)
+		if err != nil {
+			return "", fmt.Errorf("неизвестный часовой пояс %s", in.Timezone)
+		}
+		loc = l
+	}
+	now := time.Now().In(loc)
+
+	out, _ := json.Marshal(map[string]string{
+		"time":     now.Format(time.RFC3339),
+		"weekday":  now.Weekday().String(),
+		"timezone": loc.String(),
+	})
+	return string(out), nil
+}
+
+// HistorySearchTool ищет по истории текущей сессии
+type HistorySearchTool struct {
+	Storage storage.Store
+}
+
+// Name возвращает имя инструмента
+func (HistorySearchTool) Name() string { return "search_history" }
+
+// Description возвращает описание для модели
+func (HistorySearchTool) Description() string {
+	return "Ищет сообщения текущего диалога, содержащие заданный текст. Возвращает самые новые совпадения."
+}
+
+// Parameters возвращает JSON Schema аргументов
+func (HistorySearchTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"query": {"type": "string", "minLength": 1, "maxLength": 200},
+			"limit": {"type": "integer", "minimum": 1, "maximum": 20}
+		},
+		"required": ["query"],
+		"additionalProperties": false
+	}`)
+}
+
+// Timeout возвращает максимальное время выполнения
+func (HistorySearchTool) Timeout() time.Duration { return 5 * time.Second }
+
+// Execute ищет сообщения
+func (t HistorySearchTool) Execute(ctx context.Context, env Env, args json.RawMessage) (string, error) {
+	var in struct {
+		Query string `json:"query"`
+		Limit int    `json:"limit"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", fmt.Errorf("ошибка разбора аргументов: %w", err)
+	}
+	if t.Storage == nil {
+		return "", fmt.Errorf("хранилище недоступно")
+	}
+	if in.Limit <= 0 {
+		in.Limit = 5
+	}
+
+	messages, err := t.Storage.SearchMessages(env.SessionID, in.Query, in.Limit)
+	if err != nil {
+		return "", err
+	}
+
+	type found struct {
+		Role      string `json:"role"`
+		Content   string `json:"content"`
+		CreatedAt string `json:"created_at"`
+	}
+	results := make([]found, 0, len(messages))
+	for _, m := range messages {
+		results = append(results, found{
+			Role:      m.Role,
+			Content:   m.Content,
+			CreatedAt: m.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	out, _ := json.Marshal(results)
+	return string(out), nil
+}
+
+// maxFetchBytes максимальный размер тела ответа http_fetch, который видит модель
+const maxFetchBytes = 64 * 1024
+
+// HTTPFetchTool выполняет GET-запрос к хостам из белого списка
+type HTTPFetchTool struct {
+	Allowlist []string     // разрешенные хосты; "*.example.com" — любой поддомен
+	Client    *http.Client // nil — клиент по умолчанию; CheckRedirect всегда заменяется проверкой белого списка
+}
+
+// Name возвращает имя инструмента
+func (HTTPFetchTool) Name() string { return "http_fetch" }
+
+// Description возвращает описание для модели
+func (t HTTPFetchTool) Description() string {
+	return "Загружает страницу или API по HTTP(S) методом GET и возвращает статус и начало тела ответа. Разрешенные хосты: " +
+		strings.Join(t.Allowlist, ", ")
+}
+
+// Parameters возвращает JSON Schema аргументов
+func (HTTPFetchTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"url": {"type": "string", "minLength": 1, "maxLength": 2000}
+		},
+		"required": ["url"],
+		"additionalProperties": false
+	}`)
+}
+
+// Timeout возвращает максимальное время выполнения
+func (HTTPFetchTool) Timeout() time.Duration { return 15 * time.Second }
+
+// Execute загружает URL
+func (t HTTPFetchTool) Execute(ctx context.Context, env Env, args json.RawMessage) (string, error) {
+	var in struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", fmt.Errorf("ошибка разбора аргументов: %w", err)
+	}
+
+	u, err := url.Parse(in.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("некорректный URL: %s", in.URL)
+	}
+	if !t.allowed(u.Hostname()) {
+		return "", fmt.Errorf("хост %s не входит в белый список", u.Hostname())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("ошибка создания запроса: %w", err)
+	}
+
+	// Редиректы тоже проверяем по белому списку, в том числе для переданного клиента
+	client := &http.Client{}
+	if t.Client != nil {
+		c := *t.Client
+		client = &c
+	}
+	client.CheckRedirect = func(r *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return fmt.Errorf("слишком много редиректов")
+		}
+		if !t.allowed(r.URL.Hostname()) {
+			return fmt.Errorf("редирект на хост %s вне белого списка", r.URL.Hostname())
+		}
+		return nil
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("ошибка выполнения запроса: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("ошибка чтения ответа: %w", err)
+	}
+	truncated := len(body) > maxFetchBytes
+	if truncated {
+		body = body[:maxFetchBytes]
+	}
+
+	out, _ := json.Marshal(map[string]interface{}{
+		"status":       resp.StatusCode,
+		"content_type": resp.Header.Get("Content-Type"),
+		"body":         strings.ToValidUTF8(string(body), ""),
+		"truncated":    truncated,
+	})
+	return string(out), nil
+}
+
+// allowed проверяет хост по белому списку
+func (t HTTPFetchTool) allowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, pattern := range t.Allowlist {
+		pattern = strings.ToLower(pattern)
+		if strings.HasPrefix(pattern, "*.") {
+			if strings.HasSuffix(host, pattern[1:]) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEvalExpression(t *testing.T) {
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"2 ^ 3 ^ 2", 512},
+		{"-2 ^ 2", -4},
+		{"10 % 4", 2},
+		{"2350 * 0.17", 399.5},
+		{"sqrt(16) + abs(-3)", 7},
+		{"round(2.5) + floor(1.9) + ceil(1.1)", 6},
+		{"ln(e)", 1},
+		{"log10(1000)", 3},
+		{"cos(0)", 1},
+	}
+	for _, tt := range tests {
+		got, err := evalExpression(tt.expr)
+		if err != nil {
+			t.Errorf("evalExpression(%q): %v", tt.expr, err)
+			continue
+		}
+		if diff := got - tt.want; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("evalExpression(%q) = %v, ожидалось %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"", "1 +", "1 / 0", "(1 + 2", "2 3", "foo(1)", "sqrt(-1)", strings.Repeat("(", 200) + "1" + strings.Repeat(")", 200)} {
+		if got, err := evalExpression(expr); err == nil {
+			t.Errorf("evalExpression(%q) = %v, ожидалась ошибка", expr, got)
+		}
+	}
+}
+
+func TestHTTPFetchAllowed(t *testing.T) {
+	tool := HTTPFetchTool{Allowlist: []string{"api.github.com", "*.Wikipedia.org"}}
+	tests := map[string]bool{
+		"api.github.com":      true,
+		"API.GitHub.com":      true,
+		"github.com":          false,
+		"evil-api.github.com": false,
+		"ru.wikipedia.org":    true,
+		"en.m.wikipedia.org":  true,
+		"wikipedia.org":       false,
+		"evilwikipedia.org":   false,
+		"wikipedia.org.evil":  false,
+	}
+	for host, want := range tests {
+		if got := tool.allowed(host); got != want {
+			t.Errorf("allowed(%q) = %v, ожидалось %v", host, got, want)
+		}
+	}
+}
+
+// fetch выполняет http_fetch и разбирает результат
+func fetch(t *testing.T, tool HTTPFetchTool, rawURL string) (map[string]interface{}, error) {
+	t.Helper()
+	args, _ := json.Marshal(map[string]string{"url": rawURL})
+	out, err := tool.Execute(context.Background(), Env{}, args)
+	if err != nil {
+		return nil, err
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res, nil
+}
+
+func TestHTTPFetch(t *testing.T) {
+	// Второй сервер доступен по localhost — хосту вне белого списка
+	var outsideHits int
+	outside := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outsideHits++
+		w.Write([]byte("секрет"))
+	}))
+	defer outside.Close()
+	outsideURL, _ := url.Parse(outside.URL)
+	outsideURL.Host = "localhost:" + outsideURL.Port()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("привет"))
+		case "/big":
+			w.Write([]byte(strings.Repeat("a", maxFetchBytes+100)))
+		case "/redirect-inside":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		case "/redirect-outside":
+			http.Redirect(w, r, outsideURL.String(), http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	host, _ := url.Parse(srv.URL)
+
+	// Переданный клиент тоже получает проверку редиректов
+	tool := HTTPFetchTool{Allowlist: []string{host.Hostname()}, Client: srv.Client()}
+
+	res, err := fetch(t, tool, srv.URL+"/ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["status"] != float64(200) || res["body"] != "привет" || res["truncated"] != false || res["content_type"] != "text/plain" {
+		t.Errorf("ответ = %+v", res)
+	}
+
+	res, err = fetch(t, tool, srv.URL+"/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["truncated"] != true || len(res["body"].(string)) != maxFetchBytes {
+		t.Errorf("большой ответ: truncated=%v, длина %d", res["truncated"], len(res["body"].(string)))
+	}
+
+	if res, err := fetch(t, tool, srv.URL+"/redirect-inside"); err != nil || res["body"] != "привет" {
+		t.Errorf("редирект внутри белого списка: %+v, %v", res, err)
+	}
+
+	if _, err := fetch(t, tool, srv.URL+"/redirect-outside"); err == nil || !strings.Contains(err.Error(), "вне белого списка") {
+		t.Errorf("редирект наружу: %v", err)
+	}
+	if _, err := fetch(t, tool, outsideURL.String()); err == nil || !strings.Contains(err.Error(), "не входит в белый список") {
+		t.Errorf("хост вне белого списка: %v", err)
+	}
+	if outsideHits != 0 {
+		t.Errorf("запросов к хосту вне белого списка: %d", outsideHits)
+	}
+
+	for _, raw := range []string{"ftp://" + host.Host + "/ok", "file:///etc/passwd", "not a url", "http://"} {
+		if _, err := fetch(t, tool, raw); err == nil {
+			t.Errorf("URL %q: ожидалась ошибка", raw)
+		}
+	}
+}
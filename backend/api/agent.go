@@ -0,0 +1,320 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nnk/97-aic/backend/agent"
+	"github.com/nnk/97-aic/backend/logger"
+	"github.com/nnk/97-aic/backend/provider"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+// AgentHandler обрабатывает запросы к /api/v2/agent.
+// Запуск, как и генерация /api/v2/chat, идет независимо от соединения: инструменты
+// с побочными эффектами не прерываются на полпути из-за обрыва, клиент может
+// переподключиться к /api/v2/generations/{id}/stream или остановить запуск через cancel.
+type AgentHandler struct {
+	ProviderManager *provider.Manager
+	Storage         storage.Store
+	Agent           *agent.Agent
+	Generations     *GenerationRegistry
+}
+
+// AgentRequest запрос к агенту
+type AgentRequest struct {
+	Message      string   `json:"message"`
+	SessionID    string   `json:"session_id,omitempty"`
+	UseHistory   bool     `json:"use_history,omitempty"`
+	Provider     string   `json:"provider,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	SystemPrompt string   `json:"system_prompt,omitempty"`
+	Tools        []string `json:"tools,omitempty"`     // подмножество инструментов (пусто — все)
+	MaxSteps     int      `json:"max_steps,omitempty"` // не больше agent.max_steps из конфига
+	MaxTokens    int      `json:"max_tokens,omitempty"`
+	Temperature  float64  `json:"temperature,omitempty"`
+}
+
+// NewAgentHandler создает обработчик агента
+func NewAgentHandler(pm *provider.Manager, store storage.Store, a *agent.Agent, gens *GenerationRegistry) *AgentHandler {
+	return &AgentHandler{
+		ProviderManager: pm,
+		Storage:         store,
+		Agent:           a,
+		Generations:     gens,
+	}
+}
+
+// ServeHTTP обрабатывает HTTP запросы: GET — список инструментов, POST — запуск агента (SSE)
+func (h *AgentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		defs, _ := h.Agent.Tools.Definitions(nil)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"tools":     defs,
+			"max_steps": h.Agent.MaxSteps,
+		})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		return
+	}
+
+	startTime := time.Now()
+
+	bodyBytes, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Ошибка чтения запроса", http.StatusBadRequest)
+		return
+	}
+
+	var req AgentRequest
+	if err := json.Unmarshal(bodyBytes, &req); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка парсинга запроса: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.Message == "" {
+		http.Error(w, "Поле message обязательно", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.Agent.Tools.Definitions(req.Tools); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка инструментов: %v. Доступные: %s", err, strings.Join(h.Agent.Tools.List(), ", ")), http.StatusBadRequest)
+		return
+	}
+
+	p, err := h.ProviderManager.ForModel(req.Provider, req.Model)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка провайдера: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.SessionID == "" {
+		req.SessionID = fmt.Sprintf("session_%d", time.Now().UnixNano())
+	}
+	if !claimSession(w, r, h.Storage, req.SessionID) {
+		return
+	}
+	runID := uuid.New().String()
+
+	// История: активная ветка сессии (summary и шаги агентов не подмешиваем)
+	var history []provider.Message
+	if req.UseHistory && h.Storage != nil {
+		leafID, err := h.Storage.ActiveLeafID(req.SessionID)
+		if err != nil {
+			logger.Warn("ошибка загрузки активной ветки", "session_id", req.SessionID, "error", err)
+		}
+		messages, err := h.Storage.GetBranch(req.SessionID, leafID, 1000)
+		if err != nil {
+			logger.Warn("ошибка загрузки истории", "session_id", req.SessionID, "error", err)
+		}
+		for _, msg := range messages {
+			if msg.Role == storage.RoleSummary {
+				continue
+			}
+			history = append(history, provider.Message{Role: msg.Role, Content: msg.Content})
+		}
+	}
+
+	// Вопрос продолжает активную ветку, ответ сохраняется под ним
+	var questionID int64
+	if h.Storage != nil {
+		userMsg, err := h.Storage.AddMessage(storage.NewMessage{
+			SessionID: req.SessionID,
+			Role:      storage.RoleUser,
+			Content:   req.Message,
+		})
+		if err != nil {
+			logger.Warn("ошибка сохранения сообщения", "session_id", req.SessionID, "error", err)
+		} else {
+			questionID = userMsg.ID
+		}
+	}
+
+	stream, err := newSSEWriter(w, false)
+	if err != nil {
+		http.Error(w, "Streaming не поддерживается", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("запуск агента",
+		"run_id", runID,
+		"session_id", req.SessionID,
+		"provider", p.Name(),
+		"model", p.GetModel(),
+		"tools", req.Tools,
+	)
+
+	userID := contextUserID(r.Context())
+	gen, genCtx := h.Generations.Start(req.SessionID, userID)
+	w.Header().Set("X-Generation-ID", gen.ID)
+	gen.Send(EventMeta, MetaEvent{
+		Protocol:     SSEProtocolVersion,
+		GenerationID: gen.ID,
+		RunID:        runID,
+		SessionID:    req.SessionID,
+		Provider:     p.Name(),
+		Model:        p.GetModel(),
+	})
+
+	go func() {
+		defer gen.Finish()
+		h.run(genCtx, gen, &req, p, history, questionID, runID, userID, startTime)
+	}()
+
+	stream.Follow(r.Context(), gen, 0)
+}
+
+// run выполняет цикл агента, пишет события в буфер генерации и сохраняет результат
+func (h *AgentHandler) run(ctx context.Context, gen *Generation, req *AgentRequest, p provider.Provider, history []provider.Message, questionID int64, runID, userID string, startTime time.Time) {
+	// События агента: step, tool_call, tool_result как есть, текст ответа — delta
+	emit := func(event string, data interface{}) {
+		if chunk, ok := data.(string); ok && event == agent.EventContent {
+			gen.Send(EventDelta, DeltaEvent{Content: chunk})
+			return
+		}
+		gen.Send(event, data)
+	}
+
+	tok := h.ProviderManager.Tokenizer(p)
+	result, err := h.Agent.Run(ctx, p, agent.RunRequest{
+		RunID:        runID,
+		SessionID:    req.SessionID,
+		Message:      req.Message,
+		SystemPrompt: req.SystemPrompt,
+		History:      history,
+		Tools:        req.Tools,
+		MaxSteps:     req.MaxSteps,
+		MaxTokens:    req.MaxTokens,
+		Temperature:  req.Temperature,
+		Tokenizer:    tok,
+	}, emit)
+
+	durationMs := time.Since(startTime).Milliseconds()
+	statusCode := http.StatusOK
+	if err != nil {
+		statusCode = http.StatusInternalServerError
+		logger.Error("ошибка агента", "run_id", runID, "error", err, "duration_ms", durationMs)
+	}
+
+	var tokensInput, tokensOutput, tokensTotal int
+	var cost float64
+	if result != nil {
+		tokensInput, tokensOutput, tokensTotal = result.Usage.InputTokens, result.Usage.OutputTokens, result.Usage.TotalTokens
+		cost = p.CalculateCost(tokensInput, tokensOutput)
+	}
+
+	if h.Storage != nil {
+		// Логируем запуск
+		requestJSON, _ := json.Marshal(map[string]interface{}{
+			"message":    req.Message,
+			"session_id": req.SessionID,
+			"provider":   p.Name(),
+			"model":      p.GetModel(),
+			"tools":      req.Tools,
+			"run_id":     runID,
+			"source":     "agent",
+		})
+		responseData := map[string]interface{}{
+			"status": statusCode,
+		}
+		if result != nil {
+			responseData["content"] = result.Content
+			responseData["steps"] = result.Steps
+			responseData["tool_calls"] = result.ToolCalls
+			responseData["tokens_source"] = result.Usage.Source
+		}
+		if err != nil {
+			responseData["error"] = err.Error()
+		}
+		responseJSON, _ := json.Marshal(responseData)
+
+		requestLog, logErr := h.Storage.SaveRequestLog(storage.NewRequestLog{
+			SessionID:    req.SessionID,
+			UserID:       userID,
+			Provider:     p.Name(),
+			Model:        p.GetModel(),
+			RequestJSON:  string(requestJSON),
+			ResponseJSON: string(responseJSON),
+			StatusCode:   statusCode,
+			DurationMs:   durationMs,
+			TokensInput:  &tokensInput,
+			TokensOutput: &tokensOutput,
+			TokensTotal:  &tokensTotal,
+			Cost:         &cost,
+		})
+		if logErr != nil {
+			logger.Warn("ошибка сохранения лога агента", "run_id", runID, "error", logErr)
+		}
+
+		// Итоговый ответ — в историю сессии с происхождением и ссылкой на лог запуска
+		if err == nil && result != nil && result.Content != "" {
+			provenance := storage.Provenance{
+				Provider:     p.Name(),
+				Model:        p.GetModel(),
+				TokensInput:  &tokensInput,
+				TokensOutput: &tokensOutput,
+				Cost:         &cost,
+				DurationMs:   &durationMs,
+			}
+			provenance.Temperature = requestTemperature(req.Temperature)
+			if requestLog != nil {
+				provenance.RequestLogID = &requestLog.ID
+			}
+			answer := storage.NewMessage{
+				SessionID:    req.SessionID,
+				Role:         storage.RoleAssistant,
+				Content:      result.Content,
+				FinishReason: result.FinishReason,
+				Provenance:   provenance,
+			}
+			if questionID != 0 {
+				answer.ParentID = &questionID
+			}
+			if _, err := h.Storage.AddMessage(answer); err != nil {
+				logger.Warn("ошибка сохранения ответа", "session_id", req.SessionID, "error", err)
+			}
+		}
+	}
+
+	if result != nil {
+		gen.Send(EventUsage, UsageEvent{
+			InputTokens:  tokensInput,
+			OutputTokens: tokensOutput,
+			TotalTokens:  tokensTotal,
+			Source:       result.Usage.Source,
+			Cost:         cost,
+		})
+		gen.Send(EventResult, result)
+	}
+	if err != nil {
+		gen.Send(EventError, ErrorEvent{Message: err.Error()})
+	}
+
+	logger.Info("агент завершил работу",
+		"run_id", runID,
+		"session_id", req.SessionID,
+		"duration_ms", durationMs,
+		"status", statusCode,
+	)
+
+	done := DoneEvent{
+		Provider:          p.Name(),
+		Model:             p.GetModel(),
+		RequestedProvider: p.Name(),
+		DurationMs:        durationMs,
+	}
+	if result != nil {
+		done.FinishReason = result.FinishReason
+	}
+	gen.Send(EventDone, done)
+}
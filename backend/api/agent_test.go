@@ -0,0 +1,234 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nnk/97-aic/backend/agent"
+	"github.com/nnk/97-aic/backend/provider"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+func newTestAgentHandler(t *testing.T, p provider.Provider) (*AgentHandler, *storage.Storage) {
+	t.Helper()
+	store := newTestStore(t)
+	pm := provider.NewManager()
+	pm.Register(p.Name(), p)
+	tools := agent.NewRegistry(time.Second)
+	if err := tools.Register(agent.CalculatorTool{}); err != nil {
+		t.Fatal(err)
+	}
+	return NewAgentHandler(pm, store, agent.New(tools, store, 3), NewGenerationRegistry(time.Minute)), store
+}
+
+func TestAgentStreamsTypedEventsAndStoresAnswerOnActiveBranch(t *testing.T) {
+	p := newScriptedProvider(
+		scriptedTurn{toolCalls: []provider.ToolCall{{ID: "call_0", Name: "calculator", Arguments: `{"expression":"2350 * 0.17"}`}}},
+		scriptedTurn{content: "Получится 399.5", usage: &provider.Usage{InputTokens: 40, OutputTokens: 6, TotalTokens: 46, Source: provider.UsageSourceProvider}},
+	)
+	h, store := newTestAgentHandler(t, p)
+
+	// Сессия с двумя ветками: активна вторая, первая в историю попасть не должна
+	const session = "s-agent"
+	q := addMessage(t, store, storage.NewMessage{SessionID: session, Role: storage.RoleUser, Content: "Привет"})
+	addMessage(t, store, storage.NewMessage{SessionID: session, Role: storage.RoleAssistant, Content: "старый ответ", ParentID: &q})
+	active := addMessage(t, store, storage.NewMessage{SessionID: session, Role: storage.RoleAssistant, Content: "новый ответ", ParentID: &q})
+	if _, err := store.SetActiveBranch(session, active); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	body := `{"message":"Сколько будет 17% от 2350?","session_id":"s-agent","use_history":true,"provider":"scripted","temperature":0.3}`
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v2/agent", strings.NewReader(body)))
+
+	if got := rec.Header().Get("X-SSE-Protocol"); got != "2" {
+		t.Fatalf("X-SSE-Protocol = %q", got)
+	}
+	if strings.Contains(rec.Body.String(), "[DONE]") {
+		t.Error("в потоке протокола 2 остался кадр [DONE]")
+	}
+	frames := readSSE(t, strings.NewReader(rec.Body.String()))
+
+	var events []string
+	for i, f := range frames {
+		events = append(events, f.Event)
+		if want := i + 1; f.ID != strconv.Itoa(want) {
+			t.Errorf("id события %d = %q", i, f.ID)
+		}
+	}
+	wantEvents := []string{
+		EventMeta,
+		EventStep, EventToolCall, EventToolResult,
+		EventStep, EventDelta, EventDelta,
+		EventUsage, EventResult, EventDone,
+	}
+	if !reflect.DeepEqual(events, wantEvents) {
+		t.Fatalf("события = %v, ожидалось %v", events, wantEvents)
+	}
+
+	var meta MetaEvent
+	decodeFrame(t, frames[0], &meta)
+	if meta.Protocol != SSEProtocolVersion || meta.RunID == "" || meta.SessionID != session || meta.Provider != "scripted" {
+		t.Errorf("meta = %+v", meta)
+	}
+	var delta DeltaEvent
+	decodeFrame(t, frames[5], &delta)
+	if delta.Content != "Получится " {
+		t.Errorf("delta = %+v", delta)
+	}
+	var usage UsageEvent
+	decodeFrame(t, frames[7], &usage)
+	if usage.InputTokens < 40 || usage.OutputTokens < 6 {
+		t.Errorf("usage = %+v", usage)
+	}
+	var done DoneEvent
+	decodeFrame(t, frames[len(frames)-1], &done)
+	if done.FinishReason != "stop" || done.Provider != "scripted" || done.Model != "test-model" {
+		t.Errorf("done = %+v", done)
+	}
+
+	// История для модели — активная ветка
+	wantHistory := []provider.Message{{Role: storage.RoleUser, Content: "Привет"}, {Role: storage.RoleAssistant, Content: "новый ответ"}}
+	if got := p.history(0); !reflect.DeepEqual(got[:2], wantHistory) {
+		t.Errorf("история = %+v", got)
+	}
+
+	// Вопрос продолжил активную ветку, ответ сохранен под ним с происхождением и ссылкой на лог
+	leaf, err := store.ActiveLeafID(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	branch, err := store.GetBranch(session, leaf, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branch) != 4 {
+		t.Fatalf("ветка из %d сообщений: %+v", len(branch), branch)
+	}
+	question, answer := branch[2], branch[3]
+	if question.Role != storage.RoleUser || question.ParentID != active {
+		t.Errorf("вопрос = %+v, ожидался родитель %d", question, active)
+	}
+	if answer.Role != storage.RoleAssistant || answer.Content != "Получится 399.5" || answer.ParentID != question.ID || answer.FinishReason != "stop" {
+		t.Errorf("ответ = %+v", answer)
+	}
+	if answer.Provider != "scripted" || answer.Model != "test-model" ||
+		answer.TokensInput == nil || *answer.TokensInput != usage.InputTokens ||
+		answer.Temperature == nil || *answer.Temperature != 0.3 ||
+		answer.Cost == nil || answer.DurationMs == nil {
+		t.Errorf("происхождение ответа = %+v", answer.Provenance)
+	}
+	logs, err := store.GetRequestLogs(session, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || answer.RequestLogID == nil || *answer.RequestLogID != logs[0].ID {
+		t.Errorf("request_log_id = %v, логи = %+v", answer.RequestLogID, logs)
+	}
+}
+
+func TestAgentErrorEndsWithDone(t *testing.T) {
+	h, store := newTestAgentHandler(t, newScriptedProvider(scriptedTurn{err: errors.New("провайдер недоступен")}))
+
+	rec := httptest.NewRecorder()
+	body := `{"message":"Привет","session_id":"s-err","provider":"scripted","tools":["calculator"]}`
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v2/agent", strings.NewReader(body)))
+
+	frames := readSSE(t, strings.NewReader(rec.Body.String()))
+	if len(frames) < 3 || frames[len(frames)-2].Event != EventError || frames[len(frames)-1].Event != EventDone {
+		t.Fatalf("события: %+v", frames)
+	}
+	var ev ErrorEvent
+	decodeFrame(t, frames[len(frames)-2], &ev)
+	if !strings.Contains(ev.Message, "провайдер недоступен") {
+		t.Errorf("сообщение об ошибке = %q", ev.Message)
+	}
+
+	// Вопрос сохранен, ответа нет
+	messages, err := store.GetMessages("s-err", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Role != storage.RoleUser {
+		t.Errorf("сообщения = %+v", messages)
+	}
+}
+
+// Обрыв соединения не прерывает запуск: инструменты уже могли выполниться,
+// поэтому агент доводит цикл до конца и сохраняет ответ, как генерация /api/v2/chat
+func TestAgentSurvivesClientDisconnect(t *testing.T) {
+	p := newScriptedProvider(
+		scriptedTurn{toolCalls: []provider.ToolCall{{ID: "call_0", Name: "calculator", Arguments: `{"expression":"6 * 7"}`}}},
+		scriptedTurn{content: "Ответ 42"},
+	)
+	h, store := newTestAgentHandler(t, p)
+
+	rec := httptest.NewRecorder()
+	body := `{"message":"Сколько будет 6*7?","session_id":"s-gone","provider":"scripted"}`
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // клиент отключился сразу после запроса
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v2/agent", strings.NewReader(body)).WithContext(ctx))
+
+	gen := h.Generations.Get(rec.Header().Get("X-Generation-ID"))
+	if gen == nil {
+		t.Fatal("запуск не зарегистрирован как генерация")
+	}
+	waitGeneration(t, gen)
+
+	messages, err := store.GetMessages("s-gone", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[1].Content != "Ответ 42" {
+		t.Fatalf("сообщения = %+v", messages)
+	}
+
+	// Пропущенные события дочитываются через буфер генерации
+	events, done, _ := gen.Since(0)
+	if !done || len(events) == 0 || events[len(events)-1].Event != EventDone {
+		t.Fatalf("буфер генерации: done=%v, событий %d", done, len(events))
+	}
+	var meta MetaEvent
+	if err := json.Unmarshal(events[0].Data, &meta); err != nil {
+		t.Fatal(err)
+	}
+	steps, err := store.GetAgentSteps(meta.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 3 {
+		t.Errorf("шагов агента = %d, ожидалось 3: %+v", len(steps), steps)
+	}
+}
+
+func TestAgentTemperatureProvenance(t *testing.T) {
+	for name, tt := range map[string]struct {
+		body string
+		want *float64
+	}{
+		"не задана": {`{"message":"Привет","session_id":"s-t","provider":"scripted"}`, nil},
+		"задана":    {`{"message":"Привет","session_id":"s-t","provider":"scripted","temperature":0.7}`, requestTemperature(0.7)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h, store := newTestAgentHandler(t, newScriptedProvider(scriptedTurn{content: "Привет!"}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v2/agent", strings.NewReader(tt.body)))
+
+			messages, err := store.GetMessages("s-t", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := messages[len(messages)-1].Temperature
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("temperature = %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/nnk/97-aic/backend/config"
+	"github.com/nnk/97-aic/backend/logger"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+type contextKey int
+
+const (
+	userContextKey     contextKey = iota // *storage.User запроса
+	allUsersContextKey                   // запрос пришел через /api/v2/admin/* — без ограничения владельцем
+)
+
+// AuthMiddleware проверяет API-ключ запросов к /api/* и кладет пользователя в контекст.
+// Статика и /health открыты; при выключенной авторизации пропускает все запросы.
+func AuthMiddleware(cfg *config.Config, store storage.Store, next http.Handler) http.Handler {
+	if !cfg.Auth.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := r.Header.Get("X-API-Key")
+		if auth := r.Header.Get("Authorization"); key == "" && strings.HasPrefix(auth, "Bearer ") {
+			key = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+		}
+		if key == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Требуется API-ключ", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := store.UserByAPIKey(key)
+		if err != nil {
+			logger.Error("ошибка проверки API-ключа", "error", err)
+			http.Error(w, "Ошибка проверки API-ключа", http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Недействительный API-ключ", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+	})
+}
+
+// UserFromContext пользователь запроса или nil, если авторизация выключена
+func UserFromContext(ctx context.Context) *storage.User {
+	user, _ := ctx.Value(userContextKey).(*storage.User)
+	return user
+}
+
+// RequireAdmin пропускает только администраторов (при выключенной авторизации — всех)
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := UserFromContext(r.Context()); user != nil && !user.IsAdmin {
+			http.Error(w, "Требуются права администратора", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdminView отдает обработчик пользовательского API под mount (/api/v2/admin/...) для
+// администраторов без ограничения владельцем: путь mount заменяется на target.
+// Ответы те же, что у target, но по всем пользователям (?user_id= — одного пользователя).
+func AdminView(mount, target string, next http.Handler) http.Handler {
+	return RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := *r.URL
+		u.Path = target + strings.TrimPrefix(r.URL.Path, mount)
+		u.RawPath = ""
+		r2 := r.WithContext(context.WithValue(r.Context(), allUsersContextKey, true))
+		r2.URL = &u
+		next.ServeHTTP(w, r2)
+	}))
+}
+
+// ownerScope пользователь, чьими данными ограничен запрос: "" — без ограничения
+// (авторизация выключена или /api/v2/admin/* без ?user_id=)
+func ownerScope(r *http.Request) string {
+	if allUsers, _ := r.Context().Value(allUsersContextKey).(bool); allUsers {
+		return r.URL.Query().Get("user_id")
+	}
+	if user := UserFromContext(r.Context()); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
+// contextUserID id пользователя запроса для записи владельца ("" — авторизация выключена)
+func contextUserID(ctx context.Context) string {
+	if user := UserFromContext(ctx); user != nil {
+		return user.ID
+	}
+	return ""
+}
+
+// authorizeSession проверяет, что сессия принадлежит пользователю запроса. Чужая сессия
+// неотличима от несуществующей (404); несуществующая пропускается — чтение вернет пустой результат.
+func authorizeSession(w http.ResponseWriter, r *http.Request, store storage.Store, sessionID string) bool {
+	if allUsers, _ := r.Context().Value(allUsersContextKey).(bool); allUsers {
+		return true
+	}
+	user := UserFromContext(r.Context())
+	if user == nil {
+		return true
+	}
+
+	owner, found, err := store.SessionOwner(sessionID)
+	if err != nil {
+		logger.Error("ошибка проверки владельца сессии", "error", err, "session_id", sessionID)
+		http.Error(w, "Ошибка получения сессии", http.StatusInternalServerError)
+		return false
+	}
+	if found && owner != user.ID {
+		http.Error(w, "Сессия не найдена", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// claimSession перед записью в сессию: новая сессия закрепляется за пользователем запроса,
+// в чужую писать нельзя (404, как для несуществующей)
+func claimSession(w http.ResponseWriter, r *http.Request, store storage.Store, sessionID string) bool {
+	user := UserFromContext(r.Context())
+	if user == nil || store == nil {
+		return true
+	}
+
+	owner, err := store.ClaimSession(sessionID, user.ID)
+	if err != nil {
+		logger.Error("ошибка проверки владельца сессии", "error", err, "session_id", sessionID)
+		http.Error(w, "Ошибка получения сессии", http.StatusInternalServerError)
+		return false
+	}
+	if owner != user.ID {
+		http.Error(w, "Сессия не найдена", http.StatusNotFound)
+		return false
+	}
+	return true
+}
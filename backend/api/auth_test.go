@@ -0,0 +1,361 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nnk/97-aic/backend/agent"
+	"github.com/nnk/97-aic/backend/provider"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+// authFixture сервер с включенной авторизацией и маршрутами как в main.go:
+// пользователи alice и bob, администратор, у каждого свой ключ
+type authFixture struct {
+	handler           http.Handler
+	store             *storage.Storage
+	gens              *GenerationRegistry
+	alice, bob, admin *storage.User
+	aliceKey, bobKey  string
+	adminKey          string
+	bobGeneration     string
+}
+
+func newAuthFixture(t *testing.T) *authFixture {
+	t.Helper()
+	store := newTestStore(t)
+	cfg := *testConfig
+	cfg.Auth.Enabled = true
+
+	f := &authFixture{store: store, gens: NewGenerationRegistry(time.Minute)}
+	f.alice, f.aliceKey = createTestUser(t, store, "alice", false)
+	f.bob, f.bobKey = createTestUser(t, store, "bob", false)
+	f.admin, f.adminKey = createTestUser(t, store, "admin", true)
+
+	pm := provider.NewManager()
+	pm.Register("scripted", newScriptedProvider(scriptedTurn{content: "ответ"}))
+	tools := agent.NewRegistry(time.Second)
+
+	sessions := NewSessionsHandler(store, nil, &cfg)
+	history := NewHistoryHandler(store, &cfg)
+	logs := NewLogsHandler(store, &cfg)
+	search := NewSearchHandler(store, &cfg)
+	stats := NewStatsHandler(store)
+	generations := NewGenerationsHandler(f.gens)
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/v2/agent", NewAgentHandler(pm, store, agent.New(tools, store, 3), f.gens))
+	mux.Handle("/api/v2/generations", generations)
+	mux.Handle("/api/v2/generations/", generations)
+	mux.Handle("/api/v2/sessions", sessions)
+	mux.Handle("/api/v2/sessions/", sessions)
+	mux.Handle("/api/v2/search", search)
+	mux.Handle("/api/v2/stats", stats)
+	mux.Handle("/api/history", history)
+	mux.Handle("/api/logs", logs)
+	mux.Handle("/api/v2/admin/sessions", AdminView("/api/v2/admin/sessions", "/api/v2/sessions", sessions))
+	mux.Handle("/api/v2/admin/sessions/", AdminView("/api/v2/admin/sessions", "/api/v2/sessions", sessions))
+	mux.Handle("/api/v2/admin/history", AdminView("/api/v2/admin/history", "/api/history", history))
+	mux.Handle("/api/v2/admin/logs", AdminView("/api/v2/admin/logs", "/api/logs", logs))
+	mux.Handle("/api/v2/admin/search", AdminView("/api/v2/admin/search", "/api/v2/search", search))
+	mux.Handle("/api/v2/admin/stats", AdminView("/api/v2/admin/stats", "/api/v2/stats", stats))
+	mux.Handle("/api/v2/admin/generations", AdminView("/api/v2/admin/generations", "/api/v2/generations", generations))
+	mux.Handle("/api/v2/admin/generations/", AdminView("/api/v2/admin/generations", "/api/v2/generations", generations))
+	f.handler = AuthMiddleware(&cfg, store, mux)
+
+	// У каждого пользователя сессия с сообщением, логом и уникальным словом для поиска;
+	// legacy — сессия без владельца, созданная до включения авторизации
+	seed := []struct{ session, userID, content string }{
+		{"alice-session", f.alice.ID, "яблоко от alice"},
+		{"bob-session", f.bob.ID, "секрет от bob"},
+		{"legacy", "", "старое сообщение"},
+	}
+	for _, s := range seed {
+		if s.userID != "" {
+			if _, err := store.ClaimSession(s.session, s.userID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		addMessage(t, store, storage.NewMessage{SessionID: s.session, Role: storage.RoleUser, Content: s.content})
+		if _, err := store.SaveRequestLog(storage.NewRequestLog{SessionID: s.session, UserID: s.userID, Provider: "groq", StatusCode: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gen, _ := f.gens.Start("bob-session", f.bob.ID)
+	gen.Send("delta", map[string]string{"content": "секрет"})
+	f.bobGeneration = gen.ID
+	return f
+}
+
+func createTestUser(t *testing.T, store *storage.Storage, name string, isAdmin bool) (*storage.User, string) {
+	t.Helper()
+	user, err := store.CreateUser(name, isAdmin)
+	if err != nil || user == nil {
+		t.Fatalf("создание пользователя %s: %v", name, err)
+	}
+	_, key, err := store.CreateAPIKey(user.ID, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, key
+}
+
+// request запрос с ключом key ("" — без ключа)
+func (f *authFixture) request(key, method, url, body string) *http.Request {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	return req
+}
+
+func (f *authFixture) serve(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	f.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func (f *authFixture) do(key, method, url, body string) *httptest.ResponseRecorder {
+	return f.serve(f.request(key, method, url, body))
+}
+
+// get выполняет GET с ключом и разбирает ответ 200 в v
+func (f *authFixture) get(t *testing.T, key, url string, v interface{}) {
+	t.Helper()
+	rec := f.do(key, http.MethodGet, url, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", url, rec.Code, rec.Body.String())
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("GET %s: %v (%s)", url, err, rec.Body.String())
+	}
+}
+
+func (f *authFixture) sessionIDs(t *testing.T, key, url string) []string {
+	t.Helper()
+	var resp struct {
+		Sessions []storage.Session `json:"sessions"`
+	}
+	f.get(t, key, url, &resp)
+	ids := []string{}
+	for _, s := range resp.Sessions {
+		ids = append(ids, s.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (f *authFixture) logSessions(t *testing.T, key, url string) []string {
+	t.Helper()
+	var logs []storage.RequestLog
+	f.get(t, key, url, &logs)
+	ids := []string{}
+	for _, l := range logs {
+		ids = append(ids, l.SessionID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (f *authFixture) searchCount(t *testing.T, key, url string) int {
+	t.Helper()
+	var resp struct {
+		Results []storage.SearchResult `json:"results"`
+	}
+	f.get(t, key, url, &resp)
+	return len(resp.Results)
+}
+
+func (f *authFixture) statsRequests(t *testing.T, key, url string) int {
+	t.Helper()
+	var stats storage.Stats
+	f.get(t, key, url, &stats)
+	return stats.Total.Requests
+}
+
+func (f *authFixture) generationCount(t *testing.T, key, url string) int {
+	t.Helper()
+	var resp struct {
+		Generations []GenerationInfo `json:"generations"`
+	}
+	f.get(t, key, url, &resp)
+	return len(resp.Generations)
+}
+
+func TestAuthRequiresValidKey(t *testing.T) {
+	f := newAuthFixture(t)
+	for _, key := range []string{"", "aic_неизвестный"} {
+		if rec := f.do(key, http.MethodGet, "/api/v2/sessions", ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("ключ %q: %d, ожидался 401", key, rec.Code)
+		}
+	}
+
+	// X-API-Key равноценен Bearer
+	req := f.request("", http.MethodGet, "/api/v2/sessions", "")
+	req.Header.Set("X-API-Key", f.aliceKey)
+	if rec := f.serve(req); rec.Code != http.StatusOK {
+		t.Errorf("X-API-Key: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// Чужие сессии неотличимы от несуществующих: чтение и запись отвечают 404, списки их не содержат
+func TestAuthIsolatesUsers(t *testing.T) {
+	f := newAuthFixture(t)
+
+	// Свои данные видны
+	if got := f.sessionIDs(t, f.bobKey, "/api/v2/sessions"); !reflect.DeepEqual(got, []string{"bob-session"}) {
+		t.Errorf("сессии bob = %v", got)
+	}
+	if rec := f.do(f.bobKey, http.MethodGet, "/api/v2/sessions/bob-session/export", ""); rec.Code != http.StatusOK {
+		t.Errorf("экспорт своей сессии: %d", rec.Code)
+	}
+	if n := f.searchCount(t, f.bobKey, "/api/v2/search?q=секрет"); n != 1 {
+		t.Errorf("bob нашел %d своих сообщений", n)
+	}
+	if n := f.generationCount(t, f.bobKey, "/api/v2/generations"); n != 1 {
+		t.Errorf("генераций bob: %d", n)
+	}
+
+	// Сессия bob и сессия без владельца для alice не существуют
+	for _, session := range []string{"bob-session", "legacy"} {
+		t.Run(session, func(t *testing.T) {
+			requests := []struct{ method, url, body string }{
+				{http.MethodGet, "/api/v2/sessions/" + session, ""},
+				{http.MethodGet, "/api/v2/sessions/" + session + "/messages", ""},
+				{http.MethodGet, "/api/v2/sessions/" + session + "/export", ""},
+				{http.MethodGet, "/api/v2/sessions/" + session + "/export?format=md", ""},
+				{http.MethodPatch, "/api/v2/sessions/" + session, `{"title":"чужая"}`},
+				{http.MethodPost, "/api/v2/sessions/" + session + "/branch", `{"leaf_id":1}`},
+				{http.MethodDelete, "/api/v2/sessions/" + session, ""},
+				{http.MethodGet, "/api/history?session_id=" + session, ""},
+				{http.MethodPost, "/api/v2/agent", `{"message":"привет","session_id":"` + session + `","provider":"scripted"}`},
+			}
+			for _, req := range requests {
+				rec := f.do(f.aliceKey, req.method, req.url, req.body)
+				if rec.Code != http.StatusNotFound {
+					t.Errorf("%s %s: %d %s, ожидался 404", req.method, req.url, rec.Code, rec.Body.String())
+				}
+				if strings.Contains(rec.Body.String(), "секрет") || strings.Contains(rec.Body.String(), "старое") {
+					t.Errorf("%s %s раскрывает чужие данные: %s", req.method, req.url, rec.Body.String())
+				}
+			}
+			if got := f.logSessions(t, f.aliceKey, "/api/logs?session_id="+session); len(got) != 0 {
+				t.Errorf("логи чужой сессии: %v", got)
+			}
+
+			// Ни удаление, ни запись не тронули сессию
+			sess, err := f.store.GetSession(session)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sess == nil || sess.Title != "" || sess.MessageCount != 1 {
+				t.Errorf("сессия после запросов alice = %+v", sess)
+			}
+		})
+	}
+
+	if got := f.sessionIDs(t, f.aliceKey, "/api/v2/sessions"); !reflect.DeepEqual(got, []string{"alice-session"}) {
+		t.Errorf("сессии alice = %v", got)
+	}
+	if got := f.logSessions(t, f.aliceKey, "/api/logs"); !reflect.DeepEqual(got, []string{"alice-session"}) {
+		t.Errorf("логи alice = %v", got)
+	}
+	for _, q := range []string{"секрет", "старое"} {
+		if n := f.searchCount(t, f.aliceKey, "/api/v2/search?q="+q); n != 0 {
+			t.Errorf("alice нашла %d чужих сообщений по %q", n, q)
+		}
+	}
+	if n := f.statsRequests(t, f.aliceKey, "/api/v2/stats"); n != 1 {
+		t.Errorf("в статистике alice %d запросов, ожидался 1", n)
+	}
+
+	// Чужая генерация не видна ни в списке, ни по id, и остановить ее нельзя
+	if n := f.generationCount(t, f.aliceKey, "/api/v2/generations"); n != 0 {
+		t.Errorf("alice видит %d чужих генераций", n)
+	}
+	for _, req := range []struct{ method, action string }{
+		{http.MethodGet, ""}, {http.MethodGet, "/stream"}, {http.MethodPost, "/cancel"},
+	} {
+		url := "/api/v2/generations/" + f.bobGeneration + req.action
+		// Контекст отменен заранее: если поток все же откроется, он не будет ждать конца генерации
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if rec := f.serve(f.request(f.aliceKey, req.method, url, "").WithContext(ctx)); rec.Code != http.StatusNotFound {
+			t.Errorf("%s %s: %d, ожидался 404", req.method, url, rec.Code)
+		}
+	}
+	if f.gens.Get(f.bobGeneration).Stopped() {
+		t.Error("alice остановила генерацию bob")
+	}
+}
+
+// /api/v2/admin/* отдает данные всех пользователей, включая сессии без владельца; ?user_id= сужает выборку
+func TestAuthAdminView(t *testing.T) {
+	f := newAuthFixture(t)
+	all := []string{"alice-session", "bob-session", "legacy"}
+
+	// Обычному пользователю админские маршруты закрыты
+	for _, url := range []string{"/api/v2/admin/sessions", "/api/v2/admin/sessions/bob-session/messages", "/api/v2/admin/logs", "/api/v2/admin/generations"} {
+		if rec := f.do(f.aliceKey, http.MethodGet, url, ""); rec.Code != http.StatusForbidden {
+			t.Errorf("alice GET %s: %d, ожидался 403", url, rec.Code)
+		}
+	}
+
+	if got := f.sessionIDs(t, f.adminKey, "/api/v2/admin/sessions"); !reflect.DeepEqual(got, all) {
+		t.Errorf("все сессии = %v", got)
+	}
+	if got := f.sessionIDs(t, f.adminKey, "/api/v2/admin/sessions?user_id="+f.bob.ID); !reflect.DeepEqual(got, []string{"bob-session"}) {
+		t.Errorf("сессии bob = %v", got)
+	}
+	if got := f.logSessions(t, f.adminKey, "/api/v2/admin/logs"); !reflect.DeepEqual(got, all) {
+		t.Errorf("все логи = %v", got)
+	}
+	if got := f.logSessions(t, f.adminKey, "/api/v2/admin/logs?user_id="+f.alice.ID); !reflect.DeepEqual(got, []string{"alice-session"}) {
+		t.Errorf("логи alice = %v", got)
+	}
+	if n := f.searchCount(t, f.adminKey, "/api/v2/admin/search?q=старое"); n != 1 {
+		t.Errorf("поиск по всем нашел %d сообщений без владельца", n)
+	}
+	if n := f.searchCount(t, f.adminKey, "/api/v2/admin/search?q=секрет&user_id="+f.alice.ID); n != 0 {
+		t.Errorf("поиск с user_id alice нашел %d сообщений bob", n)
+	}
+	if n := f.statsRequests(t, f.adminKey, "/api/v2/admin/stats"); n != 3 {
+		t.Errorf("в общей статистике %d запросов, ожидалось 3", n)
+	}
+	if n := f.generationCount(t, f.adminKey, "/api/v2/admin/generations"); n != 1 {
+		t.Errorf("генераций всех пользователей: %d", n)
+	}
+
+	// Чужие и legacy-сессии открываются целиком
+	for _, session := range []string{"bob-session", "legacy"} {
+		for _, url := range []string{
+			"/api/v2/admin/sessions/" + session,
+			"/api/v2/admin/sessions/" + session + "/messages",
+			"/api/v2/admin/sessions/" + session + "/export",
+			"/api/v2/admin/history?session_id=" + session,
+		} {
+			if rec := f.do(f.adminKey, http.MethodGet, url, ""); rec.Code != http.StatusOK {
+				t.Errorf("GET %s: %d %s", url, rec.Code, rec.Body.String())
+			}
+		}
+	}
+	if rec := f.do(f.adminKey, http.MethodGet, "/api/v2/admin/generations/"+f.bobGeneration, ""); rec.Code != http.StatusOK {
+		t.Errorf("генерация bob через admin: %d", rec.Code)
+	}
+
+	// Через обычные маршруты администратор видит только свое
+	if got := f.sessionIDs(t, f.adminKey, "/api/v2/sessions"); len(got) != 0 {
+		t.Errorf("сессии администратора = %v", got)
+	}
+	if rec := f.do(f.adminKey, http.MethodGet, "/api/v2/sessions/legacy", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("legacy через обычный маршрут: %d, ожидался 404", rec.Code)
+	}
+}
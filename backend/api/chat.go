@@ -16,7 +16,7 @@ import (
 // ChatHandler обрабатывает запросы к /api/chat
 type ChatHandler struct {
 	GigaChatClient *gigachat.Client
-	Storage        *storage.Storage
+	Storage        storage.Store
 }
 
 // ChatRequest представляет входящий запрос
@@ -29,7 +29,7 @@ type ChatRequest struct {
 }
 
 // NewChatHandler создает новый обработчик чата
-func NewChatHandler(client *gigachat.Client, store *storage.Storage) *ChatHandler {
+func NewChatHandler(client *gigachat.Client, store storage.Store) *ChatHandler {
 	return &ChatHandler{
 		GigaChatClient: client,
 		Storage:        store,
@@ -86,6 +86,9 @@ func (h *ChatHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if req.SessionID == "" {
 		req.SessionID = fmt.Sprintf("session_%d", time.Now().UnixNano())
 	}
+	if !claimSession(w, r, h.Storage, req.SessionID) {
+		return
+	}
 
 	// Загружаем историю сообщений если нужно
 	var history []gigachat.Message
@@ -188,7 +191,16 @@ func (h *ChatHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			"content": fullResponse,
 			"status":  statusCode,
 		})
-		if _, err := h.Storage.SaveRequestLog(req.SessionID, string(requestJSON), string(responseJSON), statusCode, durationMs, nil, nil, nil, nil); err != nil {
+		if _, err := h.Storage.SaveRequestLog(storage.NewRequestLog{
+			SessionID:    req.SessionID,
+			UserID:       contextUserID(r.Context()),
+			Provider:     "gigachat",
+			Model:        gigachat.DefaultModel,
+			RequestJSON:  string(requestJSON),
+			ResponseJSON: string(responseJSON),
+			StatusCode:   statusCode,
+			DurationMs:   durationMs,
+		}); err != nil {
 			logger.Error("ошибка сохранения лога запроса", "error", err)
 		}
 	}
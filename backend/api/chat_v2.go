@@ -19,8 +19,9 @@ import (
 // ChatHandlerV2 обрабатывает запросы к /api/v2/chat с поддержкой провайдеров
 type ChatHandlerV2 struct {
 	ProviderManager *provider.Manager
-	Storage         *storage.Storage
+	Storage         storage.Store
 	Config          *config.Config
+	Generations     *GenerationRegistry
 }
 
 // ChatRequestV2 запрос к API v2
@@ -47,21 +48,33 @@ type ChatRequestV2 struct {
 	// Параметры генерации
 	MaxTokens   int     `json:"max_tokens,omitempty"`
 	Temperature float64 `json:"temperature,omitempty"`
+
+	// Инструменты: вызовы стримятся событиями tool_call, выполняет их клиент
+	Tools      []provider.Tool `json:"tools,omitempty"`
+	ToolChoice string          `json:"tool_choice,omitempty"` // auto, none, required или имя инструмента
+
+	// Ветки диалога: новый вариант вопроса становится соседней веткой исходного,
+	// перегенерированный ответ — соседом исходного ответа (message при этом не нужен)
+	EditMessageID       int64 `json:"edit_message_id,omitempty"`
+	RegenerateMessageID int64 `json:"regenerate_message_id,omitempty"`
+
+	// LegacyStream старый формат потока (безымянные data-кадры и [DONE]) для старых клиентов
+	LegacyStream bool `json:"legacy_stream,omitempty"`
 }
 
 // NewChatHandlerV2 создает новый обработчик
-func NewChatHandlerV2(pm *provider.Manager, store *storage.Storage, cfg *config.Config) *ChatHandlerV2 {
+func NewChatHandlerV2(pm *provider.Manager, store storage.Store, cfg *config.Config, gens *GenerationRegistry) *ChatHandlerV2 {
 	return &ChatHandlerV2{
 		ProviderManager: pm,
 		Storage:         store,
 		Config:          cfg,
+		Generations:     gens,
 	}
 }
 
 // ServeHTTP обрабатывает HTTP запросы
 func (h *ChatHandlerV2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
-	ctx := r.Context()
 
 	if r.Method != http.MethodPost {
 		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
@@ -81,21 +94,37 @@ func (h *ChatHandlerV2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Message == "" {
+	if req.Message == "" && req.RegenerateMessageID == 0 {
 		http.Error(w, "Поле message обязательно", http.StatusBadRequest)
 		return
 	}
 
-	// Получаем провайдер
-	p, err := h.ProviderManager.Get(req.Provider)
+	// Сессия закрепляется за пользователем до чтения истории: в чужую писать нельзя
+	if req.SessionID != "" && !claimSession(w, r, h.Storage, req.SessionID) {
+		return
+	}
+
+	// Место в дереве сообщений: конец ветки для истории и родитель нового сообщения
+	branch, status, err := h.resolveBranch(&req)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Ошибка провайдера: %v", err), http.StatusBadRequest)
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	// Устанавливаем модель если указана
-	if req.Model != "" {
-		p.SetModel(req.Model)
+	// Параметры, не заданные в запросе, берем из настроек сессии
+	if req.SessionID != "" && h.Storage != nil {
+		if sess, err := h.Storage.GetSession(req.SessionID); err != nil {
+			logger.Warn("ошибка загрузки сессии", "session_id", req.SessionID, "error", err)
+		} else if sess != nil {
+			applySessionDefaults(&req, sess)
+		}
+	}
+
+	// Получаем провайдер, привязанный к модели запроса (общий экземпляр не меняется)
+	p, err := h.ProviderManager.ForModel(req.Provider, req.Model)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка провайдера: %v", err), http.StatusBadRequest)
+		return
 	}
 
 	logger.Info("v2 запрос",
@@ -114,6 +143,9 @@ func (h *ChatHandlerV2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Генерируем session_id
 	if req.SessionID == "" {
 		req.SessionID = fmt.Sprintf("session_%d", time.Now().UnixNano())
+		if !claimSession(w, r, h.Storage, req.SessionID) {
+			return
+		}
 	}
 
 	// Загружаем историю
@@ -126,14 +158,11 @@ func (h *ChatHandlerV2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			summaryText = summaryMsg.Content
 		}
 
-		messages, err := h.Storage.GetMessages(req.SessionID, 1000)
+		messages, err := h.Storage.GetBranch(req.SessionID, branch.historyLeafID, 1000)
 		if err != nil {
 			logger.Warn("ошибка загрузки истории", "error", err)
 		} else {
 			for _, msg := range messages {
-				if msg.Role == storage.RoleSummary {
-					continue
-				}
 				history = append(history, provider.Message{
 					Role:    msg.Role,
 					Content: msg.Content,
@@ -142,33 +171,93 @@ func (h *ChatHandlerV2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Сохраняем сообщение пользователя
-	if h.Storage != nil {
-		h.Storage.SaveMessage(req.SessionID, "user", req.Message)
+	// Сохраняем сообщение пользователя (при перегенерации вопрос уже сохранен)
+	if h.Storage != nil && !branch.regenerate {
+		userMsg, err := h.Storage.AddMessage(storage.NewMessage{
+			SessionID: req.SessionID,
+			Role:      storage.RoleUser,
+			Content:   req.Message,
+			ParentID:  &branch.historyLeafID,
+		})
+		if err != nil {
+			logger.Warn("ошибка сохранения сообщения", "session_id", req.SessionID, "error", err)
+		} else {
+			branch.answerParentID = userMsg.ID
+		}
 	}
 
-	// Настройка streaming
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+	// При перегенерации активной становится ветка вопроса: новый ответ займет место
+	// старого, если до его сохранения пользователь не переключит ветку
+	if h.Storage != nil && branch.regenerate {
+		if err := h.Storage.SetActiveLeaf(req.SessionID, branch.answerParentID); err != nil {
+			logger.Warn("ошибка переключения ветки", "session_id", req.SessionID, "error", err)
+		}
+	}
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
+	// Настройка streaming
+	stream, err := newSSEWriter(w, req.LegacyStream)
+	if err != nil {
 		http.Error(w, "Streaming не поддерживается", http.StatusInternalServerError)
 		return
 	}
 
+	// Генерация идет независимо от соединения: при обрыве клиент переподключается
+	// к /api/v2/generations/{id}/stream с Last-Event-ID, а ответ сохраняется в любом случае
+	gen, genCtx := h.Generations.Start(req.SessionID, contextUserID(r.Context()))
+	w.Header().Set("X-Generation-ID", gen.ID)
+	gen.Send(EventMeta, MetaEvent{
+		Protocol:     SSEProtocolVersion,
+		GenerationID: gen.ID,
+		SessionID:    req.SessionID,
+		Provider:     p.Name(),
+		Model:        p.GetModel(),
+	})
+
+	go func() {
+		defer gen.Finish()
+		h.generate(genCtx, gen, &req, p, history, summaryText, branch.answerParentID, startTime)
+	}()
+
+	stream.Follow(r.Context(), gen, 0)
+}
+
+// generate выполняет запрос к модели и пишет события в буфер генерации
+func (h *ChatHandlerV2) generate(ctx context.Context, gen *Generation, req *ChatRequestV2, p provider.Provider, history []provider.Message, summaryText string, answerParentID int64, startTime time.Time) {
 	var fullResponse string
 
-	// Подготавливаем опции
-	systemPrompt := req.SystemPrompt
-	if summaryText != "" {
-		if systemPrompt != "" {
-			systemPrompt += "\n\n"
-		}
-		systemPrompt += "КРАТКОЕ РЕЗЮМЕ ПРЕДЫДУЩЕГО ДИАЛОГА (используй как контекст):\n" + summaryText
+	// Собираем контекст под окно модели: резерв под ответ, system prompt, summary и свежая история
+	tok := h.ProviderManager.Tokenizer(p)
+	assembled := historycompress.AssembleContext(historycompress.ContextRequest{
+		Tokenizer:       tok,
+		ContextLimit:    p.GetMaxTokens(),
+		MaxOutputTokens: req.MaxTokens,
+		SystemPrompt:    req.SystemPrompt,
+		ReasoningMode:   req.ReasoningMode,
+		JSONFormat:      req.JSONFormat,
+		JSONSchemaText:  req.JSONSchema,
+		Summary:         summaryText,
+		History:         history,
+		Message:         req.Message,
+	})
+	systemPrompt := assembled.SystemPrompt
+	history = assembled.History
+	contextReport := assembled.Report
+	if contextReport.Trimmed() || contextReport.Overflow {
+		logger.Warn("контекст обрезан под окно модели",
+			"session_id", req.SessionID,
+			"context_limit", contextReport.ContextLimit,
+			"reserved_output", contextReport.ReservedOutput,
+			"dropped_messages", contextReport.DroppedMessages,
+			"truncated_messages", contextReport.TruncatedMessages,
+			"summary_truncated", contextReport.SummaryTruncated,
+			"summary_dropped", contextReport.SummaryDropped,
+			"overflow", contextReport.Overflow,
+		)
 	}
 
+	// Метаданные контекста отправляем до ответа
+	gen.Send(EventContext, contextReport)
+
 	opts := &provider.ChatOptions{
 		SystemPrompt:   systemPrompt,
 		History:        history,
@@ -177,57 +266,116 @@ func (h *ChatHandlerV2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		ReasoningMode:  req.ReasoningMode,
 		JSONFormat:     req.JSONFormat,
 		JSONSchemaText: req.JSONSchema,
+		Tools:          req.Tools,
+		ToolChoice:     req.ToolChoice,
+		OnToolCallDelta: func(d provider.ToolCallDelta) error {
+			gen.Send(EventToolCall, d)
+			return nil
+		},
 	}
 
-	// Подсчитываем токены запроса перед отправкой
-	tokensInput := provider.CountTokensForMessages(systemPrompt, history, req.Message)
+	// Оценка токенов запроса перед отправкой токенизатором модели (используется, если провайдер не вернет usage)
+	estimatedInput := provider.CountTokensForMessages(tok, systemPrompt, history, req.Message)
 
-	// Отправляем запрос
-	err = p.Chat(ctx, req.Message, opts, func(chunk string) error {
+	// Отправляем запрос (с переключением на резервные провайдеры до первого чанка)
+	requestedProvider := p.Name()
+	var fallbacks []provider.FallbackEvent
+	p, result, err := h.ProviderManager.ChatWithFallback(ctx, p, req.Message, opts, func(chunk string) error {
 		fullResponse += chunk
-
-		data := map[string]string{"content": chunk}
-		jsonData, _ := json.Marshal(data)
-		fmt.Fprintf(w, "data: %s\n\n", jsonData)
-		flusher.Flush()
+		gen.Send(EventDelta, DeltaEvent{Content: chunk})
 		return nil
+	}, func(ev provider.FallbackEvent) {
+		fallbacks = append(fallbacks, ev)
+		logger.Warn("переключение на резервный провайдер",
+			"session_id", req.SessionID,
+			"from", ev.From,
+			"to", ev.To,
+			"error", ev.Error,
+		)
+
+		gen.Send(EventFallback, ev)
 	})
 
+	// Остановка пользователем — не ошибка: сохраняем то, что модель успела написать
+	if err != nil && gen.Stopped() {
+		logger.Info("генерация остановлена", "session_id", req.SessionID, "generation_id", gen.ID, "response_length", len(fullResponse))
+		err = nil
+	}
+
 	durationMs := time.Since(startTime).Milliseconds()
 	statusCode := http.StatusOK
 
-	// Подсчитываем токены ответа (приблизительно)
-	tokensOutput := provider.CountTokens(fullResponse)
-	tokensTotal := tokensInput + tokensOutput
+	// Токены: фактические из ответа провайдера, иначе подсчет токенизатором ответившей модели
+	usage := provider.ResolveUsage(result, h.ProviderManager.Tokenizer(p), estimatedInput, fullResponse)
+	tokensInput := usage.InputTokens
+	tokensOutput := usage.OutputTokens
+	tokensTotal := usage.TotalTokens
+
+	// Строгий JSON-режим: проверяем ответ и при ошибках просим модель исправить его
+	var jsonResult *provider.JSONResult
+	savedResponse := fullResponse
+	if err == nil && !gen.Stopped() && req.JSONFormat && (result == nil || len(result.ToolCalls) == 0) {
+		jr, repairUsage, repairErr := provider.RepairJSON(ctx, p, req.Message, opts, fullResponse, h.jsonMaxRepairs(), h.ProviderManager.Tokenizer(p), func(attempt int, errs []string) {
+			logger.Info("исправление JSON-ответа", "session_id", req.SessionID, "attempt", attempt, "errors", errs)
+			gen.Send(EventJSONRepair, map[string]interface{}{"attempt": attempt, "errors": errs})
+		})
+		if repairErr != nil {
+			logger.Warn("ошибка исправления JSON-ответа", "session_id", req.SessionID, "error", repairErr)
+		}
+		if jr.SchemaError != "" {
+			logger.Warn("JSON Schema не применена, проверен только синтаксис", "session_id", req.SessionID, "error", jr.SchemaError)
+		}
+		tokensInput += repairUsage.InputTokens
+		tokensOutput += repairUsage.OutputTokens
+		tokensTotal += repairUsage.TotalTokens
+		jsonResult = &jr
+		savedResponse = jr.Content
+	}
 
 	// Вычисляем стоимость
 	cost := p.CalculateCost(tokensInput, tokensOutput)
 
+	finishReason := ""
+	if result != nil {
+		finishReason = result.FinishReason
+	}
+	if gen.Stopped() {
+		finishReason = provider.FinishReasonStopped
+	}
+
 	if err != nil {
 		logger.Error("ошибка при обработке запроса", "error", err, "duration_ms", durationMs)
 		statusCode = http.StatusInternalServerError
-		errorData := map[string]string{"error": err.Error()}
-		jsonData, _ := json.Marshal(errorData)
-		fmt.Fprintf(w, "data: %s\n\n", jsonData)
-		flusher.Flush()
+		gen.Send(EventError, ErrorEvent{Message: err.Error()})
 	} else {
-		// Сохраняем ответ
-		if h.Storage != nil && fullResponse != "" {
-			h.Storage.SaveMessage(req.SessionID, "assistant", fullResponse)
+		// Итоговые вызовы инструментов (собранные из фрагментов tool_call)
+		if result != nil && len(result.ToolCalls) > 0 {
+			gen.Send(EventToolCalls, result.ToolCalls)
+		}
+
+		// Итог JSON-режима: проверенный объект или ошибки валидации
+		if jsonResult != nil {
+			gen.Send(EventJSONResult, jsonResult)
 		}
 	}
 
 	// Логируем запрос
 	if h.Storage != nil {
 		requestJSON, _ := json.Marshal(map[string]interface{}{
-			"message":        req.Message,
-			"session_id":     req.SessionID,
-			"provider":       p.Name(),
-			"model":          p.GetModel(),
-			"reasoning_mode": req.ReasoningMode,
-			"system_prompt":  systemPrompt,
-			"tokens_input":   tokensInput,
-			"used_summary":   summaryText != "",
+			"message":            req.Message,
+			"session_id":         req.SessionID,
+			"generation_id":      gen.ID,
+			"provider":           p.Name(),
+			"model":              p.GetModel(),
+			"requested_provider": requestedProvider,
+			"fallbacks":          fallbacks,
+			"reasoning_mode":     req.ReasoningMode,
+			"system_prompt":      systemPrompt,
+			"tokens_input":       tokensInput,
+			"tokens_estimated":   estimatedInput,
+			"tokenizer":          tok.Name(),
+			"used_summary":       summaryText != "",
+			"context":            contextReport,
 		})
 
 		// Формируем response JSON с учетом ошибок
@@ -237,8 +385,19 @@ func (h *ChatHandlerV2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			"tokens_input":  tokensInput,
 			"tokens_output": tokensOutput,
 			"tokens_total":  tokensTotal,
+			"tokens_source": usage.Source,
 			"cost":          cost,
 		}
+		if finishReason != "" {
+			responseData["finish_reason"] = finishReason
+		}
+		if result != nil && len(result.ToolCalls) > 0 {
+			responseData["tool_calls"] = result.ToolCalls
+		}
+		if jsonResult != nil {
+			responseData["json_result"] = jsonResult
+			responseData["json_content"] = jsonResult.Content
+		}
 		if err != nil {
 			responseData["error"] = err.Error()
 		}
@@ -246,32 +405,82 @@ func (h *ChatHandlerV2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		responseJSON, _ := json.Marshal(responseData)
 
 		// Сохраняем логи с токенами и стоимостью
-		h.Storage.SaveRequestLog(
-			req.SessionID,
-			string(requestJSON),
-			string(responseJSON),
-			statusCode,
-			durationMs,
-			&tokensInput,
-			&tokensOutput,
-			&tokensTotal,
-			&cost,
-		)
+		requestLog, logErr := h.Storage.SaveRequestLog(storage.NewRequestLog{
+			SessionID:     req.SessionID,
+			UserID:        gen.UserID,
+			Provider:      p.Name(),
+			Model:         p.GetModel(),
+			ReasoningMode: req.ReasoningMode,
+			RequestJSON:   string(requestJSON),
+			ResponseJSON:  string(responseJSON),
+			StatusCode:    statusCode,
+			DurationMs:    durationMs,
+			TokensInput:   &tokensInput,
+			TokensOutput:  &tokensOutput,
+			TokensTotal:   &tokensTotal,
+			Cost:          &cost,
+		})
+		if logErr != nil {
+			logger.Warn("ошибка сохранения лога запроса", "session_id", req.SessionID, "error", logErr)
+		}
+
+		// Сохраняем ответ (в JSON-режиме — последний вариант после исправлений; остановленный — частично)
+		// с происхождением: провайдер, модель, параметры, токены и ссылка на лог запроса
+		if err == nil && savedResponse != "" {
+			provenance := storage.Provenance{
+				Provider:      p.Name(),
+				Model:         p.GetModel(),
+				ReasoningMode: req.ReasoningMode,
+				TokensInput:   &tokensInput,
+				TokensOutput:  &tokensOutput,
+				Cost:          &cost,
+				DurationMs:    &durationMs,
+			}
+			provenance.Temperature = requestTemperature(req.Temperature)
+			if requestLog != nil {
+				provenance.RequestLogID = &requestLog.ID
+			}
+			if _, err := h.Storage.AddMessage(storage.NewMessage{
+				SessionID:    req.SessionID,
+				Role:         storage.RoleAssistant,
+				Content:      savedResponse,
+				FinishReason: finishReason,
+				ParentID:     &answerParentID,
+				Provenance:   provenance,
+			}); err != nil {
+				logger.Warn("ошибка сохранения ответа", "session_id", req.SessionID, "error", err)
+			}
+		}
 	}
 
 	logger.Info("v2 запрос обработан",
 		"session_id", req.SessionID,
+		"generation_id", gen.ID,
 		"provider", p.Name(),
+		"fallbacks", len(fallbacks),
 		"duration_ms", durationMs,
 		"response_length", len(fullResponse),
 		"tokens_input", tokensInput,
 		"tokens_output", tokensOutput,
 		"tokens_total", tokensTotal,
+		"tokens_source", usage.Source,
 		"cost", cost,
 	)
 
-	fmt.Fprintf(w, "data: [DONE]\n\n")
-	flusher.Flush()
+	gen.Send(EventUsage, UsageEvent{
+		InputTokens:  tokensInput,
+		OutputTokens: tokensOutput,
+		TotalTokens:  tokensTotal,
+		Source:       usage.Source,
+		Cost:         cost,
+	})
+	gen.Send(EventDone, DoneEvent{
+		FinishReason:      finishReason,
+		Provider:          p.Name(),
+		Model:             p.GetModel(),
+		RequestedProvider: requestedProvider,
+		DurationMs:        durationMs,
+	})
 
 	// Компрессия истории (асинхронно, чтобы не задерживать ответ)
 	compressEnabled := h.Config != nil && h.Config.HistoryCompression.Enabled
@@ -294,6 +503,124 @@ func (h *ChatHandlerV2) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 		}(req.SessionID)
 	}
+
+	// Название сессии по первому обмену сообщениями (асинхронно)
+	if h.autoTitle() && h.Storage != nil && err == nil && savedResponse != "" {
+		go func(sessionID string) {
+			tctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if _, err := historycompress.GenerateTitleIfNeeded(tctx, p, h.Storage, sessionID); err != nil {
+				logger.Warn("ошибка генерации названия сессии", "session_id", sessionID, "error", err)
+			}
+		}(req.SessionID)
+	}
+}
+
+// branchTarget положение нового обмена сообщениями в дереве сессии
+type branchTarget struct {
+	historyLeafID  int64 // конец ветки, которая уходит в историю модели (0 — пустая история)
+	answerParentID int64 // родитель ответа модели
+	regenerate     bool  // вопрос не сохраняется: ответ перегенерируется на существующий
+}
+
+// resolveBranch определяет ветку запроса: продолжение активной ветки, новый вариант
+// вопроса (edit_message_id) или новый вариант ответа (regenerate_message_id).
+// При перегенерации подставляет в req.Message исходный вопрос.
+func (h *ChatHandlerV2) resolveBranch(req *ChatRequestV2) (branchTarget, int, error) {
+	var target branchTarget
+	if req.EditMessageID == 0 && req.RegenerateMessageID == 0 {
+		if req.SessionID != "" && h.Storage != nil {
+			leafID, err := h.Storage.ActiveLeafID(req.SessionID)
+			if err != nil {
+				logger.Warn("ошибка загрузки активной ветки", "session_id", req.SessionID, "error", err)
+			}
+			target.historyLeafID = leafID
+		}
+		return target, http.StatusOK, nil
+	}
+
+	if req.EditMessageID != 0 && req.RegenerateMessageID != 0 {
+		return target, http.StatusBadRequest, fmt.Errorf("edit_message_id и regenerate_message_id нельзя указывать вместе")
+	}
+	if req.SessionID == "" || h.Storage == nil {
+		return target, http.StatusBadRequest, fmt.Errorf("для edit_message_id и regenerate_message_id нужен session_id")
+	}
+
+	if req.EditMessageID != 0 {
+		msg, err := h.Storage.GetMessage(req.SessionID, req.EditMessageID)
+		if err != nil {
+			return target, http.StatusInternalServerError, fmt.Errorf("Ошибка загрузки сообщения")
+		}
+		if msg == nil || msg.Role != storage.RoleUser {
+			return target, http.StatusNotFound, fmt.Errorf("Сообщение пользователя %d не найдено в сессии", req.EditMessageID)
+		}
+		target.historyLeafID = msg.ParentID
+		return target, http.StatusOK, nil
+	}
+
+	answer, err := h.Storage.GetMessage(req.SessionID, req.RegenerateMessageID)
+	if err != nil {
+		return target, http.StatusInternalServerError, fmt.Errorf("Ошибка загрузки сообщения")
+	}
+	if answer == nil || answer.Role != storage.RoleAssistant {
+		return target, http.StatusNotFound, fmt.Errorf("Ответ %d не найден в сессии", req.RegenerateMessageID)
+	}
+	question, err := h.Storage.GetMessage(req.SessionID, answer.ParentID)
+	if err != nil {
+		return target, http.StatusInternalServerError, fmt.Errorf("Ошибка загрузки сообщения")
+	}
+	if question == nil || question.Role != storage.RoleUser {
+		return target, http.StatusConflict, fmt.Errorf("Вопрос к ответу %d не найден (история могла быть сжата)", req.RegenerateMessageID)
+	}
+
+	req.Message = question.Content
+	target.historyLeafID = question.ParentID
+	target.answerParentID = question.ID
+	target.regenerate = true
+	return target, http.StatusOK, nil
+}
+
+// applySessionDefaults подставляет настройки сессии в незаданные поля запроса
+func applySessionDefaults(req *ChatRequestV2, sess *storage.Session) {
+	if req.Provider == "" {
+		req.Provider = sess.Provider
+	}
+	// Модель сессии относится к ее провайдеру
+	if req.Model == "" && req.Provider == sess.Provider {
+		req.Model = sess.Model
+	}
+	if req.SystemPrompt == "" {
+		req.SystemPrompt = sess.SystemPrompt
+	}
+	if req.ReasoningMode == "" {
+		req.ReasoningMode = sess.ReasoningMode
+	}
+}
+
+// requestTemperature температура для происхождения ответа. Провайдеры не отправляют
+// нулевую температуру (omitempty), и модель отвечает со своей по умолчанию, поэтому
+// записывается только явно заданная положительная.
+func requestTemperature(t float64) *float64 {
+	if t <= 0 {
+		return nil
+	}
+	return &t
+}
+
+// autoTitle сообщает, нужно ли генерировать названия сессий
+func (h *ChatHandlerV2) autoTitle() bool {
+	if h.Config != nil && h.Config.Sessions.AutoTitle != nil {
+		return *h.Config.Sessions.AutoTitle
+	}
+	return true
+}
+
+// jsonMaxRepairs возвращает лимит попыток исправления JSON-ответа
+func (h *ChatHandlerV2) jsonMaxRepairs() int {
+	if h.Config != nil && h.Config.JSONMode.MaxRepairs != nil {
+		return *h.Config.JSONMode.MaxRepairs
+	}
+	return provider.DefaultJSONMaxRepairs
 }
 
 // ProvidersHandler возвращает список доступных провайдеров
@@ -16,7 +16,7 @@ import (
 // CollectHandler обрабатывает запросы к /api/chat/collect
 type CollectHandler struct {
 	GigaChatClient *gigachat.Client
-	Storage        *storage.Storage
+	Storage        storage.Store
 }
 
 // CollectRequest представляет входящий запрос для режима сбора требований
@@ -39,7 +39,7 @@ type CollectResponse struct {
 }
 
 // NewCollectHandler создает новый обработчик режима сбора требований
-func NewCollectHandler(client *gigachat.Client, store *storage.Storage) *CollectHandler {
+func NewCollectHandler(client *gigachat.Client, store storage.Store) *CollectHandler {
 	return &CollectHandler{
 		GigaChatClient: client,
 		Storage:        store,
@@ -83,6 +83,9 @@ func (h *CollectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if req.SessionID == "" || req.StartNewSession {
 		req.SessionID = fmt.Sprintf("collect_%d", time.Now().UnixNano())
 	}
+	if !claimSession(w, r, h.Storage, req.SessionID) {
+		return
+	}
 
 	logger.Info("получен запрос на сбор требований",
 		"message_length", len(req.Message),
@@ -182,7 +185,16 @@ func (h *CollectHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if h.Storage != nil {
 		requestJSON, _ := json.Marshal(req)
 		responseJSON, _ := json.Marshal(response)
-		if _, err := h.Storage.SaveRequestLog(req.SessionID, string(requestJSON), string(responseJSON), http.StatusOK, durationMs, nil, nil, nil, nil); err != nil {
+		if _, err := h.Storage.SaveRequestLog(storage.NewRequestLog{
+			SessionID:    req.SessionID,
+			UserID:       contextUserID(r.Context()),
+			Provider:     "gigachat",
+			Model:        gigachat.DefaultModel,
+			RequestJSON:  string(requestJSON),
+			ResponseJSON: string(responseJSON),
+			StatusCode:   http.StatusOK,
+			DurationMs:   durationMs,
+		}); err != nil {
 			logger.Error("ошибка сохранения лога запроса", "error", err)
 		}
 	}
@@ -0,0 +1,331 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nnk/97-aic/backend/logger"
+	"github.com/nnk/97-aic/backend/provider"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+// sessionExportVersion версия формата JSON-экспорта сессии
+const sessionExportVersion = 1
+
+// SessionExport JSON-экспорт сессии: настройки, все ветки сообщений и summary.
+// Этот же документ принимает POST /api/v2/sessions/import.
+type SessionExport struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Session    storage.Session   `json:"session"`
+	Messages   []storage.Message `json:"messages"` // по возрастанию id; parent_id и session.active_leaf_id — исходные id
+}
+
+// fineTuningMessage сообщение формата дообучения OpenAI (chat fine-tuning)
+type fineTuningMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	Weight  *int   `json:"weight,omitempty"` // 0 — не учиться на этом ответе
+}
+
+// export отдает сессию файлом: format=json (по умолчанию), md или jsonl
+func (h *SessionsHandler) export(w http.ResponseWriter, r *http.Request, id string) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "md" && format != "jsonl" {
+		http.Error(w, "Неизвестный формат: "+format+" (json, md, jsonl)", http.StatusBadRequest)
+		return
+	}
+
+	exp, err := h.loadExport(id)
+	if err != nil {
+		logger.Error("ошибка экспорта сессии", "error", err, "session_id", id)
+		http.Error(w, "Ошибка экспорта сессии", http.StatusInternalServerError)
+		return
+	}
+	if exp == nil {
+		http.Error(w, "Сессия не найдена", http.StatusNotFound)
+		return
+	}
+
+	var body []byte
+	var contentType string
+	switch format {
+	case "json":
+		body, err = json.MarshalIndent(exp, "", "  ")
+		contentType = "application/json"
+	case "md":
+		body = []byte(renderMarkdown(exp))
+		contentType = "text/markdown; charset=utf-8"
+	case "jsonl":
+		body, err = renderFineTuning(exp)
+		contentType = "application/x-ndjson"
+	}
+	if err != nil {
+		logger.Error("ошибка экспорта сессии", "error", err, "session_id", id)
+		http.Error(w, "Ошибка экспорта сессии", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "session-"+id+"."+format))
+	w.Write(body)
+}
+
+// loadExport собирает экспорт сессии или nil, если ее нет
+func (h *SessionsHandler) loadExport(id string) (*SessionExport, error) {
+	sess, err := h.Storage.GetSession(id)
+	if err != nil || sess == nil {
+		return nil, err
+	}
+	messages, leafID, err := h.Storage.GetMessageTree(id)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := h.Storage.GetLatestSummary(id)
+	if err != nil {
+		return nil, err
+	}
+
+	sess.ActiveLeafID = leafID
+	if summary != nil {
+		// Summary сохраняется раньше сообщений, которые пришли после компрессии, — ставим по id
+		messages = insertByID(messages, *summary)
+	}
+	return &SessionExport{
+		Version:    sessionExportVersion,
+		ExportedAt: time.Now().UTC(),
+		Session:    *sess,
+		Messages:   messages,
+	}, nil
+}
+
+// insertByID вставляет сообщение в список, упорядоченный по id
+func insertByID(messages []storage.Message, m storage.Message) []storage.Message {
+	i := len(messages)
+	for i > 0 && messages[i-1].ID > m.ID {
+		i--
+	}
+	messages = append(messages, storage.Message{})
+	copy(messages[i+1:], messages[i:])
+	messages[i] = m
+	return messages
+}
+
+// activeBranch сообщения активной ветки экспорта от корня до session.active_leaf_id
+func activeBranch(exp *SessionExport) []storage.Message {
+	byID := make(map[int64]storage.Message, len(exp.Messages))
+	for _, m := range exp.Messages {
+		byID[m.ID] = m
+	}
+
+	var branch []storage.Message
+	for id := exp.Session.ActiveLeafID; id != 0; {
+		m, ok := byID[id]
+		if !ok || len(branch) > len(exp.Messages) {
+			break
+		}
+		branch = append(branch, m)
+		id = m.ParentID
+	}
+	for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+		branch[i], branch[j] = branch[j], branch[i]
+	}
+	return branch
+}
+
+// latestSummary summary сессии из экспорта или nil
+func latestSummary(exp *SessionExport) *storage.Message {
+	for i := len(exp.Messages) - 1; i >= 0; i-- {
+		if exp.Messages[i].Role == storage.RoleSummary {
+			return &exp.Messages[i]
+		}
+	}
+	return nil
+}
+
+// renderMarkdown активная ветка сессии в Markdown: summary, реплики и происхождение ответов
+func renderMarkdown(exp *SessionExport) string {
+	var b strings.Builder
+	sess := exp.Session
+
+	title := sess.Title
+	if title == "" {
+		title = sess.ID
+	}
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	fmt.Fprintf(&b, "- Сессия: `%s`\n", sess.ID)
+	if sess.Provider != "" {
+		fmt.Fprintf(&b, "- Провайдер: %s\n", joinNonEmpty(" / ", sess.Provider, sess.Model))
+	}
+	if sess.ReasoningMode != "" {
+		fmt.Fprintf(&b, "- Режим рассуждений: %s\n", sess.ReasoningMode)
+	}
+	fmt.Fprintf(&b, "- Создана: %s\n", sess.CreatedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Экспортирована: %s\n", exp.ExportedAt.Format(time.RFC3339))
+
+	if sess.SystemPrompt != "" {
+		fmt.Fprintf(&b, "\n## Системный промпт\n\n%s\n", sess.SystemPrompt)
+	}
+
+	if summary := latestSummary(exp); summary != nil {
+		b.WriteString("\n## Краткое содержание ранней истории\n\n")
+		for _, line := range strings.Split(summary.Content, "\n") {
+			fmt.Fprintf(&b, "> %s\n", line)
+		}
+	}
+
+	b.WriteString("\n## Диалог\n")
+	for _, m := range activeBranch(exp) {
+		label := "Пользователь"
+		if m.Role == storage.RoleAssistant {
+			label = "Ассистент"
+		}
+		fmt.Fprintf(&b, "\n### %s\n\n%s\n", label, m.Content)
+		if m.FinishReason == provider.FinishReasonStopped {
+			b.WriteString("\n_Генерация остановлена_\n")
+		}
+		if line := provenanceLine(m.Provenance); line != "" {
+			fmt.Fprintf(&b, "\n_%s_\n", line)
+		}
+	}
+	return b.String()
+}
+
+// provenanceLine происхождение ответа одной строкой: модель, токены, стоимость, время
+func provenanceLine(p storage.Provenance) string {
+	if p.Model == "" && p.Provider == "" {
+		return ""
+	}
+	parts := []string{joinNonEmpty(" / ", p.Provider, p.Model)}
+	if p.ReasoningMode != "" {
+		parts = append(parts, p.ReasoningMode)
+	}
+	if p.Temperature != nil {
+		parts = append(parts, fmt.Sprintf("t=%g", *p.Temperature))
+	}
+	if p.TokensInput != nil && p.TokensOutput != nil {
+		parts = append(parts, fmt.Sprintf("%d → %d ток.", *p.TokensInput, *p.TokensOutput))
+	}
+	if p.Cost != nil && *p.Cost > 0 {
+		parts = append(parts, fmt.Sprintf("$%.4f", *p.Cost))
+	}
+	if p.DurationMs != nil {
+		parts = append(parts, fmt.Sprintf("%.1f с", float64(*p.DurationMs)/1000))
+	}
+	return strings.Join(parts, " · ")
+}
+
+func joinNonEmpty(sep string, values ...string) string {
+	var parts []string
+	for _, v := range values {
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+// renderFineTuning активная ветка в формате дообучения OpenAI: одна строка
+// {"messages": [...]} с системным промптом сессии. Summary не входит, пример
+// заканчивается последним ответом ассистента, остановленные ответы помечаются weight: 0.
+func renderFineTuning(exp *SessionExport) ([]byte, error) {
+	var messages []fineTuningMessage
+	if exp.Session.SystemPrompt != "" {
+		messages = append(messages, fineTuningMessage{Role: "system", Content: exp.Session.SystemPrompt})
+	}
+	for _, m := range activeBranch(exp) {
+		msg := fineTuningMessage{Role: m.Role, Content: m.Content}
+		if m.Role == storage.RoleAssistant && m.FinishReason == provider.FinishReasonStopped {
+			zero := 0
+			msg.Weight = &zero
+		}
+		messages = append(messages, msg)
+	}
+
+	for len(messages) > 0 && messages[len(messages)-1].Role != storage.RoleAssistant {
+		messages = messages[:len(messages)-1]
+	}
+
+	var buf bytes.Buffer
+	if len(messages) == 0 {
+		return buf.Bytes(), nil
+	}
+	if err := json.NewEncoder(&buf).Encode(map[string]interface{}{"messages": messages}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// importSession создает новую сессию из JSON-экспорта. Id сессии и сообщений назначаются заново.
+func (h *SessionsHandler) importSession(w http.ResponseWriter, r *http.Request) {
+	var exp SessionExport
+	if err := json.NewDecoder(r.Body).Decode(&exp); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка парсинга запроса: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := validateExport(&exp); err != nil {
+		http.Error(w, "Некорректный экспорт: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sess := exp.Session
+	sess.ID = uuid.New().String()
+	sess.UserID = contextUserID(r.Context())
+	imported, err := h.Storage.ImportSession(sess, exp.Messages)
+	if err != nil {
+		logger.Error("ошибка импорта сессии", "error", err, "source_session_id", exp.Session.ID)
+		http.Error(w, "Ошибка импорта сессии", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("сессия импортирована",
+		"session_id", imported.ID,
+		"source_session_id", exp.Session.ID,
+		"messages", len(exp.Messages),
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(imported)
+}
+
+// validateExport проверяет версию, роли и ссылки на родителей: родитель должен идти раньше
+func validateExport(exp *SessionExport) error {
+	if exp.Version != sessionExportVersion {
+		return fmt.Errorf("неподдерживаемая версия %d (ожидается %d)", exp.Version, sessionExportVersion)
+	}
+	if !reasoningModes[exp.Session.ReasoningMode] {
+		return fmt.Errorf("неизвестный reasoning_mode: %s", exp.Session.ReasoningMode)
+	}
+
+	seen := make(map[int64]bool, len(exp.Messages))
+	var prevID int64
+	for i, m := range exp.Messages {
+		switch m.Role {
+		case storage.RoleUser, storage.RoleAssistant, storage.RoleSummary:
+		default:
+			return fmt.Errorf("сообщение %d: неизвестная роль %q", i, m.Role)
+		}
+		if m.ID <= prevID {
+			return fmt.Errorf("сообщение %d: id должны быть положительными и возрастать", i)
+		}
+		if m.ParentID != 0 && (m.Role == storage.RoleSummary || !seen[m.ParentID]) {
+			return fmt.Errorf("сообщение %d: родитель %d не найден среди предыдущих сообщений", m.ID, m.ParentID)
+		}
+		prevID = m.ID
+		if m.Role != storage.RoleSummary {
+			seen[m.ID] = true
+		}
+	}
+	if exp.Session.ActiveLeafID != 0 && !seen[exp.Session.ActiveLeafID] {
+		return fmt.Errorf("active_leaf_id %d не найден среди сообщений", exp.Session.ActiveLeafID)
+	}
+	return nil
+}
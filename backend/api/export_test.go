@@ -0,0 +1,191 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+// exportSession выгружает сессию в JSON через GET /api/v2/sessions/{id}/export
+func exportSession(t *testing.T, h http.Handler, id string) (SessionExport, []byte) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/sessions/"+id+"/export", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("экспорт %s: %d %s", id, rec.Code, rec.Body.String())
+	}
+	var exp SessionExport
+	if err := json.Unmarshal(rec.Body.Bytes(), &exp); err != nil {
+		t.Fatal(err)
+	}
+	return exp, rec.Body.Bytes()
+}
+
+func postImport(h http.Handler, body []byte) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v2/sessions/import", bytes.NewReader(body)))
+	return rec
+}
+
+// seedBranchedSession сессия с настройками, двумя ветками от q1, summary между ними
+// и активной веткой, которая не последняя добавленная
+func seedBranchedSession(t *testing.T, store storage.Store) {
+	t.Helper()
+	title, model, prompt, mode := "Исходная", "llama", "Отвечай кратко", "step_by_step"
+	q1 := addMessage(t, store, storage.NewMessage{SessionID: "src", Role: storage.RoleUser, Content: "q1"})
+	log, err := store.SaveRequestLog(storage.NewRequestLog{SessionID: "src", Provider: "groq", StatusCode: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokensIn, tokensOut := 12, 3
+	a1 := addMessage(t, store, storage.NewMessage{SessionID: "src", Role: storage.RoleAssistant, Content: "a1", ParentID: &q1,
+		Provenance: storage.Provenance{Provider: "groq", Model: "llama", TokensInput: &tokensIn, TokensOutput: &tokensOut, RequestLogID: &log.ID}})
+	if _, err := store.UpsertSummary("src", "резюме"); err != nil {
+		t.Fatal(err)
+	}
+	q2 := addMessage(t, store, storage.NewMessage{SessionID: "src", Role: storage.RoleUser, Content: "q2", ParentID: &a1})
+	addMessage(t, store, storage.NewMessage{SessionID: "src", Role: storage.RoleAssistant, Content: "a2", ParentID: &q2, FinishReason: "stopped"})
+	b1 := addMessage(t, store, storage.NewMessage{SessionID: "src", Role: storage.RoleAssistant, Content: "b1", ParentID: &q1})
+	addMessage(t, store, storage.NewMessage{SessionID: "src", Role: storage.RoleUser, Content: "b2", ParentID: &b1})
+
+	if _, err := store.SetActiveBranch("src", q2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.UpdateSession("src", storage.SessionPatch{Title: &title, Model: &model, SystemPrompt: &prompt, ReasoningMode: &mode}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	store := newTestStore(t)
+	seedBranchedSession(t, store)
+	h := NewSessionsHandler(store, nil, testConfig)
+
+	src, body := exportSession(t, h, "src")
+	if len(src.Messages) != 7 || src.Messages[2].Role != storage.RoleSummary {
+		t.Fatalf("экспорт = %+v", src.Messages)
+	}
+
+	rec := postImport(h, body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("импорт: %d %s", rec.Code, rec.Body.String())
+	}
+	var imported storage.Session
+	if err := json.Unmarshal(rec.Body.Bytes(), &imported); err != nil {
+		t.Fatal(err)
+	}
+	if imported.ID == "" || imported.ID == "src" || imported.MessageCount != 6 {
+		t.Fatalf("импортированная сессия = %+v", imported)
+	}
+
+	dst, _ := exportSession(t, h, imported.ID)
+
+	// Настройки сессии переносятся
+	if dst.Session.Title != src.Session.Title || dst.Session.Model != src.Session.Model ||
+		dst.Session.SystemPrompt != src.Session.SystemPrompt || dst.Session.ReasoningMode != src.Session.ReasoningMode ||
+		!dst.Session.CreatedAt.Equal(src.Session.CreatedAt) {
+		t.Errorf("сессия после импорта = %+v, исходная %+v", dst.Session, src.Session)
+	}
+
+	// Те же сообщения в том же порядке, но с новыми id; родители и активная ветка ссылаются на новые id
+	if len(dst.Messages) != len(src.Messages) {
+		t.Fatalf("сообщений %d, ожидалось %d", len(dst.Messages), len(src.Messages))
+	}
+	newID := map[int64]int64{0: 0}
+	maxSrcID := src.Messages[len(src.Messages)-1].ID
+	for i, m := range dst.Messages {
+		old := src.Messages[i]
+		if m.ID <= maxSrcID {
+			t.Errorf("сообщение %q сохранило старый id %d", m.Content, m.ID)
+		}
+		newID[old.ID] = m.ID
+
+		want := old
+		want.ID, want.SessionID, want.ParentID, want.RequestLogID = m.ID, imported.ID, newID[old.ParentID], nil
+		got := m
+		got.CreatedAt = want.CreatedAt
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("сообщение %d = %+v, ожидалось %+v", i, got, want)
+		}
+		if !m.CreatedAt.Equal(old.CreatedAt) {
+			t.Errorf("сообщение %q: created_at %v, ожидалось %v", m.Content, m.CreatedAt, old.CreatedAt)
+		}
+	}
+	if dst.Session.ActiveLeafID != newID[src.Session.ActiveLeafID] {
+		t.Errorf("конец активной ветки %d, ожидался %d (исходный %d)", dst.Session.ActiveLeafID, newID[src.Session.ActiveLeafID], src.Session.ActiveLeafID)
+	}
+	if got := contents(activeBranch(&dst)); !reflect.DeepEqual(got, []string{"q1", "a1", "q2", "a2"}) {
+		t.Errorf("активная ветка = %v", got)
+	}
+
+	// Markdown и jsonl по импортированной копии совпадают с исходными (кроме id сессии)
+	for _, format := range []string{"md", "jsonl"} {
+		get := func(id string) string {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/sessions/"+id+"/export?format="+format, nil))
+			out := strings.ReplaceAll(rec.Body.String(), id, "<id>")
+			lines := strings.Split(out, "\n")
+			for i, l := range lines {
+				if strings.HasPrefix(l, "- Экспортирована:") {
+					lines[i] = ""
+				}
+			}
+			return strings.Join(lines, "\n")
+		}
+		if a, b := get("src"), get(imported.ID); a != b {
+			t.Errorf("%s после импорта отличается:\n%s\n---\n%s", format, a, b)
+		}
+	}
+}
+
+func TestImportRejectsInvalidExport(t *testing.T) {
+	store := newTestStore(t)
+	seedBranchedSession(t, store)
+	h := NewSessionsHandler(store, nil, testConfig)
+	valid, _ := exportSession(t, h, "src")
+
+	tests := []struct {
+		name   string
+		modify func(exp *SessionExport)
+		want   string
+	}{
+		{"версия", func(exp *SessionExport) { exp.Version = 2 }, "неподдерживаемая версия"},
+		{"reasoning_mode", func(exp *SessionExport) { exp.Session.ReasoningMode = "magic" }, "reasoning_mode"},
+		{"роль", func(exp *SessionExport) { exp.Messages[0].Role = "system" }, "неизвестная роль"},
+		{"повтор id", func(exp *SessionExport) { exp.Messages[1].ID = exp.Messages[0].ID }, "возрастать"},
+		{"неизвестный родитель", func(exp *SessionExport) { exp.Messages[1].ParentID = 999 }, "родитель 999"},
+		{"родитель summary", func(exp *SessionExport) { exp.Messages[3].ParentID = exp.Messages[2].ID }, "не найден"},
+		{"summary в дереве", func(exp *SessionExport) { exp.Messages[2].ParentID = exp.Messages[0].ID }, "не найден"},
+		{"active_leaf_id", func(exp *SessionExport) { exp.Session.ActiveLeafID = exp.Messages[2].ID }, "active_leaf_id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := valid
+			exp.Messages = append([]storage.Message(nil), valid.Messages...)
+			tt.modify(&exp)
+			body, err := json.Marshal(exp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := postImport(h, body)
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("ответ %d %q, ожидалось 400 с %q", rec.Code, rec.Body.String(), tt.want)
+			}
+		})
+	}
+
+	// Отклоненный импорт не создает сессий
+	sessions, err := store.ListSessions("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Errorf("сессий %d, ожидалась 1", len(sessions))
+	}
+}
@@ -0,0 +1,383 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nnk/97-aic/backend/logger"
+)
+
+// DefaultGenerationKeep сколько хранить буфер завершенной генерации для переподключения
+const DefaultGenerationKeep = 10 * time.Minute
+
+// Generation генерация ответа, идущая независимо от HTTP-соединения.
+// События буферизуются в памяти: клиент может переподключиться и дочитать пропущенное.
+type Generation struct {
+	ID        string
+	SessionID string
+	UserID    string // владелец ("" — авторизация выключена)
+	CreatedAt time.Time
+
+	mu         sync.Mutex
+	events     []sseEvent
+	done       bool
+	stopped    bool // остановлена пользователем
+	finishedAt time.Time
+	wake       chan struct{} // закрывается при каждом новом событии
+	finished   chan struct{} // закрывается в Finish
+	cancel     context.CancelFunc
+}
+
+// GenerationInfo состояние генерации для API
+type GenerationInfo struct {
+	ID         string     `json:"id"`
+	SessionID  string     `json:"session_id"`
+	Done       bool       `json:"done"`
+	Stopped    bool       `json:"stopped,omitempty"`
+	Events     int        `json:"events"` // id последнего события
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Send добавляет событие в буфер и будит подписчиков
+func (g *Generation) Send(event string, data interface{}) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.done {
+		return
+	}
+	g.events = append(g.events, sseEvent{ID: int64(len(g.events) + 1), Event: event, Data: jsonData})
+	close(g.wake)
+	g.wake = make(chan struct{})
+}
+
+// Finish отмечает генерацию завершенной и освобождает ее контекст
+func (g *Generation) Finish() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.done {
+		return
+	}
+	g.done = true
+	g.finishedAt = time.Now()
+	close(g.wake)
+	close(g.finished)
+	g.cancel()
+}
+
+// Finished возвращает канал, который закрывается после Finish: к этому моменту
+// ответ и лог запроса генерации уже сохранены
+func (g *Generation) Finished() <-chan struct{} {
+	return g.finished
+}
+
+// Cancel останавливает генерацию: отменяет ее контекст (и HTTP-запрос к провайдеру).
+// Возвращает false, если генерация уже завершена.
+func (g *Generation) Cancel() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.done {
+		return false
+	}
+	g.stopped = true
+	g.cancel()
+	return true
+}
+
+// Stopped сообщает, остановлена ли генерация пользователем
+func (g *Generation) Stopped() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stopped
+}
+
+// Since возвращает события после lastID, признак завершения и канал, который
+// закроется при появлении следующего события
+func (g *Generation) Since(lastID int64) ([]sseEvent, bool, <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if lastID < 0 {
+		lastID = 0
+	}
+	var events []sseEvent
+	if lastID < int64(len(g.events)) {
+		events = append(events, g.events[lastID:]...)
+	}
+	return events, g.done, g.wake
+}
+
+// Info возвращает состояние генерации
+func (g *Generation) Info() GenerationInfo {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	info := GenerationInfo{
+		ID:        g.ID,
+		SessionID: g.SessionID,
+		Done:      g.done,
+		Stopped:   g.stopped,
+		Events:    len(g.events),
+		CreatedAt: g.CreatedAt,
+	}
+	if g.done {
+		finishedAt := g.finishedAt
+		info.FinishedAt = &finishedAt
+	}
+	return info
+}
+
+// GenerationRegistry активные и недавно завершенные генерации
+type GenerationRegistry struct {
+	mu    sync.Mutex
+	items map[string]*Generation
+	keep  time.Duration
+}
+
+// NewGenerationRegistry создает реестр; keep — сколько хранить завершенные генерации
+func NewGenerationRegistry(keep time.Duration) *GenerationRegistry {
+	if keep <= 0 {
+		keep = DefaultGenerationKeep
+	}
+	return &GenerationRegistry{
+		items: make(map[string]*Generation),
+		keep:  keep,
+	}
+}
+
+// Start регистрирует генерацию. Возвращенный контекст не зависит от HTTP-запроса
+// и отменяется в Finish.
+func (r *GenerationRegistry) Start(sessionID, userID string) (*Generation, context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	gen := &Generation{
+		ID:        uuid.New().String(),
+		SessionID: sessionID,
+		UserID:    userID,
+		CreatedAt: time.Now(),
+		wake:      make(chan struct{}),
+		finished:  make(chan struct{}),
+		cancel:    cancel,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cleanupLocked()
+	r.items[gen.ID] = gen
+	return gen, ctx
+}
+
+// Get возвращает генерацию по id или nil
+func (r *GenerationRegistry) Get(id string) *Generation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cleanupLocked()
+	return r.items[id]
+}
+
+// List возвращает генерации сессии (пустой sessionID — все) пользователя userID
+// (пустой — всех пользователей), от новых к старым
+func (r *GenerationRegistry) List(sessionID, userID string) []GenerationInfo {
+	r.mu.Lock()
+	r.cleanupLocked()
+	var gens []*Generation
+	for _, gen := range r.items {
+		if (sessionID == "" || gen.SessionID == sessionID) && (userID == "" || gen.UserID == userID) {
+			gens = append(gens, gen)
+		}
+	}
+	r.mu.Unlock()
+
+	infos := make([]GenerationInfo, 0, len(gens))
+	for _, gen := range gens {
+		infos = append(infos, gen.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreatedAt.After(infos[j].CreatedAt)
+	})
+	return infos
+}
+
+// ActiveSessions возвращает сессии, в которых идут генерации
+func (r *GenerationRegistry) ActiveSessions() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var sessions []string
+	for _, gen := range r.items {
+		gen.mu.Lock()
+		done := gen.done
+		gen.mu.Unlock()
+		if !done && !seen[gen.SessionID] {
+			seen[gen.SessionID] = true
+			sessions = append(sessions, gen.SessionID)
+		}
+	}
+	return sessions
+}
+
+// shutdownGrace сколько Shutdown ждет отмененные генерации, пока они сохраняют частичные ответы
+const shutdownGrace = 5 * time.Second
+
+// Shutdown ждет завершения идущих генераций, чтобы их ответы успели сохраниться
+// до закрытия хранилища. Если ctx истекает раньше, оставшиеся генерации
+// останавливаются (как через cancel: частичный ответ сохраняется) и Shutdown
+// ждет их еще до shutdownGrace. Вызывается после остановки HTTP-сервера.
+func (r *GenerationRegistry) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	var running []*Generation
+	for _, gen := range r.items {
+		running = append(running, gen)
+	}
+	r.mu.Unlock()
+
+	waitAll := func(done <-chan struct{}) bool {
+		for _, gen := range running {
+			select {
+			case <-gen.Finished():
+			case <-done:
+				return false
+			}
+		}
+		return true
+	}
+
+	if waitAll(ctx.Done()) {
+		return nil
+	}
+
+	stopped := 0
+	for _, gen := range running {
+		if gen.Cancel() {
+			stopped++
+		}
+	}
+	logger.Warn("генерации не завершились до остановки сервера, останавливаю", "count", stopped)
+
+	graceCtx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	if !waitAll(graceCtx.Done()) {
+		return fmt.Errorf("генерации не завершились за %s после остановки", shutdownGrace)
+	}
+	return nil
+}
+
+// cleanupLocked удаляет завершенные генерации старше keep
+func (r *GenerationRegistry) cleanupLocked() {
+	cutoff := time.Now().Add(-r.keep)
+	for id, gen := range r.items {
+		gen.mu.Lock()
+		expired := gen.done && gen.finishedAt.Before(cutoff)
+		gen.mu.Unlock()
+		if expired {
+			delete(r.items, id)
+		}
+	}
+}
+
+// GenerationsHandler обрабатывает запросы к /api/v2/generations:
+//
+//	GET /api/v2/generations?session_id=...      — генерации сессии
+//	GET /api/v2/generations/{id}                — состояние генерации
+//	GET /api/v2/generations/{id}/stream         — поток событий (с Last-Event-ID дочитывает пропущенное)
+//	POST /api/v2/generations/{id}/cancel        — остановить генерацию
+type GenerationsHandler struct {
+	Generations *GenerationRegistry
+}
+
+// NewGenerationsHandler создает обработчик генераций
+func NewGenerationsHandler(gens *GenerationRegistry) *GenerationsHandler {
+	return &GenerationsHandler{Generations: gens}
+}
+
+// ServeHTTP обрабатывает HTTP запросы
+func (h *GenerationsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v2/generations"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+
+	wantMethod := http.MethodGet
+	if action == "cancel" {
+		wantMethod = http.MethodPost
+	}
+	if r.Method != wantMethod {
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if id == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"generations": h.Generations.List(r.URL.Query().Get("session_id"), ownerScope(r)),
+		})
+		return
+	}
+
+	gen := h.Generations.Get(id)
+	if scope := ownerScope(r); gen != nil && scope != "" && gen.UserID != scope {
+		gen = nil // чужая генерация неотличима от несуществующей
+	}
+	if gen == nil {
+		http.Error(w, "Генерация не найдена", http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(gen.Info())
+	case "stream":
+		h.stream(w, r, gen)
+	case "cancel":
+		if gen.Cancel() {
+			logger.Info("генерация остановлена пользователем", "generation_id", gen.ID, "session_id", gen.SessionID)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(gen.Info())
+	default:
+		http.Error(w, "Не найдено", http.StatusNotFound)
+	}
+}
+
+// stream отдает события генерации после Last-Event-ID (заголовок или параметр last_event_id)
+func (h *GenerationsHandler) stream(w http.ResponseWriter, r *http.Request, gen *Generation) {
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	var lastID int64
+	if lastEventID != "" {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || id < 0 {
+			http.Error(w, "Некорректный Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+
+	stream, err := newSSEWriter(w, false)
+	if err != nil {
+		http.Error(w, "Streaming не поддерживается", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Generation-ID", gen.ID)
+
+	logger.Info("переподключение к генерации",
+		"generation_id", gen.ID,
+		"session_id", gen.SessionID,
+		"last_event_id", lastID,
+	)
+	stream.Follow(r.Context(), gen, lastID)
+}
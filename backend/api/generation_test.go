@@ -0,0 +1,325 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nnk/97-aic/backend/config"
+	"github.com/nnk/97-aic/backend/provider"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+// startGeneration регистрирует генерацию с событиями delta "1".."n"
+func startGeneration(reg *GenerationRegistry, n int) (*Generation, context.Context) {
+	gen, ctx := reg.Start("s", "")
+	for i := 1; i <= n; i++ {
+		gen.Send(EventDelta, DeltaEvent{Content: string(rune('0' + i))})
+	}
+	return gen, ctx
+}
+
+func TestGenerationBuffersEvents(t *testing.T) {
+	gen, _ := startGeneration(NewGenerationRegistry(time.Minute), 3)
+
+	events, done, wake := gen.Since(0)
+	if len(events) != 3 || done || events[0].ID != 1 || events[2].ID != 3 {
+		t.Fatalf("Since(0) = %+v, done=%v", events, done)
+	}
+	events, _, _ = gen.Since(2)
+	if len(events) != 1 || events[0].ID != 3 || string(events[0].Data) != `{"content":"3"}` {
+		t.Errorf("Since(2) = %+v", events)
+	}
+	if events, _, _ := gen.Since(3); len(events) != 0 {
+		t.Errorf("Since(3) = %+v", events)
+	}
+
+	// Новое событие будит подписчиков
+	gen.Send(EventDelta, DeltaEvent{Content: "4"})
+	select {
+	case <-wake:
+	default:
+		t.Error("wake не закрыт после нового события")
+	}
+
+	gen.Finish()
+	gen.Send(EventDelta, DeltaEvent{Content: "после завершения"})
+	events, done, _ = gen.Since(0)
+	if len(events) != 4 || !done {
+		t.Errorf("после Finish: %d событий, done=%v", len(events), done)
+	}
+	select {
+	case <-gen.Finished():
+	default:
+		t.Error("Finished не закрыт после Finish")
+	}
+}
+
+func TestGenerationStreamReplaysAfterLastEventID(t *testing.T) {
+	reg := NewGenerationRegistry(time.Minute)
+	h := NewGenerationsHandler(reg)
+	gen, _ := startGeneration(reg, 4)
+	gen.Finish()
+	url := "/api/v2/generations/" + gen.ID + "/stream"
+
+	ids := func(rec *httptest.ResponseRecorder) []string {
+		var got []string
+		for _, f := range readSSE(t, strings.NewReader(rec.Body.String())) {
+			got = append(got, f.ID)
+		}
+		return got
+	}
+
+	// Заголовок Last-Event-ID, как при автоматическом переподключении EventSource
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Last-Event-ID", "2")
+	h.ServeHTTP(rec, req)
+	if got := ids(rec); strings.Join(got, ",") != "3,4" {
+		t.Errorf("после Last-Event-ID 2: id = %v", got)
+	}
+	if rec.Header().Get("X-Generation-ID") != gen.ID {
+		t.Errorf("X-Generation-ID = %q", rec.Header().Get("X-Generation-ID"))
+	}
+
+	// Параметр запроса для клиентов без заголовков
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url+"?last_event_id=3", nil))
+	if got := ids(rec); strings.Join(got, ",") != "4" {
+		t.Errorf("после last_event_id=3: id = %v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	if got := ids(rec); strings.Join(got, ",") != "1,2,3,4" {
+		t.Errorf("без Last-Event-ID: id = %v", got)
+	}
+
+	for _, bad := range []string{"abc", "-1"} {
+		rec = httptest.NewRecorder()
+		req = httptest.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("Last-Event-ID", bad)
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Last-Event-ID %q: %d", bad, rec.Code)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/generations/unknown/stream", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("неизвестная генерация: %d", rec.Code)
+	}
+}
+
+func TestGenerationStreamFollowsLiveGeneration(t *testing.T) {
+	reg := NewGenerationRegistry(time.Minute)
+	h := NewGenerationsHandler(reg)
+	gen, _ := startGeneration(reg, 2)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v2/generations/"+gen.ID+"/stream", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	served := make(chan struct{})
+	go func() {
+		h.ServeHTTP(rec, req)
+		close(served)
+	}()
+
+	// Поток продолжается событиями, пришедшими после подключения, до завершения генерации
+	time.Sleep(20 * time.Millisecond)
+	gen.Send(EventDelta, DeltaEvent{Content: "3"})
+	gen.Send(EventDone, DoneEvent{FinishReason: "stop"})
+	gen.Finish()
+
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("поток не завершился вместе с генерацией")
+	}
+	frames := readSSE(t, strings.NewReader(rec.Body.String()))
+	if len(frames) != 3 || frames[0].ID != "2" || frames[2].Event != EventDone {
+		t.Errorf("события = %+v", frames)
+	}
+}
+
+func TestGenerationRegistryExpiry(t *testing.T) {
+	reg := NewGenerationRegistry(30 * time.Millisecond)
+	finished, _ := startGeneration(reg, 1)
+	finished.Finish()
+	running, _ := startGeneration(reg, 1)
+
+	if reg.Get(finished.ID) == nil {
+		t.Fatal("завершенная генерация удалена раньше срока")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if reg.Get(finished.ID) != nil {
+		t.Error("завершенная генерация не удалена после keep")
+	}
+	if reg.Get(running.ID) == nil {
+		t.Error("идущая генерация удалена по сроку")
+	}
+	if list := reg.List("", ""); len(list) != 1 || list[0].ID != running.ID {
+		t.Errorf("List = %+v", list)
+	}
+
+	// По умолчанию буфер хранится 10 минут
+	if NewGenerationRegistry(0).keep != DefaultGenerationKeep || DefaultGenerationKeep != 10*time.Minute {
+		t.Errorf("keep по умолчанию = %s", NewGenerationRegistry(0).keep)
+	}
+}
+
+func TestGenerationCancel(t *testing.T) {
+	reg := NewGenerationRegistry(time.Minute)
+	h := NewGenerationsHandler(reg)
+	gen, ctx := startGeneration(reg, 1)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/generations/"+gen.ID+"/cancel", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET cancel: %d", rec.Code)
+	}
+
+	var info GenerationInfo
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v2/generations/"+gen.ID+"/cancel", nil))
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if !info.Stopped || info.Done {
+		t.Errorf("после cancel: %+v", info)
+	}
+	select {
+	case <-ctx.Done():
+	default:
+		t.Error("контекст генерации не отменен")
+	}
+	if !gen.Stopped() {
+		t.Error("Stopped = false")
+	}
+
+	// Завершенную генерацию остановить нельзя
+	gen.Finish()
+	if gen.Cancel() {
+		t.Error("Cancel завершенной генерации вернул true")
+	}
+}
+
+// streamUntilCancel пишет начало ответа и ждет отмены контекста
+type streamUntilCancel struct {
+	*scriptedProvider
+	started chan struct{}
+}
+
+func (p *streamUntilCancel) Chat(ctx context.Context, message string, opts *provider.ChatOptions, onChunk func(string) error) (*provider.ChatResult, error) {
+	if err := onChunk("Начало ответа"); err != nil {
+		return nil, err
+	}
+	close(p.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestChatV2CancelSavesPartialAnswer(t *testing.T) {
+	store := newTestStore(t)
+	p := &streamUntilCancel{scriptedProvider: newScriptedProvider(), started: make(chan struct{})}
+	pm := provider.NewManager()
+	pm.Register(p.Name(), p)
+	autoTitle := false
+	cfg := &config.Config{}
+	cfg.Sessions.AutoTitle = &autoTitle
+	reg := NewGenerationRegistry(time.Minute)
+	chat := NewChatHandlerV2(pm, store, cfg, reg)
+
+	// Клиент сразу отключается: генерация идет без него
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	body := `{"message":"Расскажи длинную историю","session_id":"s-stop","provider":"scripted"}`
+	chat.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v2/chat", strings.NewReader(body)).WithContext(ctx))
+
+	<-p.started
+	gen := reg.Get(rec.Header().Get("X-Generation-ID"))
+	if gen == nil {
+		t.Fatal("генерация не зарегистрирована")
+	}
+	cancelRec := httptest.NewRecorder()
+	NewGenerationsHandler(reg).ServeHTTP(cancelRec, httptest.NewRequest(http.MethodPost, "/api/v2/generations/"+gen.ID+"/cancel", nil))
+	if cancelRec.Code != http.StatusOK {
+		t.Fatalf("cancel: %d", cancelRec.Code)
+	}
+	waitGeneration(t, gen)
+
+	messages, err := store.GetMessages("s-stop", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("сообщения = %+v", messages)
+	}
+	answer := messages[1]
+	if answer.Role != storage.RoleAssistant || answer.Content != "Начало ответа" || answer.FinishReason != provider.FinishReasonStopped {
+		t.Errorf("частичный ответ = %+v", answer)
+	}
+
+	events, _, _ := gen.Since(0)
+	if last := events[len(events)-1]; last.Event != EventDone || !strings.Contains(string(last.Data), `"finish_reason":"stopped"`) {
+		t.Errorf("последнее событие = %s %s", last.Event, last.Data)
+	}
+}
+
+func TestGenerationRegistryShutdown(t *testing.T) {
+	t.Run("дожидается завершения", func(t *testing.T) {
+		reg := NewGenerationRegistry(time.Minute)
+		gen, _ := startGeneration(reg, 1)
+		saved := false
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			saved = true // ответ сохраняется до Finish
+			gen.Finish()
+		}()
+
+		if err := reg.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if !saved || gen.Stopped() {
+			t.Errorf("saved=%v, stopped=%v", saved, gen.Stopped())
+		}
+	})
+
+	t.Run("останавливает по таймауту", func(t *testing.T) {
+		reg := NewGenerationRegistry(time.Minute)
+		gen, genCtx := startGeneration(reg, 1)
+		go func() {
+			<-genCtx.Done() // генерация завершается только после отмены
+			gen.Finish()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := reg.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if !gen.Stopped() || !gen.Info().Done {
+			t.Errorf("после Shutdown: %+v", gen.Info())
+		}
+	})
+}
+
+func TestGenerationRegistryActiveSessions(t *testing.T) {
+	reg := NewGenerationRegistry(time.Minute)
+	reg.Start("a", "")
+	reg.Start("a", "")
+	done, _ := reg.Start("b", "")
+	done.Finish()
+
+	// Сессии завершенных генераций может удалять очистка по политике хранения
+	if got := reg.ActiveSessions(); len(got) != 1 || got[0] != "a" {
+		t.Errorf("ActiveSessions = %v", got)
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nnk/97-aic/backend/provider"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+// newTestStore открывает SQLite базу во временном каталоге теста
+func newTestStore(t *testing.T) *storage.Storage {
+	t.Helper()
+	store, err := storage.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// addMessage сохраняет сообщение и возвращает его id
+func addMessage(t *testing.T, store storage.Store, m storage.NewMessage) int64 {
+	t.Helper()
+	msg, err := store.AddMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.ID
+}
+
+// waitGeneration ждет завершения генерации, идущей после отключения клиента
+func waitGeneration(t *testing.T, gen *Generation) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !gen.Info().Done {
+		if time.Now().After(deadline) {
+			t.Fatalf("генерация %s не завершилась", gen.ID)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// scriptedTurn один ответ scriptedProvider: текст, вызовы инструментов или ошибка
+type scriptedTurn struct {
+	content   string
+	toolCalls []provider.ToolCall
+	usage     *provider.Usage
+	err       error
+}
+
+// scriptedProvider провайдер для тестов: отвечает ходами по порядку и запоминает запросы
+type scriptedProvider struct {
+	name  string
+	model string
+
+	mu       sync.Mutex
+	turns    []scriptedTurn
+	requests []*provider.ChatOptions
+}
+
+func newScriptedProvider(turns ...scriptedTurn) *scriptedProvider {
+	return &scriptedProvider{name: "scripted", model: "test-model", turns: turns}
+}
+
+func (p *scriptedProvider) Name() string     { return p.name }
+func (p *scriptedProvider) Models() []string { return []string{p.model} }
+
+func (p *scriptedProvider) Chat(ctx context.Context, message string, opts *provider.ChatOptions, onChunk func(string) error) (*provider.ChatResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.requests = append(p.requests, opts)
+	turn := scriptedTurn{content: "нет ответа"}
+	if len(p.turns) > 0 {
+		turn, p.turns = p.turns[0], p.turns[1:]
+	}
+	p.mu.Unlock()
+
+	if turn.err != nil {
+		return nil, turn.err
+	}
+	result := &provider.ChatResult{FinishReason: "stop", Usage: turn.usage}
+	if len(turn.toolCalls) > 0 {
+		for i, call := range turn.toolCalls {
+			if opts != nil && opts.OnToolCallDelta != nil {
+				if err := opts.OnToolCallDelta(provider.ToolCallDelta{Index: i, ID: call.ID, Name: call.Name, ArgumentsDelta: call.Arguments}); err != nil {
+					return nil, err
+				}
+			}
+		}
+		result.ToolCalls = turn.toolCalls
+		result.FinishReason = provider.FinishReasonToolCalls
+		return result, nil
+	}
+	for _, word := range strings.SplitAfter(turn.content, " ") {
+		if err := onChunk(word); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (p *scriptedProvider) SetModel(model string)                 { p.model = model }
+func (p *scriptedProvider) GetModel() string                      { return p.model }
+func (p *scriptedProvider) GetMaxTokens() int                     { return 8192 }
+func (p *scriptedProvider) GetMaxTokensForModel(model string) int { return 8192 }
+func (p *scriptedProvider) CalculateCost(in, out int) float64     { return float64(in+out) / 1000 }
+
+// history история, с которой пришел i-й запрос к провайдеру
+func (p *scriptedProvider) history(i int) []provider.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if i >= len(p.requests) || p.requests[i] == nil {
+		return nil
+	}
+	return p.requests[i].History
+}
+
+// sseFrame событие SSE в том виде, в котором его видит клиент
+type sseFrame struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// readSSE разбирает поток SSE на события (кадры разделены пустой строкой)
+func readSSE(t *testing.T, r io.Reader) []sseFrame {
+	t.Helper()
+	var frames []sseFrame
+	var cur sseFrame
+	var data []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if cur.Event != "" || len(data) > 0 {
+				cur.Data = strings.Join(data, "\n")
+				frames = append(frames, cur)
+			}
+			cur, data = sseFrame{}, nil
+		case strings.HasPrefix(line, "id: "):
+			cur.ID = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			cur.Event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, strings.TrimPrefix(line, "data: "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return frames
+}
+
+// decodeFrame разбирает data события в v
+func decodeFrame(t *testing.T, f sseFrame, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(f.Data), v); err != nil {
+		t.Fatalf("data события %s: %v (%s)", f.Event, err, f.Data)
+	}
+}
@@ -2,22 +2,24 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/nnk/97-aic/backend/config"
 	"github.com/nnk/97-aic/backend/logger"
+	"github.com/nnk/97-aic/backend/provider"
 	"github.com/nnk/97-aic/backend/storage"
 )
 
 // HistoryHandler обрабатывает запросы к /api/history
 type HistoryHandler struct {
-	Storage *storage.Storage
+	Storage storage.Store
 	Config  *config.Config
 }
 
 // NewHistoryHandler создает новый обработчик истории
-func NewHistoryHandler(store *storage.Storage, cfg *config.Config) *HistoryHandler {
+func NewHistoryHandler(store storage.Store, cfg *config.Config) *HistoryHandler {
 	return &HistoryHandler{
 		Storage: store,
 		Config:  cfg,
@@ -47,23 +49,34 @@ func (h *HistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		limit = h.Config.MaxQueryLimit
 	}
 
-	messages, err := h.Storage.GetMessages(sessionID, limit)
+	page, paged, err := pageParams(r, limit, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !authorizeSession(w, r, h.Storage, sessionID) {
+		return
+	}
+
+	// По умолчанию summary скрываем (чтобы не ломать UI/контракты ролей).
+	withSummary := includeSummary != "" && includeSummary != "0" && includeSummary != "false"
+	messages, next, err := h.Storage.GetMessagesPage(sessionID, page, withSummary)
 	if err != nil {
 		logger.Error("ошибка получения истории", "error", err, "session_id", sessionID)
 		http.Error(w, "Ошибка получения истории", http.StatusInternalServerError)
 		return
 	}
 
-	// По умолчанию summary скрываем (чтобы не ломать UI/контракты ролей).
-	if includeSummary == "" || includeSummary == "0" || includeSummary == "false" {
-		filtered := make([]storage.Message, 0, len(messages))
-		for _, m := range messages {
-			if m.Role == storage.RoleSummary {
-				continue
-			}
-			filtered = append(filtered, m)
+	if messages == nil {
+		messages = []storage.Message{}
+	}
+	if paged {
+		resp := map[string]interface{}{"messages": messages}
+		if next > 0 {
+			resp["next_cursor"] = next
 		}
-		messages = filtered
+		writeJSON(w, resp)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -72,14 +85,46 @@ func (h *HistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// pageParams разбирает параметры keyset-пагинации: after_id листает к новым записям,
+// before_id — к старым (before_id=0 — с самых новых). paged сообщает, что передан
+// хотя бы один из них: тогда ответ — объект с next_cursor вместо массива.
+func pageParams(r *http.Request, limit int, newestFirst bool) (storage.Page, bool, error) {
+	params := r.URL.Query()
+	page := storage.Page{Limit: limit, Newest: newestFirst}
+
+	parse := func(name string) (int64, error) {
+		v, err := strconv.ParseInt(params.Get(name), 10, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("Некорректный %s", name)
+		}
+		return v, nil
+	}
+
+	var err error
+	hasBefore, hasAfter := params.Has("before_id"), params.Has("after_id")
+	if hasBefore {
+		if page.BeforeID, err = parse("before_id"); err != nil {
+			return page, false, err
+		}
+		page.Newest = true
+	}
+	if hasAfter {
+		if page.AfterID, err = parse("after_id"); err != nil {
+			return page, false, err
+		}
+		page.Newest = false
+	}
+	return page, hasBefore || hasAfter, nil
+}
+
 // LogsHandler обрабатывает запросы к /api/logs
 type LogsHandler struct {
-	Storage *storage.Storage
+	Storage storage.Store
 	Config  *config.Config
 }
 
 // NewLogsHandler создает новый обработчик логов
-func NewLogsHandler(store *storage.Storage, cfg *config.Config) *LogsHandler {
+func NewLogsHandler(store storage.Store, cfg *config.Config) *LogsHandler {
 	return &LogsHandler{
 		Storage: store,
 		Config:  cfg,
@@ -108,13 +153,32 @@ func (h *LogsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		limit = h.Config.MaxQueryLimit
 	}
 
-	logs, err := h.Storage.GetRequestLogs(sessionID, limit)
+	page, paged, err := pageParams(r, limit, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter := storage.RequestLogFilter{UserID: ownerScope(r), SessionID: sessionID}
+	logs, next, err := h.Storage.GetRequestLogsPage(filter, page)
 	if err != nil {
 		logger.Error("ошибка получения логов", "error", err, "session_id", sessionID)
 		http.Error(w, "Ошибка получения логов", http.StatusInternalServerError)
 		return
 	}
 
+	if paged {
+		if logs == nil {
+			logs = []storage.RequestLog{}
+		}
+		resp := map[string]interface{}{"logs": logs}
+		if next > 0 {
+			resp["next_cursor"] = next
+		}
+		writeJSON(w, resp)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(logs); err != nil {
 		logger.Error("ошибка кодирования ответа", "error", err)
@@ -123,12 +187,13 @@ func (h *LogsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // HealthHandler обрабатывает запросы к /health
 type HealthHandler struct {
-	Storage *storage.Storage
+	Storage         storage.Store
+	ProviderManager *provider.Manager
 }
 
 // NewHealthHandler создает новый обработчик health check
-func NewHealthHandler(store *storage.Storage) *HealthHandler {
-	return &HealthHandler{Storage: store}
+func NewHealthHandler(store storage.Store, pm *provider.Manager) *HealthHandler {
+	return &HealthHandler{Storage: store, ProviderManager: pm}
 }
 
 // ServeHTTP обрабатывает HTTP запросы для health check
@@ -138,7 +203,7 @@ func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	status := map[string]string{
+	status := map[string]interface{}{
 		"status": "ok",
 	}
 
@@ -152,6 +217,22 @@ func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Состояние провайдеров: отдельный недоступный провайдер не делает сервис degraded,
+	// а вот если недоступны все — отвечать нечем
+	if h.ProviderManager != nil {
+		health := h.ProviderManager.Health()
+		status["providers"] = health
+		available := 0
+		for _, hs := range health {
+			if hs.State != provider.CircuitOpen {
+				available++
+			}
+		}
+		if len(health) > 0 && available == 0 {
+			status["status"] = "degraded"
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	if status["status"] != "ok" {
 		w.WriteHeader(http.StatusServiceUnavailable)
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/nnk/97-aic/backend/config"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+var testConfig = &config.Config{DefaultQueryLimit: 100, MaxQueryLimit: 1000}
+
+// getJSON выполняет GET к обработчику и разбирает ответ 200 в v
+func getJSON(t *testing.T, h http.Handler, url string, v interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", url, rec.Code, rec.Body.String())
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("GET %s: %v (%s)", url, err, rec.Body.String())
+	}
+}
+
+type historyPage struct {
+	Messages   []storage.Message `json:"messages"`
+	NextCursor int64             `json:"next_cursor"`
+}
+
+func contents(messages []storage.Message) []string {
+	out := []string{}
+	for _, m := range messages {
+		out = append(out, m.Content)
+	}
+	return out
+}
+
+// seedHistory сессия s: активная ветка m1, m2, m4..m9, summary между m2 и m4
+// и соседняя ветка x (ответ на m1), которая в историю не попадает
+func seedHistory(t *testing.T, store *storage.Storage) {
+	t.Helper()
+	m1 := addMessage(t, store, storage.NewMessage{SessionID: "s", Role: storage.RoleUser, Content: "m1"})
+	parent := addMessage(t, store, storage.NewMessage{SessionID: "s", Role: storage.RoleAssistant, Content: "m2", ParentID: &m1})
+	addMessage(t, store, storage.NewMessage{SessionID: "s", Role: storage.RoleAssistant, Content: "x", ParentID: &m1})
+	if _, err := store.UpsertSummary("s", "summary"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{"m4", "m5", "m6", "m7", "m8", "m9"} {
+		role := storage.RoleUser
+		if c == "m5" || c == "m7" || c == "m9" {
+			role = storage.RoleAssistant
+		}
+		parent = addMessage(t, store, storage.NewMessage{SessionID: "s", Role: role, Content: c, ParentID: &parent})
+	}
+}
+
+// walkHistory проходит все страницы от курсора 0 и возвращает их содержимое
+func walkHistory(t *testing.T, h http.Handler, url, cursorParam string) [][]string {
+	t.Helper()
+	var pages [][]string
+	var cursor int64
+	for i := 0; i < 10; i++ {
+		var page historyPage
+		getJSON(t, h, url+"&"+cursorParam+"="+strconv.FormatInt(cursor, 10), &page)
+		pages = append(pages, contents(page.Messages))
+		if page.NextCursor == 0 {
+			return pages
+		}
+		cursor = page.NextCursor
+	}
+	t.Fatalf("больше 10 страниц: %v", pages)
+	return nil
+}
+
+func TestHistoryPagination(t *testing.T) {
+	store := newTestStore(t)
+	seedHistory(t, store)
+	h := NewHistoryHandler(store, testConfig)
+
+	tests := []struct {
+		name  string
+		url   string
+		param string
+		want  [][]string
+	}{
+		{
+			// summary отфильтрован в SQL и не занимает места: страницы полные
+			name:  "к новым",
+			url:   "/api/history?session_id=s&limit=3",
+			param: "after_id",
+			want:  [][]string{{"m1", "m2", "m4"}, {"m5", "m6", "m7"}, {"m8", "m9"}},
+		},
+		{
+			name:  "к старым",
+			url:   "/api/history?session_id=s&limit=3",
+			param: "before_id",
+			want:  [][]string{{"m7", "m8", "m9"}, {"m4", "m5", "m6"}, {"m1", "m2"}},
+		},
+		{
+			name:  "ровно на границе страницы",
+			url:   "/api/history?session_id=s&limit=4",
+			param: "after_id",
+			want:  [][]string{{"m1", "m2", "m4", "m5"}, {"m6", "m7", "m8", "m9"}},
+		},
+		{
+			name:  "с summary",
+			url:   "/api/history?session_id=s&limit=3&include_summary=1",
+			param: "after_id",
+			want:  [][]string{{"m1", "m2", "summary"}, {"m4", "m5", "m6"}, {"m7", "m8", "m9"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := walkHistory(t, h, tt.url, tt.param); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("страницы = %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryWithoutCursor(t *testing.T) {
+	store := newTestStore(t)
+	seedHistory(t, store)
+	h := NewHistoryHandler(store, testConfig)
+
+	// Без курсора — массив первых limit сообщений
+	var messages []storage.Message
+	getJSON(t, h, "/api/history?session_id=s&limit=3", &messages)
+	if got := contents(messages); !reflect.DeepEqual(got, []string{"m1", "m2", "m4"}) {
+		t.Errorf("история = %v", got)
+	}
+
+	// Пустая сессия — пустой массив, а не null
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history?session_id=empty", nil))
+	if body := rec.Body.String(); body != "[]\n" {
+		t.Errorf("пустая история = %q", body)
+	}
+}
+
+func TestLogsPagination(t *testing.T) {
+	store := newTestStore(t)
+	var ids []int64
+	for i := 0; i < 5; i++ {
+		session := "s"
+		if i == 2 {
+			session = "other"
+		}
+		log, err := store.SaveRequestLog(storage.NewRequestLog{SessionID: session, Provider: "p", StatusCode: 200})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session == "s" {
+			ids = append(ids, log.ID)
+		}
+	}
+	h := NewLogsHandler(store, testConfig)
+
+	// Логи листаются от новых к старым, фильтр по сессии не ломает курсор
+	var got []int64
+	url := "/api/logs?session_id=s&limit=3&before_id=0"
+	for i := 0; i < 5; i++ {
+		var page struct {
+			Logs       []storage.RequestLog `json:"logs"`
+			NextCursor int64                `json:"next_cursor"`
+		}
+		getJSON(t, h, url, &page)
+		if len(page.Logs) > 3 {
+			t.Fatalf("страница из %d логов при limit=3", len(page.Logs))
+		}
+		for _, l := range page.Logs {
+			got = append(got, l.ID)
+		}
+		if page.NextCursor == 0 {
+			break
+		}
+		url = "/api/logs?session_id=s&limit=3&before_id=" + strconv.FormatInt(page.NextCursor, 10)
+	}
+
+	want := map[int64]bool{}
+	for _, id := range ids {
+		want[id] = true
+	}
+	if len(got) != len(ids) {
+		t.Fatalf("логи %v, ожидались %v", got, ids)
+	}
+	for _, id := range got {
+		if !want[id] {
+			t.Errorf("лишний лог %d", id)
+		}
+		delete(want, id)
+	}
+}
@@ -11,11 +11,13 @@ import (
 
 	"github.com/nnk/97-aic/backend/logger"
 	"github.com/nnk/97-aic/backend/provider"
+	"github.com/nnk/97-aic/backend/storage"
 )
 
 // ModelsCompareHandler обрабатывает запросы для сравнения моделей
 type ModelsCompareHandler struct {
 	ProviderManager *provider.Manager
+	Storage         storage.Store
 }
 
 // CompareRequest запрос на сравнение моделей
@@ -33,6 +35,7 @@ type ModelResult struct {
 	TokensInput   int     `json:"tokens_input,omitempty"`
 	TokensOutput  int     `json:"tokens_output,omitempty"`
 	TokensTotal   int     `json:"tokens_total,omitempty"`
+	TokensSource  string  `json:"tokens_source,omitempty"` // provider — из ответа API, tokenizer — локальный токенизатор, estimate — оценка
 	Cost          float64 `json:"cost,omitempty"` // Стоимость в USD
 	Error         string  `json:"error,omitempty"`
 	ResponseTime  float64 `json:"response_time"` // Время ответа в секундах
@@ -66,9 +69,10 @@ type Comparison struct {
 }
 
 // NewModelsCompareHandler создает новый обработчик сравнения моделей
-func NewModelsCompareHandler(pm *provider.Manager) *ModelsCompareHandler {
+func NewModelsCompareHandler(pm *provider.Manager, store storage.Store) *ModelsCompareHandler {
 	return &ModelsCompareHandler{
 		ProviderManager: pm,
+		Storage:         store,
 	}
 }
 
@@ -115,8 +119,9 @@ func (h *ModelsCompareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		"models", req.Models,
 	)
 
-	// Выполняем запросы ко всем моделям
-	results := h.compareModels(r.Context(), req.Message, req.Models)
+	// Выполняем запросы ко всем моделям (логи сравнения группируются по общему session_id)
+	sessionID := fmt.Sprintf("compare_%d", time.Now().UnixNano())
+	results := h.compareModels(r.Context(), sessionID, req.Message, req.Models)
 
 	// Формируем сводку
 	summary := h.buildSummary(results)
@@ -144,11 +149,12 @@ func (h *ModelsCompareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 }
 
 // compareModels выполняет запросы ко всем моделям параллельно
-func (h *ModelsCompareHandler) compareModels(ctx context.Context, message string, models []string) []ModelResult {
+func (h *ModelsCompareHandler) compareModels(ctx context.Context, sessionID string, message string, models []string) []ModelResult {
 	results := make([]ModelResult, 0, len(models))
 
 	for _, modelSpec := range models {
 		result := h.testModel(ctx, message, modelSpec)
+		h.saveLog(ctx, sessionID, message, result)
 		results = append(results, result)
 	}
 
@@ -177,8 +183,8 @@ func (h *ModelsCompareHandler) testModel(ctx context.Context, message string, mo
 		Model:    modelName,
 	}
 
-	// Получаем провайдер
-	p, err := h.ProviderManager.Get(providerName)
+	// Получаем провайдер, привязанный к модели этого теста
+	p, err := h.ProviderManager.ForModel(providerName, modelName)
 	if err != nil {
 		result.Error = fmt.Sprintf("Провайдер не найден: %v. Доступные провайдеры: %v", err, h.ProviderManager.List())
 		result.DurationMs = time.Since(startTime).Milliseconds()
@@ -190,22 +196,16 @@ func (h *ModelsCompareHandler) testModel(ctx context.Context, message string, mo
 		return result
 	}
 
-	// Устанавливаем модель
-	p.SetModel(modelName)
-
 	// Выполняем запрос
 	var fullResponse strings.Builder
-	var tokenCount int
 
 	opts := &provider.ChatOptions{
 		Temperature: 0.7,
 		MaxTokens:   1000,
 	}
 
-	err = p.Chat(ctx, message, opts, func(chunk string) error {
+	chatResult, err := p.Chat(ctx, message, opts, func(chunk string) error {
 		fullResponse.WriteString(chunk)
-		// Простой подсчет токенов (приблизительный: ~4 символа на токен)
-		tokenCount += len(chunk) / 4
 		return nil
 	})
 
@@ -213,8 +213,14 @@ func (h *ModelsCompareHandler) testModel(ctx context.Context, message string, mo
 	result.DurationMs = duration.Milliseconds()
 	result.ResponseTime = duration.Seconds()
 	result.Response = fullResponse.String()
-	result.TokensOutput = tokenCount
-	result.TokensTotal = tokenCount
+
+	// Токены из ответа провайдера, иначе оценка
+	tok := h.ProviderManager.Tokenizer(p)
+	usage := provider.ResolveUsage(chatResult, tok, provider.CountTokensForMessages(tok, "", nil, message), result.Response)
+	result.TokensInput = usage.InputTokens
+	result.TokensOutput = usage.OutputTokens
+	result.TokensTotal = usage.TotalTokens
+	result.TokensSource = usage.Source
 
 	// Вычисляем скорость генерации
 	if result.ResponseTime > 0 {
@@ -239,8 +245,7 @@ func (h *ModelsCompareHandler) testModel(ctx context.Context, message string, mo
 		"tokens", result.TokensTotal,
 	)
 
-	// Для платных моделей вычисляем стоимость (заглушка, нужно реализовать для каждого провайдера)
-	result.Cost = h.calculateCost(providerName, modelName, result.TokensTotal)
+	result.Cost = p.CalculateCost(result.TokensInput, result.TokensOutput)
 
 	logger.Info("модель протестирована",
 		"provider", providerName,
@@ -252,17 +257,55 @@ func (h *ModelsCompareHandler) testModel(ctx context.Context, message string, mo
 	return result
 }
 
-// calculateCost вычисляет стоимость запроса (заглушка)
-func (h *ModelsCompareHandler) calculateCost(provider, model string, tokens int) float64 {
-	// Для локальных моделей (Ollama) стоимость = 0
-	if provider == "ollama" {
-		return 0.0
+// saveLog сохраняет результат теста модели в request_logs
+func (h *ModelsCompareHandler) saveLog(ctx context.Context, sessionID, message string, result ModelResult) {
+	if h.Storage == nil {
+		return
+	}
+
+	statusCode := http.StatusOK
+	if result.Error != "" {
+		statusCode = http.StatusInternalServerError
 	}
 
-	// Для платных провайдеров можно добавить реальные цены
-	// Например, для Groq или GigaChat
-	// Пока возвращаем 0 для всех
-	return 0.0
+	requestJSON, _ := json.Marshal(map[string]interface{}{
+		"message":    message,
+		"session_id": sessionID,
+		"provider":   result.Provider,
+		"model":      result.Model,
+		"source":     "models_compare",
+	})
+	responseData := map[string]interface{}{
+		"content":       result.Response,
+		"status":        statusCode,
+		"tokens_input":  result.TokensInput,
+		"tokens_output": result.TokensOutput,
+		"tokens_total":  result.TokensTotal,
+		"tokens_source": result.TokensSource,
+		"cost":          result.Cost,
+	}
+	if result.Error != "" {
+		responseData["error"] = result.Error
+	}
+	responseJSON, _ := json.Marshal(responseData)
+
+	tokensInput, tokensOutput, tokensTotal, cost := result.TokensInput, result.TokensOutput, result.TokensTotal, result.Cost
+	if _, err := h.Storage.SaveRequestLog(storage.NewRequestLog{
+		SessionID:    sessionID,
+		UserID:       contextUserID(ctx),
+		Provider:     result.Provider,
+		Model:        result.Model,
+		RequestJSON:  string(requestJSON),
+		ResponseJSON: string(responseJSON),
+		StatusCode:   statusCode,
+		DurationMs:   result.DurationMs,
+		TokensInput:  &tokensInput,
+		TokensOutput: &tokensOutput,
+		TokensTotal:  &tokensTotal,
+		Cost:         &cost,
+	}); err != nil {
+		logger.Warn("ошибка сохранения лога сравнения", "error", err)
+	}
 }
 
 // buildSummary строит сводку по результатам
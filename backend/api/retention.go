@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nnk/97-aic/backend/retention"
+)
+
+// RetentionHandler обрабатывает /api/v2/admin/retention:
+// GET — политика хранения и отчет последней очистки, POST — запустить очистку сейчас
+type RetentionHandler struct {
+	Janitor *retention.Janitor
+}
+
+// NewRetentionHandler создает обработчик политики хранения
+func NewRetentionHandler(janitor *retention.Janitor) *RetentionHandler {
+	return &RetentionHandler{
+		Janitor: janitor,
+	}
+}
+
+// retentionStatus ответ /api/v2/admin/retention
+type retentionStatus struct {
+	Enabled    bool              `json:"enabled"`
+	Policy     retentionPolicy   `json:"policy"`
+	LastReport *retention.Report `json:"last_report"` // null — очистка еще не запускалась
+}
+
+// retentionPolicy политика в единицах конфига (retention в config.yaml)
+type retentionPolicy struct {
+	LogsMaxAgeDays      int `json:"logs_max_age_days"`
+	LogsMaxRows         int `json:"logs_max_rows"`
+	SessionsMaxIdleDays int `json:"sessions_max_idle_days"`
+	IntervalMin         int `json:"interval_min"`
+}
+
+// ServeHTTP обрабатывает HTTP запросы политики хранения
+func (h *RetentionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		// Ручной запуск работает и без ограничений в политике — тогда только отчет с нулями
+		h.Janitor.RunOnce(r.Context())
+	default:
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		return
+	}
+
+	const day = 24 * time.Hour
+	p := h.Janitor.Policy
+	writeJSON(w, retentionStatus{
+		Enabled: p.Enabled(),
+		Policy: retentionPolicy{
+			LogsMaxAgeDays:      int(p.LogsMaxAge / day),
+			LogsMaxRows:         p.LogsMaxRows,
+			SessionsMaxIdleDays: int(p.SessionsMaxIdle / day),
+			IntervalMin:         int(p.Interval / time.Minute),
+		},
+		LastReport: h.Janitor.LastReport(),
+	})
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nnk/97-aic/backend/config"
+	"github.com/nnk/97-aic/backend/logger"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+// SearchHandler обрабатывает запросы к /api/v2/search — поиск по сообщениям всех сессий
+type SearchHandler struct {
+	Storage storage.Store
+	Config  *config.Config
+}
+
+// NewSearchHandler создает обработчик поиска
+func NewSearchHandler(store storage.Store, cfg *config.Config) *SearchHandler {
+	return &SearchHandler{
+		Storage: store,
+		Config:  cfg,
+	}
+}
+
+// ServeHTTP обрабатывает HTTP запросы поиска
+func (h *SearchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	q := storage.SearchQuery{
+		Query:     strings.TrimSpace(params.Get("q")),
+		SessionID: params.Get("session_id"),
+		UserID:    ownerScope(r),
+		Role:      params.Get("role"),
+		Limit:     h.Config.DefaultQueryLimit,
+	}
+	if q.Query == "" {
+		http.Error(w, "Параметр q обязателен", http.StatusBadRequest)
+		return
+	}
+	switch q.Role {
+	case "", storage.RoleUser, storage.RoleAssistant, storage.RoleSummary:
+	default:
+		http.Error(w, "Неизвестная роль: "+q.Role, http.StatusBadRequest)
+		return
+	}
+
+	if l, err := strconv.Atoi(params.Get("limit")); err == nil && l > 0 {
+		q.Limit = l
+	}
+	if q.Limit > h.Config.MaxQueryLimit {
+		q.Limit = h.Config.MaxQueryLimit
+	}
+
+	if c := params.Get("cursor"); c != "" {
+		cursor, err := strconv.ParseInt(c, 10, 64)
+		if err != nil || cursor <= 0 {
+			http.Error(w, "Некорректный cursor", http.StatusBadRequest)
+			return
+		}
+		q.BeforeID = cursor
+	}
+
+	var err error
+	if q.From, err = parseDateParam(params.Get("from"), false); err != nil {
+		http.Error(w, "Некорректный from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.To, err = parseDateParam(params.Get("to"), true); err != nil {
+		http.Error(w, "Некорректный to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, next, err := h.Storage.Search(q)
+	if err != nil {
+		logger.Error("ошибка поиска", "error", err, "query", q.Query)
+		http.Error(w, "Ошибка поиска", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"results": results,
+		"mode":    h.Storage.SearchMode(),
+	}
+	if next > 0 {
+		resp["next_cursor"] = strconv.FormatInt(next, 10)
+	}
+	writeJSON(w, resp)
+}
+
+// parseDateParam разбирает дату фильтра: RFC3339 или YYYY-MM-DD (UTC).
+// Для верхней границы дата без времени включает весь день.
+func parseDateParam(s string, upper bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
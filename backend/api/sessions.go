@@ -0,0 +1,249 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nnk/97-aic/backend/config"
+	"github.com/nnk/97-aic/backend/logger"
+	"github.com/nnk/97-aic/backend/provider"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+// SessionsHandler обрабатывает запросы к /api/v2/sessions:
+//
+//	GET    /api/v2/sessions       — список сессий (недавние первыми)
+//	GET    /api/v2/sessions/{id}  — сессия
+//	PATCH  /api/v2/sessions/{id}  — изменить название и настройки по умолчанию
+//	DELETE /api/v2/sessions/{id}  — удалить сессию с сообщениями
+//	GET    /api/v2/sessions/{id}/messages — все сообщения сессии (дерево веток)
+//	POST   /api/v2/sessions/{id}/branch   — сделать активной ветку через сообщение
+//	GET    /api/v2/sessions/{id}/export?format=json|md|jsonl — выгрузить сессию
+//	POST   /api/v2/sessions/import        — создать сессию из JSON-экспорта
+//
+// С авторизацией доступны только сессии пользователя запроса.
+type SessionsHandler struct {
+	Storage         storage.Store
+	ProviderManager *provider.Manager
+	Config          *config.Config
+}
+
+// NewSessionsHandler создает обработчик сессий
+func NewSessionsHandler(store storage.Store, pm *provider.Manager, cfg *config.Config) *SessionsHandler {
+	return &SessionsHandler{
+		Storage:         store,
+		ProviderManager: pm,
+		Config:          cfg,
+	}
+}
+
+// reasoningModes допустимые режимы рассуждения ("" — не задан)
+var reasoningModes = map[string]bool{
+	"":             true,
+	"direct":       true,
+	"step_by_step": true,
+	"experts":      true,
+}
+
+// ServeHTTP обрабатывает HTTP запросы
+func (h *SessionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v2/sessions"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+
+	if id == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+		h.list(w, r)
+		return
+	}
+
+	if id == "import" && action == "" && r.Method == http.MethodPost {
+		h.importSession(w, r)
+		return
+	}
+	if !authorizeSession(w, r, h.Storage, id) {
+		return
+	}
+
+	switch action {
+	case "":
+	case "messages":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+		h.tree(w, id)
+		return
+	case "branch":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+		h.switchBranch(w, r, id)
+		return
+	case "export":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+		h.export(w, r, id)
+		return
+	default:
+		http.Error(w, "Не найдено", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sess, err := h.Storage.GetSession(id)
+		if err != nil {
+			logger.Error("ошибка получения сессии", "error", err, "session_id", id)
+			http.Error(w, "Ошибка получения сессии", http.StatusInternalServerError)
+			return
+		}
+		if sess == nil {
+			http.Error(w, "Сессия не найдена", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, sess)
+	case http.MethodPatch:
+		h.patch(w, r, id)
+	case http.MethodDelete:
+		deleted, err := h.Storage.DeleteSession(id)
+		if err != nil {
+			logger.Error("ошибка удаления сессии", "error", err, "session_id", id)
+			http.Error(w, "Ошибка удаления сессии", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Сессия не найдена", http.StatusNotFound)
+			return
+		}
+		logger.Info("сессия удалена", "session_id", id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *SessionsHandler) list(w http.ResponseWriter, r *http.Request) {
+	limit := h.Config.DefaultQueryLimit
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > h.Config.MaxQueryLimit {
+		limit = h.Config.MaxQueryLimit
+	}
+
+	sessions, err := h.Storage.ListSessions(ownerScope(r), limit)
+	if err != nil {
+		logger.Error("ошибка получения сессий", "error", err)
+		http.Error(w, "Ошибка получения сессий", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"sessions": sessions})
+}
+
+func (h *SessionsHandler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	var patch storage.SessionPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка парсинга запроса: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if patch.ReasoningMode != nil && !reasoningModes[*patch.ReasoningMode] {
+		http.Error(w, "Неизвестный reasoning_mode: "+*patch.ReasoningMode, http.StatusBadRequest)
+		return
+	}
+	if patch.Provider != nil && *patch.Provider != "" {
+		model := ""
+		if patch.Model != nil {
+			model = *patch.Model
+		}
+		if _, err := h.ProviderManager.ForModel(*patch.Provider, model); err != nil {
+			http.Error(w, fmt.Sprintf("Ошибка провайдера: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	existing, err := h.Storage.GetSession(id)
+	if err != nil {
+		logger.Error("ошибка получения сессии", "error", err, "session_id", id)
+		http.Error(w, "Ошибка получения сессии", http.StatusInternalServerError)
+		return
+	}
+	if existing == nil {
+		http.Error(w, "Сессия не найдена", http.StatusNotFound)
+		return
+	}
+
+	sess, err := h.Storage.UpdateSession(id, patch)
+	if err != nil {
+		logger.Error("ошибка обновления сессии", "error", err, "session_id", id)
+		http.Error(w, "Ошибка обновления сессии", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, sess)
+}
+
+// tree отдает все сообщения сессии с parent_id и конец активной ветки
+func (h *SessionsHandler) tree(w http.ResponseWriter, id string) {
+	messages, leafID, err := h.Storage.GetMessageTree(id)
+	if err != nil {
+		logger.Error("ошибка получения дерева сообщений", "error", err, "session_id", id)
+		http.Error(w, "Ошибка получения сообщений", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"active_leaf_id": leafID,
+		"messages":       messages,
+	})
+}
+
+// switchBranch переключает активную ветку: {"message_id": N} — любое сообщение ветки,
+// активной становится самая новая ветка, проходящая через него
+func (h *SessionsHandler) switchBranch(w http.ResponseWriter, r *http.Request, id string) {
+	var body struct {
+		MessageID int64 `json:"message_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.MessageID == 0 {
+		http.Error(w, "Поле message_id обязательно", http.StatusBadRequest)
+		return
+	}
+
+	leafID, err := h.Storage.SetActiveBranch(id, body.MessageID)
+	if err != nil {
+		logger.Error("ошибка переключения ветки", "error", err, "session_id", id)
+		http.Error(w, "Ошибка переключения ветки", http.StatusInternalServerError)
+		return
+	}
+	if leafID == 0 {
+		http.Error(w, "Сообщение не найдено в сессии", http.StatusNotFound)
+		return
+	}
+
+	messages, err := h.Storage.GetBranch(id, leafID, h.Config.MaxQueryLimit)
+	if err != nil {
+		logger.Error("ошибка получения ветки", "error", err, "session_id", id)
+		http.Error(w, "Ошибка получения ветки", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("ветка переключена", "session_id", id, "message_id", body.MessageID, "active_leaf_id", leafID)
+	writeJSON(w, map[string]interface{}{
+		"active_leaf_id": leafID,
+		"messages":       messages,
+	})
+}
+
+// writeJSON отправляет значение как JSON
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("ошибка кодирования ответа", "error", err)
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// SSEProtocolVersion версия протокола событий /api/v2/chat (передается в meta и заголовке X-SSE-Protocol)
+const SSEProtocolVersion = 2
+
+// Имена событий потока
+const (
+	EventMeta       = "meta"        // параметры запроса: сессия, провайдер, модель
+	EventDelta      = "delta"       // фрагмент ответа
+	EventUsage      = "usage"       // токены и стоимость
+	EventError      = "error"       // ошибка генерации
+	EventDone       = "done"        // конец потока
+	EventContext    = "context"     // что из истории попало в окно модели
+	EventFallback   = "fallback"    // переключение на резервный провайдер
+	EventToolCall   = "tool_call"   // фрагмент вызова инструмента
+	EventToolCalls  = "tool_calls"  // итоговые вызовы инструментов
+	EventJSONRepair = "json_repair" // попытка исправления JSON-ответа
+	EventJSONResult = "json_result" // итог строгого JSON-режима
+
+	// Только /api/v2/agent
+	EventStep       = "step"        // начало шага модели
+	EventToolResult = "tool_result" // результат выполнения инструмента
+	EventResult     = "result"      // итог запуска агента
+)
+
+// MetaEvent данные события meta
+type MetaEvent struct {
+	Protocol     int    `json:"protocol"`
+	GenerationID string `json:"generation_id,omitempty"` // для переподключения к потоку (/api/v2/chat)
+	RunID        string `json:"run_id,omitempty"`        // запуск агента (/api/v2/agent)
+	SessionID    string `json:"session_id"`
+	Provider     string `json:"provider"`
+	Model        string `json:"model"`
+}
+
+// DeltaEvent данные события delta
+type DeltaEvent struct {
+	Content string `json:"content"`
+}
+
+// UsageEvent данные события usage
+type UsageEvent struct {
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	TotalTokens  int     `json:"total_tokens"`
+	Source       string  `json:"source"` // provider, tokenizer, estimate
+	Cost         float64 `json:"cost"`
+}
+
+// ErrorEvent данные события error
+type ErrorEvent struct {
+	Message string `json:"message"`
+}
+
+// DoneEvent данные события done
+type DoneEvent struct {
+	FinishReason      string `json:"finish_reason,omitempty"`
+	Provider          string `json:"provider"` // ответивший провайдер (после переключений)
+	Model             string `json:"model"`
+	RequestedProvider string `json:"requested_provider"`
+	DurationMs        int64  `json:"duration_ms"`
+}
+
+// sseEvent событие потока с порядковым id (в пределах генерации)
+type sseEvent struct {
+	ID    int64
+	Event string
+	Data  json.RawMessage
+}
+
+// sseWriter пишет события SSE с последовательными id.
+// В legacy-режиме пишет старый формат: безымянные кадры data: {"content": ...}, {"error": ...}, [DONE].
+type sseWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	legacy  bool
+	lastID  int64
+}
+
+// newSSEWriter выставляет заголовки потока; возвращает ошибку, если ResponseWriter не умеет Flush
+func newSSEWriter(w http.ResponseWriter, legacy bool) (*sseWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming не поддерживается")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	if !legacy {
+		w.Header().Set("X-SSE-Protocol", strconv.Itoa(SSEProtocolVersion))
+	}
+
+	return &sseWriter{w: w, flusher: flusher, legacy: legacy}, nil
+}
+
+// Send отправляет событие со следующим id
+func (s *sseWriter) Send(event string, data interface{}) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.write(sseEvent{ID: s.lastID + 1, Event: event, Data: jsonData})
+}
+
+// Write отправляет готовое событие (например из буфера генерации) с его id
+func (s *sseWriter) Write(ev sseEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.write(ev)
+}
+
+func (s *sseWriter) write(ev sseEvent) {
+	s.lastID = ev.ID
+	if s.legacy {
+		s.writeLegacy(ev)
+		return
+	}
+	fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Event, ev.Data)
+	s.flusher.Flush()
+}
+
+// writeLegacy отправляет событие в старом формате (протокол 1)
+func (s *sseWriter) writeLegacy(ev sseEvent) {
+	var frame interface{}
+	switch ev.Event {
+	case EventMeta, EventUsage:
+		// В старом протоколе этих событий нет
+		return
+	case EventDelta:
+		var delta DeltaEvent
+		json.Unmarshal(ev.Data, &delta)
+		frame = map[string]string{"content": delta.Content}
+	case EventError:
+		var e ErrorEvent
+		json.Unmarshal(ev.Data, &e)
+		frame = map[string]string{"error": e.Message}
+	case EventDone:
+		fmt.Fprintf(s.w, "data: [DONE]\n\n")
+		s.flusher.Flush()
+		return
+	default:
+		frame = map[string]json.RawMessage{ev.Event: ev.Data}
+	}
+
+	jsonData, err := json.Marshal(frame)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(s.w, "data: %s\n\n", jsonData)
+	s.flusher.Flush()
+}
+
+// Follow отправляет события генерации после lastID и ждет новых до конца генерации
+// или до отключения клиента (сама генерация при этом продолжается)
+func (s *sseWriter) Follow(ctx context.Context, gen *Generation, lastID int64) {
+	for {
+		events, done, wake := gen.Since(lastID)
+		for _, ev := range events {
+			s.Write(ev)
+			lastID = ev.ID
+		}
+		if done {
+			return
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
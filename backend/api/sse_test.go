@@ -0,0 +1,119 @@
+package api
+
+import (
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/nnk/97-aic/backend/history"
+	"github.com/nnk/97-aic/backend/provider"
+)
+
+// testdata/sse — эталонные потоки /api/v2/chat. Те же файлы разбирает клиент
+// (frontend/tests/sse.test.mjs), так что формат событий сервера и парсер фронтенда
+// проверяются на одних данных. После намеренного изменения протокола:
+// go test ./api -run TestSSEFixtures -update
+var updateFixtures = flag.Bool("update", false, "перезаписать эталонные файлы testdata")
+
+type fixtureEvent struct {
+	event string
+	data  interface{}
+}
+
+// chatStream события успешного ответа: контекст, переключение провайдера, текст, вызов инструмента
+var chatStream = []fixtureEvent{
+	{EventMeta, MetaEvent{Protocol: SSEProtocolVersion, GenerationID: "gen-1", SessionID: "session_1", Provider: "groq", Model: "llama-3.3-70b-versatile"}},
+	{EventContext, history.ContextReport{ContextLimit: 8192, ReservedOutput: 1024, Budget: 7168, UsedTokens: 120, HistoryTotal: 4, HistoryKept: 3, DroppedMessages: 1}},
+	{EventFallback, provider.FallbackEvent{From: "gigachat", To: "groq", Error: "connection refused"}},
+	{EventDelta, DeltaEvent{Content: "Привет"}},
+	{EventDelta, DeltaEvent{Content: ", мир!\nКак дела?"}},
+	{EventToolCall, provider.ToolCallDelta{Index: 0, ID: "call_0", Name: "calculator", ArgumentsDelta: `{"expression":"2+2"}`}},
+	{EventToolCalls, []provider.ToolCall{{ID: "call_0", Name: "calculator", Arguments: `{"expression":"2+2"}`}}},
+	{EventUsage, UsageEvent{InputTokens: 120, OutputTokens: 9, TotalTokens: 129, Source: provider.UsageSourceProvider, Cost: 0.0004}},
+	{EventDone, DoneEvent{FinishReason: "stop", Provider: "groq", Model: "llama-3.3-70b-versatile", RequestedProvider: "gigachat", DurationMs: 812}},
+}
+
+// chatErrorStream ответ, оборванный ошибкой провайдера
+var chatErrorStream = []fixtureEvent{
+	{EventMeta, MetaEvent{Protocol: SSEProtocolVersion, GenerationID: "gen-2", SessionID: "session_1", Provider: "gigachat", Model: "GigaChat"}},
+	{EventDelta, DeltaEvent{Content: "Нача"}},
+	{EventError, ErrorEvent{Message: "gigachat: status 503"}},
+	{EventUsage, UsageEvent{InputTokens: 10, OutputTokens: 1, TotalTokens: 11, Source: provider.UsageSourceEstimate}},
+	{EventDone, DoneEvent{Provider: "gigachat", Model: "GigaChat", RequestedProvider: "gigachat", DurationMs: 95}},
+}
+
+func TestSSEFixtures(t *testing.T) {
+	tests := []struct {
+		file   string
+		legacy bool
+		events []fixtureEvent
+	}{
+		{"chat_v2.sse", false, chatStream},
+		{"chat_v2_error.sse", false, chatErrorStream},
+		{"chat_legacy.sse", true, chatStream},
+		{"chat_legacy_error.sse", true, chatErrorStream},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			stream, err := newSSEWriter(rec, tt.legacy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ev := range tt.events {
+				stream.Send(ev.event, ev.data)
+			}
+
+			wantHeader := "2"
+			if tt.legacy {
+				wantHeader = ""
+			}
+			if got := rec.Header().Get("X-SSE-Protocol"); got != wantHeader {
+				t.Errorf("X-SSE-Protocol = %q, ожидалось %q", got, wantHeader)
+			}
+
+			path := filepath.Join("testdata", "sse", tt.file)
+			if *updateFixtures {
+				if err := os.WriteFile(path, rec.Body.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rec.Body.String(); got != string(want) {
+				t.Errorf("поток отличается от %s:\n%s\nожидалось:\n%s", path, got, want)
+			}
+		})
+	}
+}
+
+// Разбор эталона тем же способом, что и на клиенте: кадры через пустую строку, id по порядку
+func TestSSEFixtureFrames(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "sse", "chat_v2.sse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := readSSE(t, strings.NewReader(string(data)))
+	if len(frames) != len(chatStream) {
+		t.Fatalf("кадров %d, ожидалось %d", len(frames), len(chatStream))
+	}
+	for i, f := range frames {
+		if f.Event != chatStream[i].event || f.ID != strconv.Itoa(i+1) {
+			t.Errorf("кадр %d: %+v", i, f)
+		}
+		if strings.Contains(f.Data, "\n") {
+			t.Errorf("кадр %d: data в несколько строк", i)
+		}
+	}
+	var delta DeltaEvent
+	decodeFrame(t, frames[4], &delta)
+	if delta.Content != ", мир!\nКак дела?" {
+		t.Errorf("delta = %q", delta.Content)
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nnk/97-aic/backend/logger"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+// defaultStatsWindow период статистики, если from не задан: логи растут без ограничения,
+// и сводка по всей таблице на каждый запрос обходилась бы все дороже
+const defaultStatsWindow = 30 * 24 * time.Hour
+
+// defaultStatsGroupBy группировка статистики по умолчанию
+var defaultStatsGroupBy = []string{storage.StatsByProvider, storage.StatsByModel, storage.StatsByDay}
+
+// StatsHandler обрабатывает запросы к /api/v2/stats — агрегаты логов запросов
+type StatsHandler struct {
+	Storage storage.Store
+}
+
+// NewStatsHandler создает обработчик статистики
+func NewStatsHandler(store storage.Store) *StatsHandler {
+	return &StatsHandler{
+		Storage: store,
+	}
+}
+
+// ServeHTTP обрабатывает HTTP запросы статистики
+func (h *StatsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params := r.URL.Query()
+	q := storage.StatsQuery{
+		Provider: params.Get("provider"),
+		Model:    params.Get("model"),
+		UserID:   ownerScope(r),
+		GroupBy:  defaultStatsGroupBy,
+	}
+
+	// group_by= (пустое значение) — только итог
+	if params.Has("group_by") {
+		q.GroupBy = nil
+		for _, by := range strings.Split(params.Get("group_by"), ",") {
+			by = strings.TrimSpace(by)
+			switch by {
+			case "":
+			case storage.StatsByProvider, storage.StatsByModel, storage.StatsByDay:
+				q.GroupBy = append(q.GroupBy, by)
+			default:
+				http.Error(w, "Неизвестная группировка: "+by, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	var err error
+	if q.From, err = parseDateParam(params.Get("from"), false); err != nil {
+		http.Error(w, "Некорректный from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.To, err = parseDateParam(params.Get("to"), true); err != nil {
+		http.Error(w, "Некорректный to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if q.From.IsZero() {
+		to := q.To
+		if to.IsZero() {
+			to = time.Now()
+		}
+		q.From = to.Add(-defaultStatsWindow)
+	}
+
+	stats, err := h.Storage.RequestStats(q)
+	if err != nil {
+		logger.Error("ошибка получения статистики", "error", err)
+		http.Error(w, "Ошибка получения статистики", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, stats)
+}
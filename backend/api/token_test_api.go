@@ -10,11 +10,13 @@ import (
 
 	"github.com/nnk/97-aic/backend/logger"
 	"github.com/nnk/97-aic/backend/provider"
+	"github.com/nnk/97-aic/backend/storage"
 )
 
 // TokenTestHandler обрабатывает запросы для тестирования токенов
 type TokenTestHandler struct {
 	ProviderManager *provider.Manager
+	Storage         storage.Store
 }
 
 // TokenTestRequest запрос на тестирование токенов
@@ -32,6 +34,7 @@ type TokenTestResult struct {
 	TokensInput  int     `json:"tokens_input"`   // Токены запроса
 	TokensOutput int     `json:"tokens_output"`  // Токены ответа
 	TokensTotal  int     `json:"tokens_total"`   // Всего токенов
+	TokensSource string  `json:"tokens_source"`  // provider — из ответа API, tokenizer — локальный токенизатор, estimate — оценка
 	Cost         float64 `json:"cost"`           // Стоимость
 	DurationMs   int64   `json:"duration_ms"`    // Время выполнения
 	Success      bool    `json:"success"`        // Успех/ошибка
@@ -58,9 +61,10 @@ type TokenTestSummary struct {
 }
 
 // NewTokenTestHandler создает новый обработчик тестирования токенов
-func NewTokenTestHandler(pm *provider.Manager) *TokenTestHandler {
+func NewTokenTestHandler(pm *provider.Manager, store storage.Store) *TokenTestHandler {
 	return &TokenTestHandler{
 		ProviderManager: pm,
+		Storage:         store,
 	}
 }
 
@@ -83,18 +87,13 @@ func (h *TokenTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Получаем провайдер
-	p, err := h.ProviderManager.Get(req.Provider)
+	// Получаем провайдер, привязанный к модели запроса (общий экземпляр не меняется)
+	p, err := h.ProviderManager.ForModel(req.Provider, req.Model)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Ошибка провайдера: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	// Устанавливаем модель
-	if req.Model != "" {
-		p.SetModel(req.Model)
-	}
-
 	maxTokens := p.GetMaxTokens()
 
 	// Определяем какие тесты нужно выполнить
@@ -105,10 +104,12 @@ func (h *TokenTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		testTypes = []string{req.TestType}
 	}
 
-	// Выполняем тесты
+	// Выполняем тесты (логи группируются по общему session_id)
+	sessionID := fmt.Sprintf("token_test_%d", time.Now().UnixNano())
 	var results []TokenTestResult
 	for _, testType := range testTypes {
 		result := h.runTest(r.Context(), p, testType, maxTokens)
+		h.saveLog(r.Context(), sessionID, p, result)
 		results = append(results, result)
 	}
 
@@ -137,6 +138,9 @@ func (h *TokenTestHandler) runTest(ctx context.Context, p provider.Provider, tes
 		MaxTokens: maxTokens,
 	}
 
+	// Длина запросов считается токенизатором тестируемой модели
+	tok := h.ProviderManager.Tokenizer(p)
+
 	// Генерируем запрос в зависимости от типа теста
 	var message string
 	var targetTokens int
@@ -144,18 +148,18 @@ func (h *TokenTestHandler) runTest(ctx context.Context, p provider.Provider, tes
 	switch testType {
 	case "short":
 		targetTokens = 50
-		message = provider.GenerateTextForTokens(targetTokens, "Это короткий тестовый запрос. ")
+		message = provider.GenerateTextForTokens(tok, targetTokens, "Это короткий тестовый запрос. ")
 	case "long":
 		// Генерируем запрос близкий к лимиту, но не превышающий его
 		targetTokens = maxTokens - 500 // Оставляем запас
 		if targetTokens < 1000 {
 			targetTokens = 1000 // Минимум 1000 токенов для длинного запроса
 		}
-		message = provider.GenerateTextForTokens(targetTokens, "Это длинный тестовый запрос для проверки обработки больших объемов текста. ")
+		message = provider.GenerateTextForTokens(tok, targetTokens, "Это длинный тестовый запрос для проверки обработки больших объемов текста. ")
 	case "exceed_limit":
 		// Генерируем запрос, который превышает лимит
 		targetTokens = maxTokens + 1000
-		message = provider.GenerateTextForTokens(targetTokens, "Это запрос, который превышает максимальный лимит токенов модели. ")
+		message = provider.GenerateTextForTokens(tok, targetTokens, "Это запрос, который превышает максимальный лимит токенов модели. ")
 	default:
 		result.Error = fmt.Sprintf("Неизвестный тип теста: %s", testType)
 		result.Success = false
@@ -163,13 +167,12 @@ func (h *TokenTestHandler) runTest(ctx context.Context, p provider.Provider, tes
 	}
 
 	result.Message = message
-	result.TokensInput = provider.CountTokens(message)
 
 	startTime := time.Now()
 
 	// Выполняем запрос
 	var fullResponse string
-	err := p.Chat(ctx, message, &provider.ChatOptions{
+	chatResult, err := p.Chat(ctx, message, &provider.ChatOptions{
 		MaxTokens: maxTokens,
 	}, func(chunk string) error {
 		fullResponse += chunk
@@ -179,8 +182,13 @@ func (h *TokenTestHandler) runTest(ctx context.Context, p provider.Provider, tes
 	durationMs := time.Since(startTime).Milliseconds()
 	result.DurationMs = durationMs
 	result.Response = fullResponse
-	result.TokensOutput = provider.CountTokens(fullResponse)
-	result.TokensTotal = result.TokensInput + result.TokensOutput
+
+	// Токены из ответа провайдера, иначе оценка
+	usage := provider.ResolveUsage(chatResult, tok, tok.Count(message), fullResponse)
+	result.TokensInput = usage.InputTokens
+	result.TokensOutput = usage.OutputTokens
+	result.TokensTotal = usage.TotalTokens
+	result.TokensSource = usage.Source
 	result.Cost = p.CalculateCost(result.TokensInput, result.TokensOutput)
 
 	if err != nil {
@@ -201,12 +209,65 @@ func (h *TokenTestHandler) runTest(ctx context.Context, p provider.Provider, tes
 			"tokens_input", result.TokensInput,
 			"tokens_output", result.TokensOutput,
 			"tokens_total", result.TokensTotal,
+			"tokens_source", result.TokensSource,
 		)
 	}
 
 	return result
 }
 
+// saveLog сохраняет результат теста в request_logs
+func (h *TokenTestHandler) saveLog(ctx context.Context, sessionID string, p provider.Provider, result TokenTestResult) {
+	if h.Storage == nil {
+		return
+	}
+
+	statusCode := http.StatusOK
+	if !result.Success {
+		statusCode = http.StatusInternalServerError
+	}
+
+	requestJSON, _ := json.Marshal(map[string]interface{}{
+		"message":    result.Message,
+		"session_id": sessionID,
+		"provider":   p.Name(),
+		"model":      p.GetModel(),
+		"test_type":  result.TestType,
+		"source":     "token_test",
+	})
+	responseData := map[string]interface{}{
+		"content":       result.Response,
+		"status":        statusCode,
+		"tokens_input":  result.TokensInput,
+		"tokens_output": result.TokensOutput,
+		"tokens_total":  result.TokensTotal,
+		"tokens_source": result.TokensSource,
+		"cost":          result.Cost,
+	}
+	if result.Error != "" {
+		responseData["error"] = result.Error
+	}
+	responseJSON, _ := json.Marshal(responseData)
+
+	tokensInput, tokensOutput, tokensTotal, cost := result.TokensInput, result.TokensOutput, result.TokensTotal, result.Cost
+	if _, err := h.Storage.SaveRequestLog(storage.NewRequestLog{
+		SessionID:    sessionID,
+		UserID:       contextUserID(ctx),
+		Provider:     p.Name(),
+		Model:        p.GetModel(),
+		RequestJSON:  string(requestJSON),
+		ResponseJSON: string(responseJSON),
+		StatusCode:   statusCode,
+		DurationMs:   result.DurationMs,
+		TokensInput:  &tokensInput,
+		TokensOutput: &tokensOutput,
+		TokensTotal:  &tokensTotal,
+		Cost:         &cost,
+	}); err != nil {
+		logger.Warn("ошибка сохранения лога теста токенов", "error", err)
+	}
+}
+
 // buildSummary строит сводку по результатам тестирования
 func (h *TokenTestHandler) buildSummary(results []TokenTestResult) TokenTestSummary {
 	summary := TokenTestSummary{
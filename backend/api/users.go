@@ -0,0 +1,215 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nnk/97-aic/backend/logger"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+// UsersHandler обрабатывает запросы к /api/v2/admin/users (только администраторы):
+//
+//	GET    /api/v2/admin/users                    — пользователи
+//	POST   /api/v2/admin/users                    — создать пользователя с первым ключом
+//	GET    /api/v2/admin/users/{id}/keys          — ключи пользователя
+//	POST   /api/v2/admin/users/{id}/keys          — выпустить ключ
+//	DELETE /api/v2/admin/users/{id}/keys/{key_id} — отозвать ключ
+type UsersHandler struct {
+	Storage storage.Store
+}
+
+// NewUsersHandler создает обработчик пользователей
+func NewUsersHandler(store storage.Store) *UsersHandler {
+	return &UsersHandler{
+		Storage: store,
+	}
+}
+
+// issuedKey выпущенный ключ: key показывается только в этом ответе
+type issuedKey struct {
+	User   *storage.User   `json:"user,omitempty"`
+	APIKey *storage.APIKey `json:"api_key"`
+	Key    string          `json:"key"`
+}
+
+// ServeHTTP обрабатывает HTTP запросы пользователей
+func (h *UsersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v2/admin/users"), "/")
+	parts := strings.Split(rest, "/")
+
+	switch {
+	case rest == "":
+		switch r.Method {
+		case http.MethodGet:
+			users, err := h.Storage.ListUsers()
+			if err != nil {
+				logger.Error("ошибка получения пользователей", "error", err)
+				http.Error(w, "Ошибка получения пользователей", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, map[string]interface{}{"users": users})
+		case http.MethodPost:
+			h.create(w, r)
+		default:
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		}
+	case len(parts) == 2 && parts[1] == "keys":
+		switch r.Method {
+		case http.MethodGet:
+			h.listKeys(w, parts[0])
+		case http.MethodPost:
+			h.createKey(w, r, parts[0])
+		default:
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		}
+	case len(parts) == 3 && parts[1] == "keys":
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+			return
+		}
+		h.revokeKey(w, parts[0], parts[2])
+	default:
+		http.Error(w, "Не найдено", http.StatusNotFound)
+	}
+}
+
+// create создает пользователя {"name": "...", "is_admin": false} и выпускает ему ключ
+func (h *UsersHandler) create(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name    string `json:"name"`
+		IsAdmin bool   `json:"is_admin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("Ошибка парсинга запроса: %v", err), http.StatusBadRequest)
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		http.Error(w, "Поле name обязательно", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.Storage.CreateUser(body.Name, body.IsAdmin)
+	if err != nil {
+		logger.Error("ошибка создания пользователя", "error", err)
+		http.Error(w, "Ошибка создания пользователя", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "Пользователь "+body.Name+" уже существует", http.StatusConflict)
+		return
+	}
+
+	apiKey, key, err := h.Storage.CreateAPIKey(user.ID, "")
+	if err != nil {
+		logger.Error("ошибка создания ключа", "error", err, "user_id", user.ID)
+		http.Error(w, "Ошибка создания ключа", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("пользователь создан", "user_id", user.ID, "name", user.Name, "is_admin", user.IsAdmin)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(issuedKey{User: user, APIKey: apiKey, Key: key})
+}
+
+// findUser возвращает пользователя или отвечает 404
+func (h *UsersHandler) findUser(w http.ResponseWriter, userID string) *storage.User {
+	user, err := h.Storage.GetUser(userID)
+	if err != nil {
+		logger.Error("ошибка получения пользователя", "error", err, "user_id", userID)
+		http.Error(w, "Ошибка получения пользователя", http.StatusInternalServerError)
+		return nil
+	}
+	if user == nil {
+		http.Error(w, "Пользователь не найден", http.StatusNotFound)
+	}
+	return user
+}
+
+func (h *UsersHandler) listKeys(w http.ResponseWriter, userID string) {
+	if h.findUser(w, userID) == nil {
+		return
+	}
+	keys, err := h.Storage.ListAPIKeys(userID)
+	if err != nil {
+		logger.Error("ошибка получения ключей", "error", err, "user_id", userID)
+		http.Error(w, "Ошибка получения ключей", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"keys": keys})
+}
+
+// createKey выпускает пользователю ключ {"name": "..."} (тело необязательно)
+func (h *UsersHandler) createKey(w http.ResponseWriter, r *http.Request, userID string) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("Ошибка парсинга запроса: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if h.findUser(w, userID) == nil {
+		return
+	}
+
+	apiKey, key, err := h.Storage.CreateAPIKey(userID, strings.TrimSpace(body.Name))
+	if err != nil {
+		logger.Error("ошибка создания ключа", "error", err, "user_id", userID)
+		http.Error(w, "Ошибка создания ключа", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("ключ выпущен", "user_id", userID, "key_id", apiKey.ID, "prefix", apiKey.Prefix)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(issuedKey{APIKey: apiKey, Key: key})
+}
+
+func (h *UsersHandler) revokeKey(w http.ResponseWriter, userID, keyIDStr string) {
+	keyID, err := strconv.ParseInt(keyIDStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Некорректный id ключа", http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := h.Storage.RevokeAPIKey(userID, keyID)
+	if err != nil {
+		logger.Error("ошибка отзыва ключа", "error", err, "user_id", userID, "key_id", keyID)
+		http.Error(w, "Ошибка отзыва ключа", http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, "Ключ не найден", http.StatusNotFound)
+		return
+	}
+	logger.Info("ключ отозван", "user_id", userID, "key_id", keyID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MeHandler обрабатывает GET /api/v2/me — текущий пользователь
+type MeHandler struct{}
+
+// NewMeHandler создает обработчик текущего пользователя
+func NewMeHandler() *MeHandler {
+	return &MeHandler{}
+}
+
+// ServeHTTP обрабатывает HTTP запросы текущего пользователя. Без авторизации user — null.
+func (h *MeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Метод не разрешен", http.StatusMethodNotAllowed)
+		return
+	}
+	user := UserFromContext(r.Context())
+	writeJSON(w, map[string]interface{}{
+		"auth_enabled": user != nil,
+		"user":         user,
+	})
+}
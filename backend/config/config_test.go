@@ -0,0 +1,90 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func loadYAML(t *testing.T, data string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestOpenAICompatibleModelMaxTokens(t *testing.T) {
+	cfg, err := loadYAML(t, `
+providers:
+  openai_compatible:
+    - name: "gateway"
+      enabled: true
+      api_url: "http://localhost:8000/v1"
+      max_tokens: 16384
+      models:
+        - "small"
+        - name: "large"
+          max_tokens: 131072
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc := cfg.Providers.OpenAICompatible[0]
+	if got, want := pc.ModelNames(), []string{"small", "large"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ModelNames = %v, ожидалось %v", got, want)
+	}
+	if got, want := pc.ModelMaxTokens(), map[string]int{"large": 131072}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ModelMaxTokens = %v, ожидалось %v", got, want)
+	}
+	if pc.MaxTokens != 16384 {
+		t.Errorf("MaxTokens = %d", pc.MaxTokens)
+	}
+}
+
+func TestOpenAICompatibleStreamUsage(t *testing.T) {
+	cfg, err := loadYAML(t, `
+providers:
+  openai_compatible:
+    - name: "vllm"
+      enabled: true
+      api_url: "http://localhost:8000/v1"
+    - name: "lmstudio"
+      enabled: true
+      api_url: "http://localhost:1234/v1"
+      stream_usage: false
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Providers.OpenAICompatible[0].StreamUsageEnabled() {
+		t.Error("без stream_usage usage в стриме должен запрашиваться")
+	}
+	if cfg.Providers.OpenAICompatible[1].StreamUsageEnabled() {
+		t.Error("stream_usage: false не отключил usage в стриме")
+	}
+}
+
+func TestOpenAICompatibleModelValidation(t *testing.T) {
+	for name, models := range map[string]string{
+		"без имени":                `[{max_tokens: 1000}]`,
+		"отрицательный max_tokens": `[{name: "m", max_tokens: -1}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadYAML(t, `
+providers:
+  openai_compatible:
+    - name: "gateway"
+      enabled: true
+      api_url: "http://localhost:8000/v1"
+      models: `+models+"\n")
+			if err == nil || !strings.Contains(err.Error(), "models[0]") {
+				t.Errorf("ожидалась ошибка про models[0], получено %v", err)
+			}
+		})
+	}
+}
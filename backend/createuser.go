@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nnk/97-aic/backend/config"
+	"github.com/nnk/97-aic/backend/logger"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+// runCreateUser создает пользователя с API-ключом и печатает ключ; возвращает код выхода.
+// Аргументы: <name> [--admin] [config.yaml]
+func runCreateUser(args []string) int {
+	logger.Init(config.DefaultLogLevel, false)
+
+	isAdmin := false
+	configPath := "config.yaml"
+	var positional []string
+	for _, arg := range args {
+		if arg == "--admin" || arg == "-admin" {
+			isAdmin = true
+			continue
+		}
+		positional = append(positional, arg)
+	}
+	if len(positional) == 0 || len(positional) > 2 {
+		logger.Error("использование: create-user <name> [--admin] [config.yaml]")
+		return 2
+	}
+	name := positional[0]
+	if len(positional) == 2 {
+		configPath = positional[1]
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		logger.Error("ошибка загрузки конфига", "error", err, "path", configPath)
+		return 1
+	}
+	logger.Init(cfg.LogLevel, cfg.LogFormat == "json")
+
+	store, err := storage.Open(cfg.DatabaseURL, cfg.DatabasePath)
+	if err != nil {
+		logger.Error("ошибка инициализации хранилища", "error", err)
+		return 1
+	}
+	defer store.Close()
+
+	user, err := store.CreateUser(name, isAdmin)
+	if err != nil {
+		logger.Error("ошибка создания пользователя", "error", err)
+		return 1
+	}
+	if user == nil {
+		logger.Error("пользователь уже существует", "name", name)
+		return 1
+	}
+	apiKey, key, err := store.CreateAPIKey(user.ID, "")
+	if err != nil {
+		logger.Error("ошибка создания ключа", "error", err)
+		return 1
+	}
+
+	logger.Info("пользователь создан", "user_id", user.ID, "name", user.Name, "is_admin", user.IsAdmin, "key_id", apiKey.ID)
+	// Ключ показывается один раз: в БД хранится только его хеш
+	fmt.Println(key)
+	return 0
+}
@@ -18,6 +18,9 @@ import (
 	"github.com/google/uuid"
 )
 
+// DefaultModel модель, которой клиент отправляет запросы
+const DefaultModel = "GigaChat"
+
 // Client представляет клиент для работы с GigaChat API
 type Client struct {
 	httpClient *http.Client
@@ -368,7 +371,7 @@ func (c *Client) ChatWithOptions(ctx context.Context, message string, opts *Chat
 	})
 
 	reqBody := ChatRequest{
-		Model:    "GigaChat",
+		Model:    DefaultModel,
 		Messages: messages,
 		Stream:   true,
 	}
@@ -48,8 +48,10 @@ func (c Config) withDefaults() Config {
 }
 
 // CompressSessionIfNeeded сворачивает историю в summary (батчами) и удаляет оригиналы.
-// Возвращает true, если была выполнена компрессия хотя бы один раз.
-func CompressSessionIfNeeded(ctx context.Context, p provider.Provider, store *storage.Storage, sessionID string, cfg Config) (bool, error) {
+// Summary одно на сессию и подставляется в историю любой ветки, поэтому сжимается только
+// начало диалога, общее для всех веток (до первой развилки); ответы на удаленные сообщения
+// становятся корнями дерева. Возвращает true, если была выполнена компрессия хотя бы один раз.
+func CompressSessionIfNeeded(ctx context.Context, p provider.Provider, store storage.Store, sessionID string, cfg Config) (bool, error) {
 	if store == nil || p == nil || sessionID == "" {
 		return false, nil
 	}
@@ -157,7 +159,7 @@ func summarize(ctx context.Context, p provider.Provider, prompt string, maxToken
 	}
 
 	var out strings.Builder
-	err := p.Chat(ctx, prompt, opts, func(chunk string) error {
+	_, err := p.Chat(ctx, prompt, opts, func(chunk string) error {
 		out.WriteString(chunk)
 		return nil
 	})
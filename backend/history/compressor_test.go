@@ -0,0 +1,200 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nnk/97-aic/backend/provider"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+// summaryProvider отвечает на запрос резюме фиксированным текстом
+type summaryProvider struct {
+	calls int
+}
+
+func (p *summaryProvider) Name() string     { return "summary" }
+func (p *summaryProvider) Models() []string { return []string{"m"} }
+func (p *summaryProvider) Chat(ctx context.Context, message string, opts *provider.ChatOptions, onChunk func(string) error) (*provider.ChatResult, error) {
+	p.calls++
+	return &provider.ChatResult{}, onChunk(fmt.Sprintf("резюме %d", p.calls))
+}
+func (p *summaryProvider) SetModel(string)                   {}
+func (p *summaryProvider) GetModel() string                  { return "m" }
+func (p *summaryProvider) GetMaxTokens() int                 { return 4096 }
+func (p *summaryProvider) GetMaxTokensForModel(string) int   { return 4096 }
+func (p *summaryProvider) CalculateCost(in, out int) float64 { return 0 }
+
+func newTestStore(t *testing.T) *storage.Storage {
+	t.Helper()
+	store, err := storage.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// addChain добавляет n сообщений (user/assistant по очереди) под parentID
+// (0 — новый корень) и возвращает их id
+func addChain(t *testing.T, store storage.Store, session string, parentID int64, prefix string, n int) []int64 {
+	t.Helper()
+	var ids []int64
+	for i := 0; i < n; i++ {
+		role := storage.RoleUser
+		if i%2 == 1 {
+			role = storage.RoleAssistant
+		}
+		parent := parentID
+		msg, err := store.AddMessage(storage.NewMessage{
+			SessionID: session,
+			Role:      role,
+			Content:   fmt.Sprintf("%s%d", prefix, i),
+			ParentID:  &parent,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, msg.ID)
+		parentID = msg.ID
+	}
+	return ids
+}
+
+func branchContents(t *testing.T, store storage.Store, session string, leafID int64) []string {
+	t.Helper()
+	msgs, err := store.GetBranch(session, leafID, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, m := range msgs {
+		out = append(out, m.Content)
+	}
+	return out
+}
+
+var compressCfg = Config{Enabled: true, EveryMessages: 2, KeepLastMessages: 2}
+
+func TestCompressLinearSession(t *testing.T) {
+	store := newTestStore(t)
+	ids := addChain(t, store, "s", 0, "m", 8)
+
+	p := &summaryProvider{}
+	did, err := CompressSessionIfNeeded(context.Background(), p, store, "s", compressCfg)
+	if err != nil || !did {
+		t.Fatalf("did=%v err=%v", did, err)
+	}
+
+	// Свернуто все, кроме хвоста из KeepLastMessages + EveryMessages
+	leaf, _ := store.ActiveLeafID("s")
+	if leaf != ids[7] {
+		t.Fatalf("конец ветки %d, ожидался %d", leaf, ids[7])
+	}
+	if got := branchContents(t, store, "s", leaf); !reflect.DeepEqual(got, []string{"m4", "m5", "m6", "m7"}) {
+		t.Errorf("ветка после компрессии = %v", got)
+	}
+	first, _ := store.GetMessage("s", ids[4])
+	if first.ParentID != 0 {
+		t.Errorf("первое оставшееся сообщение ссылается на удаленное %d", first.ParentID)
+	}
+	summary, _ := store.GetLatestSummary("s")
+	if summary == nil || summary.Content != "резюме 2" {
+		t.Errorf("summary = %+v", summary)
+	}
+}
+
+func TestCompressKeepsMessagesOtherBranchesDependOn(t *testing.T) {
+	store := newTestStore(t)
+
+	// m0..m3 — общее начало; на m3 развилка: ветка a (6 сообщений) и ветка b (2 сообщения)
+	shared := addChain(t, store, "s", 0, "m", 4)
+	branchB := addChain(t, store, "s", shared[3], "b", 2)
+	branchA := addChain(t, store, "s", shared[3], "a", 6)
+
+	p := &summaryProvider{}
+	if _, err := CompressSessionIfNeeded(context.Background(), p, store, "s", compressCfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Сжато только общее начало: после него у дерева два корня, и компрессия останавливается
+	if p.calls != 2 {
+		t.Errorf("батчей сжато %d, ожидалось 2", p.calls)
+	}
+	for _, id := range shared {
+		if m, _ := store.GetMessage("s", id); m != nil {
+			t.Errorf("общее сообщение %d не сжато", id)
+		}
+	}
+
+	// Обе ветки целы и начинаются сразу после summary
+	if got := branchContents(t, store, "s", branchA[5]); !reflect.DeepEqual(got, []string{"a0", "a1", "a2", "a3", "a4", "a5"}) {
+		t.Errorf("активная ветка = %v", got)
+	}
+	if got := branchContents(t, store, "s", branchB[1]); !reflect.DeepEqual(got, []string{"b0", "b1"}) {
+		t.Errorf("соседняя ветка = %v", got)
+	}
+
+	// В дереве нет ссылок на удаленные сообщения
+	tree, leaf, err := store.GetMessageTree("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf != branchA[5] {
+		t.Errorf("конец активной ветки %d, ожидался %d", leaf, branchA[5])
+	}
+	exists := map[int64]bool{}
+	for _, m := range tree {
+		exists[m.ID] = true
+	}
+	for _, m := range tree {
+		if m.ParentID != 0 && !exists[m.ParentID] {
+			t.Errorf("сообщение %d ссылается на удаленное %d", m.ID, m.ParentID)
+		}
+	}
+
+	// Повторный запуск ничего не трогает: общего начала больше нет
+	if did, err := CompressSessionIfNeeded(context.Background(), p, store, "s", compressCfg); err != nil || did {
+		t.Errorf("повторная компрессия: did=%v err=%v", did, err)
+	}
+}
+
+func TestCompressSkipsSessionForkedAtRoot(t *testing.T) {
+	store := newTestStore(t)
+	addChain(t, store, "s", 0, "x", 2)
+	addChain(t, store, "s", 0, "m", 8)
+
+	p := &summaryProvider{}
+	did, err := CompressSessionIfNeeded(context.Background(), p, store, "s", compressCfg)
+	if err != nil || did || p.calls != 0 {
+		t.Fatalf("did=%v calls=%d err=%v", did, p.calls, err)
+	}
+}
+
+func TestDeleteMessagesReparentsChildren(t *testing.T) {
+	store := newTestStore(t)
+	ids := addChain(t, store, "s", 0, "m", 4)
+	side := addChain(t, store, "s", ids[1], "b", 1)
+
+	// Удаление середины: ответы переходят к ближайшему оставшемуся предку
+	if err := store.DeleteMessagesByIDs("s", []int64{ids[1], ids[2]}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{ids[3], side[0]} {
+		m, _ := store.GetMessage("s", id)
+		if m.ParentID != ids[0] {
+			t.Errorf("сообщение %d: родитель %d, ожидался %d", id, m.ParentID, ids[0])
+		}
+	}
+
+	// Удаление конца активной ветки сдвигает его к оставшемуся предку
+	if err := store.DeleteMessagesByIDs("s", []int64{side[0]}); err != nil {
+		t.Fatal(err)
+	}
+	if leaf, _ := store.ActiveLeafID("s"); leaf != ids[0] {
+		t.Errorf("конец активной ветки %d, ожидался %d", leaf, ids[0])
+	}
+}
@@ -0,0 +1,211 @@
+package history
+
+import (
+	"github.com/nnk/97-aic/backend/provider"
+	"github.com/nnk/97-aic/backend/tokenizer"
+)
+
+// SummaryPrefix заголовок, с которым summary диалога добавляется в system prompt
+const SummaryPrefix = "КРАТКОЕ РЕЗЮМЕ ПРЕДЫДУЩЕГО ДИАЛОГА (используй как контекст):\n"
+
+const (
+	// defaultReservedOutput сколько токенов оставлять под ответ, если max_tokens не задан
+	defaultReservedOutput = 1024
+	// messageOverhead служебные токены на одно сообщение истории (роль, разметка)
+	messageOverhead = 2
+	// requestOverhead служебные токены на структуру запроса
+	requestOverhead = 10
+	// minTruncatedTokens меньше этого сообщение не обрезается, а отбрасывается целиком
+	minTruncatedTokens = 32
+	// truncationMarker ставится на месте обрезанной части текста
+	truncationMarker = "…"
+)
+
+// ContextRequest исходные данные для сборки контекста запроса
+type ContextRequest struct {
+	Tokenizer       tokenizer.Tokenizer // nil — приблизительная оценка
+	ContextLimit    int                 // окно контекста модели (Provider.GetMaxTokens)
+	MaxOutputTokens int                 // max_tokens запроса, резервируется под ответ
+	SystemPrompt    string              // system prompt пользователя (не обрезается)
+	ReasoningMode   string              // режим рассуждения: его инструкцию провайдер добавит к system prompt
+	JSONFormat      bool                // JSON-режим: провайдер добавит JSON-инструкцию
+	JSONSchemaText  string              // схема, которая попадет в JSON-инструкцию
+	Summary         string              // summary предыдущего диалога
+	History         []provider.Message  // история от старых к новым
+	Message         string              // текущее сообщение (не обрезается)
+}
+
+// ContextReport что попало в контекст и что было отрезано
+type ContextReport struct {
+	ContextLimit      int  `json:"context_limit"`
+	ReservedOutput    int  `json:"reserved_output"`
+	Budget            int  `json:"budget"`      // токенов доступно под вход
+	UsedTokens        int  `json:"used_tokens"` // токенов занято входом
+	HistoryTotal      int  `json:"history_total"`
+	HistoryKept       int  `json:"history_kept"`
+	DroppedMessages   int  `json:"dropped_messages"`
+	TruncatedMessages int  `json:"truncated_messages"`
+	SummaryTruncated  bool `json:"summary_truncated,omitempty"`
+	SummaryDropped    bool `json:"summary_dropped,omitempty"`
+	Overflow          bool `json:"overflow,omitempty"` // system prompt и сообщение не помещаются даже без истории
+}
+
+// Trimmed сообщает, была ли часть контекста отброшена или обрезана
+func (r ContextReport) Trimmed() bool {
+	return r.DroppedMessages > 0 || r.TruncatedMessages > 0 || r.SummaryTruncated || r.SummaryDropped
+}
+
+// AssembledContext собранный контекст запроса
+type AssembledContext struct {
+	SystemPrompt string // system prompt пользователя + summary
+	History      []provider.Message
+	Report       ContextReport
+}
+
+// AssembleContext собирает контекст, укладывающийся в окно модели.
+// Под ответ резервируется max_tokens, затем в оставшийся бюджет помещаются
+// итоговый system prompt (вместе с инструкциями режима рассуждения и JSON,
+// которые добавит провайдер) и текущее сообщение целиком, summary и история
+// от новых сообщений к старым. Не поместившиеся старые сообщения отбрасываются,
+// граничное сообщение (и summary) обрезаются с начала.
+func AssembleContext(req ContextRequest) AssembledContext {
+	tok := req.Tokenizer
+	if tok == nil {
+		tok = tokenizer.Approximate{}
+	}
+
+	report := ContextReport{
+		ContextLimit: req.ContextLimit,
+		HistoryTotal: len(req.History),
+	}
+
+	// Без известного лимита ничего не обрезаем
+	if req.ContextLimit <= 0 {
+		report.HistoryKept = len(req.History)
+		return AssembledContext{
+			SystemPrompt: joinSystemPrompt(req.SystemPrompt, req.Summary),
+			History:      req.History,
+			Report:       report,
+		}
+	}
+
+	reserved := req.MaxOutputTokens
+	if reserved <= 0 {
+		reserved = defaultReservedOutput
+		if reserved > req.ContextLimit/4 {
+			reserved = req.ContextLimit / 4
+		}
+	}
+	if reserved >= req.ContextLimit {
+		reserved = req.ContextLimit / 2
+	}
+	report.ReservedOutput = reserved
+	report.Budget = req.ContextLimit - reserved
+
+	// Обязательная часть: структура запроса, итоговый system prompt, текущее сообщение
+	used := requestOverhead + tok.Count(req.Message)
+	systemPrompt := provider.BuildSystemPrompt(&provider.ChatOptions{
+		SystemPrompt:   req.SystemPrompt,
+		ReasoningMode:  req.ReasoningMode,
+		JSONFormat:     req.JSONFormat,
+		JSONSchemaText: req.JSONSchemaText,
+	})
+	if systemPrompt != "" {
+		used += tok.Count(systemPrompt)
+	}
+	if used > report.Budget {
+		report.Overflow = true
+	}
+	remaining := report.Budget - used
+
+	// Summary
+	summary := req.Summary
+	if summary != "" {
+		cost := tok.Count(SummaryPrefix + summary)
+		switch {
+		case cost <= remaining:
+			remaining -= cost
+		case remaining-tok.Count(SummaryPrefix) >= minTruncatedTokens:
+			summary = truncateHead(tok, summary, remaining-tok.Count(SummaryPrefix))
+			report.SummaryTruncated = true
+			remaining -= tok.Count(SummaryPrefix + summary)
+		default:
+			summary = ""
+			report.SummaryDropped = true
+		}
+	}
+
+	// История: от новых сообщений к старым
+	kept := make([]provider.Message, 0, len(req.History))
+	start := len(req.History)
+	for i := len(req.History) - 1; i >= 0; i-- {
+		msg := req.History[i]
+		cost := tok.Count(msg.Role) + tok.Count(msg.Content) + messageOverhead
+		if cost <= remaining {
+			remaining -= cost
+			kept = append(kept, msg)
+			start = i
+			continue
+		}
+
+		// Граничное сообщение обрезаем с начала, если в бюджете осталось достаточно места
+		available := remaining - tok.Count(msg.Role) - messageOverhead
+		if available >= minTruncatedTokens {
+			msg.Content = truncateHead(tok, msg.Content, available)
+			remaining -= tok.Count(msg.Role) + tok.Count(msg.Content) + messageOverhead
+			kept = append(kept, msg)
+			report.TruncatedMessages++
+			start = i
+		}
+		break
+	}
+	report.DroppedMessages = start
+
+	// Возвращаем хронологический порядок
+	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+		kept[i], kept[j] = kept[j], kept[i]
+	}
+	report.HistoryKept = len(kept)
+	report.UsedTokens = report.Budget - remaining
+
+	return AssembledContext{
+		SystemPrompt: joinSystemPrompt(req.SystemPrompt, summary),
+		History:      kept,
+		Report:       report,
+	}
+}
+
+// joinSystemPrompt добавляет summary к system prompt пользователя
+func joinSystemPrompt(systemPrompt, summary string) string {
+	if summary == "" {
+		return systemPrompt
+	}
+	if systemPrompt != "" {
+		systemPrompt += "\n\n"
+	}
+	return systemPrompt + SummaryPrefix + summary
+}
+
+// truncateHead оставляет конец текста (самую свежую часть), укладывающийся в maxTokens
+// вместе с маркером обрезки
+func truncateHead(tok tokenizer.Tokenizer, text string, maxTokens int) string {
+	if tok.Count(text) <= maxTokens {
+		return text
+	}
+	runes := []rune(text)
+
+	// Бинарный поиск самого длинного суффикса, который помещается в бюджет
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if tok.Count(truncationMarker+string(runes[len(runes)-mid:])) <= maxTokens {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	if lo == 0 {
+		return ""
+	}
+	return truncationMarker + string(runes[len(runes)-lo:])
+}
@@ -0,0 +1,57 @@
+package history
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nnk/97-aic/backend/provider"
+	"github.com/nnk/97-aic/backend/tokenizer"
+)
+
+// Инструкции режима рассуждения и JSON провайдер добавляет к system prompt позже,
+// но место под них должно быть зарезервировано при сборке контекста
+func TestAssembleContextReservesModePrompts(t *testing.T) {
+	var history []provider.Message
+	for i := 0; i < 80; i++ {
+		history = append(history, provider.Message{Role: "user", Content: strings.Repeat("слово ", 20)})
+	}
+	base := ContextRequest{
+		ContextLimit:    2048,
+		MaxOutputTokens: 256,
+		SystemPrompt:    "Ты помощник.",
+		History:         history,
+		Message:         "Реши задачу",
+	}
+
+	plain := AssembleContext(base)
+
+	withModes := base
+	withModes.ReasoningMode = provider.ReasoningExperts
+	withModes.JSONFormat = true
+	withModes.JSONSchemaText = `{"type":"object","properties":{"answer":{"type":"string"}}}`
+	modes := AssembleContext(withModes)
+
+	if modes.Report.HistoryKept >= plain.Report.HistoryKept {
+		t.Errorf("с инструкциями сохранено %d сообщений истории, без них %d: инструкции не учтены в бюджете",
+			modes.Report.HistoryKept, plain.Report.HistoryKept)
+	}
+
+	// Итоговый запрос, который соберет провайдер, укладывается в бюджет
+	tok := tokenizer.Approximate{}
+	finalPrompt := provider.BuildSystemPrompt(&provider.ChatOptions{
+		SystemPrompt:   modes.SystemPrompt,
+		ReasoningMode:  withModes.ReasoningMode,
+		JSONFormat:     withModes.JSONFormat,
+		JSONSchemaText: withModes.JSONSchemaText,
+	})
+	used := requestOverhead + tok.Count(finalPrompt) + tok.Count(withModes.Message)
+	for _, msg := range modes.History {
+		used += tok.Count(msg.Role) + tok.Count(msg.Content) + messageOverhead
+	}
+	if used > modes.Report.Budget {
+		t.Errorf("итоговый запрос занимает %d токенов при бюджете %d", used, modes.Report.Budget)
+	}
+	if modes.SystemPrompt != base.SystemPrompt {
+		t.Errorf("SystemPrompt = %q: инструкции добавляет провайдер, а не AssembleContext", modes.SystemPrompt)
+	}
+}
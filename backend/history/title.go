@@ -0,0 +1,115 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nnk/97-aic/backend/logger"
+	"github.com/nnk/97-aic/backend/provider"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+const (
+	titleMaxTokens   = 32
+	titleTemperature = 0.3
+	titleMaxRunes    = 80
+	titleSourceRunes = 1500 // сколько текста сообщения отдавать модели
+)
+
+// GenerateTitleIfNeeded придумывает название сессии по первому обмену сообщениями,
+// если у сессии еще нет названия. Возвращает название или пустую строку, если генерация не нужна.
+func GenerateTitleIfNeeded(ctx context.Context, p provider.Provider, store storage.Store, sessionID string) (string, error) {
+	if store == nil || p == nil || sessionID == "" {
+		return "", nil
+	}
+
+	sess, err := store.GetSession(sessionID)
+	if err != nil || sess == nil || sess.Title != "" {
+		return "", err
+	}
+
+	messages, err := store.GetMessages(sessionID, 10)
+	if err != nil {
+		return "", err
+	}
+	var question, answer string
+	for _, m := range messages {
+		switch {
+		case m.Role == storage.RoleUser && question == "":
+			question = m.Content
+		case m.Role == storage.RoleAssistant && question != "" && answer == "":
+			answer = m.Content
+		}
+	}
+	if question == "" || answer == "" {
+		return "", nil
+	}
+
+	title, err := generateTitle(ctx, p, question, answer)
+	if err != nil {
+		return "", err
+	}
+
+	saved, err := store.SetSessionTitleIfEmpty(sessionID, title)
+	if err != nil || !saved {
+		// Пользователь успел назвать сессию сам
+		return "", err
+	}
+	logger.Info("название сессии сгенерировано", "session_id", sessionID, "title", title)
+	return title, nil
+}
+
+func generateTitle(ctx context.Context, p provider.Provider, question, answer string) (string, error) {
+	var b strings.Builder
+	b.WriteString("Придумай короткое название для диалога (2–6 слов) на языке вопроса.\n")
+	b.WriteString("Ответь только названием: без кавычек, точки в конце и пояснений.\n\n")
+	b.WriteString("Вопрос пользователя:\n")
+	b.WriteString(truncateRunes(question, titleSourceRunes))
+	b.WriteString("\n\nОтвет ассистента:\n")
+	b.WriteString(truncateRunes(answer, titleSourceRunes))
+
+	opts := &provider.ChatOptions{
+		SystemPrompt: "Ты — модуль, который придумывает названия диалогов. Отвечай только названием.",
+		MaxTokens:    titleMaxTokens,
+		Temperature:  titleTemperature,
+	}
+
+	var out strings.Builder
+	_, err := p.Chat(ctx, b.String(), opts, func(chunk string) error {
+		out.WriteString(chunk)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("ошибка генерации названия: %w", err)
+	}
+
+	title := normalizeTitle(out.String())
+	if title == "" {
+		return "", fmt.Errorf("получено пустое название")
+	}
+	return title, nil
+}
+
+// normalizeTitle берет первую непустую строку ответа и убирает кавычки, markdown и точку в конце
+func normalizeTitle(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimLeft(line, "#*- ")
+		line = strings.TrimPrefix(line, "Название:")
+		line = strings.Trim(strings.TrimSpace(line), "\"'«»*`")
+		line = strings.TrimRight(line, ".")
+		if line != "" {
+			return truncateRunes(strings.TrimSpace(line), titleMaxRunes)
+		}
+	}
+	return ""
+}
+
+func truncateRunes(s string, n int) string {
+	rs := []rune(s)
+	if len(rs) <= n {
+		return s
+	}
+	return string(rs[:n])
+}
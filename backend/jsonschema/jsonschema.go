@@ -0,0 +1,326 @@
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema схема JSON. Поддерживается подмножество, достаточное для аргументов
+// инструментов и структурированных ответов: type, properties, required,
+// additionalProperties (true/false), items (одна схема), enum, const,
+// minimum/maximum, minLength/maxLength, minItems/maxItems. Остальные ключевые
+// слова (anyOf, $ref, pattern, format, ...) Parse отклоняет: молча пропущенное
+// ограничение выдавало бы несоответствующее схеме значение за проверенное.
+type Schema struct {
+	Type                 interface{}        `json:"type,omitempty"` // строка или список строк
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Const                json.RawMessage    `json:"const,omitempty"` // как есть, чтобы отличать const: null от отсутствия
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+// ValidationError ошибки проверки значения по схеме
+type ValidationError struct {
+	Problems []string // "путь: описание"
+}
+
+// Error возвращает текст ошибки
+func (e *ValidationError) Error() string {
+	return "значение не соответствует схеме: " + strings.Join(e.Problems, "; ")
+}
+
+// supportedKeywords ключевые слова, которые проверяет validate
+var supportedKeywords = map[string]bool{
+	"type": true, "properties": true, "required": true, "additionalProperties": true,
+	"items": true, "enum": true, "const": true, "minimum": true, "maximum": true,
+	"minLength": true, "maxLength": true, "minItems": true, "maxItems": true,
+}
+
+// annotationKeywords ключевые слова, не влияющие на проверку
+var annotationKeywords = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true,
+	"description": true, "default": true, "examples": true,
+}
+
+// knownTypes типы JSON Schema
+var knownTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true,
+}
+
+// Parse разбирает схему из JSON. Возвращает ошибку, если схема использует
+// ключевые слова, которые валидатор не поддерживает.
+func Parse(data []byte) (*Schema, error) {
+	if err := checkKeywords("$", data); err != nil {
+		return nil, fmt.Errorf("JSON Schema не поддерживается: %w", err)
+	}
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("ошибка разбора JSON Schema: %w", err)
+	}
+	return &s, nil
+}
+
+// checkKeywords рекурсивно проверяет, что схема по пути path состоит только
+// из поддерживаемых ключевых слов
+func checkKeywords(path string, data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil || raw == nil {
+		return fmt.Errorf("%s: схема должна быть JSON-объектом", path)
+	}
+
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !supportedKeywords[key] && !annotationKeywords[key] {
+			return fmt.Errorf("%s: ключевое слово %q не поддерживается", path, key)
+		}
+	}
+
+	if t, ok := raw["type"]; ok {
+		var names []string
+		var name string
+		if err := json.Unmarshal(t, &name); err == nil {
+			names = []string{name}
+		} else if err := json.Unmarshal(t, &names); err != nil {
+			return fmt.Errorf("%s: type должен быть строкой или списком строк", path)
+		}
+		for _, name := range names {
+			if !knownTypes[name] {
+				return fmt.Errorf("%s: неизвестный тип %q", path, name)
+			}
+		}
+	}
+
+	if ap, ok := raw["additionalProperties"]; ok {
+		var b bool
+		if err := json.Unmarshal(ap, &b); err != nil {
+			return fmt.Errorf("%s: additionalProperties поддерживается только как true или false", path)
+		}
+	}
+
+	if props, ok := raw["properties"]; ok {
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(props, &m); err != nil {
+			return fmt.Errorf("%s: properties должен быть объектом", path)
+		}
+		names := make([]string, 0, len(m))
+		for name := range m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := checkKeywords(path+".properties."+name, m[name]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if items, ok := raw["items"]; ok {
+		if trimmed := bytes.TrimSpace(items); len(trimmed) > 0 && trimmed[0] == '[' {
+			return fmt.Errorf("%s: items поддерживается только как одна схема", path)
+		}
+		if err := checkKeywords(path+".items", items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateJSON разбирает data и проверяет его по схеме.
+// Возвращает *ValidationError, если значение не соответствует схеме.
+func (s *Schema) ValidateJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return fmt.Errorf("некорректный JSON: %w", err)
+	}
+	if dec.More() {
+		return fmt.Errorf("некорректный JSON: лишние данные после значения")
+	}
+	return s.Validate(value)
+}
+
+// Validate проверяет разобранное значение (числа — json.Number или float64)
+func (s *Schema) Validate(value interface{}) error {
+	var problems []string
+	s.validate("$", value, &problems)
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (s *Schema) validate(path string, value interface{}, problems *[]string) {
+	if s == nil {
+		return
+	}
+	addf := func(format string, args ...interface{}) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if types := s.types(); len(types) > 0 {
+		actual := typeOf(value)
+		ok := false
+		for _, t := range types {
+			if t == actual || (t == "number" && actual == "integer") {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			addf("ожидается %s, получено %s", strings.Join(types, " или "), actual)
+			return
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if equal(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			addf("значение не входит в enum")
+		}
+	}
+	if len(s.Const) > 0 {
+		var c interface{}
+		if err := json.Unmarshal(s.Const, &c); err != nil || !equal(c, value) {
+			addf("значение не равно const")
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				addf("отсутствует обязательное поле %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					addf("лишнее поле %q", name)
+				}
+				continue
+			}
+			prop.validate(path+"."+name, v[name], problems)
+		}
+
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			addf("элементов меньше %d", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			addf("элементов больше %d", *s.MaxItems)
+		}
+		for i, item := range v {
+			s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, problems)
+		}
+
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			addf("длина меньше %d", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			addf("длина больше %d", *s.MaxLength)
+		}
+
+	case json.Number, float64:
+		f, _ := toFloat(v)
+		if s.Minimum != nil && f < *s.Minimum {
+			addf("значение меньше %v", *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			addf("значение больше %v", *s.Maximum)
+		}
+	}
+}
+
+// types возвращает список допустимых типов
+func (s *Schema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, item := range t {
+			if name, ok := item.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// typeOf возвращает тип значения в терминах JSON Schema
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number, float64:
+		f, _ := toFloat(v)
+		if f == float64(int64(f)) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "unknown"
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// equal сравнивает значения enum/const с учетом разных представлений чисел
+func equal(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
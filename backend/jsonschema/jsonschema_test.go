@@ -0,0 +1,127 @@
+package jsonschema
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseRejectsUnsupportedKeywords(t *testing.T) {
+	tests := []struct {
+		schema string
+		want   string // фрагмент ошибки
+	}{
+		{`{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, `"anyOf"`},
+		{`{"oneOf": [{"type": "string"}]}`, `"oneOf"`},
+		{`{"allOf": [{"type": "string"}]}`, `"allOf"`},
+		{`{"$ref": "#/definitions/user"}`, `"$ref"`},
+		{`{"type": "string", "pattern": "^[a-z]+$"}`, `"pattern"`},
+		{`{"type": "number", "exclusiveMinimum": 0}`, `"exclusiveMinimum"`},
+		{`{"type": "object", "properties": {"email": {"type": "string", "format": "email"}}}`, `$.properties.email: ключевое слово "format"`},
+		{`{"type": "array", "items": {"type": "object", "properties": {"id": {"not": {}}}}}`, `$.items.properties.id: ключевое слово "not"`},
+		{`{"type": "array", "items": [{"type": "string"}]}`, "items поддерживается только как одна схема"},
+		{`{"type": "object", "additionalProperties": {"type": "string"}}`, "additionalProperties поддерживается только как true или false"},
+		{`{"type": "int"}`, `неизвестный тип "int"`},
+		{`{"type": 1}`, "type должен быть строкой или списком строк"},
+		{`{"properties": {"a": true}}`, "$.properties.a: схема должна быть JSON-объектом"},
+		{`[]`, "$: схема должна быть JSON-объектом"},
+		{`null`, "$: схема должна быть JSON-объектом"},
+	}
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.schema))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%s) = %v, ожидалась ошибка с %q", tt.schema, err, tt.want)
+		}
+	}
+}
+
+func TestParseAcceptsAnnotations(t *testing.T) {
+	_, err := Parse([]byte(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id": "person",
+		"title": "Человек",
+		"description": "Карточка",
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "description": "Имя", "examples": ["Иван"]},
+			"age": {"type": ["integer", "null"], "default": null, "$comment": "лет"}
+		},
+		"required": ["name"],
+		"additionalProperties": false
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		schema   string
+		value    string
+		problems []string // пусто — значение соответствует схеме
+	}{
+		{"тип", `{"type": "string"}`, `"x"`, nil},
+		{"неверный тип", `{"type": "string"}`, `1`, []string{"$: ожидается string, получено integer"}},
+		{"integer — это number", `{"type": "number"}`, `3`, nil},
+		{"дробное не integer", `{"type": "integer"}`, `3.5`, []string{"$: ожидается integer, получено number"}},
+		{"целое с точкой — integer", `{"type": "integer"}`, `3.0`, nil},
+		{"список типов", `{"type": ["string", "null"]}`, `null`, nil},
+
+		{"объект", `{"type": "object", "properties": {"age": {"type": "integer", "minimum": 0, "maximum": 150}}, "required": ["name", "age"], "additionalProperties": false}`,
+			`{"age": 200, "x": 1}`,
+			[]string{`$: отсутствует обязательное поле "name"`, "$.age: значение больше 150", `$: лишнее поле "x"`}},
+		{"дополнительные поля по умолчанию разрешены", `{"type": "object", "properties": {}}`, `{"x": 1}`, nil},
+
+		{"массив", `{"type": "array", "items": {"type": "string", "minLength": 2}, "minItems": 1, "maxItems": 2}`,
+			`["ab", "c", "de"]`, []string{"$: элементов больше 2", "$[1]: длина меньше 2"}},
+		{"пустой массив", `{"type": "array", "minItems": 1}`, `[]`, []string{"$: элементов меньше 1"}},
+		{"длина в символах, а не байтах", `{"type": "string", "maxLength": 3}`, `"мир"`, nil},
+		{"слишком длинная строка", `{"type": "string", "maxLength": 2}`, `"мир"`, []string{"$: длина больше 2"}},
+
+		{"enum", `{"enum": ["a", 1, null]}`, `1.0`, nil},
+		{"enum null", `{"enum": ["a", null]}`, `null`, nil},
+		{"не из enum", `{"enum": ["a", "b"]}`, `"c"`, []string{"$: значение не входит в enum"}},
+		{"const", `{"const": {"k": [1, 2]}}`, `{"k": [1, 2]}`, nil},
+		{"const число", `{"const": 2}`, `2.0`, nil},
+		{"const null", `{"const": null}`, `null`, nil},
+		{"не const null", `{"const": null}`, `0`, []string{"$: значение не равно const"}},
+		{"не const", `{"const": "a"}`, `"b"`, []string{"$: значение не равно const"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := Parse([]byte(tt.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = schema.ValidateJSON([]byte(tt.value))
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Errorf("ValidateJSON(%s) = %v", tt.value, err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("ValidateJSON(%s) = %v, ожидалась *ValidationError", tt.value, err)
+			}
+			if strings.Join(verr.Problems, "\n") != strings.Join(tt.problems, "\n") {
+				t.Errorf("проблемы = %q, ожидалось %q", verr.Problems, tt.problems)
+			}
+		})
+	}
+}
+
+func TestValidateJSONSyntax(t *testing.T) {
+	schema, err := Parse([]byte(`{"type": "object"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{``, `{"a":`, `{} {}`, `{"a": 1} x`} {
+		err := schema.ValidateJSON([]byte(data))
+		var verr *ValidationError
+		if err == nil || errors.As(err, &verr) {
+			t.Errorf("ValidateJSON(%q) = %v, ожидалась ошибка синтаксиса", data, err)
+		}
+	}
+}
@@ -9,15 +9,27 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/nnk/97-aic/backend/agent"
 	"github.com/nnk/97-aic/backend/api"
 	"github.com/nnk/97-aic/backend/config"
 	"github.com/nnk/97-aic/backend/gigachat"
 	"github.com/nnk/97-aic/backend/logger"
 	"github.com/nnk/97-aic/backend/provider"
+	"github.com/nnk/97-aic/backend/retention"
 	"github.com/nnk/97-aic/backend/storage"
+	"github.com/nnk/97-aic/backend/tokenizer"
 )
 
 func main() {
+	// Подкоманда: server migrate [config.yaml] — применить миграции схемы и выйти
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+	// Подкоманда: server create-user <name> [--admin] [config.yaml] — создать пользователя и напечатать API-ключ
+	if len(os.Args) > 1 && os.Args[1] == "create-user" {
+		os.Exit(runCreateUser(os.Args[2:]))
+	}
+
 	// Загрузка конфигурации
 	configPath := "config.yaml"
 	if len(os.Args) > 1 {
@@ -41,12 +53,22 @@ func main() {
 	)
 
 	// Инициализация хранилища
-	store, err := storage.New(cfg.DatabasePath)
+	store, err := storage.Open(cfg.DatabaseURL, cfg.DatabasePath)
 	if err != nil {
 		logger.Error("ошибка инициализации хранилища", "error", err)
 		os.Exit(1)
 	}
-	logger.Info("хранилище инициализировано", "path", cfg.DatabasePath)
+	if cfg.DatabaseURL != "" {
+		logger.Info("хранилище инициализировано", "backend", "postgres")
+	} else {
+		logger.Info("хранилище инициализировано", "backend", "sqlite", "path", cfg.DatabasePath)
+	}
+	if store.SearchMode() == storage.SearchModeLike {
+		// Сервер собран без тега sqlite_fts5: поиск без ранжирования и стемминга,
+		// регистр не учитывается только для ASCII (кириллица ищется с точностью до регистра)
+		logger.Warn("FTS5 недоступен, поиск работает через LIKE (соберите с -tags sqlite_fts5)",
+			"search_mode", store.SearchMode())
+	}
 
 	// Создание клиента GigaChat (с автообновлением токена) - legacy
 	var gigachatClient *gigachat.Client
@@ -60,6 +82,12 @@ func main() {
 
 	// Создание менеджера провайдеров
 	providerManager := provider.NewManager()
+	providerManager.SetBreakerConfig(provider.BreakerConfig{
+		FailureThreshold: cfg.CircuitBreaker.FailureThreshold,
+		OpenTimeout:      time.Duration(cfg.CircuitBreaker.OpenTimeoutSec) * time.Second,
+		ProbeInterval:    time.Duration(cfg.CircuitBreaker.ProbeIntervalSec) * time.Second,
+		ProbeTimeout:     time.Duration(cfg.CircuitBreaker.ProbeTimeoutSec) * time.Second,
+	})
 
 	// Регистрация GigaChat
 	if cfg.GigaChatAccessToken != "" || cfg.GigaChatAuthKey != "" {
@@ -69,6 +97,7 @@ func main() {
 			APIURL:        cfg.GigaChatAPIURL,
 			AuthURL:       cfg.GigaChatAuthURL,
 			SkipTLSVerify: cfg.GigaChatSkipTLSVerify,
+			Retry:         toRetryConfig(cfg.Providers.GigaChat.Retry),
 		})
 		providerManager.Register("gigachat", gcProvider)
 		if cfg.GigaChatSkipTLSVerify {
@@ -84,16 +113,46 @@ func main() {
 			APIKey: cfg.Providers.Groq.APIKey,
 			APIURL: cfg.Providers.Groq.APIURL,
 			Model:  cfg.Providers.Groq.Model,
+			Retry:  toRetryConfig(cfg.Providers.Groq.Retry),
 		})
 		providerManager.Register("groq", groqProvider)
 		logger.Info("Groq провайдер зарегистрирован", "model", groqProvider.GetModel())
 	}
 
+	// Регистрация OpenAI-совместимых провайдеров (vLLM, LM Studio, OpenRouter, ...)
+	for _, pc := range cfg.Providers.OpenAICompatible {
+		if !pc.Enabled {
+			continue
+		}
+		compatProvider := provider.NewOpenAICompatibleProvider(provider.OpenAICompatibleConfig{
+			Name:             pc.Name,
+			APIKey:           pc.APIKey,
+			APIURL:           pc.APIURL,
+			Model:            pc.Model,
+			Models:           pc.ModelNames(),
+			Headers:          pc.Headers,
+			MaxTokens:        pc.ModelMaxTokens(),
+			DefaultMaxTokens: pc.MaxTokens,
+			InputPricePer1k:  pc.Pricing.InputPer1K,
+			OutputPricePer1k: pc.Pricing.OutputPer1K,
+			Retry:            toRetryConfig(pc.Retry),
+
+			DisableStreamUsage: !pc.StreamUsageEnabled(),
+		})
+		providerManager.Register(pc.Name, compatProvider)
+		logger.Info("OpenAI-совместимый провайдер зарегистрирован",
+			"provider", pc.Name,
+			"api_url", pc.APIURL,
+			"model", compatProvider.GetModel(),
+		)
+	}
+
 	// Регистрация Ollama
 	if cfg.Providers.Ollama.Enabled {
 		ollamaProvider := provider.NewOllamaProvider(provider.OllamaConfig{
 			APIURL: cfg.Providers.Ollama.APIURL,
 			Model:  cfg.Providers.Ollama.Model,
+			Retry:  toRetryConfig(cfg.Providers.Ollama.Retry),
 		})
 		providerManager.Register("ollama", ollamaProvider)
 		logger.Info("Ollama провайдер зарегистрирован", "model", ollamaProvider.GetModel())
@@ -113,6 +172,40 @@ func main() {
 		logger.Info("провайдер по умолчанию", "provider", defaultProvider)
 	}
 
+	// Цепочка резервных провайдеров
+	if len(cfg.FallbackChain) > 0 {
+		providerManager.SetFallbackChain(cfg.FallbackChain)
+		logger.Info("цепочка резервных провайдеров", "chain", cfg.FallbackChain)
+	}
+
+	// Токенизаторы для точного подсчета токенов (словари загружаются один раз на файл)
+	if len(cfg.Tokenizers) > 0 {
+		registry := tokenizer.NewRegistry()
+		loaded := map[string]tokenizer.Encoder{}
+		for _, tc := range cfg.Tokenizers {
+			tok, ok := loaded[tc.Path]
+			if !ok {
+				var err error
+				tok, err = tokenizer.Load(tc.Type, tc.Path)
+				if err != nil {
+					logger.Error("ошибка загрузки токенизатора", "path", tc.Path, "error", err)
+					os.Exit(1)
+				}
+				loaded[tc.Path] = tok
+			}
+			if err := registry.Add(tc.Provider, tc.Model, tok); err != nil {
+				logger.Error("ошибка настройки токенизатора", "provider", tc.Provider, "error", err)
+				os.Exit(1)
+			}
+			logger.Info("токенизатор подключен", "provider", tc.Provider, "model", tc.Model, "tokenizer", tok.Name())
+		}
+		providerManager.SetTokenizers(registry)
+	}
+
+	// Фоновые проверки доступности провайдеров
+	healthCtx, stopHealthChecks := context.WithCancel(context.Background())
+	providerManager.StartHealthChecks(healthCtx)
+
 	// Настройка handlers (legacy + v2)
 	var chatHandler *api.ChatHandler
 	var collectHandler *api.CollectHandler
@@ -120,13 +213,60 @@ func main() {
 		chatHandler = api.NewChatHandler(gigachatClient, store)
 		collectHandler = api.NewCollectHandler(gigachatClient, store)
 	}
-	chatHandlerV2 := api.NewChatHandlerV2(providerManager, store, cfg)
+	// Генерации v2 идут независимо от соединения и буферизуются для переподключения
+	generations := api.NewGenerationRegistry(api.DefaultGenerationKeep)
+	chatHandlerV2 := api.NewChatHandlerV2(providerManager, store, cfg, generations)
+	generationsHandler := api.NewGenerationsHandler(generations)
 	providersHandler := api.NewProvidersHandler(providerManager)
-	modelsCompareHandler := api.NewModelsCompareHandler(providerManager)
-	tokenTestHandler := api.NewTokenTestHandler(providerManager)
+	modelsCompareHandler := api.NewModelsCompareHandler(providerManager, store)
+	tokenTestHandler := api.NewTokenTestHandler(providerManager, store)
 	historyHandler := api.NewHistoryHandler(store, cfg)
+	sessionsHandler := api.NewSessionsHandler(store, providerManager, cfg)
 	logsHandler := api.NewLogsHandler(store, cfg)
-	healthHandler := api.NewHealthHandler(store)
+	searchHandler := api.NewSearchHandler(store, cfg)
+	statsHandler := api.NewStatsHandler(store)
+	healthHandler := api.NewHealthHandler(store, providerManager)
+
+	// Фоновая очистка данных по политике хранения
+	janitor := retention.New(store, retention.Policy{
+		LogsMaxAge:      time.Duration(cfg.Retention.LogsMaxAgeDays) * 24 * time.Hour,
+		LogsMaxRows:     cfg.Retention.LogsMaxRows,
+		SessionsMaxIdle: time.Duration(cfg.Retention.SessionsMaxIdleDays) * 24 * time.Hour,
+		Interval:        time.Duration(cfg.Retention.IntervalMin) * time.Minute,
+	})
+	janitor.ActiveSessions = generations.ActiveSessions
+	if janitor.Policy.Enabled() {
+		logger.Info("очистка данных включена",
+			"logs_max_age_days", cfg.Retention.LogsMaxAgeDays,
+			"logs_max_rows", cfg.Retention.LogsMaxRows,
+			"sessions_max_idle_days", cfg.Retention.SessionsMaxIdleDays,
+			"interval", janitor.Policy.Interval,
+		)
+	}
+	janitorCtx, stopJanitor := context.WithCancel(context.Background())
+	janitor.Start(janitorCtx)
+	retentionHandler := api.NewRetentionHandler(janitor)
+	usersHandler := api.NewUsersHandler(store)
+	meHandler := api.NewMeHandler()
+
+	// Агент с локальными инструментами
+	agentTools := agent.NewRegistry(time.Duration(cfg.Agent.ToolTimeoutSec) * time.Second)
+	builtinTools := []agent.Tool{
+		agent.CalculatorTool{},
+		agent.CurrentTimeTool{},
+		agent.HistorySearchTool{Storage: store},
+	}
+	if len(cfg.Agent.HTTPAllowlist) > 0 {
+		builtinTools = append(builtinTools, agent.HTTPFetchTool{Allowlist: cfg.Agent.HTTPAllowlist})
+	}
+	for _, t := range builtinTools {
+		if err := agentTools.Register(t); err != nil {
+			logger.Error("ошибка регистрации инструмента агента", "tool", t.Name(), "error", err)
+			os.Exit(1)
+		}
+	}
+	agentHandler := api.NewAgentHandler(providerManager, store, agent.New(agentTools, store, cfg.Agent.MaxSteps), generations)
+	logger.Info("агент настроен", "tools", agentTools.List(), "max_steps", cfg.Agent.MaxSteps)
 
 	// Раздача статики
 	staticDir := filepath.Join(".", "static")
@@ -147,17 +287,44 @@ func main() {
 	}
 	// API v2 с поддержкой провайдеров
 	mux.Handle("/api/v2/chat", chatHandlerV2)
+	mux.Handle("/api/v2/generations", generationsHandler)
+	mux.Handle("/api/v2/generations/", generationsHandler)
 	mux.Handle("/api/v2/providers", providersHandler)
 	mux.Handle("/api/v2/models/compare", modelsCompareHandler)
 	mux.Handle("/api/v2/token-test", tokenTestHandler)
+	mux.Handle("/api/v2/agent", agentHandler)
+	mux.Handle("/api/v2/sessions", sessionsHandler)
+	mux.Handle("/api/v2/sessions/", sessionsHandler)
+	mux.Handle("/api/v2/search", searchHandler)
+	mux.Handle("/api/v2/stats", statsHandler)
+	mux.Handle("/api/v2/me", meHandler)
+	// Администрирование: пользователи, хранение и просмотр данных всех пользователей
+	mux.Handle("/api/v2/admin/users", api.RequireAdmin(usersHandler))
+	mux.Handle("/api/v2/admin/users/", api.RequireAdmin(usersHandler))
+	mux.Handle("/api/v2/admin/retention", api.RequireAdmin(retentionHandler))
+	mux.Handle("/api/v2/admin/sessions", api.AdminView("/api/v2/admin/sessions", "/api/v2/sessions", sessionsHandler))
+	mux.Handle("/api/v2/admin/sessions/", api.AdminView("/api/v2/admin/sessions", "/api/v2/sessions", sessionsHandler))
+	mux.Handle("/api/v2/admin/history", api.AdminView("/api/v2/admin/history", "/api/history", historyHandler))
+	mux.Handle("/api/v2/admin/logs", api.AdminView("/api/v2/admin/logs", "/api/logs", logsHandler))
+	mux.Handle("/api/v2/admin/search", api.AdminView("/api/v2/admin/search", "/api/v2/search", searchHandler))
+	mux.Handle("/api/v2/admin/stats", api.AdminView("/api/v2/admin/stats", "/api/v2/stats", statsHandler))
+	mux.Handle("/api/v2/admin/generations", api.AdminView("/api/v2/admin/generations", "/api/v2/generations", generationsHandler))
+	mux.Handle("/api/v2/admin/generations/", api.AdminView("/api/v2/admin/generations", "/api/v2/generations", generationsHandler))
 	// Общие endpoints
 	mux.Handle("/api/history", historyHandler)
 	mux.Handle("/api/logs", logsHandler)
 	mux.Handle("/health", healthHandler)
 	mux.Handle("/", fs)
 
+	if cfg.Auth.Enabled {
+		logger.Info("авторизация по API-ключам включена")
+	} else {
+		logger.Warn("авторизация выключена: API и данные всех сессий открыты (auth.enabled в конфиге)")
+	}
+
 	// Применяем middleware
 	var handler http.Handler = mux
+	handler = api.AuthMiddleware(cfg, store, handler)
 	handler = api.LimitBodyMiddleware(cfg.MaxRequestBodySize, handler)
 	handler = api.CORSMiddleware(cfg, handler)
 
@@ -182,11 +349,20 @@ func main() {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
+		stopHealthChecks()
+		stopJanitor()
+
 		server.SetKeepAlivesEnabled(false)
 		if err := server.Shutdown(ctx); err != nil {
 			logger.Error("ошибка graceful shutdown", "error", err)
 		}
 
+		// Генерации идут независимо от соединений: дожидаемся, пока они сохранят
+		// ответы (по истечении таймаута останавливаем с сохранением частичных)
+		if err := generations.Shutdown(ctx); err != nil {
+			logger.Error("ошибка остановки генераций", "error", err)
+		}
+
 		// Закрываем хранилище
 		if err := store.Close(); err != nil {
 			logger.Error("ошибка закрытия хранилища", "error", err)
@@ -205,3 +381,13 @@ func main() {
 	<-done
 	logger.Info("сервер остановлен")
 }
+
+// toRetryConfig переводит настройки повторов из конфига в формат провайдера
+func toRetryConfig(rc config.RetryConfig) provider.RetryConfig {
+	return provider.RetryConfig{
+		MaxAttempts:    rc.MaxAttempts,
+		InitialBackoff: time.Duration(rc.InitialBackoffMs) * time.Millisecond,
+		MaxBackoff:     time.Duration(rc.MaxBackoffMs) * time.Millisecond,
+		MaxRetryAfter:  time.Duration(rc.MaxRetryAfterMs) * time.Millisecond,
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/nnk/97-aic/backend/config"
+	"github.com/nnk/97-aic/backend/logger"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+// runMigrate применяет миграции схемы хранилища из конфига и возвращает код выхода
+func runMigrate(args []string) int {
+	configPath := "config.yaml"
+	if len(args) > 0 {
+		configPath = args[0]
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		logger.Init(config.DefaultLogLevel, false)
+		logger.Error("ошибка загрузки конфига", "error", err, "path", configPath)
+		return 1
+	}
+	logger.Init(cfg.LogLevel, cfg.LogFormat == "json")
+
+	applied, err := storage.Migrate(cfg.DatabaseURL, cfg.DatabasePath)
+	if err != nil {
+		logger.Error("ошибка миграции", "error", err)
+		return 1
+	}
+	for _, m := range applied {
+		logger.Info("миграция применена", "version", m.Version, "name", m.Name)
+	}
+	if len(applied) == 0 {
+		logger.Info("схема актуальна, миграций не требуется")
+	}
+	return 0
+}
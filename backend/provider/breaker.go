@@ -0,0 +1,232 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Состояния circuit breaker
+const (
+	CircuitClosed   = "closed"    // провайдер работает, запросы проходят
+	CircuitOpen     = "open"      // провайдер считается недоступным, запросы отклоняются сразу
+	CircuitHalfOpen = "half_open" // пробный режим: один пробный запрос решит, закрыть или снова открыть
+)
+
+// BreakerConfig настройки circuit breaker и фоновых проверок провайдеров
+type BreakerConfig struct {
+	FailureThreshold int           // ошибок подряд до открытия (по умолчанию 3)
+	OpenTimeout      time.Duration // сколько держать открытым до пробного запроса (по умолчанию 30s)
+	ProbeInterval    time.Duration // период фоновых проверок (по умолчанию 30s)
+	ProbeTimeout     time.Duration // таймаут одной проверки (по умолчанию 5s)
+}
+
+const (
+	defaultBreakerFailureThreshold = 3
+	defaultBreakerOpenTimeout      = 30 * time.Second
+	defaultBreakerProbeInterval    = 30 * time.Second
+	defaultBreakerProbeTimeout     = 5 * time.Second
+)
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = defaultBreakerFailureThreshold
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	if c.ProbeInterval <= 0 {
+		c.ProbeInterval = defaultBreakerProbeInterval
+	}
+	if c.ProbeTimeout <= 0 {
+		c.ProbeTimeout = defaultBreakerProbeTimeout
+	}
+	return c
+}
+
+// Prober реализуют провайдеры, умеющие дешево проверить свою доступность
+// (например Ollama /api/tags или OpenAI-совместимый /models)
+type Prober interface {
+	Probe(ctx context.Context) error
+}
+
+// HealthStatus текущее состояние провайдера
+type HealthStatus struct {
+	State               string     `json:"state"` // closed, open, half_open
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	LatencyMs           int64      `json:"latency_ms"` // время до первого чанка или длительность проверки
+	LastCheckAt         *time.Time `json:"last_check_at,omitempty"`
+}
+
+// CircuitOpenError возвращается, когда запрос отклонен открытым circuit breaker
+type CircuitOpenError struct {
+	Provider  string
+	LastError string
+}
+
+// Error возвращает текст ошибки
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("провайдер %s временно недоступен: %s", e.Provider, e.LastError)
+}
+
+// circuitBreaker circuit breaker одного провайдера
+type circuitBreaker struct {
+	mu          sync.Mutex
+	cfg         BreakerConfig
+	state       string
+	probing     bool // в half_open уже выполняется пробный запрос
+	failures    int
+	openedAt    time.Time
+	lastError   string
+	lastErrorAt time.Time
+	latency     time.Duration
+	lastCheckAt time.Time
+}
+
+func newCircuitBreaker(cfg BreakerConfig) *circuitBreaker {
+	return &circuitBreaker{
+		cfg:   cfg.withDefaults(),
+		state: CircuitClosed,
+	}
+}
+
+// setConfig обновляет настройки
+func (b *circuitBreaker) setConfig(cfg BreakerConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cfg = cfg.withDefaults()
+}
+
+// allow решает, пропускать ли запрос. Открытый breaker по истечении OpenTimeout переходит в half_open.
+// В half_open пропускается только один пробный запрос, остальные отклоняются, пока он не завершится
+// (recordSuccess, recordFailure или release).
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expireOpen()
+	switch b.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// available как allow, но не занимает место пробного запроса — для выбора провайдера
+func (b *circuitBreaker) available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expireOpen()
+	return b.state == CircuitClosed || (b.state == CircuitHalfOpen && !b.probing)
+}
+
+// expireOpen переводит открытый breaker в half_open по истечении OpenTimeout (под b.mu)
+func (b *circuitBreaker) expireOpen() {
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.cfg.OpenTimeout {
+		b.state = CircuitHalfOpen
+	}
+}
+
+// release освобождает место пробного запроса, завершившегося без вердикта
+// (отмена клиентом, ошибка в самом запросе)
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// recordSuccess фиксирует успешный запрос
+func (b *circuitBreaker) recordSuccess(latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = CircuitClosed
+	b.probing = false
+	b.failures = 0
+	b.latency = latency
+	b.lastCheckAt = time.Now()
+}
+
+// recordFailure фиксирует ошибку; после FailureThreshold ошибок подряд (или ошибки в half_open) breaker открывается
+func (b *circuitBreaker) recordFailure(err error, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.probing = false
+	b.failures++
+	b.lastError = err.Error()
+	b.lastErrorAt = now
+	b.latency = latency
+	b.lastCheckAt = now
+	if b.state == CircuitHalfOpen || b.failures >= b.cfg.FailureThreshold {
+		if b.state != CircuitOpen {
+			b.openedAt = now
+		}
+		b.state = CircuitOpen
+	}
+}
+
+// recordProbe фиксирует результат фоновой проверки.
+// Успешная проверка переводит открытый breaker в half_open: следующий реальный запрос решит окончательно.
+func (b *circuitBreaker) recordProbe(err error, latency time.Duration) {
+	if err != nil {
+		b.recordFailure(err, latency)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.latency = latency
+	b.lastCheckAt = time.Now()
+	switch b.state {
+	case CircuitOpen:
+		b.state = CircuitHalfOpen
+	case CircuitClosed:
+		b.failures = 0
+	}
+}
+
+// snapshot возвращает текущее состояние
+func (b *circuitBreaker) snapshot() HealthStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := HealthStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		LastError:           b.lastError,
+		LatencyMs:           b.latency.Milliseconds(),
+	}
+	if !b.lastErrorAt.IsZero() {
+		t := b.lastErrorAt
+		status.LastErrorAt = &t
+	}
+	if !b.lastCheckAt.IsZero() {
+		t := b.lastCheckAt
+		status.LastCheckAt = &t
+	}
+	return status
+}
+
+// countsAsFailure определяет, говорит ли ошибка о неисправности провайдера.
+// Отмена запроса клиентом и ошибки в самом запросе (4xx, кроме 401/403/429) не учитываются.
+func countsAsFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 {
+		switch apiErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+			return true
+		}
+		return false
+	}
+	return true
+}
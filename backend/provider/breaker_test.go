@@ -0,0 +1,208 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errProvider = errors.New("connection refused")
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	b := newCircuitBreaker(BreakerConfig{FailureThreshold: 2, OpenTimeout: 20 * time.Millisecond})
+
+	// closed: ошибки ниже порога не открывают
+	if !b.allow() {
+		t.Fatal("закрытый breaker отклонил запрос")
+	}
+	b.recordFailure(errProvider, 0)
+	if got := b.snapshot().State; got != CircuitClosed {
+		t.Fatalf("после 1 ошибки состояние %s", got)
+	}
+
+	// closed → open на пороге
+	b.recordFailure(errProvider, 0)
+	if got := b.snapshot(); got.State != CircuitOpen || got.ConsecutiveFailures != 2 || got.LastError != errProvider.Error() {
+		t.Fatalf("после 2 ошибок: %+v", got)
+	}
+	if b.allow() || b.available() {
+		t.Fatal("открытый breaker пропустил запрос")
+	}
+
+	// open → half_open по истечении OpenTimeout
+	time.Sleep(30 * time.Millisecond)
+	if !b.available() {
+		t.Fatal("breaker недоступен после OpenTimeout")
+	}
+	if !b.allow() {
+		t.Fatal("half_open не пропустил пробный запрос")
+	}
+	if got := b.snapshot().State; got != CircuitHalfOpen {
+		t.Fatalf("состояние %s, ожидалось half_open", got)
+	}
+
+	// half_open → open при ошибке пробного запроса
+	b.recordFailure(errProvider, 0)
+	if got := b.snapshot().State; got != CircuitOpen {
+		t.Fatalf("после ошибки пробного запроса состояние %s", got)
+	}
+
+	// half_open → closed при успехе пробного запроса
+	time.Sleep(30 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("half_open не пропустил пробный запрос")
+	}
+	b.recordSuccess(time.Millisecond)
+	if got := b.snapshot(); got.State != CircuitClosed || got.ConsecutiveFailures != 0 {
+		t.Fatalf("после успеха: %+v", got)
+	}
+}
+
+func TestCircuitBreakerSuccessResetsFailures(t *testing.T) {
+	b := newCircuitBreaker(BreakerConfig{FailureThreshold: 2})
+	b.recordFailure(errProvider, 0)
+	b.recordSuccess(0)
+	b.recordFailure(errProvider, 0)
+	if got := b.snapshot().State; got != CircuitClosed {
+		t.Fatalf("ошибки не подряд открыли breaker: %s", got)
+	}
+}
+
+func TestCircuitBreakerHalfOpenSingleProbe(t *testing.T) {
+	b := newCircuitBreaker(BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Hour})
+	b.recordFailure(errProvider, 0)
+	b.recordProbe(nil, time.Millisecond) // успешная фоновая проверка: open → half_open
+
+	var allowed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if b.allow() {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 1 {
+		t.Fatalf("в half_open пропущено %d запросов, ожидался 1 пробный", allowed)
+	}
+	if b.available() {
+		t.Error("available во время пробного запроса")
+	}
+
+	// Пробный запрос без вердикта освобождает место для следующего
+	b.release()
+	if !b.allow() {
+		t.Fatal("после release пробный запрос не пропущен")
+	}
+	if b.allow() {
+		t.Fatal("пропущен второй пробный запрос")
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	b := newCircuitBreaker(BreakerConfig{FailureThreshold: 3, OpenTimeout: time.Hour})
+
+	// Неудачные проверки считаются ошибками
+	for i := 0; i < 3; i++ {
+		b.recordProbe(errProvider, 0)
+	}
+	if got := b.snapshot().State; got != CircuitOpen {
+		t.Fatalf("после 3 неудачных проверок состояние %s", got)
+	}
+
+	// Успешная проверка не закрывает breaker, а переводит в half_open
+	b.recordProbe(nil, 5*time.Millisecond)
+	if got := b.snapshot(); got.State != CircuitHalfOpen || got.LatencyMs != 5 || got.LastCheckAt == nil {
+		t.Fatalf("после успешной проверки: %+v", got)
+	}
+}
+
+// Через Manager: параллельные запросы к half_open провайдеру — до провайдера доходит один
+func TestBoundProviderHalfOpenLetsOneRequestThrough(t *testing.T) {
+	fake := newFakeProvider("fake", "m")
+	m := NewManager()
+	m.SetBreakerConfig(BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Hour})
+	m.Register("fake", fake)
+
+	p, err := m.ForModel("fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.setErr(errProvider)
+	if _, err := p.Chat(context.Background(), "x", nil, func(string) error { return nil }); err == nil {
+		t.Fatal("ожидалась ошибка провайдера")
+	}
+	if m.IsAvailable("fake") {
+		t.Fatal("провайдер доступен при открытом breaker")
+	}
+	fake.setErr(nil)
+
+	_, _, breaker, _ := m.lookup("fake")
+	breaker.recordProbe(nil, 0)
+	if !m.IsAvailable("fake") {
+		t.Fatal("провайдер недоступен в half_open")
+	}
+
+	// Пробный запрос держим до тех пор, пока остальные не получат отказ (или до таймаута,
+	// чтобы тест не зависал, если в провайдер прошло больше одного запроса)
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	timer := time.AfterFunc(time.Second, unblock)
+	defer timer.Stop()
+	var rejected int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.Chat(context.Background(), "x", nil, func(string) error {
+				<-release
+				return nil
+			})
+			var open *CircuitOpenError
+			if errors.As(err, &open) {
+				if atomic.AddInt32(&rejected, 1) == 9 {
+					unblock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if rejected != 9 {
+		t.Fatalf("отклонено %d запросов, ожидалось 9", rejected)
+	}
+	if got := fake.callCount(); got != 2 {
+		t.Fatalf("до провайдера дошло %d запросов, ожидалось 2 (ошибка + пробный)", got)
+	}
+	if got := m.Health()["fake"].State; got != CircuitClosed {
+		t.Fatalf("после успешного пробного запроса состояние %s", got)
+	}
+}
+
+func TestCountsAsFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errProvider, true},
+		{context.Canceled, false},
+		{&APIError{StatusCode: 400}, false},
+		{&APIError{StatusCode: 404}, false},
+		{&APIError{StatusCode: 401}, true},
+		{&APIError{StatusCode: 429}, true},
+		{&APIError{StatusCode: 503}, true},
+	}
+	for _, tt := range tests {
+		if got := countsAsFailure(tt.err); got != tt.want {
+			t.Errorf("countsAsFailure(%v) = %v", tt.err, got)
+		}
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"strings"
@@ -24,11 +23,14 @@ type GigaChatProvider struct {
 	apiURL     string
 	authURL    string
 	authKey    string
+	retry      RetryConfig
 
 	mu          sync.RWMutex
 	accessToken string
 	expiresAt   time.Time
-	model       string
+
+	modelMu sync.RWMutex
+	model   string
 }
 
 // GigaChatConfig конфигурация GigaChat провайдера
@@ -39,6 +41,7 @@ type GigaChatConfig struct {
 	AuthURL       string
 	Model         string
 	SkipTLSVerify bool // Пропускать проверку TLS сертификата (для тестирования)
+	Retry         RetryConfig
 }
 
 // GigaChatModels доступные модели GigaChat
@@ -70,6 +73,7 @@ func NewGigaChatProvider(cfg GigaChatConfig) *GigaChatProvider {
 		apiURL:     apiURL,
 		authURL:    authURL,
 		authKey:    cfg.AuthKey,
+		retry:      cfg.Retry,
 		model:      model,
 	}
 
@@ -94,18 +98,27 @@ func (p *GigaChatProvider) Models() []string {
 
 // SetModel устанавливает модель
 func (p *GigaChatProvider) SetModel(model string) {
+	p.modelMu.Lock()
+	defer p.modelMu.Unlock()
 	p.model = model
 }
 
 // GetModel возвращает текущую модель
 func (p *GigaChatProvider) GetModel() string {
+	p.modelMu.RLock()
+	defer p.modelMu.RUnlock()
 	return p.model
 }
 
 // GetMaxTokens возвращает максимальный лимит токенов для текущей модели
 func (p *GigaChatProvider) GetMaxTokens() int {
+	return p.GetMaxTokensForModel(p.GetModel())
+}
+
+// GetMaxTokensForModel возвращает максимальный лимит токенов для указанной модели
+func (p *GigaChatProvider) GetMaxTokensForModel(model string) int {
 	// Лимиты для разных моделей GigaChat
-	switch p.model {
+	switch model {
 	case "GigaChat-Pro":
 		return 32768 // Большой контекст
 	case "GigaChat-Plus":
@@ -176,8 +189,7 @@ func (p *GigaChatProvider) refreshToken(ctx context.Context) (string, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("ошибка получения токена: %d - %s", resp.StatusCode, string(respBody))
+		return "", newAPIError("GigaChat OAuth", resp)
 	}
 
 	var tokenResp struct {
@@ -202,6 +214,17 @@ func (p *GigaChatProvider) refreshToken(ctx context.Context) (string, error) {
 	return p.accessToken, nil
 }
 
+// invalidateToken сбрасывает токен, отклоненный API, чтобы следующая попытка получила новый.
+// Токен сбрасывается только если его еще не обновил параллельный запрос.
+func (p *GigaChatProvider) invalidateToken(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.accessToken == token {
+		p.accessToken = ""
+		p.expiresAt = time.Time{}
+	}
+}
+
 // gigachatChatRequest запрос к GigaChat API
 type gigachatChatRequest struct {
 	Model       string            `json:"model"`
@@ -209,16 +232,41 @@ type gigachatChatRequest struct {
 	Stream      bool              `json:"stream"`
 	MaxTokens   int               `json:"max_tokens,omitempty"`
 	Temperature float64           `json:"temperature,omitempty"`
+
+	// Функции: GigaChat использует устаревший формат OpenAI (functions/function_call)
+	Functions    []gigachatFunction `json:"functions,omitempty"`
+	FunctionCall interface{}        `json:"function_call,omitempty"` // "auto", "none" или {"name": ...}
 }
 
 type gigachatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role         string                `json:"role"` // system, user, assistant, function
+	Content      string                `json:"content"`
+	FunctionCall *gigachatFunctionCall `json:"function_call,omitempty"`
+	Name         string                `json:"name,omitempty"` // для role=function
+}
+
+type gigachatFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// gigachatFunctionCall вызов функции: приходит целиком, аргументы — объектом
+type gigachatFunctionCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
 }
 
 type gigachatChatResponse struct {
 	ID      string           `json:"id"`
 	Choices []gigachatChoice `json:"choices"`
+	Usage   *gigachatUsage   `json:"usage,omitempty"` // приходит в последнем чанке
+}
+
+type gigachatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
 }
 
 type gigachatChoice struct {
@@ -229,47 +277,27 @@ type gigachatChoice struct {
 }
 
 type gigachatDelta struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role         string                `json:"role"`
+	Content      string                `json:"content"`
+	FunctionCall *gigachatFunctionCall `json:"function_call,omitempty"`
 }
 
-// Chat отправляет сообщение через GigaChat API
-func (p *GigaChatProvider) Chat(ctx context.Context, message string, opts *ChatOptions, onChunk func(string) error) error {
-	token, err := p.getToken(ctx)
-	if err != nil {
-		return fmt.Errorf("ошибка получения токена: %w", err)
+// gigachatFunctionResult результат функции для GigaChat: content обязан быть JSON-объектом
+func gigachatFunctionResult(content string) string {
+	trimmed := strings.TrimSpace(content)
+	if strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		return trimmed
 	}
+	wrapped, _ := json.Marshal(map[string]string{"result": content})
+	return string(wrapped)
+}
 
+// Chat отправляет сообщение через GigaChat API
+func (p *GigaChatProvider) Chat(ctx context.Context, message string, opts *ChatOptions, onChunk func(string) error) (*ChatResult, error) {
 	messages := []gigachatMessage{}
 
-	// System prompt - объединяем все части
-	var systemPrompt string
-	if opts != nil {
-		// Базовый system prompt от пользователя
-		if opts.SystemPrompt != "" {
-			systemPrompt = opts.SystemPrompt
-		}
-
-		// Добавляем режим рассуждения
-		if opts.ReasoningMode != "" && opts.ReasoningMode != "direct" {
-			reasoningPrompt := BuildReasoningPrompt(opts.ReasoningMode, "")
-			if systemPrompt != "" {
-				systemPrompt = systemPrompt + "\n\n" + reasoningPrompt
-			} else {
-				systemPrompt = reasoningPrompt
-			}
-		}
-
-		// Добавляем JSON-инструкцию
-		if opts.JSONFormat && opts.JSONSchemaText != "" {
-			jsonPrompt := BuildJSONPrompt(opts.JSONSchemaText)
-			if systemPrompt != "" {
-				systemPrompt = systemPrompt + "\n\n" + jsonPrompt
-			} else {
-				systemPrompt = jsonPrompt
-			}
-		}
-	}
+	// System prompt пользователя + инструкции режима рассуждения и JSON
+	systemPrompt := BuildSystemPrompt(opts)
 
 	if systemPrompt != "" {
 		messages = append(messages, gigachatMessage{
@@ -281,25 +309,64 @@ func (p *GigaChatProvider) Chat(ctx context.Context, message string, opts *ChatO
 	// История
 	if opts != nil && len(opts.History) > 0 {
 		for _, msg := range opts.History {
-			messages = append(messages, gigachatMessage{
+			gm := gigachatMessage{
 				Role:    msg.Role,
 				Content: msg.Content,
-			})
+			}
+			// GigaChat принимает один вызов функции на сообщение
+			if len(msg.ToolCalls) > 0 {
+				gm.FunctionCall = &gigachatFunctionCall{
+					Name:      msg.ToolCalls[0].Name,
+					Arguments: argumentsObject(msg.ToolCalls[0].Arguments),
+				}
+			}
+			if msg.Role == RoleTool {
+				gm.Role = "function"
+				gm.Name = msg.Name
+				gm.Content = gigachatFunctionResult(msg.Content)
+			}
+			messages = append(messages, gm)
 		}
 	}
 
-	// Текущее сообщение
-	messages = append(messages, gigachatMessage{
-		Role:    "user",
-		Content: message,
-	})
+	// Текущее сообщение (пустое — продолжение после результатов инструментов)
+	if message != "" {
+		messages = append(messages, gigachatMessage{
+			Role:    "user",
+			Content: message,
+		})
+	}
 
 	reqBody := gigachatChatRequest{
-		Model:    p.model,
+		Model:    resolveModel(opts, p.GetModel()),
 		Messages: messages,
 		Stream:   true,
 	}
 
+	// Функции
+	if opts != nil && len(opts.Tools) > 0 {
+		for _, t := range opts.Tools {
+			reqBody.Functions = append(reqBody.Functions, gigachatFunction{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  toolParameters(t),
+			})
+		}
+		switch opts.ToolChoice {
+		case "", "auto", "required":
+			reqBody.FunctionCall = "auto"
+		case "none":
+			reqBody.FunctionCall = "none"
+		default:
+			reqBody.FunctionCall = map[string]string{"name": opts.ToolChoice}
+		}
+	}
+
+	var onToolCallDelta func(ToolCallDelta) error
+	if opts != nil {
+		onToolCallDelta = opts.OnToolCallDelta
+	}
+
 	if opts != nil {
 		if opts.MaxTokens > 0 {
 			reqBody.MaxTokens = opts.MaxTokens
@@ -311,36 +378,55 @@ func (p *GigaChatProvider) Chat(ctx context.Context, message string, opts *ChatO
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return fmt.Errorf("ошибка маршалинга запроса: %w", err)
+		return nil, fmt.Errorf("ошибка маршалинга запроса: %w", err)
 	}
 
 	// Debug: логируем запрос
 	fmt.Printf("[GigaChat] Request: %s\n", string(jsonData))
 
-	req, err := http.NewRequestWithContext(ctx, "POST", p.apiURL+"/chat/completions", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("ошибка создания запроса: %w", err)
-	}
+	resp, err := doWithRetry(ctx, "gigachat", p.retry, func() (*http.Response, error) {
+		// Токен получаем на каждой попытке: после 401 он сброшен и будет обновлен
+		token, err := p.getToken(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка получения токена: %w", err)
+		}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+token)
+		req, err := http.NewRequestWithContext(ctx, "POST", p.apiURL+"/chat/completions", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("ошибка создания запроса: %w", err)
+		}
 
-	resp, err := p.httpClient.Do(req)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := p.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка выполнения запроса: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			apiErr := newAPIError("GigaChat", resp)
+			if apiErr.StatusCode == http.StatusUnauthorized && p.authKey != "" {
+				// Токен истек раньше срока — сбрасываем и получаем новый при повторе
+				p.invalidateToken(token)
+				apiErr.TokenExpired = true
+			}
+			return nil, apiErr
+		}
+		return resp, nil
+	})
 	if err != nil {
-		return fmt.Errorf("ошибка выполнения запроса: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("ошибка GigaChat API: %d - %s", resp.StatusCode, string(body))
-	}
+	result := &ChatResult{}
+	var toolCalls toolCallAccumulator
 
 	scanner := bufio.NewScanner(resp.Body)
 	for scanner.Scan() {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		default:
 		}
 
@@ -359,27 +445,82 @@ func (p *GigaChatProvider) Chat(ctx context.Context, message string, opts *ChatO
 			continue
 		}
 
+		if chatResp.Usage != nil {
+			result.Usage = &Usage{
+				InputTokens:  chatResp.Usage.PromptTokens,
+				OutputTokens: chatResp.Usage.CompletionTokens,
+				TotalTokens:  chatResp.Usage.TotalTokens,
+				Source:       UsageSourceProvider,
+			}
+		}
+
 		if len(chatResp.Choices) > 0 {
 			choice := chatResp.Choices[0]
-			var content string
-			if choice.Delta != nil {
-				content = choice.Delta.Content
-			} else if choice.Message != nil {
-				content = choice.Message.Content
+			if choice.FinishReason != "" {
+				result.FinishReason = choice.FinishReason
 			}
-
-			if content != "" {
-				if err := onChunk(content); err != nil {
-					return err
+			delta := choice.Delta
+			if delta == nil {
+				delta = choice.Message
+			}
+			if delta != nil {
+				if delta.Content != "" {
+					if err := onChunk(delta.Content); err != nil {
+						return nil, err
+					}
+				}
+				if delta.FunctionCall != nil && delta.FunctionCall.Name != "" {
+					d := ToolCallDelta{
+						Index:          len(toolCalls.calls),
+						Name:           delta.FunctionCall.Name,
+						ArgumentsDelta: string(argumentsObject(string(delta.FunctionCall.Arguments))),
+					}
+					if err := toolCalls.add(d, onToolCallDelta); err != nil {
+						return nil, err
+					}
 				}
 			}
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("ошибка чтения потока: %w", err)
+		return nil, fmt.Errorf("ошибка чтения потока: %w", err)
 	}
 
+	// GigaChat завершает вызов функции причиной function_call
+	if result.ToolCalls = toolCalls.result(); len(result.ToolCalls) > 0 {
+		result.FinishReason = FinishReasonToolCalls
+	}
+
+	return result, nil
+}
+
+// Probe проверяет доступность GigaChat: получение токена и запрос /models
+func (p *GigaChatProvider) Probe(ctx context.Context) error {
+	token, err := p.getToken(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка получения токена: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.apiURL+"/models", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("ошибка выполнения запроса: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := newAPIError("GigaChat", resp)
+		if apiErr.StatusCode == http.StatusUnauthorized && p.authKey != "" {
+			p.invalidateToken(token)
+		}
+		return apiErr
+	}
 	return nil
 }
 
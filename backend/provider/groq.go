@@ -1,30 +1,11 @@
 package provider
 
-import (
-	"bufio"
-	"bytes"
-	"context"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-	"strings"
-	"time"
-)
-
-// GroqProvider провайдер для Groq API (OpenAI-compatible)
-type GroqProvider struct {
-	httpClient *http.Client
-	apiKey     string
-	apiURL     string
-	model      string
-}
-
 // GroqConfig конфигурация Groq провайдера
 type GroqConfig struct {
 	APIKey string
 	APIURL string // по умолчанию https://api.groq.com/openai/v1
 	Model  string // по умолчанию llama-3.3-70b-versatile
+	Retry  RetryConfig
 }
 
 // Доступные модели Groq (бесплатные)
@@ -36,8 +17,19 @@ var GroqModels = []string{
 	"gemma2-9b-it",            // Google Gemma 2
 }
 
-// NewGroqProvider создает новый Groq провайдер
-func NewGroqProvider(cfg GroqConfig) *GroqProvider {
+// groqMaxTokens лимиты для разных моделей Groq
+var groqMaxTokens = map[string]int{
+	"mixtral-8x7b-32768":      32768,
+	"llama-3.3-70b-versatile": 8192,
+	"llama-3.1-8b-instant":    8192,
+	"llama-3.2-3b-preview":    8192,
+	"gemma2-9b-it":            8192,
+}
+
+// NewGroqProvider создает новый Groq провайдер.
+// Groq — OpenAI-совместимый API, поэтому это преднастроенный OpenAICompatibleProvider.
+// Groq бесплатный на данный момент, поэтому цены нулевые.
+func NewGroqProvider(cfg GroqConfig) *OpenAICompatibleProvider {
 	apiURL := cfg.APIURL
 	if apiURL == "" {
 		apiURL = "https://api.groq.com/openai/v1"
@@ -48,231 +40,13 @@ func NewGroqProvider(cfg GroqConfig) *GroqProvider {
 		model = "llama-3.3-70b-versatile"
 	}
 
-	return &GroqProvider{
-		httpClient: &http.Client{
-			Timeout: 120 * time.Second,
-		},
-		apiKey: cfg.APIKey,
-		apiURL: apiURL,
-		model:  model,
-	}
-}
-
-// Name возвращает имя провайдера
-func (p *GroqProvider) Name() string {
-	return "groq"
-}
-
-// Models возвращает список доступных моделей
-func (p *GroqProvider) Models() []string {
-	return GroqModels
-}
-
-// SetModel устанавливает модель
-func (p *GroqProvider) SetModel(model string) {
-	p.model = model
-}
-
-// GetModel возвращает текущую модель
-func (p *GroqProvider) GetModel() string {
-	return p.model
-}
-
-// GetMaxTokens возвращает максимальный лимит токенов для текущей модели
-func (p *GroqProvider) GetMaxTokens() int {
-	// Лимиты для разных моделей Groq
-	switch p.model {
-	case "mixtral-8x7b-32768":
-		return 32768
-	case "llama-3.3-70b-versatile":
-		return 8192
-	case "llama-3.1-8b-instant":
-		return 8192
-	case "llama-3.2-3b-preview":
-		return 8192
-	case "gemma2-9b-it":
-		return 8192
-	default:
-		return 8192 // Стандартный лимит
-	}
-}
-
-// CalculateCost вычисляет стоимость запроса в USD
-// Groq бесплатный, но можно добавить структуру для будущих платных тарифов
-func (p *GroqProvider) CalculateCost(inputTokens, outputTokens int) float64 {
-	// Groq бесплатный на данный момент
-	return 0.0
-}
-
-// groqChatRequest запрос к Groq API
-type groqChatRequest struct {
-	Model       string        `json:"model"`
-	Messages    []groqMessage `json:"messages"`
-	Stream      bool          `json:"stream"`
-	MaxTokens   int           `json:"max_tokens,omitempty"`
-	Temperature float64       `json:"temperature,omitempty"`
-}
-
-type groqMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-type groqChatResponse struct {
-	ID      string       `json:"id"`
-	Choices []groqChoice `json:"choices"`
-}
-
-type groqChoice struct {
-	Index        int        `json:"index"`
-	Delta        *groqDelta `json:"delta,omitempty"`
-	Message      *groqDelta `json:"message,omitempty"`
-	FinishReason string     `json:"finish_reason"`
-}
-
-type groqDelta struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
-
-// Chat отправляет сообщение через Groq API
-func (p *GroqProvider) Chat(ctx context.Context, message string, opts *ChatOptions, onChunk func(string) error) error {
-	messages := []groqMessage{}
-
-	// System prompt - объединяем все части
-	var systemPrompt string
-	if opts != nil {
-		// Базовый system prompt от пользователя
-		if opts.SystemPrompt != "" {
-			systemPrompt = opts.SystemPrompt
-		}
-
-		// Добавляем режим рассуждения
-		if opts.ReasoningMode != "" && opts.ReasoningMode != "direct" {
-			reasoningPrompt := BuildReasoningPrompt(opts.ReasoningMode, "")
-			if systemPrompt != "" {
-				systemPrompt = systemPrompt + "\n\n" + reasoningPrompt
-			} else {
-				systemPrompt = reasoningPrompt
-			}
-		}
-
-		// Добавляем JSON-инструкцию
-		if opts.JSONFormat && opts.JSONSchemaText != "" {
-			jsonPrompt := BuildJSONPrompt(opts.JSONSchemaText)
-			if systemPrompt != "" {
-				systemPrompt = systemPrompt + "\n\n" + jsonPrompt
-			} else {
-				systemPrompt = jsonPrompt
-			}
-		}
-	}
-
-	if systemPrompt != "" {
-		messages = append(messages, groqMessage{
-			Role:    "system",
-			Content: systemPrompt,
-		})
-	}
-
-	// История
-	if opts != nil && len(opts.History) > 0 {
-		for _, msg := range opts.History {
-			messages = append(messages, groqMessage{
-				Role:    msg.Role,
-				Content: msg.Content,
-			})
-		}
-	}
-
-	// Текущее сообщение
-	messages = append(messages, groqMessage{
-		Role:    "user",
-		Content: message,
+	return NewOpenAICompatibleProvider(OpenAICompatibleConfig{
+		Name:      "groq",
+		APIKey:    cfg.APIKey,
+		APIURL:    apiURL,
+		Model:     model,
+		Models:    GroqModels,
+		MaxTokens: groqMaxTokens,
+		Retry:     cfg.Retry,
 	})
-
-	reqBody := groqChatRequest{
-		Model:    p.model,
-		Messages: messages,
-		Stream:   true,
-	}
-
-	if opts != nil {
-		if opts.MaxTokens > 0 {
-			reqBody.MaxTokens = opts.MaxTokens
-		}
-		if opts.Temperature >= 0 {
-			reqBody.Temperature = opts.Temperature
-		}
-	}
-
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return fmt.Errorf("ошибка маршалинга запроса: %w", err)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", p.apiURL+"/chat/completions", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("ошибка создания запроса: %w", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+p.apiKey)
-
-	resp, err := p.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("ошибка выполнения запроса: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("ошибка Groq API: %d - %s", resp.StatusCode, string(body))
-	}
-
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-
-		data := strings.TrimPrefix(line, "data: ")
-		if data == "[DONE]" {
-			break
-		}
-
-		var chatResp groqChatResponse
-		if err := json.Unmarshal([]byte(data), &chatResp); err != nil {
-			continue
-		}
-
-		if len(chatResp.Choices) > 0 {
-			choice := chatResp.Choices[0]
-			var content string
-			if choice.Delta != nil {
-				content = choice.Delta.Content
-			} else if choice.Message != nil {
-				content = choice.Message.Content
-			}
-
-			if content != "" {
-				if err := onChunk(content); err != nil {
-					return err
-				}
-			}
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("ошибка чтения потока: %w", err)
-	}
-
-	return nil
 }
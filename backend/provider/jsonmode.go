@@ -0,0 +1,201 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nnk/97-aic/backend/jsonschema"
+	"github.com/nnk/97-aic/backend/tokenizer"
+)
+
+// DefaultJSONMaxRepairs сколько раз просить модель исправить невалидный JSON
+const DefaultJSONMaxRepairs = 2
+
+// Способы проверки ответа в строгом JSON-режиме
+const (
+	JSONValidationSchema = "schema" // синтаксис JSON и соответствие JSON Schema
+	JSONValidationSyntax = "syntax" // только синтаксис: схема не задана, задана словами или не поддерживается
+)
+
+// JSONResult итог строгого JSON-режима
+type JSONResult struct {
+	Valid       bool            `json:"valid"`
+	Validation  string          `json:"validation"`             // schema или syntax: что именно проверено
+	SchemaError string          `json:"schema_error,omitempty"` // почему JSON Schema не применялась
+	Object      json.RawMessage `json:"object,omitempty"`       // проверенное значение
+	Errors      []string        `json:"errors,omitempty"`       // ошибки последней проверки
+	Attempts    int             `json:"attempts"`               // 1 + число попыток исправления
+	Content     string          `json:"-"`                      // последний текст модели
+}
+
+// ParseJSONSchema разбирает JSONSchemaText как JSON Schema.
+// Возвращает nil без ошибки, если текст — не JSON (например словесное описание
+// структуры), и ошибку, если схема использует неподдерживаемые ключевые слова.
+func ParseJSONSchema(schemaText string) (*jsonschema.Schema, error) {
+	data, ok := schemaJSON(schemaText)
+	if !ok {
+		return nil, nil
+	}
+	return jsonschema.Parse(data)
+}
+
+// schemaJSON возвращает текст схемы, если это JSON-объект
+func schemaJSON(schemaText string) ([]byte, bool) {
+	trimmed := strings.TrimSpace(schemaText)
+	if !strings.HasPrefix(trimmed, "{") || !json.Valid([]byte(trimmed)) {
+		return nil, false
+	}
+	return []byte(trimmed), true
+}
+
+// ExtractJSON достает JSON-значение из ответа модели: убирает markdown-обертку
+// ```json ... ``` и текст вокруг первого объекта/массива
+func ExtractJSON(text string) (json.RawMessage, error) {
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "```") {
+		trimmed = strings.TrimPrefix(trimmed, "```")
+		if nl := strings.IndexByte(trimmed, '\n'); nl >= 0 {
+			trimmed = trimmed[nl+1:] // язык после ``` (json)
+		}
+		trimmed = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(trimmed), "```"))
+	}
+	if json.Valid([]byte(trimmed)) {
+		return compactJSON(trimmed), nil
+	}
+
+	// Ищем объект или массив внутри текста
+	start := strings.IndexAny(trimmed, "{[")
+	if start >= 0 {
+		closing := "}"
+		if trimmed[start] == '[' {
+			closing = "]"
+		}
+		if end := strings.LastIndex(trimmed, closing); end > start {
+			candidate := trimmed[start : end+1]
+			if json.Valid([]byte(candidate)) {
+				return compactJSON(candidate), nil
+			}
+		}
+	}
+
+	var v interface{}
+	err := json.Unmarshal([]byte(trimmed), &v)
+	if err == nil {
+		err = fmt.Errorf("ответ не является JSON")
+	}
+	return nil, fmt.Errorf("некорректный JSON: %v", err)
+}
+
+func compactJSON(text string) json.RawMessage {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(text)); err != nil {
+		return json.RawMessage(text)
+	}
+	return buf.Bytes()
+}
+
+// ValidateJSONResponse проверяет ответ модели: синтаксис JSON и, если schema
+// задана, соответствие схеме
+func ValidateJSONResponse(text string, schema *jsonschema.Schema) (json.RawMessage, []string) {
+	obj, err := ExtractJSON(text)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	if schema == nil {
+		return obj, nil
+	}
+	if err := schema.ValidateJSON(obj); err != nil {
+		if verr, ok := err.(*jsonschema.ValidationError); ok {
+			return obj, verr.Problems
+		}
+		return obj, []string{err.Error()}
+	}
+	return obj, nil
+}
+
+// BuildJSONRepairPrompt просит модель исправить ответ по ошибкам валидатора
+func BuildJSONRepairPrompt(errors []string) string {
+	return "Твой предыдущий ответ не прошел проверку JSON:\n- " + strings.Join(errors, "\n- ") +
+		"\n\nИсправь ответ. Верни ТОЛЬКО исправленный JSON без пояснений и markdown-разметки."
+}
+
+// RepairJSON проверяет ответ в строгом JSON-режиме и при ошибках до maxRepairs раз
+// отправляет модели ошибки валидатора с просьбой исправить ответ. Если схема
+// не JSON Schema или не поддерживается валидатором, проверяется только синтаксис,
+// и итог сообщает об этом (Validation, SchemaError).
+// onRepair вызывается перед каждой попыткой исправления. Возвращает итог и суммарный
+// usage запросов на исправление (tok используется, если провайдер не вернул usage).
+func RepairJSON(ctx context.Context, p Provider, message string, opts *ChatOptions, response string, maxRepairs int, tok tokenizer.Tokenizer, onRepair func(attempt int, errors []string)) (JSONResult, Usage, error) {
+	var schemaText string
+	if opts != nil {
+		schemaText = opts.JSONSchemaText
+	}
+
+	result := JSONResult{Attempts: 1, Content: response, Validation: JSONValidationSyntax}
+	schema, schemaErr := ParseJSONSchema(schemaText)
+	if schema != nil {
+		result.Validation = JSONValidationSchema
+	}
+	if schemaErr != nil {
+		result.SchemaError = schemaErr.Error()
+	}
+	var repairUsage Usage
+
+	obj, errs := ValidateJSONResponse(response, schema)
+	for attempt := 1; len(errs) > 0 && attempt <= maxRepairs; attempt++ {
+		if onRepair != nil {
+			onRepair(attempt, errs)
+		}
+
+		repairOpts := ChatOptions{}
+		if opts != nil {
+			repairOpts = *opts
+		}
+		repairOpts.OnToolCallDelta = nil
+		repairOpts.Tools = nil
+		repairOpts.History = append(append([]Message(nil), repairOpts.History...),
+			Message{Role: "user", Content: message},
+			Message{Role: RoleAssistant, Content: result.Content},
+		)
+		repairPrompt := BuildJSONRepairPrompt(errs)
+
+		var content strings.Builder
+		chatResult, err := p.Chat(ctx, repairPrompt, &repairOpts, func(chunk string) error {
+			content.WriteString(chunk)
+			return nil
+		})
+		usage := ResolveUsage(chatResult, tok, CountTokensForMessages(tok, repairOpts.SystemPrompt, repairOpts.History, repairPrompt), content.String())
+		repairUsage.InputTokens += usage.InputTokens
+		repairUsage.OutputTokens += usage.OutputTokens
+		repairUsage.TotalTokens += usage.TotalTokens
+		repairUsage.Source = usage.Source
+		result.Attempts++
+		if err != nil {
+			result.Errors = errs
+			return result, repairUsage, err
+		}
+
+		result.Content = content.String()
+		obj, errs = ValidateJSONResponse(result.Content, schema)
+	}
+
+	result.Valid = len(errs) == 0
+	result.Errors = errs
+	if result.Valid {
+		result.Object = obj
+	}
+	return result, repairUsage, nil
+}
+
+// jsonFormatValue значение для нативного JSON-режима Ollama (format):
+// JSON Schema, если она задана (даже с ключевыми словами, которые не проверяет
+// наш валидатор — Ollama применяет схему сама), иначе "json"
+func jsonFormatValue(schemaText string) json.RawMessage {
+	if data, ok := schemaJSON(schemaText); ok {
+		return compactJSON(string(data))
+	}
+	return json.RawMessage(`"json"`)
+}
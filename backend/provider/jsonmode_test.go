@@ -0,0 +1,212 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string // пусто — ожидается ошибка
+	}{
+		{"объект", `{"a": 1}`, `{"a":1}`},
+		{"массив", " [1, 2]\n", `[1,2]`},
+		{"строка", `"да"`, `"да"`},
+		{"markdown", "```json\n{\"a\": 1}\n```", `{"a":1}`},
+		{"markdown без языка", "```\n[1]\n```", `[1]`},
+		{"текст вокруг объекта", "Вот ответ:\n{\"a\": {\"b\": 2}}\nГотово.", `{"a":{"b":2}}`},
+		{"текст вокруг массива", "Список: [1, 2, 3].", `[1,2,3]`},
+		{"обрезанный JSON", `{"a": 1`, ""},
+		{"не JSON", "Извините, не могу ответить", ""},
+		{"пустой ответ", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractJSON(tt.text)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("ExtractJSON = %s, ожидалась ошибка", got)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Errorf("ExtractJSON = %s, %v, ожидалось %s", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJSONSchema(t *testing.T) {
+	if schema, err := ParseJSONSchema("объект с полями name и age"); schema != nil || err != nil {
+		t.Errorf("словесное описание: %v, %v", schema, err)
+	}
+	if schema, err := ParseJSONSchema(`{"type": "object", "required": ["name"]}`); schema == nil || err != nil {
+		t.Errorf("JSON Schema: %v, %v", schema, err)
+	}
+	if schema, err := ParseJSONSchema(`{"type": "string", "format": "email"}`); schema != nil || err == nil || !strings.Contains(err.Error(), `"format"`) {
+		t.Errorf("неподдерживаемая схема: %v, %v", schema, err)
+	}
+}
+
+func TestValidateJSONResponse(t *testing.T) {
+	schema, err := ParseJSONSchema(`{"type": "object", "properties": {"age": {"type": "integer"}}, "required": ["age"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		text   string
+		errors []string
+	}{
+		{"соответствует схеме", "```json\n{\"age\": 30}\n```", nil},
+		{"не по схеме", `{"age": "тридцать"}`, []string{"$.age: ожидается integer, получено string"}},
+		{"нет поля", `{}`, []string{`$: отсутствует обязательное поле "age"`}},
+	}
+	for _, tt := range tests {
+		_, errs := ValidateJSONResponse(tt.text, schema)
+		if strings.Join(errs, "\n") != strings.Join(tt.errors, "\n") {
+			t.Errorf("%s: ошибки = %q, ожидалось %q", tt.name, errs, tt.errors)
+		}
+	}
+
+	// Без схемы проверяется только синтаксис
+	if obj, errs := ValidateJSONResponse(`{"age": "тридцать"}`, nil); len(errs) != 0 || string(obj) != `{"age":"тридцать"}` {
+		t.Errorf("без схемы: %s, %q", obj, errs)
+	}
+	if _, errs := ValidateJSONResponse(`{"age":`, nil); len(errs) != 1 || !strings.Contains(errs[0], "некорректный JSON") {
+		t.Errorf("синтаксическая ошибка: %q", errs)
+	}
+}
+
+// repairProvider отвечает по очереди заданными текстами и запоминает запросы
+type repairProvider struct {
+	fakeProvider
+	replies  []string
+	err      error
+	messages []string
+	history  [][]Message
+}
+
+func (p *repairProvider) Chat(ctx context.Context, message string, opts *ChatOptions, onChunk func(string) error) (*ChatResult, error) {
+	p.messages = append(p.messages, message)
+	p.history = append(p.history, opts.History)
+	if p.err != nil {
+		return nil, p.err
+	}
+	reply := p.replies[0]
+	p.replies = p.replies[1:]
+	return &ChatResult{FinishReason: "stop"}, onChunk(reply)
+}
+
+func TestRepairJSON(t *testing.T) {
+	const schema = `{"type": "object", "properties": {"age": {"type": "integer"}}, "required": ["age"]}`
+	tests := []struct {
+		name       string
+		schema     string
+		response   string
+		replies    []string
+		maxRepairs int
+
+		valid       bool
+		validation  string
+		schemaError bool
+		attempts    int
+		object      string
+		content     string
+	}{
+		{
+			name: "сразу валидный", schema: schema, response: `{"age": 30}`, maxRepairs: 2,
+			valid: true, validation: JSONValidationSchema, attempts: 1, object: `{"age":30}`, content: `{"age": 30}`,
+		},
+		{
+			name: "исправлен со второй попытки", schema: schema, response: "возраст тридцать",
+			replies: []string{`{"age": "30"}`, `{"age": 30}`}, maxRepairs: 2,
+			valid: true, validation: JSONValidationSchema, attempts: 3, object: `{"age":30}`, content: `{"age": 30}`,
+		},
+		{
+			name: "попытки кончились", schema: schema, response: `{}`,
+			replies: []string{`{"age": "30"}`}, maxRepairs: 1,
+			valid: false, validation: JSONValidationSchema, attempts: 2, content: `{"age": "30"}`,
+		},
+		{
+			name: "без исправлений", schema: schema, response: `{}`, maxRepairs: 0,
+			valid: false, validation: JSONValidationSchema, attempts: 1, content: `{}`,
+		},
+		{
+			name: "словесная схема — только синтаксис", schema: "объект с полем age", response: `{"age": "30"}`, maxRepairs: 2,
+			valid: true, validation: JSONValidationSyntax, attempts: 1, object: `{"age":"30"}`, content: `{"age": "30"}`,
+		},
+		{
+			name: "неподдерживаемая схема — только синтаксис", schema: `{"type": "object", "properties": {"age": {"type": "integer", "exclusiveMinimum": 0}}}`,
+			response: `{"age": -5}`, maxRepairs: 2,
+			valid: true, validation: JSONValidationSyntax, schemaError: true, attempts: 1, object: `{"age":-5}`, content: `{"age": -5}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &repairProvider{replies: tt.replies}
+			opts := &ChatOptions{JSONFormat: true, JSONSchemaText: tt.schema, History: []Message{{Role: "user", Content: "раньше"}}}
+
+			var repairs []int
+			res, _, err := RepairJSON(context.Background(), p, "Сколько лет?", opts, tt.response, tt.maxRepairs, nil, func(attempt int, errs []string) {
+				if len(errs) == 0 {
+					t.Error("onRepair без ошибок")
+				}
+				repairs = append(repairs, attempt)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Valid != tt.valid || res.Validation != tt.validation || (res.SchemaError != "") != tt.schemaError ||
+				res.Attempts != tt.attempts || string(res.Object) != tt.object || res.Content != tt.content {
+				t.Errorf("итог = %+v", res)
+			}
+			if !res.Valid && len(res.Errors) == 0 {
+				t.Error("невалидный итог без ошибок")
+			}
+			if len(repairs) != tt.attempts-1 || len(p.messages) != tt.attempts-1 {
+				t.Errorf("попыток исправления: onRepair %v, запросов %d", repairs, len(p.messages))
+			}
+
+			// Модель видит исходный вопрос и свой прошлый ответ, исходная история не меняется
+			for i, history := range p.history {
+				if len(history) != 3 || history[1].Content != "Сколько лет?" || history[2].Role != RoleAssistant {
+					t.Errorf("история попытки %d = %+v", i+1, history)
+				}
+				if !strings.Contains(p.messages[i], "не прошел проверку JSON") {
+					t.Errorf("запрос исправления = %q", p.messages[i])
+				}
+			}
+			if len(opts.History) != 1 {
+				t.Errorf("исходная история изменена: %+v", opts.History)
+			}
+		})
+	}
+}
+
+func TestRepairJSONProviderError(t *testing.T) {
+	p := &repairProvider{err: errors.New("provider down")}
+	res, _, err := RepairJSON(context.Background(), p, "вопрос", &ChatOptions{JSONFormat: true}, "не JSON", 2, nil, nil)
+	if err == nil || res.Valid || res.Attempts != 2 || len(res.Errors) == 0 || res.Content != "не JSON" {
+		t.Errorf("итог = %+v, ошибка %v", res, err)
+	}
+}
+
+func TestJSONFormatValue(t *testing.T) {
+	tests := map[string]string{
+		"":                   `"json"`,
+		"объект с полем age": `"json"`,
+		`{"type": "object"}`: `{"type":"object"}`,
+		// Ollama применяет схему сама, даже если наш валидатор ее не поддерживает
+		`{"anyOf": [{"type": "string"}]}`: `{"anyOf":[{"type":"string"}]}`,
+	}
+	for schema, want := range tests {
+		if got := string(jsonFormatValue(schema)); got != want {
+			t.Errorf("jsonFormatValue(%q) = %s, ожидалось %s", schema, got, want)
+		}
+	}
+}
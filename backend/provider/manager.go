@@ -1,21 +1,30 @@
 package provider
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
+
+	"github.com/nnk/97-aic/backend/tokenizer"
 )
 
 // Manager управляет провайдерами AI
 type Manager struct {
 	mu              sync.RWMutex
 	providers       map[string]Provider
+	breakers        map[string]*circuitBreaker
+	breakerConfig   BreakerConfig
 	defaultProvider string
+	fallbackChain   []string
+	tokenizers      *tokenizer.Registry
 }
 
 // NewManager создает новый менеджер провайдеров
 func NewManager() *Manager {
 	return &Manager{
 		providers: make(map[string]Provider),
+		breakers:  make(map[string]*circuitBreaker),
 	}
 }
 
@@ -24,6 +33,17 @@ func (m *Manager) Register(name string, p Provider) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.providers[name] = p
+	m.breakers[name] = newCircuitBreaker(m.breakerConfig)
+}
+
+// SetBreakerConfig задает настройки circuit breaker для всех провайдеров
+func (m *Manager) SetBreakerConfig(cfg BreakerConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.breakerConfig = cfg
+	for _, b := range m.breakers {
+		b.setConfig(cfg)
+	}
 }
 
 // SetDefault устанавливает провайдера по умолчанию
@@ -39,6 +59,12 @@ func (m *Manager) SetDefault(name string) error {
 
 // Get возвращает провайдера по имени
 func (m *Manager) Get(name string) (Provider, error) {
+	_, p, _, err := m.lookup(name)
+	return p, err
+}
+
+// lookup находит провайдера и его circuit breaker; пустое имя — провайдер по умолчанию
+func (m *Manager) lookup(name string) (string, Provider, *circuitBreaker, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -48,9 +74,24 @@ func (m *Manager) Get(name string) (Provider, error) {
 
 	p, ok := m.providers[name]
 	if !ok {
-		return nil, fmt.Errorf("провайдер %s не найден", name)
+		return "", nil, nil, fmt.Errorf("провайдер %s не найден", name)
+	}
+	return name, p, m.breakers[name], nil
+}
+
+// ForModel возвращает провайдера, привязанного к модели на время одного запроса.
+// Модель передается в Chat через ChatOptions.Model, поэтому общий экземпляр
+// провайдера не изменяется и параллельные запросы с разными моделями не мешают друг другу.
+// Пустая модель фиксирует текущую модель провайдера по умолчанию.
+func (m *Manager) ForModel(name, model string) (Provider, error) {
+	name, p, breaker, err := m.lookup(name)
+	if err != nil {
+		return nil, err
 	}
-	return p, nil
+	if model == "" {
+		model = p.GetModel()
+	}
+	return &boundProvider{Provider: p, name: name, model: model, breaker: breaker}, nil
 }
 
 // GetDefault возвращает провайдера по умолчанию
@@ -77,12 +118,203 @@ func (m *Manager) GetDefaultName() string {
 	return m.defaultProvider
 }
 
+// SetFallbackChain задает цепочку резервных провайдеров (например groq → gigachat → ollama).
+// Незарегистрированные провайдеры в цепочке пропускаются при выполнении запроса.
+func (m *Manager) SetFallbackChain(chain []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fallbackChain = append([]string(nil), chain...)
+}
+
+// GetFallbackChain возвращает цепочку резервных провайдеров
+func (m *Manager) GetFallbackChain() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]string(nil), m.fallbackChain...)
+}
+
+// SetTokenizers задает реестр токенизаторов для точного подсчета токенов по провайдеру и модели
+func (m *Manager) SetTokenizers(reg *tokenizer.Registry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokenizers = reg
+}
+
+// Tokenizer возвращает токенизатор для текущей модели провайдера.
+// Если словарь для модели не настроен, возвращается приблизительный tokenizer.Approximate.
+func (m *Manager) Tokenizer(p Provider) tokenizer.Tokenizer {
+	m.mu.RLock()
+	reg := m.tokenizers
+	m.mu.RUnlock()
+	return reg.For(p.Name(), p.GetModel())
+}
+
+// FallbackEvent информация о переключении на резервный провайдер
+type FallbackEvent struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Error string `json:"error"`
+}
+
+// fallbacksFor возвращает резервных провайдеров для name: провайдеры цепочки после name,
+// а если name в цепочке нет — вся цепочка
+func (m *Manager) fallbacksFor(name string) []string {
+	chain := m.GetFallbackChain()
+	for i, n := range chain {
+		if n == name {
+			return chain[i+1:]
+		}
+	}
+	result := make([]string, 0, len(chain))
+	for _, n := range chain {
+		if n != name {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// ChatWithFallback выполняет запрос через p, а если провайдер упал до первого чанка —
+// повторяет запрос у следующих провайдеров цепочки (с их моделями по умолчанию).
+// После первого чанка ошибка возвращается как есть: частичный ответ уже отдан клиенту.
+// Возвращает провайдера, который ответил (или последнего опрошенного при ошибке), и результат его запроса.
+func (m *Manager) ChatWithFallback(ctx context.Context, p Provider, message string, opts *ChatOptions, onChunk func(string) error, onFallback func(FallbackEvent)) (Provider, *ChatResult, error) {
+	started := false
+	trackedChunk := func(chunk string) error {
+		started = true
+		return onChunk(chunk)
+	}
+
+	// Фрагменты вызова инструмента тоже считаются началом ответа
+	if opts != nil && opts.OnToolCallDelta != nil {
+		tracked := *opts
+		onToolCallDelta := opts.OnToolCallDelta
+		tracked.OnToolCallDelta = func(d ToolCallDelta) error {
+			started = true
+			return onToolCallDelta(d)
+		}
+		opts = &tracked
+	}
+
+	result, err := p.Chat(ctx, message, opts, trackedChunk)
+	if err == nil || started || ctx.Err() != nil {
+		return p, result, err
+	}
+
+	current := p
+	for _, name := range m.fallbacksFor(p.Name()) {
+		if !m.IsAvailable(name) {
+			continue
+		}
+		next, getErr := m.ForModel(name, "")
+		if getErr != nil {
+			continue
+		}
+
+		if onFallback != nil {
+			onFallback(FallbackEvent{From: current.Name(), To: name, Error: err.Error()})
+		}
+
+		// Модель исходного запроса к резервному провайдеру не относится
+		fallbackOpts := ChatOptions{}
+		if opts != nil {
+			fallbackOpts = *opts
+		}
+		fallbackOpts.Model = ""
+
+		current = next
+		result, err = next.Chat(ctx, message, &fallbackOpts, trackedChunk)
+		if err == nil || started || ctx.Err() != nil {
+			return current, result, err
+		}
+	}
+
+	return current, result, err
+}
+
+// IsAvailable сообщает, пропускает ли circuit breaker запросы к провайдеру
+func (m *Manager) IsAvailable(name string) bool {
+	_, _, breaker, err := m.lookup(name)
+	if err != nil {
+		return false
+	}
+	return breaker == nil || breaker.available()
+}
+
+// Health возвращает состояние провайдеров по именам
+func (m *Manager) Health() map[string]HealthStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make(map[string]HealthStatus, len(m.breakers))
+	for name, b := range m.breakers {
+		result[name] = b.snapshot()
+	}
+	return result
+}
+
+// StartHealthChecks запускает фоновые проверки провайдеров, реализующих Prober.
+// Первая проверка выполняется сразу. Проверки останавливаются при отмене ctx.
+func (m *Manager) StartHealthChecks(ctx context.Context) {
+	m.mu.RLock()
+	interval := m.breakerConfig.withDefaults().ProbeInterval
+	m.mu.RUnlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			m.probeAll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// probeAll проверяет все провайдеры параллельно
+func (m *Manager) probeAll(ctx context.Context) {
+	m.mu.RLock()
+	timeout := m.breakerConfig.withDefaults().ProbeTimeout
+	type target struct {
+		prober  Prober
+		breaker *circuitBreaker
+	}
+	targets := make([]target, 0, len(m.providers))
+	for name, p := range m.providers {
+		if prober, ok := p.(Prober); ok {
+			targets = append(targets, target{prober: prober, breaker: m.breakers[name]})
+		}
+	}
+	m.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t target) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			err := t.prober.Probe(pctx)
+			if ctx.Err() != nil {
+				return
+			}
+			t.breaker.recordProbe(err, time.Since(start))
+		}(t)
+	}
+	wg.Wait()
+}
+
 // ProviderInfo информация о провайдере
 type ProviderInfo struct {
-	Name         string   `json:"name"`
-	Models       []string `json:"models"`
-	CurrentModel string   `json:"current_model"`
-	IsDefault    bool     `json:"is_default"`
+	Name         string       `json:"name"`
+	Models       []string     `json:"models"`
+	CurrentModel string       `json:"current_model"`
+	IsDefault    bool         `json:"is_default"`
+	Health       HealthStatus `json:"health"`
 }
 
 // ListInfo возвращает информацию о всех провайдерах
@@ -97,7 +329,73 @@ func (m *Manager) ListInfo() []ProviderInfo {
 			Models:       p.Models(),
 			CurrentModel: p.GetModel(),
 			IsDefault:    name == m.defaultProvider,
+			Health:       m.breakers[name].snapshot(),
 		})
 	}
 	return infos
 }
+
+// boundProvider обертка над провайдером с моделью, выбранной для конкретного запроса.
+// Через нее же проходят результаты запросов в circuit breaker провайдера.
+type boundProvider struct {
+	Provider
+	name    string
+	model   string
+	breaker *circuitBreaker
+}
+
+// SetModel меняет модель только для этой обертки
+func (b *boundProvider) SetModel(model string) {
+	b.model = model
+}
+
+// GetModel возвращает модель запроса
+func (b *boundProvider) GetModel() string {
+	return b.model
+}
+
+// GetMaxTokens возвращает лимит токенов для модели запроса
+func (b *boundProvider) GetMaxTokens() int {
+	return b.Provider.GetMaxTokensForModel(b.model)
+}
+
+// Chat подставляет модель запроса в опции (копию), не трогая опции вызывающего
+func (b *boundProvider) Chat(ctx context.Context, message string, opts *ChatOptions, onChunk func(string) error) (*ChatResult, error) {
+	o := ChatOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Model == "" {
+		o.Model = b.model
+	}
+
+	if b.breaker == nil {
+		return b.Provider.Chat(ctx, message, &o, onChunk)
+	}
+	if !b.breaker.allow() {
+		return nil, &CircuitOpenError{Provider: b.name, LastError: b.breaker.snapshot().LastError}
+	}
+
+	// Задержка до первого чанка — показатель «живости» провайдера
+	start := time.Now()
+	var firstChunk time.Duration
+	result, err := b.Provider.Chat(ctx, message, &o, func(chunk string) error {
+		if firstChunk == 0 {
+			firstChunk = time.Since(start)
+		}
+		return onChunk(chunk)
+	})
+	if firstChunk == 0 {
+		firstChunk = time.Since(start)
+	}
+
+	switch {
+	case err == nil:
+		b.breaker.recordSuccess(firstChunk)
+	case countsAsFailure(err):
+		b.breaker.recordFailure(err, firstChunk)
+	default:
+		b.breaker.release()
+	}
+	return result, err
+}
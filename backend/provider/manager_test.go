@@ -0,0 +1,170 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// fakeProvider провайдер для тестов: отвечает моделью, с которой пришел запрос
+type fakeProvider struct {
+	name string
+
+	mu    sync.Mutex
+	model string
+	err   error // ошибка Chat (nil — успешный ответ)
+	calls int
+}
+
+func newFakeProvider(name, model string) *fakeProvider {
+	return &fakeProvider{name: name, model: model}
+}
+
+func (f *fakeProvider) Name() string     { return f.name }
+func (f *fakeProvider) Models() []string { return []string{f.GetModel()} }
+
+func (f *fakeProvider) Chat(ctx context.Context, message string, opts *ChatOptions, onChunk func(string) error) (*ChatResult, error) {
+	f.mu.Lock()
+	f.calls++
+	err := f.err
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if err := onChunk(resolveModel(opts, f.GetModel())); err != nil {
+		return nil, err
+	}
+	return &ChatResult{FinishReason: "stop"}, nil
+}
+
+func (f *fakeProvider) SetModel(model string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.model = model
+}
+
+func (f *fakeProvider) GetModel() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.model
+}
+
+func (f *fakeProvider) GetMaxTokens() int                     { return f.GetMaxTokensForModel(f.GetModel()) }
+func (f *fakeProvider) GetMaxTokensForModel(model string) int { return len(model) * 1000 }
+func (f *fakeProvider) CalculateCost(in, out int) float64     { return 0 }
+
+func (f *fakeProvider) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *fakeProvider) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// Параллельные запросы с разными моделями через один общий провайдер (запускать с -race)
+func TestForModelConcurrentMixedModels(t *testing.T) {
+	fake := newFakeProvider("fake", "default-model")
+	m := NewManager()
+	m.Register("fake", fake)
+	if err := m.SetDefault("fake"); err != nil {
+		t.Fatal(err)
+	}
+
+	models := []string{"model-a", "model-bb", "model-ccc", ""}
+	const perModel = 50
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(models)*perModel)
+	for i := 0; i < perModel; i++ {
+		for _, model := range models {
+			wg.Add(1)
+			go func(model string) {
+				defer wg.Done()
+				want := model
+				if want == "" {
+					want = "default-model"
+				}
+
+				p, err := m.ForModel("", model)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if got := p.GetModel(); got != want {
+					errs <- fmt.Errorf("GetModel = %q, ожидалась %q", got, want)
+				}
+				if got := p.GetMaxTokens(); got != len(want)*1000 {
+					errs <- fmt.Errorf("GetMaxTokens для %q = %d", want, got)
+				}
+
+				var answered string
+				_, err = p.Chat(context.Background(), "привет", &ChatOptions{SystemPrompt: "x"}, func(chunk string) error {
+					answered += chunk
+					return nil
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+				if answered != want {
+					errs <- fmt.Errorf("запрос с моделью %q ушел с моделью %q", want, answered)
+				}
+			}(model)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got := fake.GetModel(); got != "default-model" {
+		t.Errorf("модель общего провайдера изменилась: %q", got)
+	}
+	if got := fake.callCount(); got != len(models)*perModel {
+		t.Errorf("вызовов Chat = %d, ожидалось %d", got, len(models)*perModel)
+	}
+}
+
+func TestBoundProviderSetModelIsLocal(t *testing.T) {
+	fake := newFakeProvider("fake", "default-model")
+	m := NewManager()
+	m.Register("fake", fake)
+
+	p, err := m.ForModel("fake", "model-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetModel("model-b")
+	if got := p.GetModel(); got != "model-b" {
+		t.Errorf("GetModel = %q, ожидалась model-b", got)
+	}
+	if got := fake.GetModel(); got != "default-model" {
+		t.Errorf("модель общего провайдера изменилась: %q", got)
+	}
+
+	// Модель в опциях запроса приоритетнее модели обертки, опции вызывающего не меняются
+	opts := &ChatOptions{Model: "model-c"}
+	var answered string
+	if _, err := p.Chat(context.Background(), "привет", opts, func(chunk string) error {
+		answered = chunk
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if answered != "model-c" || opts.Model != "model-c" {
+		t.Errorf("ответ с моделью %q, opts.Model = %q", answered, opts.Model)
+	}
+}
+
+func TestForModelUnknownProvider(t *testing.T) {
+	m := NewManager()
+	if _, err := m.ForModel("missing", "x"); err == nil {
+		t.Error("ожидалась ошибка для незарегистрированного провайдера")
+	}
+}
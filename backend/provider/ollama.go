@@ -6,9 +6,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,13 +16,17 @@ import (
 type OllamaProvider struct {
 	httpClient *http.Client
 	apiURL     string
-	model      string
+	retry      RetryConfig
+
+	mu    sync.RWMutex
+	model string
 }
 
 // OllamaConfig конфигурация Ollama провайдера
 type OllamaConfig struct {
 	APIURL string // по умолчанию http://localhost:11434
 	Model  string // по умолчанию llama3.2:3b
+	Retry  RetryConfig
 }
 
 // Рекомендуемые модели для разного железа
@@ -102,6 +106,7 @@ func NewOllamaProvider(cfg OllamaConfig) *OllamaProvider {
 			Timeout: 300 * time.Second, // Локальные модели могут быть медленными
 		},
 		apiURL: apiURL,
+		retry:  cfg.Retry,
 		model:  model,
 	}
 }
@@ -118,23 +123,32 @@ func (p *OllamaProvider) Models() []string {
 
 // SetModel устанавливает модель
 func (p *OllamaProvider) SetModel(model string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	p.model = model
 }
 
 // GetModel возвращает текущую модель
 func (p *OllamaProvider) GetModel() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	return p.model
 }
 
 // GetMaxTokens возвращает максимальный лимит токенов для текущей модели
 func (p *OllamaProvider) GetMaxTokens() int {
+	return p.GetMaxTokensForModel(p.GetModel())
+}
+
+// GetMaxTokensForModel возвращает максимальный лимит токенов для указанной модели
+func (p *OllamaProvider) GetMaxTokensForModel(model string) int {
 	// Лимиты зависят от модели, используем консервативные значения
 	// Большинство локальных моделей имеют лимит 2048-4096
 	// Для больших моделей может быть больше
-	if strings.Contains(p.model, "8b") || strings.Contains(p.model, "7b") {
+	if strings.Contains(model, "8b") || strings.Contains(model, "7b") {
 		return 4096
 	}
-	if strings.Contains(p.model, "3b") || strings.Contains(p.model, "2b") {
+	if strings.Contains(model, "3b") || strings.Contains(model, "2b") {
 		return 2048
 	}
 	// Для очень маленьких моделей
@@ -153,11 +167,34 @@ type ollamaChatRequest struct {
 	Messages []ollamaMessage `json:"messages"`
 	Stream   bool            `json:"stream"`
 	Options  *ollamaOptions  `json:"options,omitempty"`
+	Tools    []ollamaTool    `json:"tools,omitempty"`
+	Format   json.RawMessage `json:"format,omitempty"` // "json" или JSON Schema (нативный JSON-режим)
 }
 
 type ollamaMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"` // для role=tool
+}
+
+type ollamaTool struct {
+	Type     string             `json:"type"` // function
+	Function ollamaToolFunction `json:"function"`
+}
+
+type ollamaToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// ollamaToolCall вызов инструмента: Ollama присылает его целиком, аргументы — объектом
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
 }
 
 type ollamaOptions struct {
@@ -170,40 +207,18 @@ type ollamaChatResponse struct {
 	Message    ollamaMessage `json:"message"`
 	Done       bool          `json:"done"`
 	DoneReason string        `json:"done_reason,omitempty"`
+
+	// Счетчики токенов приходят в финальном сообщении (done=true)
+	PromptEvalCount int `json:"prompt_eval_count,omitempty"`
+	EvalCount       int `json:"eval_count,omitempty"`
 }
 
 // Chat отправляет сообщение через Ollama API
-func (p *OllamaProvider) Chat(ctx context.Context, message string, opts *ChatOptions, onChunk func(string) error) error {
+func (p *OllamaProvider) Chat(ctx context.Context, message string, opts *ChatOptions, onChunk func(string) error) (*ChatResult, error) {
 	messages := []ollamaMessage{}
 
-	// System prompt - объединяем все части
-	var systemPrompt string
-	if opts != nil {
-		// Базовый system prompt от пользователя
-		if opts.SystemPrompt != "" {
-			systemPrompt = opts.SystemPrompt
-		}
-
-		// Добавляем режим рассуждения
-		if opts.ReasoningMode != "" && opts.ReasoningMode != "direct" {
-			reasoningPrompt := BuildReasoningPrompt(opts.ReasoningMode, "")
-			if systemPrompt != "" {
-				systemPrompt = systemPrompt + "\n\n" + reasoningPrompt
-			} else {
-				systemPrompt = reasoningPrompt
-			}
-		}
-
-		// Добавляем JSON-инструкцию
-		if opts.JSONFormat && opts.JSONSchemaText != "" {
-			jsonPrompt := BuildJSONPrompt(opts.JSONSchemaText)
-			if systemPrompt != "" {
-				systemPrompt = systemPrompt + "\n\n" + jsonPrompt
-			} else {
-				systemPrompt = jsonPrompt
-			}
-		}
-	}
+	// System prompt пользователя + инструкции режима рассуждения и JSON
+	systemPrompt := BuildSystemPrompt(opts)
 
 	if systemPrompt != "" {
 		messages = append(messages, ollamaMessage{
@@ -215,25 +230,59 @@ func (p *OllamaProvider) Chat(ctx context.Context, message string, opts *ChatOpt
 	// История
 	if opts != nil && len(opts.History) > 0 {
 		for _, msg := range opts.History {
-			messages = append(messages, ollamaMessage{
+			om := ollamaMessage{
 				Role:    msg.Role,
 				Content: msg.Content,
-			})
+			}
+			for _, call := range msg.ToolCalls {
+				var oc ollamaToolCall
+				oc.Function.Name = call.Name
+				oc.Function.Arguments = argumentsObject(call.Arguments)
+				om.ToolCalls = append(om.ToolCalls, oc)
+			}
+			if msg.Role == RoleTool {
+				om.ToolName = msg.Name
+			}
+			messages = append(messages, om)
 		}
 	}
 
-	// Текущее сообщение
-	messages = append(messages, ollamaMessage{
-		Role:    "user",
-		Content: message,
-	})
+	// Текущее сообщение (пустое — продолжение после результатов инструментов)
+	if message != "" {
+		messages = append(messages, ollamaMessage{
+			Role:    "user",
+			Content: message,
+		})
+	}
 
 	reqBody := ollamaChatRequest{
-		Model:    p.model,
+		Model:    resolveModel(opts, p.GetModel()),
 		Messages: messages,
 		Stream:   true,
 	}
 
+	// Инструменты (Ollama не поддерживает tool_choice: none означает не передавать инструменты)
+	if opts != nil && opts.ToolChoice != "none" {
+		for _, t := range opts.Tools {
+			reqBody.Tools = append(reqBody.Tools, ollamaTool{
+				Type: "function",
+				Function: ollamaToolFunction{
+					Name:        t.Name,
+					Description: t.Description,
+					Parameters:  toolParameters(t),
+				},
+			})
+		}
+	}
+
+	var onToolCallDelta func(ToolCallDelta) error
+	if opts != nil {
+		onToolCallDelta = opts.OnToolCallDelta
+		if opts.JSONFormat {
+			reqBody.Format = jsonFormatValue(opts.JSONSchemaText)
+		}
+	}
+
 	if opts != nil && (opts.MaxTokens > 0 || opts.Temperature >= 0) {
 		reqBody.Options = &ollamaOptions{}
 		if opts.MaxTokens > 0 {
@@ -246,26 +295,33 @@ func (p *OllamaProvider) Chat(ctx context.Context, message string, opts *ChatOpt
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return fmt.Errorf("ошибка маршалинга запроса: %w", err)
+		return nil, fmt.Errorf("ошибка маршалинга запроса: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", p.apiURL+"/api/chat", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("ошибка создания запроса: %w", err)
-	}
+	resp, err := doWithRetry(ctx, "ollama", p.retry, func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", p.apiURL+"/api/chat", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("ошибка создания запроса: %w", err)
+		}
 
-	req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Type", "application/json")
 
-	resp, err := p.httpClient.Do(req)
+		resp, err := p.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка выполнения запроса (убедитесь что Ollama запущена: ollama serve): %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, newAPIError("Ollama", resp)
+		}
+		return resp, nil
+	})
 	if err != nil {
-		return fmt.Errorf("ошибка выполнения запроса (убедитесь что Ollama запущена: ollama serve): %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("ошибка Ollama API: %d - %s", resp.StatusCode, string(body))
-	}
+	result := &ChatResult{}
+	var toolCalls toolCallAccumulator
 
 	scanner := bufio.NewScanner(resp.Body)
 	// Увеличиваем буфер для больших ответов
@@ -275,7 +331,7 @@ func (p *OllamaProvider) Chat(ctx context.Context, message string, opts *ChatOpt
 	for scanner.Scan() {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		default:
 		}
 
@@ -291,19 +347,63 @@ func (p *OllamaProvider) Chat(ctx context.Context, message string, opts *ChatOpt
 
 		if chatResp.Message.Content != "" {
 			if err := onChunk(chatResp.Message.Content); err != nil {
-				return err
+				return nil, err
+			}
+		}
+
+		for _, call := range chatResp.Message.ToolCalls {
+			delta := ToolCallDelta{
+				Index:          len(toolCalls.calls),
+				Name:           call.Function.Name,
+				ArgumentsDelta: string(argumentsObject(string(call.Function.Arguments))),
+			}
+			if err := toolCalls.add(delta, onToolCallDelta); err != nil {
+				return nil, err
 			}
 		}
 
 		if chatResp.Done {
+			result.FinishReason = chatResp.DoneReason
+			if chatResp.PromptEvalCount > 0 || chatResp.EvalCount > 0 {
+				result.Usage = &Usage{
+					InputTokens:  chatResp.PromptEvalCount,
+					OutputTokens: chatResp.EvalCount,
+					TotalTokens:  chatResp.PromptEvalCount + chatResp.EvalCount,
+					Source:       UsageSourceProvider,
+				}
+			}
 			break
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("ошибка чтения потока: %w", err)
+		return nil, fmt.Errorf("ошибка чтения потока: %w", err)
+	}
+
+	// Ollama завершает ответ с вызовом инструментов причиной stop
+	if result.ToolCalls = toolCalls.result(); len(result.ToolCalls) > 0 {
+		result.FinishReason = FinishReasonToolCalls
 	}
 
+	return result, nil
+}
+
+// Probe проверяет доступность Ollama через /api/tags
+func (p *OllamaProvider) Probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.apiURL+"/api/tags", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("не удалось подключиться к Ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError("Ollama", resp)
+	}
 	return nil
 }
 
@@ -0,0 +1,460 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenAICompatibleProvider провайдер для любого OpenAI-совместимого API
+// (/chat/completions со стримингом SSE): Groq, vLLM, LM Studio, OpenRouter, внутренние шлюзы
+type OpenAICompatibleProvider struct {
+	httpClient *http.Client
+	name       string
+	apiKey     string
+	apiURL     string
+	headers    map[string]string
+	models     []string
+
+	maxTokens        map[string]int // лимиты по моделям
+	defaultMaxTokens int
+
+	inputPricePer1k  float64
+	outputPricePer1k float64
+
+	retry RetryConfig
+
+	disableStreamUsage bool // не отправлять stream_options
+
+	mu    sync.RWMutex
+	model string
+}
+
+// OpenAICompatibleConfig конфигурация OpenAI-совместимого провайдера
+type OpenAICompatibleConfig struct {
+	Name             string            // имя провайдера в Manager (обязательно)
+	APIKey           string            // может быть пустым для локальных серверов
+	APIURL           string            // базовый URL, например http://localhost:8000/v1
+	Model            string            // модель по умолчанию (по умолчанию первая из Models)
+	Models           []string          // список доступных моделей
+	Headers          map[string]string // дополнительные заголовки запроса
+	MaxTokens        map[string]int    // лимиты токенов по моделям
+	DefaultMaxTokens int               // лимит для моделей без явного значения (по умолчанию 8192)
+	InputPricePer1k  float64           // стоимость 1000 входных токенов в USD
+	OutputPricePer1k float64           // стоимость 1000 выходных токенов в USD
+	Timeout          time.Duration     // по умолчанию 120 секунд
+	Retry            RetryConfig       // повторы при 429/5xx/обрыве соединения
+
+	// DisableStreamUsage отключает stream_options.include_usage для серверов,
+	// которые отвечают 400 на неизвестные поля (старые vLLM, LM Studio).
+	// Без него usage считается локальным токенизатором.
+	DisableStreamUsage bool
+}
+
+// NewOpenAICompatibleProvider создает новый OpenAI-совместимый провайдер
+func NewOpenAICompatibleProvider(cfg OpenAICompatibleConfig) *OpenAICompatibleProvider {
+	models := cfg.Models
+	model := cfg.Model
+	if model == "" && len(models) > 0 {
+		model = models[0]
+	}
+	if len(models) == 0 && model != "" {
+		models = []string{model}
+	}
+
+	defaultMaxTokens := cfg.DefaultMaxTokens
+	if defaultMaxTokens <= 0 {
+		defaultMaxTokens = 8192 // Стандартный лимит
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 120 * time.Second
+	}
+
+	return &OpenAICompatibleProvider{
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+		name:             cfg.Name,
+		apiKey:           cfg.APIKey,
+		apiURL:           strings.TrimRight(cfg.APIURL, "/"),
+		headers:          cfg.Headers,
+		models:           models,
+		maxTokens:        cfg.MaxTokens,
+		defaultMaxTokens: defaultMaxTokens,
+		inputPricePer1k:  cfg.InputPricePer1k,
+		outputPricePer1k: cfg.OutputPricePer1k,
+		retry:            cfg.Retry,
+		model:            model,
+
+		disableStreamUsage: cfg.DisableStreamUsage,
+	}
+}
+
+// Name возвращает имя провайдера
+func (p *OpenAICompatibleProvider) Name() string {
+	return p.name
+}
+
+// Models возвращает список доступных моделей
+func (p *OpenAICompatibleProvider) Models() []string {
+	return p.models
+}
+
+// SetModel устанавливает модель
+func (p *OpenAICompatibleProvider) SetModel(model string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.model = model
+}
+
+// GetModel возвращает текущую модель
+func (p *OpenAICompatibleProvider) GetModel() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.model
+}
+
+// GetMaxTokens возвращает максимальный лимит токенов для текущей модели
+func (p *OpenAICompatibleProvider) GetMaxTokens() int {
+	return p.GetMaxTokensForModel(p.GetModel())
+}
+
+// GetMaxTokensForModel возвращает максимальный лимит токенов для указанной модели
+func (p *OpenAICompatibleProvider) GetMaxTokensForModel(model string) int {
+	if limit, ok := p.maxTokens[model]; ok && limit > 0 {
+		return limit
+	}
+	return p.defaultMaxTokens
+}
+
+// CalculateCost вычисляет стоимость запроса в USD по ценам из конфигурации
+func (p *OpenAICompatibleProvider) CalculateCost(inputTokens, outputTokens int) float64 {
+	inputCost := float64(inputTokens) / 1000.0 * p.inputPricePer1k
+	outputCost := float64(outputTokens) / 1000.0 * p.outputPricePer1k
+	return inputCost + outputCost
+}
+
+// openAIChatRequest запрос к /chat/completions
+type openAIChatRequest struct {
+	Model         string               `json:"model"`
+	Messages      []openAIMessage      `json:"messages"`
+	Stream        bool                 `json:"stream"`
+	StreamOptions *openAIStreamOptions `json:"stream_options,omitempty"`
+	MaxTokens     int                  `json:"max_tokens,omitempty"`
+	Temperature   float64              `json:"temperature,omitempty"`
+	Tools         []openAITool         `json:"tools,omitempty"`
+	ToolChoice    interface{}          `json:"tool_choice,omitempty"` // "auto", "none", "required" или {"type":"function",...}
+
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+// openAIResponseFormat нативный JSON-режим: модель обязана вернуть JSON-объект
+type openAIResponseFormat struct {
+	Type string `json:"type"` // json_object
+}
+
+// openAIStreamOptions просит вернуть usage в последнем чанке стрима
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+	Name       string           `json:"name,omitempty"`
+}
+
+type openAITool struct {
+	Type     string             `json:"type"` // function
+	Function openAIToolFunction `json:"function"`
+}
+
+type openAIToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// openAIToolCall вызов инструмента; в стриме приходит частями с одним index
+type openAIToolCall struct {
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIChatResponse struct {
+	ID      string         `json:"id"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+	// Groq возвращает usage в x_groq последнего чанка
+	XGroq *struct {
+		Usage *openAIUsage `json:"usage,omitempty"`
+	} `json:"x_groq,omitempty"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u *openAIUsage) toUsage() *Usage {
+	return &Usage{
+		InputTokens:  u.PromptTokens,
+		OutputTokens: u.CompletionTokens,
+		TotalTokens:  u.TotalTokens,
+		Source:       UsageSourceProvider,
+	}
+}
+
+type openAIChoice struct {
+	Index        int          `json:"index"`
+	Delta        *openAIDelta `json:"delta,omitempty"`
+	Message      *openAIDelta `json:"message,omitempty"`
+	FinishReason string       `json:"finish_reason"`
+}
+
+type openAIDelta struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []openAIToolCall `json:"tool_calls,omitempty"`
+}
+
+// Chat отправляет сообщение через OpenAI-совместимый API
+func (p *OpenAICompatibleProvider) Chat(ctx context.Context, message string, opts *ChatOptions, onChunk func(string) error) (*ChatResult, error) {
+	messages := []openAIMessage{}
+
+	// System prompt пользователя + инструкции режима рассуждения и JSON
+	systemPrompt := BuildSystemPrompt(opts)
+
+	if systemPrompt != "" {
+		messages = append(messages, openAIMessage{
+			Role:    "system",
+			Content: systemPrompt,
+		})
+	}
+
+	// История
+	if opts != nil && len(opts.History) > 0 {
+		for _, msg := range opts.History {
+			om := openAIMessage{
+				Role:       msg.Role,
+				Content:    msg.Content,
+				ToolCallID: msg.ToolCallID,
+				Name:       msg.Name,
+			}
+			for _, call := range msg.ToolCalls {
+				var oc openAIToolCall
+				oc.ID = call.ID
+				oc.Type = "function"
+				oc.Function.Name = call.Name
+				oc.Function.Arguments = call.Arguments
+				om.ToolCalls = append(om.ToolCalls, oc)
+			}
+			messages = append(messages, om)
+		}
+	}
+
+	// Текущее сообщение (пустое — продолжение после результатов инструментов)
+	if message != "" {
+		messages = append(messages, openAIMessage{
+			Role:    "user",
+			Content: message,
+		})
+	}
+
+	reqBody := openAIChatRequest{
+		Model:    resolveModel(opts, p.GetModel()),
+		Messages: messages,
+		Stream:   true,
+	}
+	if !p.disableStreamUsage {
+		reqBody.StreamOptions = &openAIStreamOptions{IncludeUsage: true}
+	}
+
+	var onToolCallDelta func(ToolCallDelta) error
+	if opts != nil {
+		if opts.MaxTokens > 0 {
+			reqBody.MaxTokens = opts.MaxTokens
+		}
+		if opts.Temperature >= 0 {
+			reqBody.Temperature = opts.Temperature
+		}
+
+		// Инструменты
+		for _, t := range opts.Tools {
+			reqBody.Tools = append(reqBody.Tools, openAITool{
+				Type: "function",
+				Function: openAIToolFunction{
+					Name:        t.Name,
+					Description: t.Description,
+					Parameters:  toolParameters(t),
+				},
+			})
+		}
+		if len(reqBody.Tools) > 0 {
+			switch opts.ToolChoice {
+			case "":
+			case "auto", "none", "required":
+				reqBody.ToolChoice = opts.ToolChoice
+			default:
+				reqBody.ToolChoice = map[string]interface{}{
+					"type":     "function",
+					"function": map[string]string{"name": opts.ToolChoice},
+				}
+			}
+		}
+		onToolCallDelta = opts.OnToolCallDelta
+
+		// Нативный JSON-режим (с инструментами не совместим)
+		if opts.JSONFormat && len(reqBody.Tools) == 0 {
+			reqBody.ResponseFormat = &openAIResponseFormat{Type: "json_object"}
+		}
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка маршалинга запроса: %w", err)
+	}
+
+	resp, err := doWithRetry(ctx, p.name, p.retry, func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", p.apiURL+"/chat/completions", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("ошибка создания запроса: %w", err)
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		if p.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+p.apiKey)
+		}
+		for key, value := range p.headers {
+			req.Header.Set(key, value)
+		}
+
+		resp, err := p.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка выполнения запроса: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, newAPIError(p.name, resp)
+		}
+		return resp, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &ChatResult{}
+	var toolCalls toolCallAccumulator
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		data := strings.TrimPrefix(line, "data: ")
+		if data == "[DONE]" {
+			break
+		}
+
+		var chatResp openAIChatResponse
+		if err := json.Unmarshal([]byte(data), &chatResp); err != nil {
+			continue
+		}
+
+		if chatResp.Usage != nil {
+			result.Usage = chatResp.Usage.toUsage()
+		} else if chatResp.XGroq != nil && chatResp.XGroq.Usage != nil {
+			result.Usage = chatResp.XGroq.Usage.toUsage()
+		}
+
+		if len(chatResp.Choices) > 0 {
+			choice := chatResp.Choices[0]
+			if choice.FinishReason != "" {
+				result.FinishReason = choice.FinishReason
+			}
+			delta := choice.Delta
+			if delta == nil {
+				delta = choice.Message
+			}
+			if delta != nil {
+				if delta.Content != "" {
+					if err := onChunk(delta.Content); err != nil {
+						return nil, err
+					}
+				}
+				for i, call := range delta.ToolCalls {
+					index := i
+					if call.Index != nil {
+						index = *call.Index
+					}
+					d := ToolCallDelta{
+						Index:          index,
+						ID:             call.ID,
+						Name:           call.Function.Name,
+						ArgumentsDelta: call.Function.Arguments,
+					}
+					if err := toolCalls.add(d, onToolCallDelta); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения потока: %w", err)
+	}
+
+	result.ToolCalls = toolCalls.result()
+
+	return result, nil
+}
+
+// Probe проверяет доступность API через /models
+func (p *OpenAICompatibleProvider) Probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.apiURL+"/models", nil)
+	if err != nil {
+		return err
+	}
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	for key, value := range p.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("ошибка выполнения запроса: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(p.name, resp)
+	}
+	return nil
+}
@@ -6,12 +6,16 @@ import (
 
 // Message представляет сообщение в чате
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string     `json:"role"` // system, user, assistant, tool
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`   // вызовы инструментов в ответе ассистента
+	ToolCallID string     `json:"tool_call_id,omitempty"` // для role=tool: на какой вызов это ответ
+	Name       string     `json:"name,omitempty"`         // для role=tool: имя инструмента
 }
 
 // ChatOptions расширенные параметры запроса
 type ChatOptions struct {
+	Model          string    `json:"model,omitempty"` // модель для этого запроса (пусто — модель провайдера по умолчанию)
 	SystemPrompt   string    `json:"system_prompt,omitempty"`
 	History        []Message `json:"history,omitempty"`
 	MaxTokens      int       `json:"max_tokens,omitempty"`
@@ -19,8 +23,42 @@ type ChatOptions struct {
 	ReasoningMode  string    `json:"reasoning_mode,omitempty"` // direct, step_by_step, experts
 	JSONFormat     bool      `json:"json_format,omitempty"`
 	JSONSchemaText string    `json:"json_schema_text,omitempty"`
+
+	// Инструменты, доступные модели
+	Tools      []Tool `json:"tools,omitempty"`
+	ToolChoice string `json:"tool_choice,omitempty"` // auto (по умолчанию), none, required или имя инструмента
+
+	// OnToolCallDelta вызывается на каждый фрагмент вызова инструмента в стриме
+	OnToolCallDelta func(ToolCallDelta) error `json:"-"`
+}
+
+// Источники данных об использовании токенов
+const (
+	UsageSourceProvider  = "provider"  // числа вернул API провайдера
+	UsageSourceTokenizer = "tokenizer" // подсчет локальным BPE токенизатором модели
+	UsageSourceEstimate  = "estimate"  // приблизительная оценка (CountTokens)
+)
+
+// Usage использование токенов запросом
+type Usage struct {
+	InputTokens  int    `json:"input_tokens"`
+	OutputTokens int    `json:"output_tokens"`
+	TotalTokens  int    `json:"total_tokens"`
+	Source       string `json:"source"` // provider, tokenizer, estimate (UsageSource*)
 }
 
+// ChatResult итог запроса, известный после окончания стрима
+type ChatResult struct {
+	Usage        *Usage `json:"usage,omitempty"`         // nil, если провайдер не вернул usage
+	FinishReason string `json:"finish_reason,omitempty"` // stop, length, tool_calls и т.п. (как вернул провайдер)
+
+	// ToolCalls вызовы инструментов, собранные из стрима (FinishReason = tool_calls)
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+}
+
+// FinishReasonStopped генерация остановлена пользователем (ответ сохранен частично)
+const FinishReasonStopped = "stopped"
+
 // Provider интерфейс для AI-провайдеров
 type Provider interface {
 	// Name возвращает имя провайдера
@@ -29,10 +67,15 @@ type Provider interface {
 	// Models возвращает список доступных моделей
 	Models() []string
 
-	// Chat отправляет сообщение и возвращает streaming ответ
-	Chat(ctx context.Context, message string, opts *ChatOptions, onChunk func(string) error) error
+	// Chat отправляет сообщение и возвращает streaming ответ.
+	// Результат (usage, finish reason, вызовы инструментов) возвращается после окончания стрима
+	// и может быть частично пустым. Пустой message означает продолжение диалога из opts.History
+	// (например после результатов инструментов) — новое сообщение пользователя не добавляется.
+	Chat(ctx context.Context, message string, opts *ChatOptions, onChunk func(string) error) (*ChatResult, error)
 
-	// SetModel устанавливает модель для использования
+	// SetModel устанавливает модель по умолчанию для провайдера.
+	// Для выбора модели в рамках одного запроса используйте ChatOptions.Model
+	// или Manager.ForModel — они не меняют общее состояние.
 	SetModel(model string)
 
 	// GetModel возвращает текущую модель
@@ -41,11 +84,22 @@ type Provider interface {
 	// GetMaxTokens возвращает максимальный лимит токенов для текущей модели
 	GetMaxTokens() int
 
+	// GetMaxTokensForModel возвращает максимальный лимит токенов для указанной модели
+	GetMaxTokensForModel(model string) int
+
 	// CalculateCost вычисляет стоимость запроса в USD
 	// Возвращает стоимость на основе количества токенов входа и выхода
 	CalculateCost(inputTokens, outputTokens int) float64
 }
 
+// resolveModel возвращает модель из опций запроса, а если она не задана — модель по умолчанию
+func resolveModel(opts *ChatOptions, defaultModel string) string {
+	if opts != nil && opts.Model != "" {
+		return opts.Model
+	}
+	return defaultModel
+}
+
 // ReasoningMode режимы рассуждения
 const (
 	ReasoningDirect     = "direct"       // Прямой ответ
@@ -117,6 +171,34 @@ func BuildReasoningPrompt(mode string, basePrompt string) string {
 	}
 }
 
+// BuildSystemPrompt собирает итоговый system prompt запроса так, как его отправляют
+// провайдеры: prompt пользователя, инструкция режима рассуждения и JSON-инструкция
+func BuildSystemPrompt(opts *ChatOptions) string {
+	if opts == nil {
+		return ""
+	}
+	systemPrompt := opts.SystemPrompt
+
+	// Добавляем режим рассуждения
+	if opts.ReasoningMode != "" && opts.ReasoningMode != ReasoningDirect {
+		systemPrompt = joinPrompt(systemPrompt, BuildReasoningPrompt(opts.ReasoningMode, ""))
+	}
+
+	// Добавляем JSON-инструкцию
+	if opts.JSONFormat {
+		systemPrompt = joinPrompt(systemPrompt, BuildJSONPrompt(opts.JSONSchemaText))
+	}
+	return systemPrompt
+}
+
+// joinPrompt дописывает часть к system prompt через пустую строку
+func joinPrompt(systemPrompt, part string) string {
+	if systemPrompt == "" {
+		return part
+	}
+	return systemPrompt + "\n\n" + part
+}
+
 // BuildJSONPrompt создает system prompt для JSON-формата
 func BuildJSONPrompt(schemaText string) string {
 	prompt := "Ваш ответ должен быть строго в формате JSON.\n"
@@ -0,0 +1,203 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/nnk/97-aic/backend/logger"
+)
+
+// RetryConfig параметры повторов запроса к API провайдера.
+// Повторы выполняются только до начала стриминга (до получения 200 OK).
+type RetryConfig struct {
+	MaxAttempts    int           // всего попыток, 1 — без повторов (по умолчанию 3)
+	InitialBackoff time.Duration // первая пауза (по умолчанию 500ms)
+	MaxBackoff     time.Duration // максимальная пауза (по умолчанию 8s)
+	MaxRetryAfter  time.Duration // если Retry-After больше — не ждем, а сразу возвращаем ошибку (по умолчанию 30s)
+}
+
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = 500 * time.Millisecond
+	defaultRetryMaxBackoff     = 8 * time.Second
+	defaultRetryMaxRetryAfter  = 30 * time.Second
+)
+
+func (c RetryConfig) withDefaults() RetryConfig {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if c.MaxBackoff < c.InitialBackoff {
+		c.MaxBackoff = c.InitialBackoff
+	}
+	if c.MaxRetryAfter <= 0 {
+		c.MaxRetryAfter = defaultRetryMaxRetryAfter
+	}
+	return c
+}
+
+// APIError ошибка HTTP-ответа API провайдера (статус не 200)
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // значение заголовка Retry-After (0 — не задан)
+
+	// TokenExpired — ответ 401 при просроченном OAuth-токене, который уже сброшен
+	// и будет получен заново при следующей попытке
+	TokenExpired bool
+}
+
+// Error возвращает текст ошибки
+func (e *APIError) Error() string {
+	return fmt.Sprintf("ошибка %s API: %d - %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// newAPIError читает и закрывает тело неуспешного ответа
+func newAPIError(providerName string, resp *http.Response) *APIError {
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	return &APIError{
+		Provider:   providerName,
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// parseRetryAfter разбирает Retry-After: число секунд или HTTP-дата
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// retryDecision результат классификации ошибки
+type retryDecision struct {
+	retry  bool
+	wait   time.Duration // минимальная пауза перед повтором (из Retry-After)
+	reason string
+}
+
+// classifyError определяет, имеет ли смысл повторять запрос после ошибки
+func classifyError(err error) retryDecision {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return retryDecision{}
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusTooManyRequests:
+			return retryDecision{retry: true, wait: apiErr.RetryAfter, reason: "rate_limit"}
+		case apiErr.StatusCode == http.StatusUnauthorized && apiErr.TokenExpired:
+			return retryDecision{retry: true, reason: "token_expired"}
+		case apiErr.StatusCode >= 500:
+			return retryDecision{retry: true, wait: apiErr.RetryAfter, reason: "server_error"}
+		}
+		return retryDecision{}
+	}
+
+	switch {
+	case errors.Is(err, syscall.ECONNRESET):
+		return retryDecision{retry: true, reason: "connection_reset"}
+	case errors.Is(err, syscall.EPIPE):
+		return retryDecision{retry: true, reason: "broken_pipe"}
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return retryDecision{retry: true, reason: "unexpected_eof"}
+	}
+	return retryDecision{}
+}
+
+// backoff вычисляет паузу перед попыткой attempt (начиная с 1) с jitter в диапазоне [d/2, d]
+func (c RetryConfig) backoff(attempt int) time.Duration {
+	d := c.InitialBackoff
+	for i := 1; i < attempt && d < c.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.MaxBackoff {
+		d = c.MaxBackoff
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// doWithRetry выполняет send с повторами при временных ошибках.
+// send должен создавать новый запрос на каждую попытку и возвращать ответ только со статусом 200,
+// а при другом статусе — *APIError. Как только ответ получен, начинается стриминг и повторов больше нет.
+func doWithRetry(ctx context.Context, providerName string, cfg RetryConfig, send func() (*http.Response, error)) (*http.Response, error) {
+	cfg = cfg.withDefaults()
+
+	var lastErr error
+	for attempt := 1; attempt <= cfg.MaxAttempts; attempt++ {
+		resp, err := send()
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+
+		decision := classifyError(err)
+		if !decision.retry || attempt == cfg.MaxAttempts {
+			break
+		}
+
+		wait := cfg.backoff(attempt)
+		if decision.reason == "token_expired" {
+			wait = 0
+		}
+		if decision.wait > 0 {
+			if decision.wait > cfg.MaxRetryAfter {
+				// Ждать слишком долго — пусть сработает резервный провайдер
+				break
+			}
+			if decision.wait > wait {
+				wait = decision.wait
+			}
+		}
+
+		logger.Warn("повтор запроса к провайдеру",
+			"provider", providerName,
+			"attempt", attempt,
+			"reason", decision.reason,
+			"wait_ms", wait.Milliseconds(),
+			"error", err,
+		)
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+
+	return nil, lastErr
+}
@@ -0,0 +1,243 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// scriptedServer отвечает i-й попытке i-м обработчиком (последний повторяется)
+func scriptedServer(t *testing.T, steps ...http.HandlerFunc) (*httptest.Server, *int32) {
+	t.Helper()
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&attempts, 1))
+		if n > len(steps) {
+			n = len(steps)
+		}
+		steps[n-1](w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &attempts
+}
+
+// sendTo повторяет send провайдеров: новый запрос на каждую попытку, не-200 — *APIError
+func sendTo(url string) func() (*http.Response, error) {
+	return func() (*http.Response, error) {
+		resp, err := http.Post(url, "application/json", nil)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка выполнения запроса: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, newAPIError("test", resp)
+		}
+		return resp, nil
+	}
+}
+
+func status(code int, headers ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i+1 < len(headers); i += 2 {
+			w.Header().Set(headers[i], headers[i+1])
+		}
+		w.WriteHeader(code)
+		fmt.Fprintf(w, "status %d", code)
+	}
+}
+
+// resetConnection обрывает соединение RST без ответа
+func resetConnection(w http.ResponseWriter, r *http.Request) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		panic(err)
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+var fastRetry = RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+func TestDoWithRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          RetryConfig
+		steps        []http.HandlerFunc
+		wantStatus   int // ожидаемый статус ошибки (0 — успешный ответ)
+		wantAttempts int32
+		minElapsed   time.Duration
+		maxElapsed   time.Duration
+	}{
+		{
+			name:         "успех с первой попытки",
+			cfg:          fastRetry,
+			steps:        []http.HandlerFunc{status(http.StatusOK)},
+			wantAttempts: 1,
+		},
+		{
+			name:         "5xx повторяется",
+			cfg:          fastRetry,
+			steps:        []http.HandlerFunc{status(http.StatusBadGateway), status(http.StatusServiceUnavailable), status(http.StatusOK)},
+			wantAttempts: 3,
+		},
+		{
+			name:         "5xx дольше MaxAttempts",
+			cfg:          fastRetry,
+			steps:        []http.HandlerFunc{status(http.StatusInternalServerError)},
+			wantStatus:   http.StatusInternalServerError,
+			wantAttempts: 3,
+		},
+		{
+			name:         "4xx не повторяется",
+			cfg:          fastRetry,
+			steps:        []http.HandlerFunc{status(http.StatusBadRequest), status(http.StatusOK)},
+			wantStatus:   http.StatusBadRequest,
+			wantAttempts: 1,
+		},
+		{
+			name:         "429 с Retry-After в секундах",
+			cfg:          fastRetry,
+			steps:        []http.HandlerFunc{status(http.StatusTooManyRequests, "Retry-After", "1"), status(http.StatusOK)},
+			wantAttempts: 2,
+			minElapsed:   time.Second,
+		},
+		{
+			name: "429 с Retry-After датой",
+			cfg:  fastRetry,
+			steps: []http.HandlerFunc{
+				func(w http.ResponseWriter, r *http.Request) {
+					// HTTP-дата с точностью до секунды: ждать придется от 1 до 2 секунд
+					at := time.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat)
+					status(http.StatusTooManyRequests, "Retry-After", at)(w, r)
+				},
+				status(http.StatusOK),
+			},
+			wantAttempts: 2,
+			minElapsed:   500 * time.Millisecond,
+		},
+		{
+			name:         "Retry-After больше MaxRetryAfter — ошибка без ожидания",
+			cfg:          RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxRetryAfter: time.Second},
+			steps:        []http.HandlerFunc{status(http.StatusTooManyRequests, "Retry-After", "120"), status(http.StatusOK)},
+			wantStatus:   http.StatusTooManyRequests,
+			wantAttempts: 1,
+			maxElapsed:   500 * time.Millisecond,
+		},
+		{
+			name:         "обрыв соединения повторяется",
+			cfg:          fastRetry,
+			steps:        []http.HandlerFunc{resetConnection, status(http.StatusOK)},
+			wantAttempts: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, attempts := scriptedServer(t, tt.steps...)
+
+			start := time.Now()
+			resp, err := doWithRetry(context.Background(), "test", tt.cfg, sendTo(srv.URL))
+			elapsed := time.Since(start)
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("ошибка: %v", err)
+				}
+			} else {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantStatus {
+					t.Fatalf("ожидалась APIError %d, получено %v", tt.wantStatus, err)
+				}
+			}
+			if got := atomic.LoadInt32(attempts); got != tt.wantAttempts {
+				t.Errorf("попыток = %d, ожидалось %d", got, tt.wantAttempts)
+			}
+			if elapsed < tt.minElapsed {
+				t.Errorf("повтор через %v, Retry-After требует не меньше %v", elapsed, tt.minElapsed)
+			}
+			if tt.maxElapsed > 0 && elapsed > tt.maxElapsed {
+				t.Errorf("ошибка вернулась через %v, ожидалось не дольше %v", elapsed, tt.maxElapsed)
+			}
+		})
+	}
+}
+
+func TestDoWithRetryContextCancelDuringWait(t *testing.T) {
+	srv, attempts := scriptedServer(t, status(http.StatusTooManyRequests, "Retry-After", "10"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := doWithRetry(ctx, "test", fastRetry, sendTo(srv.URL))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ожидалась отмена по контексту, получено %v", err)
+	}
+	if got := atomic.LoadInt32(attempts); got != 1 {
+		t.Errorf("попыток = %d, ожидалась 1", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"0", 0},
+		{"-3", 0},
+		{"Wed, 01 Jan 2025 12:00:30 GMT", 30 * time.Second},
+		{"Wed, 01 Jan 2025 11:59:00 GMT", 0}, // дата в прошлом
+		{"скоро", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, ожидалось %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		reason string // "" — не повторять
+	}{
+		{"ECONNRESET", fmt.Errorf("ошибка выполнения запроса: %w", &net.OpError{Op: "read", Err: syscall.ECONNRESET}), "connection_reset"},
+		{"EPIPE", fmt.Errorf("ошибка: %w", syscall.EPIPE), "broken_pipe"},
+		{"429", &APIError{StatusCode: http.StatusTooManyRequests}, "rate_limit"},
+		{"503", &APIError{StatusCode: http.StatusServiceUnavailable}, "server_error"},
+		{"401 с истекшим токеном", &APIError{StatusCode: http.StatusUnauthorized, TokenExpired: true}, "token_expired"},
+		{"401", &APIError{StatusCode: http.StatusUnauthorized}, ""},
+		{"отмена", context.Canceled, ""},
+		{"прочее", errors.New("ошибка маршалинга"), ""},
+	}
+	for _, tt := range tests {
+		d := classifyError(tt.err)
+		if d.retry != (tt.reason != "") || d.reason != tt.reason {
+			t.Errorf("%s: retry=%v reason=%q, ожидалось %q", tt.name, d.retry, d.reason, tt.reason)
+		}
+	}
+}
+
+func TestBackoffBounds(t *testing.T) {
+	cfg := RetryConfig{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}.withDefaults()
+	for attempt, limit := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: 300 * time.Millisecond} {
+		for i := 0; i < 20; i++ {
+			if d := cfg.backoff(attempt); d < limit/2 || d > limit {
+				t.Errorf("backoff(%d) = %v вне [%v, %v]", attempt, d, limit/2, limit)
+			}
+		}
+	}
+}
@@ -2,54 +2,51 @@ package provider
 
 import (
 	"strings"
-	"unicode/utf8"
+
+	"github.com/nnk/97-aic/backend/tokenizer"
 )
 
 // CountTokens приблизительно подсчитывает количество токенов в тексте
-// Использует эмпирическое правило: ~4 символа на токен для русского/английского текста
-// Это приблизительная оценка, точное значение зависит от модели токенизатора
+// Использует эмпирическое правило: ~4 символа на токен для русского/английского текста.
+// Точный подсчет дает BPE токенизатор модели (см. Manager.Tokenizer), а фактические
+// значения возвращают провайдеры в ChatResult.Usage (см. ResolveUsage).
 func CountTokens(text string) int {
-	if text == "" {
-		return 0
-	}
-
-	// Подсчитываем количество символов (включая пробелы)
-	charCount := utf8.RuneCountInString(text)
-
-	// Приблизительно: 4 символа = 1 токен
-	// Для более точного подсчета можно использовать библиотеку tiktoken,
-	// но для большинства случаев это достаточно
-	tokens := charCount / 4
+	return tokenizer.Approximate{}.Count(text)
+}
 
-	// Минимум 1 токен для непустого текста
-	if tokens < 1 && charCount > 0 {
-		tokens = 1
+// countWith считает токены выбранным токенизатором (nil — приблизительная оценка)
+func countWith(tok tokenizer.Tokenizer, text string) int {
+	if tok == nil {
+		return CountTokens(text)
 	}
-
-	return tokens
+	return tok.Count(text)
 }
 
 // CountTokensForMessages подсчитывает общее количество токенов для списка сообщений
-// Включает системный промпт, историю и текущее сообщение
-func CountTokensForMessages(systemPrompt string, history []Message, currentMessage string) int {
+// Включает системный промпт, историю и текущее сообщение.
+// tok — токенизатор модели, nil означает приблизительную оценку.
+func CountTokensForMessages(tok tokenizer.Tokenizer, systemPrompt string, history []Message, currentMessage string) int {
 	total := 0
 
 	// Системный промпт
 	if systemPrompt != "" {
-		total += CountTokens(systemPrompt)
+		total += countWith(tok, systemPrompt)
 	}
 
 	// История сообщений
 	for _, msg := range history {
 		// Роль тоже считается (обычно ~1-2 токена)
-		total += CountTokens(msg.Role)
-		total += CountTokens(msg.Content)
+		total += countWith(tok, msg.Role)
+		total += countWith(tok, msg.Content)
+		for _, call := range msg.ToolCalls {
+			total += countWith(tok, call.Name) + countWith(tok, call.Arguments)
+		}
 		// Добавляем небольшой overhead для форматирования (~2 токена на сообщение)
 		total += 2
 	}
 
 	// Текущее сообщение
-	total += CountTokens(currentMessage)
+	total += countWith(tok, currentMessage)
 
 	// Overhead для структуры запроса (~10 токенов)
 	total += 10
@@ -58,35 +55,68 @@ func CountTokensForMessages(systemPrompt string, history []Message, currentMessa
 }
 
 // GenerateTextForTokens генерирует текст заданной длины в токенах
-// Используется для создания тестовых запросов разной длины
-func GenerateTextForTokens(targetTokens int, baseText string) string {
+// Используется для создания тестовых запросов разной длины.
+// tok — токенизатор модели, nil означает приблизительную оценку.
+func GenerateTextForTokens(tok tokenizer.Tokenizer, targetTokens int, baseText string) string {
 	// Базовый текст
 	if baseText == "" {
 		baseText = "Это тестовое сообщение для проверки обработки токенов. "
 	}
+	if targetTokens <= 0 {
+		return ""
+	}
 
 	// Вычисляем, сколько раз нужно повторить базовый текст
-	baseTokens := CountTokens(baseText)
+	baseTokens := countWith(tok, baseText)
 	if baseTokens == 0 {
 		return strings.Repeat("Слово ", targetTokens*4) // Fallback: простое повторение
 	}
 
 	// Количество повторений с учетом targetTokens
 	repeatCount := (targetTokens / baseTokens) + 1
-
-	// Генерируем текст и обрезаем до нужного количества токенов
 	generated := strings.Repeat(baseText, repeatCount)
+	if countWith(tok, generated) <= targetTokens {
+		return generated
+	}
 
-	// Обрезаем до нужного количества токенов
-	currentTokens := CountTokens(generated)
-	if currentTokens > targetTokens {
-		// Упрощенное обрезание: удаляем по 4 символа за раз
-		charsToRemove := (currentTokens - targetTokens) * 4
-		if charsToRemove < utf8.RuneCountInString(generated) {
-			runes := []rune(generated)
-			generated = string(runes[:len(runes)-charsToRemove])
+	// Обрезаем до нужного количества токенов: бинарный поиск самого длинного
+	// префикса (по символам), который укладывается в targetTokens
+	runes := []rune(generated)
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if countWith(tok, string(runes[:mid])) <= targetTokens {
+			lo = mid
+		} else {
+			hi = mid - 1
 		}
 	}
 
-	return generated
+	return string(runes[:lo])
+}
+
+// ResolveUsage возвращает usage из ответа провайдера, а если провайдер его не вернул —
+// подсчет токенизатором tok (UsageSourceTokenizer) или приблизительную оценку (UsageSourceEstimate)
+func ResolveUsage(result *ChatResult, tok tokenizer.Tokenizer, estimatedInput int, response string) Usage {
+	if result != nil && result.Usage != nil && (result.Usage.InputTokens > 0 || result.Usage.OutputTokens > 0) {
+		usage := *result.Usage
+		if usage.TotalTokens == 0 {
+			usage.TotalTokens = usage.InputTokens + usage.OutputTokens
+		}
+		usage.Source = UsageSourceProvider
+		return usage
+	}
+
+	source := UsageSourceTokenizer
+	if tokenizer.IsApproximate(tok) {
+		source = UsageSourceEstimate
+	}
+
+	output := countWith(tok, response)
+	return Usage{
+		InputTokens:  estimatedInput,
+		OutputTokens: output,
+		TotalTokens:  estimatedInput + output,
+		Source:       source,
+	}
 }
@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Роли сообщений, связанные с вызовом инструментов
+const (
+	RoleAssistant = "assistant"
+	RoleTool      = "tool" // результат выполнения инструмента (Message.ToolCallID, Message.Name)
+)
+
+// FinishReasonToolCalls модель остановилась, чтобы вызвать инструменты (ChatResult.ToolCalls)
+const FinishReasonToolCalls = "tool_calls"
+
+// Tool описание инструмента (функции), который может вызвать модель
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"` // JSON Schema аргументов
+}
+
+// ToolCall вызов инструмента моделью
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // аргументы в виде JSON-строки
+}
+
+// ToolCallDelta фрагмент вызова инструмента в стриме.
+// Провайдеры с потоковыми аргументами (OpenAI-совместимые) присылают несколько
+// дельт с одним Index, остальные — одну дельту с полными аргументами.
+type ToolCallDelta struct {
+	Index          int    `json:"index"`
+	ID             string `json:"id,omitempty"`
+	Name           string `json:"name,omitempty"`
+	ArgumentsDelta string `json:"arguments_delta,omitempty"`
+}
+
+// toolCallAccumulator собирает вызовы инструментов из дельт стрима
+type toolCallAccumulator struct {
+	calls []ToolCall
+}
+
+// add добавляет дельту и передает ее в onDelta (если задан)
+func (a *toolCallAccumulator) add(d ToolCallDelta, onDelta func(ToolCallDelta) error) error {
+	for len(a.calls) <= d.Index {
+		a.calls = append(a.calls, ToolCall{})
+	}
+	call := &a.calls[d.Index]
+	if d.ID != "" {
+		call.ID = d.ID
+	}
+	if d.Name != "" {
+		call.Name = d.Name
+	}
+	call.Arguments += d.ArgumentsDelta
+
+	if onDelta != nil {
+		return onDelta(d)
+	}
+	return nil
+}
+
+// result возвращает собранные вызовы; вызовам без ID (Ollama, GigaChat) присваивается call_<n>
+func (a *toolCallAccumulator) result() []ToolCall {
+	if len(a.calls) == 0 {
+		return nil
+	}
+	calls := make([]ToolCall, 0, len(a.calls))
+	for i, call := range a.calls {
+		if call.Name == "" {
+			continue
+		}
+		if call.ID == "" {
+			call.ID = fmt.Sprintf("call_%d", i)
+		}
+		if call.Arguments == "" {
+			call.Arguments = "{}"
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+// toolParameters возвращает схему аргументов инструмента (пустой объект, если схема не задана)
+func toolParameters(t Tool) json.RawMessage {
+	if len(t.Parameters) == 0 {
+		return json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+	return t.Parameters
+}
+
+// argumentsObject превращает JSON-строку аргументов в объект для API,
+// которые принимают аргументы объектом (Ollama, GigaChat)
+func argumentsObject(arguments string) json.RawMessage {
+	if arguments == "" || !json.Valid([]byte(arguments)) {
+		return json.RawMessage(`{}`)
+	}
+	return json.RawMessage(arguments)
+}
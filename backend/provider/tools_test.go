@@ -0,0 +1,247 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// replayServer отдает записанный стрим построчно (с Flush после каждой строки, как настоящий API)
+// и сохраняет тело последнего запроса
+func replayServer(t *testing.T, fixture, contentType string) (*httptest.Server, *[]byte) {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", contentType)
+		flusher := w.(http.Flusher)
+		scanner := bufio.NewScanner(strings.NewReader(string(data)))
+		for scanner.Scan() {
+			io.WriteString(w, scanner.Text()+"\n")
+			flusher.Flush()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &body
+}
+
+var testTools = []Tool{
+	{Name: "calculator", Description: "Вычисляет выражение", Parameters: json.RawMessage(`{"type":"object","properties":{"expression":{"type":"string"}},"required":["expression"]}`)},
+	{Name: "current_time", Description: "Текущее время"},
+}
+
+// streamCapture собирает то, что провайдер отдал через колбэки
+type streamCapture struct {
+	content string
+	deltas  []ToolCallDelta
+}
+
+func (c *streamCapture) opts() *ChatOptions {
+	return &ChatOptions{
+		Tools:       testTools,
+		Temperature: -1,
+		OnToolCallDelta: func(d ToolCallDelta) error {
+			c.deltas = append(c.deltas, d)
+			return nil
+		},
+	}
+}
+
+func (c *streamCapture) onChunk(chunk string) error {
+	c.content += chunk
+	return nil
+}
+
+// argumentsFromDeltas склеивает аргументы вызова index из дельт, как это делает клиент SSE
+func argumentsFromDeltas(deltas []ToolCallDelta, index int) string {
+	var sb strings.Builder
+	for _, d := range deltas {
+		if d.Index == index {
+			sb.WriteString(d.ArgumentsDelta)
+		}
+	}
+	return sb.String()
+}
+
+func TestOpenAICompatibleToolCallStream(t *testing.T) {
+	srv, reqBody := replayServer(t, "openai_tool_calls.sse", "text/event-stream")
+	p := NewOpenAICompatibleProvider(OpenAICompatibleConfig{Name: "groq", APIURL: srv.URL, Model: "llama-3.3-70b-versatile"})
+
+	var c streamCapture
+	result, err := p.Chat(context.Background(), "Сколько будет 17% от 2350 и который час?", c.opts(), c.onChunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ToolCall{
+		{ID: "call_Jx7", Name: "calculator", Arguments: `{"expression": "2350 * 0.17"}`},
+		{ID: "call_Qm2", Name: "current_time", Arguments: `{"timezone": "Europe/Moscow"}`},
+	}
+	if !reflect.DeepEqual(result.ToolCalls, want) {
+		t.Errorf("ToolCalls = %+v, ожидалось %+v", result.ToolCalls, want)
+	}
+	if result.FinishReason != FinishReasonToolCalls {
+		t.Errorf("FinishReason = %q", result.FinishReason)
+	}
+	if c.content != "Считаю." {
+		t.Errorf("content = %q", c.content)
+	}
+	if result.Usage == nil || result.Usage.InputTokens != 312 || result.Usage.OutputTokens != 48 || result.Usage.Source != UsageSourceProvider {
+		t.Errorf("Usage = %+v", result.Usage)
+	}
+
+	// Аргументы приходят дельтами, по которым клиент собирает тот же результат
+	if len(c.deltas) != 6 {
+		t.Errorf("дельт = %d, ожидалось 6", len(c.deltas))
+	}
+	for i, call := range want {
+		if got := argumentsFromDeltas(c.deltas, i); got != call.Arguments {
+			t.Errorf("аргументы вызова %d из дельт = %q", i, got)
+		}
+	}
+
+	// В запросе инструменты в формате tools; без схемы — пустой объект
+	var req struct {
+		Tools []struct {
+			Type     string `json:"type"`
+			Function struct {
+				Name       string          `json:"name"`
+				Parameters json.RawMessage `json:"parameters"`
+			} `json:"function"`
+		} `json:"tools"`
+		ToolChoice interface{} `json:"tool_choice"`
+	}
+	if err := json.Unmarshal(*reqBody, &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Tools) != 2 || req.Tools[0].Type != "function" || req.Tools[0].Function.Name != "calculator" {
+		t.Fatalf("tools в запросе: %s", *reqBody)
+	}
+	if got := string(req.Tools[1].Function.Parameters); got != `{"type":"object","properties":{}}` {
+		t.Errorf("parameters без схемы = %s", got)
+	}
+}
+
+func TestOpenAICompatibleStreamOptions(t *testing.T) {
+	for name, disable := range map[string]bool{"по умолчанию": false, "отключено": true} {
+		t.Run(name, func(t *testing.T) {
+			srv, reqBody := replayServer(t, "openai_tool_calls.sse", "text/event-stream")
+			p := NewOpenAICompatibleProvider(OpenAICompatibleConfig{Name: "lmstudio", APIURL: srv.URL, Model: "qwen", DisableStreamUsage: disable})
+
+			var c streamCapture
+			if _, err := p.Chat(context.Background(), "привет", c.opts(), c.onChunk); err != nil {
+				t.Fatal(err)
+			}
+
+			var req map[string]json.RawMessage
+			if err := json.Unmarshal(*reqBody, &req); err != nil {
+				t.Fatal(err)
+			}
+			_, sent := req["stream_options"]
+			if sent == disable {
+				t.Errorf("stream_options в запросе: %s", *reqBody)
+			}
+		})
+	}
+}
+
+func TestOllamaToolCallStream(t *testing.T) {
+	srv, reqBody := replayServer(t, "ollama_tool_calls.ndjson", "application/x-ndjson")
+	p := NewOllamaProvider(OllamaConfig{APIURL: srv.URL})
+
+	var c streamCapture
+	result, err := p.Chat(context.Background(), "Сколько будет 17% от 2350 и который час?", c.opts(), c.onChunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ollama не присылает id вызовов и завершает ответ причиной stop
+	want := []ToolCall{
+		{ID: "call_0", Name: "calculator", Arguments: `{"expression":"2350 * 0.17"}`},
+		{ID: "call_1", Name: "current_time", Arguments: `{}`},
+	}
+	if !reflect.DeepEqual(result.ToolCalls, want) {
+		t.Errorf("ToolCalls = %+v, ожидалось %+v", result.ToolCalls, want)
+	}
+	if result.FinishReason != FinishReasonToolCalls {
+		t.Errorf("FinishReason = %q", result.FinishReason)
+	}
+	if result.Usage == nil || result.Usage.InputTokens != 205 || result.Usage.OutputTokens != 37 {
+		t.Errorf("Usage = %+v", result.Usage)
+	}
+	if len(c.deltas) != 2 || c.deltas[1].Index != 1 {
+		t.Errorf("дельты = %+v", c.deltas)
+	}
+
+	if !strings.Contains(string(*reqBody), `"tools":[{"type":"function","function":{"name":"calculator"`) {
+		t.Errorf("tools в запросе: %s", *reqBody)
+	}
+}
+
+func TestGigaChatFunctionCallStream(t *testing.T) {
+	srv, reqBody := replayServer(t, "gigachat_function_call.sse", "text/event-stream")
+	p := NewGigaChatProvider(GigaChatConfig{APIURL: srv.URL, AccessToken: "test-token"})
+
+	var c streamCapture
+	result, err := p.Chat(context.Background(), "Сколько будет 17% от 2350?", c.opts(), c.onChunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// GigaChat присылает функцию целиком с причиной function_call; она приводится к tool_calls
+	want := []ToolCall{
+		{ID: "call_0", Name: "calculator", Arguments: `{"expression":"2350 * 0.17"}`},
+	}
+	if !reflect.DeepEqual(result.ToolCalls, want) {
+		t.Errorf("ToolCalls = %+v, ожидалось %+v", result.ToolCalls, want)
+	}
+	if result.FinishReason != FinishReasonToolCalls {
+		t.Errorf("FinishReason = %q", result.FinishReason)
+	}
+	if result.Usage == nil || result.Usage.TotalTokens != 209 {
+		t.Errorf("Usage = %+v", result.Usage)
+	}
+
+	var req struct {
+		Functions    []gigachatFunction `json:"functions"`
+		FunctionCall interface{}        `json:"function_call"`
+	}
+	if err := json.Unmarshal(*reqBody, &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Functions) != 2 || req.Functions[0].Name != "calculator" || req.FunctionCall != "auto" {
+		t.Errorf("functions в запросе: %s", *reqBody)
+	}
+}
+
+func TestToolCallAccumulator(t *testing.T) {
+	var acc toolCallAccumulator
+	for _, d := range []ToolCallDelta{
+		{Index: 1, ID: "b", Name: "second"},
+		{Index: 0, ID: "a", Name: "first", ArgumentsDelta: `{"x":`},
+		{Index: 0, ArgumentsDelta: `1}`},
+		{Index: 2, ArgumentsDelta: `{}`}, // без имени — отбрасывается
+	} {
+		if err := acc.add(d, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []ToolCall{
+		{ID: "a", Name: "first", Arguments: `{"x":1}`},
+		{ID: "b", Name: "second", Arguments: `{}`},
+	}
+	if got := acc.result(); !reflect.DeepEqual(got, want) {
+		t.Errorf("result = %+v, ожидалось %+v", got, want)
+	}
+}
@@ -0,0 +1,183 @@
+package retention
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nnk/97-aic/backend/logger"
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+// defaultInterval период очистки по умолчанию
+const defaultInterval = time.Hour
+
+// Policy политика хранения; нулевые значения — без ограничения
+type Policy struct {
+	LogsMaxAge      time.Duration // возраст логов запросов
+	LogsMaxRows     int           // число последних логов запросов
+	SessionsMaxIdle time.Duration // время без обновления сессии
+	Interval        time.Duration // период очистки
+}
+
+// Enabled задано ли хотя бы одно ограничение
+func (p Policy) Enabled() bool {
+	return p.LogsMaxAge > 0 || p.LogsMaxRows > 0 || p.SessionsMaxIdle > 0
+}
+
+// Report результат одного прохода очистки
+type Report struct {
+	StartedAt          time.Time `json:"started_at"`
+	DurationMs         int64     `json:"duration_ms"`
+	LogsDeletedByAge   int64     `json:"logs_deleted_by_age"`
+	LogsDeletedByCount int64     `json:"logs_deleted_by_count"`
+	SessionsDeleted    int64     `json:"sessions_deleted"`
+	MessagesDeleted    int64     `json:"messages_deleted"`
+	Compacted          bool      `json:"compacted"` // выполнен VACUUM / incremental_vacuum
+	Error              string    `json:"error,omitempty"`
+}
+
+// deleted сколько строк удалено за проход
+func (r Report) deleted() int64 {
+	return r.LogsDeletedByAge + r.LogsDeletedByCount + r.SessionsDeleted + r.MessagesDeleted
+}
+
+// Janitor периодически удаляет данные сверх политики хранения и сжимает БД
+type Janitor struct {
+	Storage storage.Store
+	Policy  Policy
+
+	// ActiveSessions сессии с идущей генерацией: их ответы еще не сохранены,
+	// поэтому такие сессии не удаляются, даже если давно не обновлялись (nil — нет таких)
+	ActiveSessions func() []string
+
+	runMu sync.Mutex // один проход за раз (фоновый или ручной)
+
+	mu   sync.RWMutex
+	last *Report
+}
+
+// New создает уборщика
+func New(store storage.Store, policy Policy) *Janitor {
+	if policy.Interval <= 0 {
+		policy.Interval = defaultInterval
+	}
+	return &Janitor{
+		Storage: store,
+		Policy:  policy,
+	}
+}
+
+// Start запускает очистку сразу и затем каждые Policy.Interval до отмены ctx.
+// Без ограничений в политике ничего не делает.
+func (j *Janitor) Start(ctx context.Context) {
+	if !j.Policy.Enabled() {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(j.Policy.Interval)
+		defer ticker.Stop()
+		for {
+			j.RunOnce(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunOnce выполняет один проход очистки по политике и запоминает отчет
+func (j *Janitor) RunOnce(ctx context.Context) Report {
+	j.runMu.Lock()
+	defer j.runMu.Unlock()
+
+	report := j.run(ctx)
+
+	j.mu.Lock()
+	j.last = &report
+	j.mu.Unlock()
+
+	if report.Error != "" {
+		logger.Error("ошибка очистки данных",
+			"error", report.Error,
+			"logs_deleted_by_age", report.LogsDeletedByAge,
+			"logs_deleted_by_count", report.LogsDeletedByCount,
+			"sessions_deleted", report.SessionsDeleted,
+			"messages_deleted", report.MessagesDeleted,
+		)
+	} else {
+		logger.Info("очистка данных завершена",
+			"logs_deleted_by_age", report.LogsDeletedByAge,
+			"logs_deleted_by_count", report.LogsDeletedByCount,
+			"sessions_deleted", report.SessionsDeleted,
+			"messages_deleted", report.MessagesDeleted,
+			"compacted", report.Compacted,
+			"duration_ms", report.DurationMs,
+		)
+	}
+	return report
+}
+
+// run удаляет старые сессии (кроме сессий с идущей генерацией), затем логи по возрасту
+// и по количеству.
+// Останавливается на первой ошибке; сжатие — только если что-то удалено.
+func (j *Janitor) run(ctx context.Context) (report Report) {
+	report.StartedAt = time.Now().UTC()
+	defer func() {
+		report.DurationMs = time.Since(report.StartedAt).Milliseconds()
+	}()
+	fail := func(err error) Report {
+		report.Error = err.Error()
+		return report
+	}
+
+	now := time.Now()
+	var err error
+	if j.Policy.SessionsMaxIdle > 0 {
+		var keep []string
+		if j.ActiveSessions != nil {
+			keep = j.ActiveSessions()
+		}
+		if report.SessionsDeleted, report.MessagesDeleted, err = j.Storage.DeleteInactiveSessions(now.Add(-j.Policy.SessionsMaxIdle), keep); err != nil {
+			return fail(err)
+		}
+	}
+	if ctx.Err() != nil {
+		return fail(ctx.Err())
+	}
+	if j.Policy.LogsMaxAge > 0 {
+		if report.LogsDeletedByAge, err = j.Storage.DeleteRequestLogsBefore(now.Add(-j.Policy.LogsMaxAge)); err != nil {
+			return fail(err)
+		}
+	}
+	if ctx.Err() != nil {
+		return fail(ctx.Err())
+	}
+	if j.Policy.LogsMaxRows > 0 {
+		if report.LogsDeletedByCount, err = j.Storage.TrimRequestLogs(j.Policy.LogsMaxRows); err != nil {
+			return fail(err)
+		}
+	}
+
+	if report.deleted() > 0 && ctx.Err() == nil {
+		if err := j.Storage.Compact(); err != nil {
+			return fail(err)
+		}
+		report.Compacted = true
+	}
+	return report
+}
+
+// LastReport отчет последнего прохода или nil, если очистка еще не запускалась
+func (j *Janitor) LastReport() *Report {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	if j.last == nil {
+		return nil
+	}
+	report := *j.last
+	return &report
+}
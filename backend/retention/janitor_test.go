@@ -0,0 +1,198 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nnk/97-aic/backend/storage"
+)
+
+const sqlTimeLayout = "2006-01-02 15:04:05"
+
+// testStore SQLite-хранилище и прямое соединение с той же БД, чтобы состарить строки
+func testStore(t *testing.T) (*storage.Storage, *sql.DB) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	store, err := storage.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return store, db
+}
+
+func exec(t *testing.T, db *sql.DB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ago(d time.Duration) string {
+	return time.Now().Add(-d).UTC().Format(sqlTimeLayout)
+}
+
+// seed сессии old и busy не обновлялись 40 дней, fresh — активна; логов 5, из них два старых
+func seed(t *testing.T, store *storage.Storage, db *sql.DB) {
+	t.Helper()
+	for _, m := range []struct{ session, content string }{
+		{"old", "вопрос"}, {"old", "ответ"}, {"busy", "вопрос"}, {"fresh", "вопрос"},
+	} {
+		if _, err := store.SaveMessage(m.session, storage.RoleUser, m.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exec(t, db, "UPDATE sessions SET updated_at = ? WHERE id IN ('old', 'busy')", ago(40*24*time.Hour))
+
+	for i := 0; i < 5; i++ {
+		if _, err := store.SaveRequestLog(storage.NewRequestLog{SessionID: "fresh", Provider: "test", StatusCode: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exec(t, db, "UPDATE request_logs SET created_at = ? WHERE id <= 2", ago(10*24*time.Hour))
+}
+
+func TestJanitorPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		want   Report
+		logs   int      // логов после прохода
+		gone   []string // удаленные сессии
+	}{
+		{
+			name:   "без ограничений",
+			policy: Policy{},
+			want:   Report{},
+			logs:   5,
+		},
+		{
+			name:   "логи по возрасту",
+			policy: Policy{LogsMaxAge: 7 * 24 * time.Hour},
+			want:   Report{LogsDeletedByAge: 2, Compacted: true},
+			logs:   3,
+		},
+		{
+			name:   "логи по количеству",
+			policy: Policy{LogsMaxRows: 4},
+			want:   Report{LogsDeletedByCount: 1, Compacted: true},
+			logs:   4,
+		},
+		{
+			name:   "неактивные сессии",
+			policy: Policy{SessionsMaxIdle: 30 * 24 * time.Hour},
+			want:   Report{SessionsDeleted: 1, MessagesDeleted: 2, Compacted: true},
+			logs:   5,
+			gone:   []string{"old"},
+		},
+		{
+			name:   "все ограничения",
+			policy: Policy{LogsMaxAge: 7 * 24 * time.Hour, LogsMaxRows: 2, SessionsMaxIdle: 30 * 24 * time.Hour},
+			want:   Report{LogsDeletedByAge: 2, LogsDeletedByCount: 1, SessionsDeleted: 1, MessagesDeleted: 2, Compacted: true},
+			logs:   2,
+			gone:   []string{"old"},
+		},
+		{
+			name:   "нечего удалять",
+			policy: Policy{LogsMaxAge: 30 * 24 * time.Hour, LogsMaxRows: 10, SessionsMaxIdle: 60 * 24 * time.Hour},
+			want:   Report{},
+			logs:   5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, db := testStore(t)
+			seed(t, store, db)
+			j := New(store, tt.policy)
+			j.ActiveSessions = func() []string { return []string{"busy"} }
+
+			report := j.RunOnce(context.Background())
+			if report.StartedAt.IsZero() || report.DurationMs < 0 {
+				t.Errorf("время прохода: %+v", report)
+			}
+			report.StartedAt, report.DurationMs = time.Time{}, 0
+			if report != tt.want {
+				t.Errorf("отчет = %+v, ожидался %+v", report, tt.want)
+			}
+			if last := j.LastReport(); last == nil || last.SessionsDeleted != tt.want.SessionsDeleted || last.Compacted != tt.want.Compacted {
+				t.Errorf("LastReport = %+v", last)
+			}
+
+			logs, err := store.GetRequestLogs("fresh", 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(logs) != tt.logs {
+				t.Errorf("осталось логов %d, ожидалось %d", len(logs), tt.logs)
+			}
+			// Остаются самые новые логи
+			for _, l := range logs {
+				if l.ID <= int64(5-tt.logs) {
+					t.Errorf("остался старый лог %d", l.ID)
+				}
+			}
+
+			// Сессия с идущей генерацией не удаляется, даже если давно не обновлялась
+			gone := map[string]bool{}
+			for _, id := range tt.gone {
+				gone[id] = true
+			}
+			for _, id := range []string{"old", "busy", "fresh"} {
+				sess, err := store.GetSession(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (sess == nil) != gone[id] {
+					t.Errorf("сессия %s: %+v, удаление ожидалось: %v", id, sess, gone[id])
+				}
+			}
+		})
+	}
+}
+
+func TestJanitorDeletesFinishedGenerationSession(t *testing.T) {
+	store, db := testStore(t)
+	seed(t, store, db)
+	j := New(store, Policy{SessionsMaxIdle: 30 * 24 * time.Hour})
+
+	// Без идущих генераций удаляются обе неактивные сессии
+	report := j.RunOnce(context.Background())
+	if report.SessionsDeleted != 2 || report.MessagesDeleted != 3 || report.Error != "" {
+		t.Errorf("отчет = %+v", report)
+	}
+}
+
+func TestJanitorStopsOnCancel(t *testing.T) {
+	store, db := testStore(t)
+	seed(t, store, db)
+	j := New(store, Policy{LogsMaxAge: 7 * 24 * time.Hour, SessionsMaxIdle: 30 * 24 * time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report := j.RunOnce(ctx)
+	if report.Error == "" || report.SessionsDeleted != 2 || report.LogsDeletedByAge != 0 || report.Compacted {
+		t.Errorf("отчет = %+v", report)
+	}
+}
+
+func TestJanitorStartWithoutPolicy(t *testing.T) {
+	store, _ := testStore(t)
+	j := New(store, Policy{})
+	if j.Policy.Interval != defaultInterval {
+		t.Errorf("Interval = %s", j.Policy.Interval)
+	}
+
+	j.Start(context.Background())
+	time.Sleep(20 * time.Millisecond)
+	if j.LastReport() != nil {
+		t.Error("очистка запущена без ограничений в политике")
+	}
+}
@@ -3,5 +3,5 @@
 # Скрипт для запуска backend в режиме разработки с отключенной проверкой TLS
 
 export GIGACHAT_SKIP_TLS_VERIFY=true
-go run .
+go run -tags sqlite_fts5 .
 
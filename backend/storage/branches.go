@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Сессия — дерево сообщений: у каждого user/assistant сообщения есть parent_id,
+// sessions.active_leaf_id указывает на конец активной ветки. История для модели
+// и /api/history — путь от корня до active_leaf_id.
+
+// activeBranchCTE id сообщений активной ветки сессии (параметр — session_id)
+const activeBranchCTE = `WITH RECURSIVE branch(id) AS (
+	SELECT active_leaf_id FROM sessions WHERE id = ? AND active_leaf_id IS NOT NULL
+	UNION ALL
+	SELECT m.parent_id FROM messages m JOIN branch b ON m.id = b.id WHERE m.parent_id IS NOT NULL
+)
+`
+
+// leafBranchCTE id сообщений ветки, заканчивающейся заданным сообщением (параметр — id)
+const leafBranchCTE = `WITH RECURSIVE branch(id) AS (
+	SELECT CAST(? AS BIGINT)
+	UNION ALL
+	SELECT m.parent_id FROM messages m JOIN branch b ON m.id = b.id WHERE m.parent_id IS NOT NULL
+)
+`
+
+// moveLeafQuery запрос, делающий сохраненное сообщение концом активной ветки.
+// Ответ модели сохраняется после генерации: если пользователь за это время
+// переключил ветку или отправил новый вопрос, конец ветки уже не указывает
+// на родителя ответа и не переносится.
+func moveLeafQuery(role, sessionID string, id, parentID int64) (string, []interface{}) {
+	if role == RoleAssistant {
+		return "UPDATE sessions SET active_leaf_id = ? WHERE id = ? AND COALESCE(active_leaf_id, 0) = ?",
+			[]interface{}{id, sessionID, parentID}
+	}
+	return "UPDATE sessions SET active_leaf_id = ? WHERE id = ?", []interface{}{id, sessionID}
+}
+
+// GetMessage возвращает сообщение сессии или nil
+func (s *Storage) GetMessage(sessionID string, id int64) (*Message, error) {
+	row := s.db.QueryRow("SELECT "+messageColumns+" FROM messages WHERE session_id = ? AND id = ?", sessionID, id)
+	msg, err := scanMessage(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения сообщения: %w", err)
+	}
+	return &msg, nil
+}
+
+// GetBranch возвращает сообщения ветки от корня до leafID включительно (leafID = 0 — пустая ветка)
+func (s *Storage) GetBranch(sessionID string, leafID int64, limit int) ([]Message, error) {
+	if leafID == 0 {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := s.db.Query(
+		leafBranchCTE+"SELECT "+messageColumns+" FROM messages WHERE session_id = ? AND id IN (SELECT id FROM branch) ORDER BY id ASC LIMIT ?",
+		leafID, sessionID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения ветки: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования сообщения: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// GetMessageTree возвращает все сообщения сессии (все ветки) и конец активной ветки
+func (s *Storage) GetMessageTree(sessionID string) ([]Message, int64, error) {
+	rows, err := s.db.Query(
+		"SELECT "+messageColumns+" FROM messages WHERE session_id = ? AND role != ? ORDER BY id ASC",
+		sessionID, RoleSummary,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка получения дерева сообщений: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("ошибка сканирования сообщения: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ошибка чтения сообщений: %w", err)
+	}
+
+	leafID, err := s.ActiveLeafID(sessionID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return messages, leafID, nil
+}
+
+// ActiveLeafID возвращает конец активной ветки (0 — сессия пуста или не существует)
+func (s *Storage) ActiveLeafID(sessionID string) (int64, error) {
+	var leafID int64
+	err := s.db.QueryRow("SELECT COALESCE(active_leaf_id, 0) FROM sessions WHERE id = ?", sessionID).Scan(&leafID)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("ошибка получения активной ветки: %w", err)
+	}
+	return leafID, nil
+}
+
+// SetActiveBranch делает активной ветку, проходящую через сообщение messageID:
+// от него спускается по самым новым ответам до листа. Возвращает новый конец ветки
+// или 0, если сообщения нет в сессии.
+func (s *Storage) SetActiveBranch(sessionID string, messageID int64) (int64, error) {
+	msg, err := s.GetMessage(sessionID, messageID)
+	if err != nil {
+		return 0, err
+	}
+	if msg == nil || msg.Role == RoleSummary {
+		return 0, nil
+	}
+
+	leafID := messageID
+	for {
+		var childID sql.NullInt64
+		if err := s.db.QueryRow(
+			"SELECT MAX(id) FROM messages WHERE session_id = ? AND parent_id = ? AND role != ?",
+			sessionID, leafID, RoleSummary,
+		).Scan(&childID); err != nil {
+			return 0, fmt.Errorf("ошибка поиска продолжения ветки: %w", err)
+		}
+		if !childID.Valid {
+			break
+		}
+		leafID = childID.Int64
+	}
+
+	if _, err := s.db.Exec("UPDATE sessions SET active_leaf_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", leafID, sessionID); err != nil {
+		return 0, fmt.Errorf("ошибка переключения ветки: %w", err)
+	}
+	return leafID, nil
+}
+
+// SetActiveLeaf делает концом активной ветки сообщение leafID (без спуска к ответам)
+func (s *Storage) SetActiveLeaf(sessionID string, leafID int64) error {
+	if _, err := s.db.Exec("UPDATE sessions SET active_leaf_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", nullInt64(leafID), sessionID); err != nil {
+		return fmt.Errorf("ошибка переключения ветки: %w", err)
+	}
+	return nil
+}
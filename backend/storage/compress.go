@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Компрессия истории заменяет начало диалога одним summary на всю сессию, поэтому сжимать
+// можно только сообщения, через которые проходят все ветки: от корня до первой развилки
+// включительно. Сообщения ниже развилки нужны только своим веткам и не сжимаются.
+
+// treeLink связь сообщения дерева с родителем (ParentID = 0 — корень)
+type treeLink struct {
+	ID       int64
+	ParentID int64
+}
+
+// maxBranchLength ограничение длины ветки, которую просматривает компрессия
+const maxBranchLength = 100000
+
+// treeLinksQuery связи всех сообщений дерева сессии (параметры — session_id, RoleSummary)
+const treeLinksQuery = "SELECT id, COALESCE(parent_id, 0) FROM messages WHERE session_id = ? AND role != ?"
+
+// sharedPrefix начало ветки branch (от корня), общее для всех веток сессии.
+// Родитель, которого нет в сессии (удален старой компрессией), считается корнем.
+func sharedPrefix(branch []Message, links []treeLink) []Message {
+	exists := make(map[int64]bool, len(links))
+	for _, l := range links {
+		exists[l.ID] = true
+	}
+	roots := 0
+	children := make(map[int64]int)
+	for _, l := range links {
+		if l.ParentID == 0 || !exists[l.ParentID] {
+			roots++
+		} else {
+			children[l.ParentID]++
+		}
+	}
+	if roots > 1 {
+		return nil
+	}
+	for i, m := range branch {
+		if children[m.ID] > 1 {
+			return branch[:i+1]
+		}
+	}
+	return branch
+}
+
+// compressionBatch первые batchSize user/assistant сообщений активной ветки branch, которые можно
+// свернуть в summary: общие для всех веток и не входящие в keepLast последних сообщений ветки
+func compressionBatch(branch []Message, links []treeLink, batchSize, keepLast int) []Message {
+	if keepLast > len(branch) {
+		keepLast = len(branch)
+	}
+	head := branch[:len(branch)-keepLast]
+	if prefix := sharedPrefix(branch, links); len(prefix) < len(head) {
+		head = prefix
+	}
+
+	var batch []Message
+	for _, m := range head {
+		if m.Role != RoleUser && m.Role != RoleAssistant {
+			continue
+		}
+		batch = append(batch, m)
+		if len(batch) == batchSize {
+			break
+		}
+	}
+	return batch
+}
+
+// messageRemover удаляет сообщения внутри транзакции SQLite или PostgreSQL, не разрывая дерево
+type messageRemover struct {
+	exec  func(query string, args ...interface{}) error
+	links func() ([]treeLink, error)
+	leaf  func() (int64, error) // текущий конец активной ветки
+}
+
+// run переносит оставшиеся ответы удаляемых сообщений к ближайшему оставшемуся предку
+// (без него ответ становится корнем) и так же сдвигает конец активной ветки.
+// Сами сообщения удаляет вызывающий в той же транзакции.
+func (rm messageRemover) run(sessionID string, ids []int64) error {
+	links, err := rm.links()
+	if err != nil {
+		return fmt.Errorf("ошибка получения дерева сообщений: %w", err)
+	}
+	leafID, err := rm.leaf()
+	if err != nil {
+		return err
+	}
+
+	deleted := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	parents := make(map[int64]int64, len(links))
+	for _, l := range links {
+		parents[l.ID] = l.ParentID
+	}
+	survivor := func(id int64) int64 {
+		for id != 0 && deleted[id] {
+			id = parents[id]
+		}
+		return id
+	}
+
+	for _, l := range links {
+		if deleted[l.ID] || !deleted[l.ParentID] {
+			continue
+		}
+		if err := rm.exec("UPDATE messages SET parent_id = ? WHERE session_id = ? AND id = ?", nullInt64(survivor(l.ParentID)), sessionID, l.ID); err != nil {
+			return fmt.Errorf("ошибка переноса ветки: %w", err)
+		}
+	}
+	if deleted[leafID] {
+		if err := rm.exec("UPDATE sessions SET active_leaf_id = ? WHERE id = ?", nullInt64(survivor(leafID)), sessionID); err != nil {
+			return fmt.Errorf("ошибка обновления активной ветки: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetOldestNonSummaryMessages возвращает самые ранние user/assistant сообщения активной ветки,
+// общие для всех веток сессии, исключая keepLast последних сообщений ветки.
+func (s *Storage) GetOldestNonSummaryMessages(sessionID string, batchSize int, keepLast int) ([]Message, error) {
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("batchSize должен быть > 0")
+	}
+	if keepLast < 0 {
+		keepLast = 0
+	}
+
+	leafID, err := s.ActiveLeafID(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	branch, err := s.GetBranch(sessionID, leafID, maxBranchLength)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения сообщений для компрессии: %w", err)
+	}
+	links, err := scanTreeLinks(s.db.Query(treeLinksQuery, sessionID, RoleSummary))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения сообщений для компрессии: %w", err)
+	}
+	return compressionBatch(branch, links, batchSize, keepLast), nil
+}
+
+// DeleteMessagesByIDs удаляет сообщения по списку id (в рамках сессии).
+// Ответы на удаленные сообщения переносятся к ближайшему оставшемуся предку.
+func (s *Storage) DeleteMessagesByIDs(sessionID string, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	rm := messageRemover{
+		exec: func(query string, args ...interface{}) error {
+			_, err := tx.Exec(query, args...)
+			return err
+		},
+		links: func() ([]treeLink, error) {
+			return scanTreeLinks(tx.Query(treeLinksQuery, sessionID, RoleSummary))
+		},
+		leaf: func() (int64, error) {
+			var leafID int64
+			err := tx.QueryRow("SELECT COALESCE(active_leaf_id, 0) FROM sessions WHERE id = ?", sessionID).Scan(&leafID)
+			if err != nil && err != sql.ErrNoRows {
+				return 0, fmt.Errorf("ошибка получения активной ветки: %w", err)
+			}
+			return leafID, nil
+		},
+	}
+	if err := rm.run(sessionID, ids); err != nil {
+		return err
+	}
+
+	placeholders := strings.TrimRight(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, sessionID)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	if _, err := tx.Exec("DELETE FROM messages WHERE session_id = ? AND id IN ("+placeholders+")", args...); err != nil {
+		return fmt.Errorf("ошибка удаления сообщений: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка удаления сообщений: %w", err)
+	}
+	return nil
+}
+
+// scanTreeLinks читает результат treeLinksQuery
+func scanTreeLinks(rows *sql.Rows, err error) ([]treeLink, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []treeLink
+	for rows.Next() {
+		var l treeLink
+		if err := rows.Scan(&l.ID, &l.ParentID); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
+
+// GetOldestNonSummaryMessages возвращает самые ранние user/assistant сообщения активной ветки,
+// общие для всех веток сессии, исключая keepLast последних сообщений ветки.
+func (p *PostgresStore) GetOldestNonSummaryMessages(sessionID string, batchSize int, keepLast int) ([]Message, error) {
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("batchSize должен быть > 0")
+	}
+	if keepLast < 0 {
+		keepLast = 0
+	}
+
+	leafID, err := p.ActiveLeafID(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	branch, err := p.GetBranch(sessionID, leafID, maxBranchLength)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения сообщений для компрессии: %w", err)
+	}
+	links, err := pgTreeLinks(context.Background(), p.pool, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения сообщений для компрессии: %w", err)
+	}
+	return compressionBatch(branch, links, batchSize, keepLast), nil
+}
+
+// DeleteMessagesByIDs удаляет сообщения по списку id (в рамках сессии).
+// Ответы на удаленные сообщения переносятся к ближайшему оставшемуся предку.
+func (p *PostgresStore) DeleteMessagesByIDs(sessionID string, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rm := messageRemover{
+		exec: func(query string, args ...interface{}) error {
+			_, err := tx.Exec(ctx, rebind(query), args...)
+			return err
+		},
+		links: func() ([]treeLink, error) {
+			return pgTreeLinks(ctx, tx, sessionID)
+		},
+		leaf: func() (int64, error) {
+			var leafID int64
+			err := tx.QueryRow(ctx, rebind("SELECT COALESCE(active_leaf_id, 0) FROM sessions WHERE id = ?"), sessionID).Scan(&leafID)
+			if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				return 0, fmt.Errorf("ошибка получения активной ветки: %w", err)
+			}
+			return leafID, nil
+		},
+	}
+	if err := rm.run(sessionID, ids); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, rebind("DELETE FROM messages WHERE session_id = ? AND id = ANY(?)"), sessionID, ids); err != nil {
+		return fmt.Errorf("ошибка удаления сообщений: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("ошибка удаления сообщений: %w", err)
+	}
+	return nil
+}
+
+// pgQuerier пул или транзакция PostgreSQL
+type pgQuerier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// pgTreeLinks выполняет treeLinksQuery в PostgreSQL
+func pgTreeLinks(ctx context.Context, q pgQuerier, sessionID string) ([]treeLink, error) {
+	rows, err := q.Query(ctx, rebind(treeLinksQuery), sessionID, RoleSummary)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []treeLink
+	for rows.Next() {
+		var l treeLink
+		if err := rows.Scan(&l.ID, &l.ParentID); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
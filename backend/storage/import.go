@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// sessionImporter создает сессию с сообщениями внутри транзакции SQLite или PostgreSQL
+type sessionImporter struct {
+	exec    func(query string, args ...interface{}) error
+	queryID func(query string, args ...interface{}) (int64, error) // запрос с RETURNING id
+	timeArg func(time.Time) interface{}                            // время в формате драйвера
+}
+
+// run вставляет сессию и сообщения (по возрастанию id). Id сообщений назначаются заново,
+// parent_id и active_leaf_id пересчитываются; ссылки на логи запросов не переносятся.
+func (im sessionImporter) run(sess Session, messages []Message) error {
+	now := time.Now()
+	orDefault := func(t time.Time) interface{} {
+		if t.IsZero() {
+			t = now
+		}
+		return im.timeArg(t)
+	}
+
+	if err := im.exec(
+		"INSERT INTO sessions (id, user_id, title, provider, model, system_prompt, reasoning_mode, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		sess.ID, nullString(sess.UserID), sess.Title, nullString(sess.Provider), nullString(sess.Model), nullString(sess.SystemPrompt), nullString(sess.ReasoningMode),
+		orDefault(sess.CreatedAt), orDefault(sess.UpdatedAt),
+	); err != nil {
+		return fmt.Errorf("ошибка создания сессии: %w", err)
+	}
+
+	sorted := append([]Message(nil), messages...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	ids := make(map[int64]int64, len(sorted))
+	var leafID, lastID int64
+	for _, m := range sorted {
+		var parent interface{}
+		if m.Role != RoleSummary && m.ParentID != 0 {
+			id, ok := ids[m.ParentID]
+			if !ok {
+				return fmt.Errorf("ошибка импорта сообщения %d: родитель %d не найден среди предыдущих сообщений", m.ID, m.ParentID)
+			}
+			parent = id
+		}
+
+		provenance := m.Provenance
+		provenance.RequestLogID = nil
+		args := append([]interface{}{sess.ID, m.Role, m.Content, nullString(m.FinishReason)}, provenance.args()...)
+		args = append(args, parent, orDefault(m.CreatedAt))
+
+		id, err := im.queryID(
+			"INSERT INTO messages (session_id, role, content, finish_reason, "+provenanceColumns+", parent_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id",
+			args...,
+		)
+		if err != nil {
+			return fmt.Errorf("ошибка импорта сообщения %d: %w", m.ID, err)
+		}
+		if m.ID != 0 {
+			ids[m.ID] = id
+		}
+		if m.Role != RoleSummary {
+			lastID = id
+			if m.ID == sess.ActiveLeafID {
+				leafID = id
+			}
+		}
+	}
+
+	// Без известного конца активной ветки активной становится последняя добавленная
+	if leafID == 0 {
+		leafID = lastID
+	}
+	if err := im.exec("UPDATE sessions SET active_leaf_id = ? WHERE id = ?", nullInt64(leafID), sess.ID); err != nil {
+		return fmt.Errorf("ошибка обновления активной ветки: %w", err)
+	}
+	return nil
+}
+
+// ImportSession создает сессию sess.ID с сообщениями (включая summary и все ветки).
+// Сообщения ссылаются на родителей по своим исходным id; новые id назначает БД.
+func (s *Storage) ImportSession(sess Session, messages []Message) (*Session, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	im := sessionImporter{
+		exec: func(query string, args ...interface{}) error {
+			_, err := tx.Exec(query, args...)
+			return err
+		},
+		queryID: func(query string, args ...interface{}) (int64, error) {
+			var id int64
+			err := tx.QueryRow(query, args...).Scan(&id)
+			return id, err
+		},
+		timeArg: func(t time.Time) interface{} { return t.UTC().Format(sqlTimeLayout) },
+	}
+	if err := im.run(sess, messages); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("ошибка импорта сессии: %w", err)
+	}
+	return s.GetSession(sess.ID)
+}
+
+// ImportSession создает сессию sess.ID с сообщениями (включая summary и все ветки).
+// Сообщения ссылаются на родителей по своим исходным id; новые id назначает БД.
+func (p *PostgresStore) ImportSession(sess Session, messages []Message) (*Session, error) {
+	ctx := context.Background()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	im := sessionImporter{
+		exec: func(query string, args ...interface{}) error {
+			_, err := tx.Exec(ctx, rebind(query), args...)
+			return err
+		},
+		queryID: func(query string, args ...interface{}) (int64, error) {
+			var id int64
+			err := tx.QueryRow(ctx, rebind(query), args...).Scan(&id)
+			return id, err
+		},
+		timeArg: func(t time.Time) interface{} { return t },
+	}
+	if err := im.run(sess, messages); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("ошибка импорта сессии: %w", err)
+	}
+	return p.GetSession(sess.ID)
+}
@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Схема версионируется миграциями migrations/<диалект>/NNNN_имя.sql. Каждая миграция
+// применяется один раз в своей транзакции и записывается в schema_migrations.
+// Новые изменения схемы — только новыми файлами с следующим номером, старые файлы не меняются.
+
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// Migration миграция схемы
+type Migration struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	SQL     string `json:"-"`
+}
+
+// loadMigrations читает миграции диалекта (sqlite, postgres), упорядоченные по номеру
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения миграций: %w", err)
+	}
+
+	var migrations []Migration
+	seen := map[int]string{}
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".sql")
+		num, rest, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("некорректное имя миграции %s: ожидается NNNN_имя.sql", e.Name())
+		}
+		if prev, dup := seen[version]; dup {
+			return nil, fmt.Errorf("миграции %s и %s с одинаковым номером", prev, e.Name())
+		}
+		seen[version] = e.Name()
+
+		data, err := migrationFiles.ReadFile(path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения миграции %s: %w", e.Name(), err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: rest, SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrate применяет недостающие миграции (как при старте сервера) и возвращает примененные
+func Migrate(databaseURL, dbPath string) ([]Migration, error) {
+	st, err := Open(databaseURL, dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
+
+	switch s := st.(type) {
+	case *Storage:
+		return s.applied, nil
+	case *PostgresStore:
+		return s.applied, nil
+	}
+	return nil, nil
+}
+
+const sqliteSchemaMigrationsSQL = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+)`
+
+// migrate приводит схему SQLite к последней версии, затем настраивает поиск
+// (индекс FTS5 зависит от сборки, поэтому не входит в версионированные миграции)
+func (s *Storage) migrate() error {
+	migrations, err := loadMigrations("sqlite")
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(sqliteSchemaMigrationsSQL); err != nil {
+		return fmt.Errorf("ошибка создания таблицы schema_migrations: %w", err)
+	}
+
+	applied, err := s.appliedVersions()
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		if err := s.baselineLegacySchema(migrations, applied); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		if err := s.applyMigration(m); err != nil {
+			return err
+		}
+		s.applied = append(s.applied, m)
+	}
+
+	return s.migrateSearch()
+}
+
+func (s *Storage) appliedVersions() (map[int]bool, error) {
+	rows, err := s.db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("ошибка чтения schema_migrations: %w", err)
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+func (s *Storage) applyMigration(m Migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return fmt.Errorf("ошибка миграции %04d_%s: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+		return fmt.Errorf("ошибка записи миграции %04d_%s: %w", m.Version, m.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка миграции %04d_%s: %w", m.Version, m.Name, err)
+	}
+	return nil
+}
+
+// legacyProbes проверки схемы, созданной до schema_migrations: true — изменение миграции уже есть в БД
+var legacyProbes = map[int]func(s *Storage) (bool, error){
+	1: func(s *Storage) (bool, error) { return s.hasTable("messages") },
+	2: func(s *Storage) (bool, error) { return s.hasColumn("request_logs", "tokens_input") },
+	3: func(s *Storage) (bool, error) {
+		var ddl string
+		if err := s.db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'messages'").Scan(&ddl); err != nil {
+			return false, fmt.Errorf("ошибка чтения схемы messages: %w", err)
+		}
+		return strings.Contains(ddl, "'summary'"), nil
+	},
+	4: func(s *Storage) (bool, error) { return s.hasTable("agent_steps") },
+	5: func(s *Storage) (bool, error) { return s.hasColumn("messages", "finish_reason") },
+	6: func(s *Storage) (bool, error) { return s.hasTable("sessions") },
+	7: func(s *Storage) (bool, error) { return s.hasColumn("messages", "parent_id") },
+}
+
+// baselineLegacySchema отмечает примененными миграции, изменения которых уже есть в БД,
+// созданной до появления schema_migrations. Для новой БД ничего не делает.
+func (s *Storage) baselineLegacySchema(migrations []Migration, applied map[int]bool) error {
+	exists, err := s.hasTable("messages")
+	if err != nil || !exists {
+		return err
+	}
+
+	for _, m := range migrations {
+		probe, ok := legacyProbes[m.Version]
+		if !ok {
+			continue
+		}
+		present, err := probe(s)
+		if err != nil {
+			return err
+		}
+		if !present {
+			continue
+		}
+		if _, err := s.db.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+			return fmt.Errorf("ошибка записи миграции %04d_%s: %w", m.Version, m.Name, err)
+		}
+		applied[m.Version] = true
+	}
+	return nil
+}
+
+// hasTable проверяет существование таблицы
+func (s *Storage) hasTable(table string) (bool, error) {
+	var n int
+	if err := s.db.QueryRow("SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n); err != nil {
+		return false, fmt.Errorf("ошибка проверки таблицы %s: %w", table, err)
+	}
+	return n > 0, nil
+}
+
+// hasColumn проверяет существование колонки
+func (s *Storage) hasColumn(table, column string) (bool, error) {
+	var n int
+	if err := s.db.QueryRow("SELECT COUNT(1) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n); err != nil {
+		return false, fmt.Errorf("ошибка проверки структуры таблицы: %w", err)
+	}
+	return n > 0, nil
+}
+
+const postgresSchemaMigrationsSQL = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+// migrate приводит схему PostgreSQL к последней версии
+func (p *PostgresStore) migrate(ctx context.Context) error {
+	migrations, err := loadMigrations("postgres")
+	if err != nil {
+		return err
+	}
+	if _, err := p.pool.Exec(ctx, postgresSchemaMigrationsSQL); err != nil {
+		return fmt.Errorf("ошибка создания таблицы schema_migrations: %w", err)
+	}
+
+	rows, err := p.pool.Query(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("ошибка чтения schema_migrations: %w", err)
+	}
+	applied := map[int]bool{}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return fmt.Errorf("ошибка чтения schema_migrations: %w", err)
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("ошибка чтения schema_migrations: %w", err)
+	}
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		tx, err := p.pool.Begin(ctx)
+		if err != nil {
+			return fmt.Errorf("ошибка начала транзакции: %w", err)
+		}
+		if _, err := tx.Exec(ctx, m.SQL); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("ошибка миграции %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name); err != nil {
+			tx.Rollback(ctx)
+			return fmt.Errorf("ошибка записи миграции %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return fmt.Errorf("ошибка миграции %04d_%s: %w", m.Version, m.Name, err)
+		}
+		p.applied = append(p.applied, m)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+-- Исходная схема PostgreSQL (соответствует SQLite-миграциям 0001–0007)
+CREATE TABLE IF NOT EXISTS messages (
+	id BIGSERIAL PRIMARY KEY,
+	session_id TEXT NOT NULL,
+	role TEXT NOT NULL CHECK(role IN ('user', 'assistant', 'summary')),
+	content TEXT NOT NULL,
+	finish_reason TEXT,
+	parent_id BIGINT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_messages_session ON messages(session_id);
+CREATE INDEX IF NOT EXISTS idx_messages_created ON messages(created_at);
+CREATE INDEX IF NOT EXISTS idx_messages_parent ON messages(parent_id);
+CREATE INDEX IF NOT EXISTS idx_messages_search ON messages USING GIN (to_tsvector('simple', content));
+
+CREATE TABLE IF NOT EXISTS request_logs (
+	id BIGSERIAL PRIMARY KEY,
+	session_id TEXT,
+	request_json TEXT NOT NULL,
+	response_json TEXT,
+	status_code INTEGER,
+	duration_ms BIGINT,
+	tokens_input INTEGER,
+	tokens_output INTEGER,
+	tokens_total INTEGER,
+	cost DOUBLE PRECISION,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_request_logs_session ON request_logs(session_id);
+CREATE INDEX IF NOT EXISTS idx_request_logs_created ON request_logs(created_at);
+
+CREATE TABLE IF NOT EXISTS agent_steps (
+	id BIGSERIAL PRIMARY KEY,
+	run_id TEXT NOT NULL,
+	session_id TEXT NOT NULL,
+	step INTEGER NOT NULL,
+	kind TEXT NOT NULL CHECK(kind IN ('model', 'tool')),
+	tool_name TEXT,
+	tool_call_id TEXT,
+	arguments TEXT,
+	content TEXT NOT NULL DEFAULT '',
+	error TEXT,
+	duration_ms BIGINT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_agent_steps_run ON agent_steps(run_id);
+CREATE INDEX IF NOT EXISTS idx_agent_steps_session ON agent_steps(session_id);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT PRIMARY KEY,
+	title TEXT NOT NULL DEFAULT '',
+	provider TEXT,
+	model TEXT,
+	system_prompt TEXT,
+	reasoning_mode TEXT,
+	active_leaf_id BIGINT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_updated ON sessions(updated_at);
@@ -0,0 +1,11 @@
+-- Происхождение ответов модели: провайдер, модель, параметры, токены, стоимость, время
+-- и строка request_logs, в которой записан запрос
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS provider TEXT;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS model TEXT;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reasoning_mode TEXT;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS temperature DOUBLE PRECISION;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS tokens_input INTEGER;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS tokens_output INTEGER;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS cost DOUBLE PRECISION;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS duration_ms BIGINT;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS request_log_id BIGINT;
@@ -0,0 +1,13 @@
+-- Провайдер, модель и режим рассуждений логов запросов — отдельными колонками для статистики.
+-- Старые логи заполняются из request_json (поля верхнего уровня, как их пишет encoding/json).
+ALTER TABLE request_logs ADD COLUMN IF NOT EXISTS provider TEXT;
+ALTER TABLE request_logs ADD COLUMN IF NOT EXISTS model TEXT;
+ALTER TABLE request_logs ADD COLUMN IF NOT EXISTS reasoning_mode TEXT;
+
+UPDATE request_logs SET
+	provider = NULLIF(substring(request_json FROM '"provider":"([^"]*)"'), ''),
+	model = NULLIF(substring(request_json FROM '"model":"([^"]*)"'), ''),
+	reasoning_mode = NULLIF(substring(request_json FROM '"reasoning_mode":"([^"]*)"'), '')
+WHERE provider IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_request_logs_provider_model ON request_logs(provider, model, created_at);
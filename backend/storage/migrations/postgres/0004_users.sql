@@ -0,0 +1,26 @@
+-- Пользователи и их API-ключи; сессии и логи запросов принадлежат пользователю.
+-- Данные, созданные до включения авторизации (user_id NULL), видны только администраторам.
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- В БД хранится только SHA-256 ключа; prefix — начало ключа для отображения
+CREATE TABLE IF NOT EXISTS api_keys (
+	id BIGSERIAL PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users(id),
+	name TEXT NOT NULL DEFAULT '',
+	prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_used_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id);
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_id TEXT;
+ALTER TABLE request_logs ADD COLUMN IF NOT EXISTS user_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id, updated_at);
+CREATE INDEX IF NOT EXISTS idx_request_logs_user ON request_logs(user_id);
@@ -0,0 +1,22 @@
+-- Исходная схема: сообщения (только user/assistant) и логи запросов
+CREATE TABLE IF NOT EXISTS messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_id TEXT NOT NULL,
+	role TEXT NOT NULL CHECK(role IN ('user', 'assistant')),
+	content TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_messages_session ON messages(session_id);
+CREATE INDEX IF NOT EXISTS idx_messages_created ON messages(created_at);
+
+CREATE TABLE IF NOT EXISTS request_logs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_id TEXT,
+	request_json TEXT NOT NULL,
+	response_json TEXT,
+	status_code INTEGER,
+	duration_ms INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_request_logs_session ON request_logs(session_id);
+CREATE INDEX IF NOT EXISTS idx_request_logs_created ON request_logs(created_at);
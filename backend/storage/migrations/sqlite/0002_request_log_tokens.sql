@@ -0,0 +1,5 @@
+-- Токены и стоимость запроса
+ALTER TABLE request_logs ADD COLUMN tokens_input INTEGER;
+ALTER TABLE request_logs ADD COLUMN tokens_output INTEGER;
+ALTER TABLE request_logs ADD COLUMN tokens_total INTEGER;
+ALTER TABLE request_logs ADD COLUMN cost REAL;
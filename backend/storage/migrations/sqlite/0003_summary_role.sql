@@ -0,0 +1,14 @@
+-- Роль summary для компрессии истории. SQLite не умеет менять CHECK, поэтому таблица пересоздается.
+CREATE TABLE messages_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_id TEXT NOT NULL,
+	role TEXT NOT NULL CHECK(role IN ('user', 'assistant', 'summary')),
+	content TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO messages_new (id, session_id, role, content, created_at)
+SELECT id, session_id, role, content, created_at FROM messages;
+DROP TABLE messages;
+ALTER TABLE messages_new RENAME TO messages;
+CREATE INDEX idx_messages_session ON messages(session_id);
+CREATE INDEX idx_messages_created ON messages(created_at);
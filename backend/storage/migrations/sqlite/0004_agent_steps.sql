@@ -0,0 +1,17 @@
+-- Шаги серверного агента
+CREATE TABLE IF NOT EXISTS agent_steps (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	run_id TEXT NOT NULL,
+	session_id TEXT NOT NULL,
+	step INTEGER NOT NULL,
+	kind TEXT NOT NULL CHECK(kind IN ('model', 'tool')),
+	tool_name TEXT,
+	tool_call_id TEXT,
+	arguments TEXT,
+	content TEXT NOT NULL DEFAULT '',
+	error TEXT,
+	duration_ms INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_agent_steps_run ON agent_steps(run_id);
+CREATE INDEX IF NOT EXISTS idx_agent_steps_session ON agent_steps(session_id);
@@ -0,0 +1,2 @@
+-- Причина завершения ответа (stopped для остановленных генераций)
+ALTER TABLE messages ADD COLUMN finish_reason TEXT;
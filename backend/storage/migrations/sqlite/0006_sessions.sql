@@ -0,0 +1,14 @@
+-- Сессии; сессии, известные только по messages, переносятся с датами первого и последнего сообщения
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT PRIMARY KEY,
+	title TEXT NOT NULL DEFAULT '',
+	provider TEXT,
+	model TEXT,
+	system_prompt TEXT,
+	reasoning_mode TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_updated ON sessions(updated_at);
+INSERT OR IGNORE INTO sessions (id, created_at, updated_at)
+SELECT session_id, MIN(created_at), MAX(created_at) FROM messages GROUP BY session_id;
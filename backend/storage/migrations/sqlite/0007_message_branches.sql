@@ -0,0 +1,13 @@
+-- Дерево сообщений (ветки диалога). Существующие сессии становятся одной веткой:
+-- каждое сообщение — ребенок предыдущего.
+ALTER TABLE messages ADD COLUMN parent_id INTEGER;
+ALTER TABLE sessions ADD COLUMN active_leaf_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_messages_parent ON messages(parent_id);
+
+UPDATE messages SET parent_id = (
+	SELECT MAX(p.id) FROM messages p
+	WHERE p.session_id = messages.session_id AND p.id < messages.id AND p.role != 'summary'
+) WHERE role != 'summary';
+UPDATE sessions SET active_leaf_id = (
+	SELECT MAX(m.id) FROM messages m WHERE m.session_id = sessions.id AND m.role != 'summary'
+);
@@ -0,0 +1,11 @@
+-- Происхождение ответов модели: провайдер, модель, параметры, токены, стоимость, время
+-- и строка request_logs, в которой записан запрос
+ALTER TABLE messages ADD COLUMN provider TEXT;
+ALTER TABLE messages ADD COLUMN model TEXT;
+ALTER TABLE messages ADD COLUMN reasoning_mode TEXT;
+ALTER TABLE messages ADD COLUMN temperature REAL;
+ALTER TABLE messages ADD COLUMN tokens_input INTEGER;
+ALTER TABLE messages ADD COLUMN tokens_output INTEGER;
+ALTER TABLE messages ADD COLUMN cost REAL;
+ALTER TABLE messages ADD COLUMN duration_ms INTEGER;
+ALTER TABLE messages ADD COLUMN request_log_id INTEGER;
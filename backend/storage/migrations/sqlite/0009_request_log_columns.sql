@@ -0,0 +1,13 @@
+-- Провайдер, модель и режим рассуждений логов запросов — отдельными колонками для статистики.
+-- Старые логи заполняются из request_json.
+ALTER TABLE request_logs ADD COLUMN provider TEXT;
+ALTER TABLE request_logs ADD COLUMN model TEXT;
+ALTER TABLE request_logs ADD COLUMN reasoning_mode TEXT;
+
+UPDATE request_logs SET
+	provider = NULLIF(json_extract(request_json, '$.provider'), ''),
+	model = NULLIF(json_extract(request_json, '$.model'), ''),
+	reasoning_mode = NULLIF(json_extract(request_json, '$.reasoning_mode'), '')
+WHERE json_valid(request_json) AND json_type(request_json) = 'object';
+
+CREATE INDEX IF NOT EXISTS idx_request_logs_provider_model ON request_logs(provider, model, created_at);
@@ -0,0 +1,26 @@
+-- Пользователи и их API-ключи; сессии и логи запросов принадлежат пользователю.
+-- Данные, созданные до включения авторизации (user_id NULL), видны только администраторам.
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	is_admin INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- В БД хранится только SHA-256 ключа; prefix — начало ключа для отображения
+CREATE TABLE IF NOT EXISTS api_keys (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id TEXT NOT NULL REFERENCES users(id),
+	name TEXT NOT NULL DEFAULT '',
+	prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_used_at DATETIME,
+	revoked_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id);
+
+ALTER TABLE sessions ADD COLUMN user_id TEXT;
+ALTER TABLE request_logs ADD COLUMN user_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id, updated_at);
+CREATE INDEX IF NOT EXISTS idx_request_logs_user ON request_logs(user_id);
@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// openLegacyFixture создает БД из testdata/legacy/v<stage>.sql (0 — пустой файл БД) и открывает ее через New
+func openLegacyFixture(t *testing.T, stage int) (*Storage, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	if stage > 0 {
+		script, err := os.ReadFile(filepath.Join("testdata", "legacy", fmt.Sprintf("v%d.sql", stage)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(script)); err != nil {
+			db.Close()
+			t.Fatalf("v%d.sql: %v", stage, err)
+		}
+		db.Close()
+	}
+
+	s, err := New(path)
+	if err != nil {
+		t.Fatalf("миграция БД этапа %d: %v", stage, err)
+	}
+	return s, path
+}
+
+func migrationVersions(migrations []Migration) []int {
+	versions := []int{}
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+	}
+	return versions
+}
+
+// parents parent_id сообщений сессии по id
+func parents(t *testing.T, s *Storage, sessionID string) map[int64]int64 {
+	t.Helper()
+	rows, err := s.db.Query("SELECT id, COALESCE(parent_id, 0) FROM messages WHERE session_id = ?", sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	out := map[int64]int64{}
+	for rows.Next() {
+		var id, parent int64
+		if err := rows.Scan(&id, &parent); err != nil {
+			t.Fatal(err)
+		}
+		out[id] = parent
+	}
+	return out
+}
+
+// Каждый этап схемы до schema_migrations (по одной фикстуре на legacyProbes) доводится
+// до последней версии: уже сделанные изменения отмечаются, недостающие миграции применяются
+func TestMigrateLegacySchemas(t *testing.T) {
+	all, err := loadMigrations("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := all[len(all)-1].Version
+
+	for stage := 0; stage <= len(legacyProbes); stage++ {
+		t.Run(fmt.Sprintf("v%d", stage), func(t *testing.T) {
+			s, path := openLegacyFixture(t, stage)
+
+			var wantApplied []int
+			for v := stage + 1; v <= latest; v++ {
+				wantApplied = append(wantApplied, v)
+			}
+			if got := migrationVersions(s.applied); !reflect.DeepEqual(got, wantApplied) {
+				t.Errorf("применены миграции %v, ожидались %v", got, wantApplied)
+			}
+			versions, err := s.appliedVersions()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(versions) != latest {
+				t.Errorf("в schema_migrations %d версий, ожидалось %d", len(versions), latest)
+			}
+			for _, c := range []struct{ table, column string }{
+				{"messages", "parent_id"}, {"messages", "request_log_id"}, {"sessions", "user_id"}, {"request_logs", "model"},
+			} {
+				if ok, _ := s.hasColumn(c.table, c.column); !ok {
+					t.Errorf("нет колонки %s.%s", c.table, c.column)
+				}
+			}
+
+			if stage > 0 {
+				checkLegacyData(t, s, stage)
+			}
+
+			// Повторное открытие ничего не применяет
+			s.Close()
+			again, err := New(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer again.Close()
+			if len(again.applied) != 0 {
+				t.Errorf("повторно применены %v", migrationVersions(again.applied))
+			}
+		})
+	}
+}
+
+// checkLegacyData проверяет перенос данных фикстуры этапа stage
+func checkLegacyData(t *testing.T, s *Storage, stage int) {
+	t.Helper()
+
+	// До этапа 7 сессии становятся одной веткой: каждое сообщение — ответ на предыдущее, summary вне дерева.
+	// На этапе 7 дерево уже есть (8 — вторая ветка от 2) и не пересчитывается.
+	wantS1 := map[int64]int64{1: 0, 2: 1, 4: 2, 5: 4}
+	leafS1 := int64(5)
+	if stage >= 3 {
+		wantS1[3] = 0
+	}
+	if stage >= 7 {
+		wantS1[8] = 2
+	}
+	if got := parents(t, s, "s1"); !reflect.DeepEqual(got, wantS1) {
+		t.Errorf("родители s1 = %v, ожидались %v", got, wantS1)
+	}
+	if got := parents(t, s, "s2"); !reflect.DeepEqual(got, map[int64]int64{6: 0, 7: 6}) {
+		t.Errorf("родители s2 = %v", got)
+	}
+	if leaf, _ := s.ActiveLeafID("s1"); leaf != leafS1 {
+		t.Errorf("конец активной ветки s1 %d, ожидался %d", leaf, leafS1)
+	}
+	if leaf, _ := s.ActiveLeafID("s2"); leaf != 7 {
+		t.Errorf("конец активной ветки s2 %d, ожидался 7", leaf)
+	}
+	history, err := s.GetMessages("s1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Привет", "Здравствуйте", "Как дела?", "Хорошо"}
+	if stage >= 3 {
+		want = []string{"Привет", "Здравствуйте", "Резюме начала", "Как дела?", "Хорошо"}
+	}
+	if got := messageContents(history); !reflect.DeepEqual(got, want) {
+		t.Errorf("история s1 = %v, ожидалась %v", got, want)
+	}
+
+	// До этапа 6 сессии создаются по сообщениям с датами первого и последнего
+	sessions := []struct {
+		id, title        string
+		created, updated time.Time
+	}{
+		{"s1", "", time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC), time.Date(2024, 1, 10, 10, 1, 4, 0, time.UTC)},
+		{"s2", "", time.Date(2024, 1, 11, 9, 0, 0, 0, time.UTC), time.Date(2024, 1, 11, 9, 30, 0, 0, time.UTC)},
+	}
+	if stage >= 6 {
+		sessions[0].title = "Старая сессия"
+	}
+	if stage >= 7 {
+		sessions[0].updated = time.Date(2024, 1, 12, 8, 0, 0, 0, time.UTC)
+	}
+	for _, want := range sessions {
+		sess, err := s.GetSession(want.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sess == nil {
+			t.Errorf("сессия %s не перенесена", want.id)
+			continue
+		}
+		if sess.Title != want.title || !sess.CreatedAt.Equal(want.created) || !sess.UpdatedAt.Equal(want.updated) {
+			t.Errorf("сессия %s = %q %v–%v, ожидалось %q %v–%v", want.id, sess.Title, sess.CreatedAt, sess.UpdatedAt, want.title, want.created, want.updated)
+		}
+	}
+
+	// Провайдер и модель старых логов заполняются из request_json
+	logs, err := s.GetRequestLogs("s1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Provider != "groq" || logs[0].Model != "llama" || logs[0].DurationMs != 812 {
+		t.Fatalf("логи = %+v", logs)
+	}
+	if hasTokens := logs[0].TokensTotal != nil; hasTokens != (stage >= 2) {
+		t.Errorf("tokens_total = %v на этапе %d", logs[0].TokensTotal, stage)
+	}
+
+	if stage >= 3 {
+		if summary, _ := s.GetLatestSummary("s1"); summary == nil || summary.Content != "Резюме начала" {
+			t.Errorf("summary = %+v", summary)
+		}
+	}
+	if stage >= 4 {
+		if steps, _ := s.GetAgentSteps("run-1"); len(steps) != 1 || steps[0].ToolName != "calculator" {
+			t.Errorf("шаги агента = %+v", steps)
+		}
+	}
+	if stage >= 5 {
+		if m, _ := s.GetMessage("s1", 5); m == nil || m.FinishReason != "stopped" {
+			t.Errorf("finish_reason не перенесен: %+v", m)
+		}
+	}
+
+	// Перенесенная БД принимает новые сообщения и роли
+	m, err := s.SaveMessage("s1", RoleUser, "Новое")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ParentID != leafS1 {
+		t.Errorf("новое сообщение ссылается на %d, ожидался %d", m.ParentID, leafS1)
+	}
+	if _, err := s.UpsertSummary("s2", "Резюме"); err != nil {
+		t.Errorf("summary в перенесенной БД: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package storage
+
+// Page окно keyset-пагинации по id. Курсор — id последней записи страницы
+// в направлении обхода: с Newest листаем к старым (BeforeID), без него — к новым (AfterID).
+type Page struct {
+	Limit    int
+	BeforeID int64 // только записи с id < BeforeID (0 — без ограничения)
+	AfterID  int64 // только записи с id > AfterID (0 — без ограничения)
+	Newest   bool  // брать самые новые записи окна, иначе самые старые
+}
+
+// conditions условия окна для колонки id
+func (p Page) conditions(column string) ([]string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if p.BeforeID > 0 {
+		where = append(where, column+" < ?")
+		args = append(args, p.BeforeID)
+	}
+	if p.AfterID > 0 {
+		where = append(where, column+" > ?")
+		args = append(args, p.AfterID)
+	}
+	return where, args
+}
+
+// order порядок выборки: сначала записи, ближайшие к началу обхода
+func (p Page) order(column string) string {
+	if p.Newest {
+		return column + " DESC"
+	}
+	return column + " ASC"
+}
+
+// size лимит страницы (def, если Limit не задан). Запрос выбирает size()+1 записей:
+// лишняя запись показывает, что есть продолжение, и в ответ не попадает
+func (p Page) size(def int) int {
+	if p.Limit <= 0 {
+		return def
+	}
+	return p.Limit
+}
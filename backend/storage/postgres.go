@@ -0,0 +1,644 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore хранилище в PostgreSQL (database_url в конфиге). Схема и запросы совпадают
+// с SQLite там, где это возможно; поиск — встроенный полнотекстовый поиск PostgreSQL.
+type PostgresStore struct {
+	pool    *pgxpool.Pool
+	applied []Migration // миграции, примененные при открытии
+}
+
+// NewPostgres подключается к PostgreSQL и применяет миграции
+func NewPostgres(databaseURL string) (*PostgresStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pool, err := pgxpool.New(ctx, databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия БД: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("ошибка подключения к БД: %w", err)
+	}
+
+	p := &PostgresStore{pool: pool}
+	if err := p.migrate(context.Background()); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("ошибка миграции: %w", err)
+	}
+	return p, nil
+}
+
+// rebind заменяет плейсхолдеры ? на $1, $2, ... (вне строковых литералов),
+// чтобы запросы SQLite можно было выполнять в PostgreSQL
+func rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (p *PostgresStore) exec(query string, args ...interface{}) (pgconn.CommandTag, error) {
+	return p.pool.Exec(context.Background(), rebind(query), args...)
+}
+
+func (p *PostgresStore) query(query string, args ...interface{}) (pgx.Rows, error) {
+	return p.pool.Query(context.Background(), rebind(query), args...)
+}
+
+func (p *PostgresStore) queryRow(query string, args ...interface{}) pgx.Row {
+	return p.pool.QueryRow(context.Background(), rebind(query), args...)
+}
+
+// collectMessages читает сообщения, выбранные через messageColumns
+func collectMessages(rows pgx.Rows) ([]Message, error) {
+	defer rows.Close()
+	var messages []Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования сообщения: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения сообщений: %w", err)
+	}
+	return messages, nil
+}
+
+// SaveMessage сохраняет сообщение в конец активной ветки
+func (p *PostgresStore) SaveMessage(sessionID, role, content string) (*Message, error) {
+	return p.AddMessage(NewMessage{SessionID: sessionID, Role: role, Content: content})
+}
+
+// SaveMessageWithFinishReason сохраняет сообщение с причиной завершения ответа
+func (p *PostgresStore) SaveMessageWithFinishReason(sessionID, role, content, finishReason string) (*Message, error) {
+	return p.AddMessage(NewMessage{SessionID: sessionID, Role: role, Content: content, FinishReason: finishReason})
+}
+
+// AddMessage сохраняет сообщение. User/assistant сообщения становятся концом активной ветки
+// сессии; ответ модели — только если конец ветки все еще указывает на его родителя.
+func (p *PostgresStore) AddMessage(m NewMessage) (*Message, error) {
+	if _, err := p.exec(
+		"INSERT INTO sessions (id) VALUES (?) ON CONFLICT(id) DO UPDATE SET updated_at = CURRENT_TIMESTAMP",
+		m.SessionID,
+	); err != nil {
+		return nil, fmt.Errorf("ошибка обновления сессии: %w", err)
+	}
+
+	inTree := m.Role != RoleSummary
+	parentExpr := "CAST(? AS BIGINT)"
+	var parentArg interface{}
+	switch {
+	case !inTree:
+	case m.ParentID != nil:
+		parentArg = nullInt64(*m.ParentID)
+	default:
+		parentExpr = "(SELECT active_leaf_id FROM sessions WHERE id = ?)"
+		parentArg = m.SessionID
+	}
+
+	msg := Message{SessionID: m.SessionID, Role: m.Role, Content: m.Content, FinishReason: m.FinishReason, Provenance: m.Provenance}
+	args := append([]interface{}{m.SessionID, m.Role, m.Content, nullString(m.FinishReason)}, m.Provenance.args()...)
+	args = append(args, parentArg)
+	err := p.queryRow(
+		"INSERT INTO messages (session_id, role, content, finish_reason, "+provenanceColumns+", parent_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, "+parentExpr+") RETURNING id, COALESCE(parent_id, 0), created_at",
+		args...,
+	).Scan(&msg.ID, &msg.ParentID, &msg.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сохранения сообщения: %w", err)
+	}
+
+	if inTree {
+		query, args := moveLeafQuery(m.Role, m.SessionID, msg.ID, msg.ParentID)
+		if _, err := p.exec(query, args...); err != nil {
+			return nil, fmt.Errorf("ошибка обновления активной ветки: %w", err)
+		}
+	}
+	return &msg, nil
+}
+
+// GetMessage возвращает сообщение сессии или nil
+func (p *PostgresStore) GetMessage(sessionID string, id int64) (*Message, error) {
+	msg, err := scanMessage(p.queryRow("SELECT "+messageColumns+" FROM messages WHERE session_id = ? AND id = ?", sessionID, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения сообщения: %w", err)
+	}
+	return &msg, nil
+}
+
+// GetMessages возвращает первые limit сообщений активной ветки сессии вместе с summary
+func (p *PostgresStore) GetMessages(sessionID string, limit int) ([]Message, error) {
+	messages, _, err := p.GetMessagesPage(sessionID, Page{Limit: limit}, true)
+	return messages, err
+}
+
+// GetMessagesPage возвращает страницу сообщений активной ветки сессии (с summary, если withSummary)
+// по возрастанию id и курсор следующей страницы (0 — страниц больше нет)
+func (p *PostgresStore) GetMessagesPage(sessionID string, page Page, withSummary bool) ([]Message, int64, error) {
+	limit := page.size(100)
+	query, args := messagesPageQuery(sessionID, page, withSummary)
+	args = append(args, limit+1)
+
+	rows, err := p.query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка получения сообщений: %w", err)
+	}
+	messages, err := collectMessages(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var next int64
+	if len(messages) > limit {
+		messages = messages[:limit]
+		next = messages[limit-1].ID
+	}
+	if page.Newest {
+		slices.Reverse(messages)
+	}
+	return messages, next, nil
+}
+
+// SearchMessages ищет user/assistant сообщения сессии по подстроке без учета регистра, самые новые первыми
+func (p *PostgresStore) SearchMessages(sessionID, query string, limit int) ([]Message, error) {
+	if limit <= 0 {
+		limit = 10
+	}
+
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query)
+	rows, err := p.query(
+		`SELECT `+messageColumns+` FROM messages
+WHERE session_id = ? AND role IN (?, ?) AND content ILIKE ?
+ORDER BY id DESC LIMIT ?`,
+		sessionID, RoleUser, RoleAssistant, "%"+escaped+"%", limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска сообщений: %w", err)
+	}
+	return collectMessages(rows)
+}
+
+// GetBranch возвращает сообщения ветки от корня до leafID включительно (leafID = 0 — пустая ветка)
+func (p *PostgresStore) GetBranch(sessionID string, leafID int64, limit int) ([]Message, error) {
+	if leafID == 0 {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := p.query(
+		leafBranchCTE+"SELECT "+messageColumns+" FROM messages WHERE session_id = ? AND id IN (SELECT id FROM branch) ORDER BY id ASC LIMIT ?",
+		leafID, sessionID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения ветки: %w", err)
+	}
+	return collectMessages(rows)
+}
+
+// GetMessageTree возвращает все сообщения сессии (все ветки) и конец активной ветки
+func (p *PostgresStore) GetMessageTree(sessionID string) ([]Message, int64, error) {
+	rows, err := p.query(
+		"SELECT "+messageColumns+" FROM messages WHERE session_id = ? AND role != ? ORDER BY id ASC",
+		sessionID, RoleSummary,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка получения дерева сообщений: %w", err)
+	}
+	messages, err := collectMessages(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	if messages == nil {
+		messages = []Message{}
+	}
+
+	leafID, err := p.ActiveLeafID(sessionID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return messages, leafID, nil
+}
+
+// ActiveLeafID возвращает конец активной ветки (0 — сессия пуста или не существует)
+func (p *PostgresStore) ActiveLeafID(sessionID string) (int64, error) {
+	var leafID int64
+	err := p.queryRow("SELECT COALESCE(active_leaf_id, 0) FROM sessions WHERE id = ?", sessionID).Scan(&leafID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("ошибка получения активной ветки: %w", err)
+	}
+	return leafID, nil
+}
+
+// SetActiveBranch делает активной ветку, проходящую через сообщение messageID
+// (см. Storage.SetActiveBranch)
+func (p *PostgresStore) SetActiveBranch(sessionID string, messageID int64) (int64, error) {
+	msg, err := p.GetMessage(sessionID, messageID)
+	if err != nil {
+		return 0, err
+	}
+	if msg == nil || msg.Role == RoleSummary {
+		return 0, nil
+	}
+
+	leafID := messageID
+	for {
+		var childID *int64
+		if err := p.queryRow(
+			"SELECT MAX(id) FROM messages WHERE session_id = ? AND parent_id = ? AND role != ?",
+			sessionID, leafID, RoleSummary,
+		).Scan(&childID); err != nil {
+			return 0, fmt.Errorf("ошибка поиска продолжения ветки: %w", err)
+		}
+		if childID == nil {
+			break
+		}
+		leafID = *childID
+	}
+
+	if _, err := p.exec("UPDATE sessions SET active_leaf_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", leafID, sessionID); err != nil {
+		return 0, fmt.Errorf("ошибка переключения ветки: %w", err)
+	}
+	return leafID, nil
+}
+
+// SetActiveLeaf делает концом активной ветки сообщение leafID (без спуска к ответам)
+func (p *PostgresStore) SetActiveLeaf(sessionID string, leafID int64) error {
+	if _, err := p.exec("UPDATE sessions SET active_leaf_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", nullInt64(leafID), sessionID); err != nil {
+		return fmt.Errorf("ошибка переключения ветки: %w", err)
+	}
+	return nil
+}
+
+// GetLatestSummary возвращает последнее summary для сессии (если есть).
+func (p *PostgresStore) GetLatestSummary(sessionID string) (*Message, error) {
+	msg, err := scanMessage(p.queryRow(
+		"SELECT "+messageColumns+" FROM messages WHERE session_id = ? AND role = ? ORDER BY id DESC LIMIT 1",
+		sessionID, RoleSummary,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения summary: %w", err)
+	}
+	return &msg, nil
+}
+
+// UpsertSummary создает или обновляет summary сообщение.
+func (p *PostgresStore) UpsertSummary(sessionID string, content string) (*Message, error) {
+	existing, err := p.GetLatestSummary(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return p.SaveMessage(sessionID, RoleSummary, content)
+	}
+	if err := p.queryRow(
+		"UPDATE messages SET content = ?, created_at = CURRENT_TIMESTAMP WHERE id = ? RETURNING created_at",
+		content, existing.ID,
+	).Scan(&existing.CreatedAt); err != nil {
+		return nil, fmt.Errorf("ошибка обновления summary: %w", err)
+	}
+	existing.Content = content
+	return existing, nil
+}
+
+// CountNonSummaryMessages возвращает количество user/assistant сообщений активной ветки сессии.
+func (p *PostgresStore) CountNonSummaryMessages(sessionID string) (int, error) {
+	var cnt int
+	if err := p.queryRow(
+		activeBranchCTE+"SELECT COUNT(1) FROM messages WHERE session_id = ? AND role IN (?, ?) AND id IN (SELECT id FROM branch)",
+		sessionID, sessionID, RoleUser, RoleAssistant,
+	).Scan(&cnt); err != nil {
+		return 0, fmt.Errorf("ошибка подсчета сообщений: %w", err)
+	}
+	return cnt, nil
+}
+
+// GetSession возвращает сессию или nil, если ее нет
+func (p *PostgresStore) GetSession(sessionID string) (*Session, error) {
+	sess, err := scanSession(p.queryRow("SELECT "+sessionColumns+" FROM sessions s WHERE s.id = ?", sessionID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения сессии: %w", err)
+	}
+	return &sess, nil
+}
+
+// ListSessions возвращает сессии пользователя userID ("" — всех пользователей), недавно обновленные первыми
+func (p *PostgresStore) ListSessions(userID string, limit int) ([]Session, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query, args := listSessionsSQL(userID, limit)
+	rows, err := p.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения сессий: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования сессии: %w", err)
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, rows.Err()
+}
+
+// UpdateSession меняет поля сессии, заданные в patch. Возвращает nil, если сессии нет.
+func (p *PostgresStore) UpdateSession(sessionID string, patch SessionPatch) (*Session, error) {
+	sets, args := patch.assignments()
+	if len(sets) > 0 {
+		args = append(args, sessionID)
+		if _, err := p.exec("UPDATE sessions SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...); err != nil {
+			return nil, fmt.Errorf("ошибка обновления сессии: %w", err)
+		}
+	}
+	return p.GetSession(sessionID)
+}
+
+// SetSessionTitleIfEmpty задает название, если пользователь еще не назвал сессию.
+// Возвращает true, если название записано.
+func (p *PostgresStore) SetSessionTitleIfEmpty(sessionID, title string) (bool, error) {
+	tag, err := p.exec("UPDATE sessions SET title = ? WHERE id = ? AND title = ''", title, sessionID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка сохранения названия сессии: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// DeleteSession удаляет сессию вместе с сообщениями и шагами агентов (логи запросов остаются).
+// Возвращает false, если сессии не было.
+func (p *PostgresStore) DeleteSession(sessionID string) (bool, error) {
+	ctx := context.Background()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM messages WHERE session_id = $1", sessionID); err != nil {
+		return false, fmt.Errorf("ошибка удаления сообщений сессии: %w", err)
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM agent_steps WHERE session_id = $1", sessionID); err != nil {
+		return false, fmt.Errorf("ошибка удаления шагов агента: %w", err)
+	}
+	tag, err := tx.Exec(ctx, "DELETE FROM sessions WHERE id = $1", sessionID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка удаления сессии: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("ошибка удаления сессии: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// SearchMode способ поиска: всегда полнотекстовый поиск PostgreSQL
+func (p *PostgresStore) SearchMode() string {
+	return SearchModePostgres
+}
+
+// Search ищет сообщения, содержащие все слова запроса (по префиксу), самые новые первыми.
+// Возвращает курсор следующей страницы (0 — страниц больше нет).
+func (p *PostgresStore) Search(q SearchQuery) ([]SearchResult, int64, error) {
+	terms := searchTerms(q.Query)
+	if len(terms) == 0 {
+		return []SearchResult{}, 0, nil
+	}
+	if q.Limit <= 0 {
+		q.Limit = 20
+	}
+
+	tsQuery := tsQuery(terms)
+	where := []string{"to_tsvector('simple', m.content) @@ to_tsquery('simple', ?)"}
+	args := []interface{}{tsQuery, tsQuery}
+	if q.SessionID != "" {
+		where = append(where, "m.session_id = ?")
+		args = append(args, q.SessionID)
+	}
+	if q.UserID != "" {
+		where = append(where, "s.user_id = ?")
+		args = append(args, q.UserID)
+	}
+	if q.Role != "" {
+		where = append(where, "m.role = ?")
+		args = append(args, q.Role)
+	}
+	if !q.From.IsZero() {
+		where = append(where, "m.created_at >= ?")
+		args = append(args, q.From)
+	}
+	if !q.To.IsZero() {
+		where = append(where, "m.created_at < ?")
+		args = append(args, q.To)
+	}
+	if q.BeforeID > 0 {
+		where = append(where, "m.id < ?")
+		args = append(args, q.BeforeID)
+	}
+	args = append(args, q.Limit+1)
+
+	// Первый плейсхолдер — запрос для ts_headline, второй — для условия
+	headline := fmt.Sprintf(`ts_headline('simple', m.content, to_tsquery('simple', ?),
+'StartSel="%s", StopSel="%s", MaxWords=%d, MinWords=%d, ShortWord=0, MaxFragments=1, FragmentDelimiter="…"')`,
+		rawHighlightStart, rawHighlightEnd, snippetTokens+8, snippetTokens/2)
+	rows, err := p.query(`SELECT m.id, m.session_id, COALESCE(s.title, ''), m.role, `+headline+`, m.created_at
+FROM messages m LEFT JOIN sessions s ON s.id = m.session_id
+WHERE `+strings.Join(where, " AND ")+` ORDER BY m.id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка поиска сообщений: %w", err)
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.MessageID, &r.SessionID, &r.SessionTitle, &r.Role, &r.Snippet, &r.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("ошибка сканирования результата поиска: %w", err)
+		}
+		r.Snippet = markSnippet(r.Snippet)
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ошибка чтения результатов поиска: %w", err)
+	}
+
+	var next int64
+	if len(results) > q.Limit {
+		results = results[:q.Limit]
+		next = results[len(results)-1].MessageID
+	}
+	return results, next, nil
+}
+
+// tsQuery превращает слова в tsquery: каждое слово — лексема с поиском по префиксу, все слова обязательны
+func tsQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		t = strings.NewReplacer(`\`, `\\`, "'", "''").Replace(t)
+		parts[i] = "'" + t + "':*"
+	}
+	return strings.Join(parts, " & ")
+}
+
+// SaveRequestLog сохраняет лог запроса
+func (p *PostgresStore) SaveRequestLog(entry NewRequestLog) (*RequestLog, error) {
+	log := entry.requestLog()
+	if err := p.queryRow(requestLogInsertSQL+" RETURNING id, created_at", entry.args()...).Scan(&log.ID, &log.CreatedAt); err != nil {
+		return nil, fmt.Errorf("ошибка сохранения лога: %w", err)
+	}
+	return log, nil
+}
+
+// GetRequestLogs возвращает последние limit логов запросов, новые первыми
+func (p *PostgresStore) GetRequestLogs(sessionID string, limit int) ([]RequestLog, error) {
+	logs, _, err := p.GetRequestLogsPage(RequestLogFilter{SessionID: sessionID}, Page{Limit: limit, Newest: true})
+	return logs, err
+}
+
+// GetRequestLogsPage возвращает страницу логов запросов (новые первыми)
+// и курсор следующей страницы (0 — страниц больше нет)
+func (p *PostgresStore) GetRequestLogsPage(filter RequestLogFilter, page Page) ([]RequestLog, int64, error) {
+	limit := page.size(100)
+	query := `SELECT id, COALESCE(session_id, ''), COALESCE(user_id, ''), COALESCE(provider, ''), COALESCE(model, ''), COALESCE(reasoning_mode, ''), request_json, COALESCE(response_json, ''), COALESCE(status_code, 0),
+COALESCE(duration_ms, 0), tokens_input, tokens_output, tokens_total, cost, created_at FROM request_logs`
+
+	where, args := filter.conditions()
+	pageWhere, pageArgs := page.conditions("id")
+	where, args = append(where, pageWhere...), append(args, pageArgs...)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY " + page.order("id") + " LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := p.query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка получения логов: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []RequestLog
+	for rows.Next() {
+		var log RequestLog
+		if err := rows.Scan(&log.ID, &log.SessionID, &log.UserID, &log.Provider, &log.Model, &log.ReasoningMode, &log.RequestJSON, &log.ResponseJSON, &log.StatusCode, &log.DurationMs,
+			&log.TokensInput, &log.TokensOutput, &log.TokensTotal, &log.Cost, &log.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("ошибка сканирования лога: %w", err)
+		}
+		logs = append(logs, log)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ошибка чтения логов: %w", err)
+	}
+
+	var next int64
+	if len(logs) > limit {
+		logs = logs[:limit]
+		next = logs[limit-1].ID
+	}
+	if !page.Newest {
+		slices.Reverse(logs)
+	}
+	return logs, next, nil
+}
+
+// SaveAgentStep сохраняет шаг агента
+func (p *PostgresStore) SaveAgentStep(step *AgentStep) error {
+	err := p.queryRow(
+		`INSERT INTO agent_steps (run_id, session_id, step, kind, tool_name, tool_call_id, arguments, content, error, duration_ms)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id, created_at`,
+		step.RunID, step.SessionID, step.Step, step.Kind, step.ToolName, step.ToolCallID, step.Arguments, step.Content, step.Error, step.DurationMs,
+	).Scan(&step.ID, &step.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения шага агента: %w", err)
+	}
+	return nil
+}
+
+// GetAgentSteps возвращает шаги запуска агента по порядку
+func (p *PostgresStore) GetAgentSteps(runID string) ([]AgentStep, error) {
+	rows, err := p.query(
+		`SELECT id, run_id, session_id, step, kind, COALESCE(tool_name, ''), COALESCE(tool_call_id, ''),
+COALESCE(arguments, ''), content, COALESCE(error, ''), COALESCE(duration_ms, 0), created_at
+FROM agent_steps WHERE run_id = ? ORDER BY id ASC`,
+		runID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения шагов агента: %w", err)
+	}
+	defer rows.Close()
+
+	var steps []AgentStep
+	for rows.Next() {
+		var st AgentStep
+		if err := rows.Scan(&st.ID, &st.RunID, &st.SessionID, &st.Step, &st.Kind, &st.ToolName, &st.ToolCallID,
+			&st.Arguments, &st.Content, &st.Error, &st.DurationMs, &st.CreatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования шага агента: %w", err)
+		}
+		steps = append(steps, st)
+	}
+	return steps, rows.Err()
+}
+
+// Close закрывает пул соединений
+func (p *PostgresStore) Close() error {
+	p.pool.Close()
+	return nil
+}
+
+// RequestStats возвращает статистику логов запросов
+func (p *PostgresStore) RequestStats(q StatsQuery) (*Stats, error) {
+	return requestStats(q, postgresStats, func(query string, args []interface{}, scan func(statsRows) error) error {
+		rows, err := p.query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		return scan(rows)
+	})
+}
+
+// postgresStats диалект статистики PostgreSQL
+var postgresStats = statsDialect{
+	day:     "to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD')",
+	timeArg: func(t time.Time) interface{} { return t },
+}
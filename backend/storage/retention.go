@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQL очистки общий для SQLite и PostgreSQL (в PostgreSQL — через rebind)
+const (
+	deleteLogsBeforeSQL = "DELETE FROM request_logs WHERE created_at < ?"
+
+	// Оставляет keep самых новых логов (OFFSET keep-1 — id самого старого из них)
+	trimLogsSQL = "DELETE FROM request_logs WHERE id < (SELECT id FROM request_logs ORDER BY id DESC LIMIT 1 OFFSET ?)"
+
+	// Ссылки ответов на удаленные логи запросов
+	unlinkDeletedLogsSQL = `UPDATE messages SET request_log_id = NULL
+WHERE request_log_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM request_logs l WHERE l.id = messages.request_log_id)`
+)
+
+// inactiveSessionsWhere условие на sessions для DeleteInactiveSessions и его аргументы
+func inactiveSessionsWhere(cutoff interface{}, keep []string) (string, []interface{}) {
+	where := "updated_at < ?"
+	args := []interface{}{cutoff}
+	if len(keep) > 0 {
+		where += " AND id NOT IN (?" + strings.Repeat(", ?", len(keep)-1) + ")"
+		for _, id := range keep {
+			args = append(args, id)
+		}
+	}
+	return where, args
+}
+
+// DeleteRequestLogsBefore удаляет логи запросов старше before и возвращает число удаленных
+func (s *Storage) DeleteRequestLogsBefore(before time.Time) (int64, error) {
+	return s.deleteRequestLogs(deleteLogsBeforeSQL, before.UTC().Format(sqlTimeLayout))
+}
+
+// TrimRequestLogs оставляет keep самых новых логов запросов и возвращает число удаленных
+func (s *Storage) TrimRequestLogs(keep int) (int64, error) {
+	if keep <= 0 {
+		return 0, fmt.Errorf("keep должен быть > 0")
+	}
+	return s.deleteRequestLogs(trimLogsSQL, keep-1)
+}
+
+func (s *Storage) deleteRequestLogs(query string, args ...interface{}) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка удаления логов: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка удаления логов: %w", err)
+	}
+	if n > 0 {
+		if _, err := tx.Exec(unlinkDeletedLogsSQL); err != nil {
+			return 0, fmt.Errorf("ошибка обновления ссылок на логи: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("ошибка удаления логов: %w", err)
+	}
+	return n, nil
+}
+
+// DeleteInactiveSessions удаляет сессии без активности (updated_at) с before, кроме keep,
+// вместе с сообщениями и шагами агентов, как DeleteSession. Возвращает число удаленных
+// сессий и сообщений.
+func (s *Storage) DeleteInactiveSessions(before time.Time, keep []string) (int64, int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	where, args := inactiveSessionsWhere(before.UTC().Format(sqlTimeLayout), keep)
+	result, err := tx.Exec("DELETE FROM messages WHERE session_id IN (SELECT id FROM sessions WHERE "+where+")", args...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка удаления сообщений сессий: %w", err)
+	}
+	messages, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка удаления сообщений сессий: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM agent_steps WHERE session_id IN (SELECT id FROM sessions WHERE "+where+")", args...); err != nil {
+		return 0, 0, fmt.Errorf("ошибка удаления шагов агента: %w", err)
+	}
+	result, err = tx.Exec("DELETE FROM sessions WHERE "+where, args...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка удаления сессий: %w", err)
+	}
+	sessions, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка удаления сессий: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("ошибка удаления сессий: %w", err)
+	}
+	return sessions, messages, nil
+}
+
+// Compact возвращает освободившееся место. Первый вызов переводит БД в режим
+// auto_vacuum = INCREMENTAL полным VACUUM, дальше достаточно incremental_vacuum.
+func (s *Storage) Compact() error {
+	var mode int
+	if err := s.db.QueryRow("PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return fmt.Errorf("ошибка чтения auto_vacuum: %w", err)
+	}
+
+	const incremental = 2
+	if mode != incremental {
+		if _, err := s.db.Exec("PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+			return fmt.Errorf("ошибка настройки auto_vacuum: %w", err)
+		}
+		if _, err := s.db.Exec("VACUUM"); err != nil {
+			return fmt.Errorf("ошибка VACUUM: %w", err)
+		}
+		return nil
+	}
+
+	if _, err := s.db.Exec("PRAGMA incremental_vacuum"); err != nil {
+		return fmt.Errorf("ошибка incremental_vacuum: %w", err)
+	}
+	return nil
+}
+
+// DeleteRequestLogsBefore удаляет логи запросов старше before и возвращает число удаленных
+func (p *PostgresStore) DeleteRequestLogsBefore(before time.Time) (int64, error) {
+	return p.deleteRequestLogs(deleteLogsBeforeSQL, before)
+}
+
+// TrimRequestLogs оставляет keep самых новых логов запросов и возвращает число удаленных
+func (p *PostgresStore) TrimRequestLogs(keep int) (int64, error) {
+	if keep <= 0 {
+		return 0, fmt.Errorf("keep должен быть > 0")
+	}
+	return p.deleteRequestLogs(trimLogsSQL, keep-1)
+}
+
+func (p *PostgresStore) deleteRequestLogs(query string, args ...interface{}) (int64, error) {
+	ctx := context.Background()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, rebind(query), args...)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка удаления логов: %w", err)
+	}
+	if tag.RowsAffected() > 0 {
+		if _, err := tx.Exec(ctx, unlinkDeletedLogsSQL); err != nil {
+			return 0, fmt.Errorf("ошибка обновления ссылок на логи: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("ошибка удаления логов: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// DeleteInactiveSessions удаляет сессии без активности (updated_at) с before, кроме keep,
+// вместе с сообщениями и шагами агентов, как DeleteSession. Возвращает число удаленных
+// сессий и сообщений.
+func (p *PostgresStore) DeleteInactiveSessions(before time.Time, keep []string) (int64, int64, error) {
+	ctx := context.Background()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	where, args := inactiveSessionsWhere(before, keep)
+	messages, err := tx.Exec(ctx, rebind("DELETE FROM messages WHERE session_id IN (SELECT id FROM sessions WHERE "+where+")"), args...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка удаления сообщений сессий: %w", err)
+	}
+	if _, err := tx.Exec(ctx, rebind("DELETE FROM agent_steps WHERE session_id IN (SELECT id FROM sessions WHERE "+where+")"), args...); err != nil {
+		return 0, 0, fmt.Errorf("ошибка удаления шагов агента: %w", err)
+	}
+	sessions, err := tx.Exec(ctx, rebind("DELETE FROM sessions WHERE "+where), args...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ошибка удаления сессий: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, fmt.Errorf("ошибка удаления сессий: %w", err)
+	}
+	return sessions.RowsAffected(), messages.RowsAffected(), nil
+}
+
+// Compact помечает место удаленных строк для повторного использования (VACUUM ANALYZE)
+func (p *PostgresStore) Compact() error {
+	if _, err := p.pool.Exec(context.Background(), "VACUUM ANALYZE request_logs, messages, agent_steps, sessions"); err != nil {
+		return fmt.Errorf("ошибка VACUUM: %w", err)
+	}
+	return nil
+}
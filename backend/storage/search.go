@@ -0,0 +1,341 @@
+package storage
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Полнотекстовый поиск по сообщениям всех сессий. Индекс messages_fts (FTS5) доступен,
+// только если драйвер собран с тегом sqlite_fts5; без него поиск работает через LIKE.
+
+// Способы поиска (Store.SearchMode)
+const (
+	SearchModeFTS5     = "fts5"     // SQLite с индексом FTS5
+	SearchModeLike     = "like"     // SQLite без FTS5: подстрока, регистр без учета только для ASCII
+	SearchModePostgres = "postgres" // полнотекстовый поиск PostgreSQL
+)
+
+// Маркеры найденных слов в SearchResult.Snippet
+const (
+	HighlightStart = "<mark>"
+	HighlightEnd   = "</mark>"
+)
+
+// Маркеры, которые расставляют snippet() и ts_headline: символы из области частного
+// использования Unicode переживают экранирование HTML и заменяются на HighlightStart/HighlightEnd после него
+const (
+	rawHighlightStart = "\uE000"
+	rawHighlightEnd   = "\uE001"
+)
+
+var rawHighlightReplacer = strings.NewReplacer(rawHighlightStart, HighlightStart, rawHighlightEnd, HighlightEnd)
+
+const (
+	snippetTokens  = 16  // слов в сниппете FTS5
+	snippetRunes   = 160 // длина сниппета без FTS5
+	snippetContext = 40  // символов перед первым совпадением
+	sqlTimeLayout  = "2006-01-02 15:04:05"
+)
+
+// searchTriggers триггеры синхронизации messages_fts с messages
+var searchTriggers = []string{"messages_fts_ai", "messages_fts_ad", "messages_fts_au"}
+
+const searchIndexSQL = `
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+	content,
+	content='messages',
+	content_rowid='id',
+	tokenize='unicode61 remove_diacritics 2'
+);
+CREATE TRIGGER IF NOT EXISTS messages_fts_ai AFTER INSERT ON messages BEGIN
+	INSERT INTO messages_fts(rowid, content) VALUES (new.id, new.content);
+END;
+CREATE TRIGGER IF NOT EXISTS messages_fts_ad AFTER DELETE ON messages BEGIN
+	INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+END;
+CREATE TRIGGER IF NOT EXISTS messages_fts_au AFTER UPDATE OF content ON messages BEGIN
+	INSERT INTO messages_fts(messages_fts, rowid, content) VALUES ('delete', old.id, old.content);
+	INSERT INTO messages_fts(rowid, content) VALUES (new.id, new.content);
+END;
+`
+
+// SearchQuery параметры поиска
+type SearchQuery struct {
+	Query     string
+	SessionID string    // "" — все сессии
+	UserID    string    // "" — сессии всех пользователей
+	Role      string    // "" — все роли
+	From      time.Time // нулевое — без ограничения
+	To        time.Time // не включается; нулевое — без ограничения
+	BeforeID  int64     // курсор: только сообщения с id < BeforeID (0 — с начала)
+	Limit     int
+}
+
+// SearchResult найденное сообщение
+type SearchResult struct {
+	MessageID    int64     `json:"message_id"`
+	SessionID    string    `json:"session_id"`
+	SessionTitle string    `json:"session_title"`
+	Role         string    `json:"role"`
+	Snippet      string    `json:"snippet"` // HTML: текст экранирован, найденные слова в <mark>
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// migrateSearch создает индекс FTS5 с триггерами. Индекс заполняется заново, если триггеров
+// не было: новая БД, старая БД или запуски сборкой без FTS5, пока индекс не обновлялся.
+func (s *Storage) migrateSearch() error {
+	var enabled bool
+	if err := s.db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return fmt.Errorf("ошибка проверки поддержки FTS5: %w", err)
+	}
+
+	var triggers int
+	if err := s.db.QueryRow(
+		"SELECT COUNT(1) FROM sqlite_master WHERE type = 'trigger' AND name IN (?, ?, ?)",
+		searchTriggers[0], searchTriggers[1], searchTriggers[2],
+	).Scan(&triggers); err != nil {
+		return fmt.Errorf("ошибка проверки триггеров поиска: %w", err)
+	}
+
+	if !enabled {
+		// Триггеры пишут в messages_fts: без модуля fts5 на них падала бы каждая вставка
+		for _, name := range searchTriggers {
+			if _, err := s.db.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+				return fmt.Errorf("ошибка удаления триггера %s: %w", name, err)
+			}
+		}
+		s.fts = false
+		return nil
+	}
+
+	if _, err := s.db.Exec(searchIndexSQL); err != nil {
+		return fmt.Errorf("ошибка создания индекса поиска: %w", err)
+	}
+	if triggers < len(searchTriggers) {
+		if _, err := s.db.Exec("INSERT INTO messages_fts(messages_fts) VALUES ('rebuild')"); err != nil {
+			return fmt.Errorf("ошибка заполнения индекса поиска: %w", err)
+		}
+	}
+	s.fts = true
+	return nil
+}
+
+// SearchMode способ поиска: fts5 или like
+func (s *Storage) SearchMode() string {
+	if s.fts {
+		return SearchModeFTS5
+	}
+	return SearchModeLike
+}
+
+// Search ищет сообщения, содержащие все слова запроса, самые новые первыми.
+// Возвращает курсор следующей страницы (0 — страниц больше нет).
+func (s *Storage) Search(q SearchQuery) ([]SearchResult, int64, error) {
+	terms := searchTerms(q.Query)
+	if len(terms) == 0 {
+		return []SearchResult{}, 0, nil
+	}
+	if q.Limit <= 0 {
+		q.Limit = 20
+	}
+
+	var where []string
+	var args []interface{}
+	if s.fts {
+		where = append(where, "messages_fts MATCH ?")
+		args = append(args, ftsQuery(terms))
+	} else {
+		escaper := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+		for _, t := range terms {
+			where = append(where, `m.content LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+escaper.Replace(t)+"%")
+		}
+	}
+	if q.SessionID != "" {
+		where = append(where, "m.session_id = ?")
+		args = append(args, q.SessionID)
+	}
+	if q.UserID != "" {
+		where = append(where, "s.user_id = ?")
+		args = append(args, q.UserID)
+	}
+	if q.Role != "" {
+		where = append(where, "m.role = ?")
+		args = append(args, q.Role)
+	}
+	if !q.From.IsZero() {
+		where = append(where, "m.created_at >= ?")
+		args = append(args, q.From.UTC().Format(sqlTimeLayout))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "m.created_at < ?")
+		args = append(args, q.To.UTC().Format(sqlTimeLayout))
+	}
+	if q.BeforeID > 0 {
+		where = append(where, "m.id < ?")
+		args = append(args, q.BeforeID)
+	}
+	args = append(args, q.Limit+1)
+
+	var query string
+	if s.fts {
+		query = fmt.Sprintf(`SELECT m.id, m.session_id, COALESCE(s.title, ''), m.role,
+snippet(messages_fts, 0, '%s', '%s', '…', %d), m.created_at
+FROM messages_fts JOIN messages m ON m.id = messages_fts.rowid
+LEFT JOIN sessions s ON s.id = m.session_id
+WHERE %s ORDER BY m.id DESC LIMIT ?`, rawHighlightStart, rawHighlightEnd, snippetTokens, strings.Join(where, " AND "))
+	} else {
+		query = `SELECT m.id, m.session_id, COALESCE(s.title, ''), m.role, m.content, m.created_at
+FROM messages m LEFT JOIN sessions s ON s.id = m.session_id
+WHERE ` + strings.Join(where, " AND ") + ` ORDER BY m.id DESC LIMIT ?`
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ошибка поиска сообщений: %w", err)
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.MessageID, &r.SessionID, &r.SessionTitle, &r.Role, &r.Snippet, &r.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("ошибка сканирования результата поиска: %w", err)
+		}
+		if s.fts {
+			r.Snippet = markSnippet(r.Snippet)
+		} else {
+			r.Snippet = highlightSnippet(r.Snippet, terms)
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ошибка чтения результатов поиска: %w", err)
+	}
+
+	var next int64
+	if len(results) > q.Limit {
+		results = results[:q.Limit]
+		next = results[len(results)-1].MessageID
+	}
+	return results, next, nil
+}
+
+// searchTerms разбивает запрос на слова; кавычки убираются, чтобы не ломать синтаксис FTS5
+func searchTerms(query string) []string {
+	var terms []string
+	for _, f := range strings.Fields(query) {
+		f = strings.ReplaceAll(f, `"`, "")
+		if f != "" {
+			terms = append(terms, f)
+		}
+	}
+	return terms
+}
+
+// ftsQuery превращает слова в запрос FTS5: каждое слово — фраза с поиском по префиксу,
+// все слова обязательны
+func ftsQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = `"` + t + `"*`
+	}
+	return strings.Join(parts, " ")
+}
+
+// markSnippet экранирует HTML в сниппете с маркерами rawHighlightStart/rawHighlightEnd
+// и заменяет их на <mark>: текст сообщения не должен попасть в разметку страницы
+func markSnippet(raw string) string {
+	return rawHighlightReplacer.Replace(html.EscapeString(raw))
+}
+
+// highlightSnippet вырезает фрагмент текста вокруг первого совпадения, экранирует HTML
+// и отмечает все вхождения слов (без учета регистра)
+func highlightSnippet(content string, terms []string) string {
+	text := []rune(content)
+	lower := lowerRunes(text)
+	needles := make([][]rune, 0, len(terms))
+	for _, t := range terms {
+		needles = append(needles, lowerRunes([]rune(t)))
+	}
+
+	// Первое совпадение определяет окно сниппета
+	first := len(text)
+	for _, n := range needles {
+		if i := indexRunes(lower, n); i >= 0 && i < first {
+			first = i
+		}
+	}
+	if first == len(text) {
+		first = 0
+	}
+	start := first - snippetContext
+	if start < 0 {
+		start = 0
+	}
+	end := start + snippetRunes
+	if end > len(text) {
+		end = len(text)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	plain := start // начало текста без совпадений, еще не записанного в b
+	for i := start; i < end; {
+		matched := 0
+		for _, n := range needles {
+			if len(n) > matched && i+len(n) <= end && indexRunes(lower[i:i+len(n)], n) == 0 {
+				matched = len(n)
+			}
+		}
+		if matched == 0 {
+			i++
+			continue
+		}
+		b.WriteString(html.EscapeString(string(text[plain:i])))
+		b.WriteString(HighlightStart)
+		b.WriteString(html.EscapeString(string(text[i : i+matched])))
+		b.WriteString(HighlightEnd)
+		i += matched
+		plain = i
+	}
+	b.WriteString(html.EscapeString(string(text[plain:end])))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// lowerRunes переводит символы в нижний регистр, сохраняя длину (позиции совпадают с исходным текстом)
+func lowerRunes(rs []rune) []rune {
+	lower := make([]rune, len(rs))
+	for i, r := range rs {
+		lower[i] = unicode.ToLower(r)
+	}
+	return lower
+}
+
+// indexRunes ищет needle в hay; -1 — не найдено
+func indexRunes(hay, needle []rune) int {
+	if len(needle) == 0 {
+		return -1
+	}
+	for i := 0; i+len(needle) <= len(hay); i++ {
+		match := true
+		for j, r := range needle {
+			if hay[i+j] != r {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
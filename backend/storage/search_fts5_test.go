@@ -0,0 +1,38 @@
+//go:build sqlite_fts5
+
+package storage
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Сборка с тегом sqlite_fts5 (так собирается сервер в CI и deploy.sh) обязана включать FTS5:
+// иначе поиск молча откатывается на LIKE
+func TestSearchModeFTS5(t *testing.T) {
+	s, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if got := s.SearchMode(); got != SearchModeFTS5 {
+		t.Fatalf("SearchMode = %q, ожидался %q", got, SearchModeFTS5)
+	}
+
+	if _, err := s.SaveMessage("s1", RoleUser, "Как настроить ПОЛНОТЕКСТОВЫЙ поиск?"); err != nil {
+		t.Fatal(err)
+	}
+	// В отличие от LIKE, FTS5 (unicode61) не учитывает регистр кириллицы
+	results, next, err := s.Search(SearchQuery{Query: "полнотекстовый", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || next != 0 {
+		t.Fatalf("найдено %d (next %d), ожидалось 1", len(results), next)
+	}
+	if want := HighlightStart + "ПОЛНОТЕКСТОВЫЙ" + HighlightEnd; !strings.Contains(results[0].Snippet, want) {
+		t.Errorf("сниппет %q без подсветки %q", results[0].Snippet, want)
+	}
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Session сессия чата: название и настройки по умолчанию для запросов без явных параметров
+type Session struct {
+	ID            string    `json:"id"`
+	UserID        string    `json:"user_id,omitempty"` // владелец ("" — создана без авторизации)
+	Title         string    `json:"title"`
+	Provider      string    `json:"provider,omitempty"`
+	Model         string    `json:"model,omitempty"`
+	SystemPrompt  string    `json:"system_prompt,omitempty"`
+	ReasoningMode string    `json:"reasoning_mode,omitempty"`
+	MessageCount  int       `json:"message_count"`            // user/assistant сообщения (все ветки)
+	ActiveLeafID  int64     `json:"active_leaf_id,omitempty"` // конец активной ветки
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// SessionPatch изменяемые поля сессии (nil — не менять)
+type SessionPatch struct {
+	Title         *string `json:"title,omitempty"`
+	Provider      *string `json:"provider,omitempty"`
+	Model         *string `json:"model,omitempty"`
+	SystemPrompt  *string `json:"system_prompt,omitempty"`
+	ReasoningMode *string `json:"reasoning_mode,omitempty"`
+}
+
+// assignments SET-выражения для заданных полей (пусто — менять нечего)
+func (patch SessionPatch) assignments() ([]string, []interface{}) {
+	var sets []string
+	var args []interface{}
+	add := func(column string, value *string) {
+		if value != nil {
+			sets = append(sets, column+" = ?")
+			args = append(args, strings.TrimSpace(*value))
+		}
+	}
+	add("title", patch.Title)
+	add("provider", patch.Provider)
+	add("model", patch.Model)
+	add("system_prompt", patch.SystemPrompt)
+	add("reasoning_mode", patch.ReasoningMode)
+
+	if len(sets) > 0 {
+		sets = append(sets, "updated_at = CURRENT_TIMESTAMP")
+	}
+	return sets, args
+}
+
+const sessionColumns = `s.id, s.title, COALESCE(s.provider, ''), COALESCE(s.model, ''), COALESCE(s.system_prompt, ''),
+COALESCE(s.reasoning_mode, ''),
+(SELECT COUNT(1) FROM messages m WHERE m.session_id = s.id AND m.role IN ('user', 'assistant')),
+COALESCE(s.active_leaf_id, 0), s.created_at, s.updated_at, COALESCE(s.user_id, '')`
+
+func scanSession(row interface{ Scan(...interface{}) error }) (Session, error) {
+	var sess Session
+	err := row.Scan(&sess.ID, &sess.Title, &sess.Provider, &sess.Model, &sess.SystemPrompt,
+		&sess.ReasoningMode, &sess.MessageCount, &sess.ActiveLeafID, &sess.CreatedAt, &sess.UpdatedAt, &sess.UserID)
+	return sess, err
+}
+
+// touchSession создает сессию, если ее нет, и обновляет updated_at
+func (s *Storage) touchSession(sessionID string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO sessions (id) VALUES (?)
+ON CONFLICT(id) DO UPDATE SET updated_at = CURRENT_TIMESTAMP`,
+		sessionID,
+	)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления сессии: %w", err)
+	}
+	return nil
+}
+
+// GetSession возвращает сессию или nil, если ее нет
+func (s *Storage) GetSession(sessionID string) (*Session, error) {
+	row := s.db.QueryRow("SELECT "+sessionColumns+" FROM sessions s WHERE s.id = ?", sessionID)
+	sess, err := scanSession(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения сессии: %w", err)
+	}
+	return &sess, nil
+}
+
+// ListSessions возвращает сессии пользователя userID ("" — всех пользователей), недавно обновленные первыми
+func (s *Storage) ListSessions(userID string, limit int) ([]Session, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	query, args := listSessionsSQL(userID, limit)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения сессий: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования сессии: %w", err)
+		}
+		sessions = append(sessions, sess)
+	}
+
+	return sessions, rows.Err()
+}
+
+// listSessionsSQL запрос ListSessions
+func listSessionsSQL(userID string, limit int) (string, []interface{}) {
+	query := "SELECT " + sessionColumns + " FROM sessions s"
+	var args []interface{}
+	if userID != "" {
+		query += " WHERE s.user_id = ?"
+		args = append(args, userID)
+	}
+	return query + " ORDER BY s.updated_at DESC, s.id DESC LIMIT ?", append(args, limit)
+}
+
+// UpdateSession меняет поля сессии, заданные в patch. Возвращает nil, если сессии нет.
+func (s *Storage) UpdateSession(sessionID string, patch SessionPatch) (*Session, error) {
+	sets, args := patch.assignments()
+	if len(sets) > 0 {
+		args = append(args, sessionID)
+		if _, err := s.db.Exec("UPDATE sessions SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...); err != nil {
+			return nil, fmt.Errorf("ошибка обновления сессии: %w", err)
+		}
+	}
+
+	return s.GetSession(sessionID)
+}
+
+// SetSessionTitleIfEmpty задает название, если пользователь еще не назвал сессию.
+// Возвращает true, если название записано.
+func (s *Storage) SetSessionTitleIfEmpty(sessionID, title string) (bool, error) {
+	result, err := s.db.Exec("UPDATE sessions SET title = ? WHERE id = ? AND title = ''", title, sessionID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка сохранения названия сессии: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("ошибка сохранения названия сессии: %w", err)
+	}
+	return n > 0, nil
+}
+
+// DeleteSession удаляет сессию вместе с сообщениями и шагами агентов (логи запросов остаются).
+// Возвращает false, если сессии не было.
+func (s *Storage) DeleteSession(sessionID string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM messages WHERE session_id = ?", sessionID); err != nil {
+		return false, fmt.Errorf("ошибка удаления сообщений сессии: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM agent_steps WHERE session_id = ?", sessionID); err != nil {
+		return false, fmt.Errorf("ошибка удаления шагов агента: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM sessions WHERE id = ?", sessionID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка удаления сессии: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("ошибка удаления сессии: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("ошибка удаления сессии: %w", err)
+	}
+	return n > 0, nil
+}
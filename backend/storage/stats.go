@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Измерения группировки статистики логов запросов
+const (
+	StatsByProvider = "provider"
+	StatsByModel    = "model"
+	StatsByDay      = "day" // день created_at в UTC
+)
+
+// StatsQuery параметры статистики логов запросов
+type StatsQuery struct {
+	From     time.Time // нулевое — без ограничения
+	To       time.Time // не включается; нулевое — без ограничения
+	Provider string
+	Model    string
+	UserID   string   // "" — логи всех пользователей
+	GroupBy  []string // StatsByProvider, StatsByModel, StatsByDay; пусто — только итог
+}
+
+// StatsRow агрегаты группы логов. Ошибка — статус ответа 400 и выше.
+type StatsRow struct {
+	Provider     string  `json:"provider,omitempty"`
+	Model        string  `json:"model,omitempty"`
+	Day          string  `json:"day,omitempty"`
+	Requests     int     `json:"requests"`
+	Errors       int     `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	LatencyP50Ms int64   `json:"latency_p50_ms"`
+	LatencyP95Ms int64   `json:"latency_p95_ms"`
+	TokensInput  int64   `json:"tokens_input"`
+	TokensOutput int64   `json:"tokens_output"`
+	TokensTotal  int64   `json:"tokens_total"`
+	Cost         float64 `json:"cost"`
+}
+
+// Stats статистика логов запросов: группы и итог по всем логам выборки
+type Stats struct {
+	Groups []StatsRow `json:"groups"`
+	Total  StatsRow   `json:"total"`
+}
+
+// statsDialect различия SQL статистики между SQLite и PostgreSQL
+type statsDialect struct {
+	day     string                      // день created_at в UTC в формате YYYY-MM-DD
+	timeArg func(time.Time) interface{} // граница периода в формате драйвера
+}
+
+// statsRows строки выборки (database/sql и pgx)
+type statsRows interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+}
+
+// statsQueryFunc выполняет запрос и передает строки в scan
+type statsQueryFunc func(query string, args []interface{}, scan func(statsRows) error) error
+
+// requestStats считает статистику в БД: счетчики и суммы — GROUP BY, перцентили задержки —
+// оконными функциями, так что из БД читается по строке (и по две для перцентилей) на группу
+func requestStats(q StatsQuery, d statsDialect, query statsQueryFunc) (*Stats, error) {
+	where, args := statsWhere(q, d)
+
+	stats := &Stats{Groups: []StatsRow{}}
+	if len(q.GroupBy) > 0 {
+		groups, err := statsGroups(q.GroupBy, where, args, d, query)
+		if err != nil {
+			return nil, err
+		}
+		stats.Groups = groups
+	}
+	total, err := statsGroups(nil, where, args, d, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(total) > 0 {
+		stats.Total = total[0]
+	}
+	return stats, nil
+}
+
+// statsWhere условие выборки логов и его аргументы
+func statsWhere(q StatsQuery, d statsDialect) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if !q.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, d.timeArg(q.From))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, d.timeArg(q.To))
+	}
+	if q.Provider != "" {
+		where = append(where, "provider = ?")
+		args = append(args, q.Provider)
+	}
+	if q.Model != "" {
+		where = append(where, "model = ?")
+		args = append(args, q.Model)
+	}
+	if q.UserID != "" {
+		where = append(where, "user_id = ?")
+		args = append(args, q.UserID)
+	}
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+// statsGroupColumns выражения измерений группировки в порядке groupBy
+func statsGroupColumns(groupBy []string, d statsDialect) []string {
+	columns := make([]string, 0, len(groupBy))
+	for _, by := range groupBy {
+		switch by {
+		case StatsByProvider:
+			columns = append(columns, "COALESCE(provider, '')")
+		case StatsByModel:
+			columns = append(columns, "COALESCE(model, '')")
+		case StatsByDay:
+			columns = append(columns, d.day)
+		}
+	}
+	return columns
+}
+
+// scanGroupKey читает значения измерений и раскладывает их по полям ключа группы
+func scanGroupKey(rows statsRows, groupBy []string, rest ...interface{}) (StatsRow, error) {
+	values := make([]string, len(groupBy))
+	dest := make([]interface{}, 0, len(groupBy)+len(rest))
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	if err := rows.Scan(append(dest, rest...)...); err != nil {
+		return StatsRow{}, err
+	}
+
+	var key StatsRow
+	for i, by := range groupBy {
+		switch by {
+		case StatsByProvider:
+			key.Provider = values[i]
+		case StatsByModel:
+			key.Model = values[i]
+		case StatsByDay:
+			key.Day = values[i]
+		}
+	}
+	return key, nil
+}
+
+// statsGroups агрегаты групп (groupBy пуст — одна строка итога), упорядоченные по дню,
+// провайдеру и модели
+func statsGroups(groupBy []string, where string, args []interface{}, d statsDialect, query statsQueryFunc) ([]StatsRow, error) {
+	columns := statsGroupColumns(groupBy, d)
+	selectGroup, groupClause, partition := "", "", ""
+	if len(columns) > 0 {
+		selectGroup = strings.Join(columns, ", ") + ", "
+		groupClause = " GROUP BY " + strings.Join(columns, ", ")
+		partition = "PARTITION BY " + strings.Join(columns, ", ") + " "
+	}
+
+	rows := []StatsRow{}
+	index := map[StatsRow]int{}
+	err := query("SELECT "+selectGroup+`COUNT(1),
+COALESCE(SUM(CASE WHEN status_code >= 400 THEN 1 ELSE 0 END), 0),
+COALESCE(SUM(tokens_input), 0), COALESCE(SUM(tokens_output), 0), COALESCE(SUM(tokens_total), 0), COALESCE(SUM(cost), 0)
+FROM request_logs`+where+groupClause, args, func(r statsRows) error {
+		for r.Next() {
+			var row StatsRow
+			key, err := scanGroupKey(r, groupBy, &row.Requests, &row.Errors,
+				&row.TokensInput, &row.TokensOutput, &row.TokensTotal, &row.Cost)
+			if err != nil {
+				return err
+			}
+			if row.Requests == 0 {
+				continue // итог пустой выборки
+			}
+			row.Provider, row.Model, row.Day = key.Provider, key.Model, key.Day
+			row.ErrorRate = float64(row.Errors) / float64(row.Requests)
+			index[key] = len(rows)
+			rows = append(rows, row)
+		}
+		return r.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения статистики: %w", err)
+	}
+	if len(rows) == 0 {
+		return rows, nil
+	}
+
+	// Перцентиль по ближайшему рангу: номер ceil(p*n) в группе, упорядоченной по задержке
+	err = query(`SELECT `+groupAliases(len(columns))+`rn, cnt, duration_ms FROM (
+SELECT `+aliasColumns(columns)+`COALESCE(duration_ms, 0) AS duration_ms,
+ROW_NUMBER() OVER (`+partition+`ORDER BY COALESCE(duration_ms, 0)) AS rn,
+COUNT(1) OVER (`+strings.TrimSpace(partition)+`) AS cnt
+FROM request_logs`+where+`
+) ranked WHERE rn = (50 * cnt + 99) / 100 OR rn = (95 * cnt + 99) / 100`, args, func(r statsRows) error {
+		for r.Next() {
+			var rn, cnt, duration int64
+			key, err := scanGroupKey(r, groupBy, &rn, &cnt, &duration)
+			if err != nil {
+				return err
+			}
+			i, ok := index[key]
+			if !ok {
+				continue
+			}
+			if rn == (50*cnt+99)/100 {
+				rows[i].LatencyP50Ms = duration
+			}
+			if rn == (95*cnt+99)/100 {
+				rows[i].LatencyP95Ms = duration
+			}
+		}
+		return r.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения перцентилей задержки: %w", err)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		return a.Model < b.Model
+	})
+	return rows, nil
+}
+
+// aliasColumns выражения измерений с псевдонимами g0, g1, ... для подзапроса
+func aliasColumns(columns []string) string {
+	var sb strings.Builder
+	for i, c := range columns {
+		fmt.Fprintf(&sb, "%s AS g%d, ", c, i)
+	}
+	return sb.String()
+}
+
+// groupAliases псевдонимы g0, g1, ... измерений подзапроса
+func groupAliases(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "g%d, ", i)
+	}
+	return sb.String()
+}
+
+// sqliteStats диалект статистики SQLite: created_at хранится текстом в UTC
+var sqliteStats = statsDialect{
+	day:     "COALESCE(date(created_at), '')",
+	timeArg: func(t time.Time) interface{} { return t.UTC().Format(sqlTimeLayout) },
+}
+
+// RequestStats возвращает статистику логов запросов
+func (s *Storage) RequestStats(q StatsQuery) (*Stats, error) {
+	return requestStats(q, sqliteStats, func(query string, args []interface{}, scan func(statsRows) error) error {
+		rows, err := s.db.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		return scan(rows)
+	})
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"database/sql"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -11,18 +12,59 @@ import (
 
 // Storage представляет хранилище данных
 type Storage struct {
-	db *sql.DB
+	db      *sql.DB
+	fts     bool        // индекс messages_fts доступен (сборка с тегом sqlite_fts5)
+	applied []Migration // миграции, примененные при открытии
 }
 
 // Message представляет сообщение чата
 type Message struct {
-	ID        int64     `json:"id"`
-	SessionID string    `json:"session_id"`
-	Role      string    `json:"role"`
-	Content   string    `json:"content"`
+	ID           int64  `json:"id"`
+	SessionID    string `json:"session_id"`
+	Role         string `json:"role"`
+	Content      string `json:"content"`
+	FinishReason string `json:"finish_reason,omitempty"` // stopped — ответ остановлен пользователем
+	ParentID     int64  `json:"parent_id,omitempty"`     // предыдущее сообщение ветки (0 — корень)
+	Provenance
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// Provenance происхождение ответа модели (пусто у сообщений пользователя и summary)
+type Provenance struct {
+	Provider      string   `json:"provider,omitempty"`
+	Model         string   `json:"model,omitempty"`
+	ReasoningMode string   `json:"reasoning_mode,omitempty"`
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TokensInput   *int     `json:"tokens_input,omitempty"`
+	TokensOutput  *int     `json:"tokens_output,omitempty"`
+	Cost          *float64 `json:"cost,omitempty"`
+	DurationMs    *int64   `json:"duration_ms,omitempty"`
+	RequestLogID  *int64   `json:"request_log_id,omitempty"` // строка request_logs с запросом, на который это ответ
+}
+
+// NewMessage параметры сохраняемого сообщения
+type NewMessage struct {
+	SessionID    string
+	Role         string
+	Content      string
+	FinishReason string
+	ParentID     *int64 // nil — продолжить активную ветку, 0 — новый корень
+	Provenance
+}
+
+// messageColumns колонки messages в порядке scanMessage
+const messageColumns = "id, session_id, role, content, COALESCE(finish_reason, ''), COALESCE(parent_id, 0), " +
+	"COALESCE(provider, ''), COALESCE(model, ''), COALESCE(reasoning_mode, ''), temperature, tokens_input, tokens_output, cost, duration_ms, request_log_id, created_at"
+
+// scanMessage читает строку, выбранную через messageColumns
+func scanMessage(row interface{ Scan(...interface{}) error }) (Message, error) {
+	var msg Message
+	err := row.Scan(&msg.ID, &msg.SessionID, &msg.Role, &msg.Content, &msg.FinishReason, &msg.ParentID,
+		&msg.Provider, &msg.Model, &msg.ReasoningMode, &msg.Temperature, &msg.TokensInput, &msg.TokensOutput, &msg.Cost, &msg.DurationMs, &msg.RequestLogID,
+		&msg.CreatedAt)
+	return msg, err
+}
+
 const (
 	RoleUser      = "user"
 	RoleAssistant = "assistant"
@@ -31,19 +73,113 @@ const (
 
 // RequestLog представляет лог запроса/ответа
 type RequestLog struct {
-	ID           int64     `json:"id"`
-	SessionID    string    `json:"session_id"`
-	RequestJSON  string    `json:"request_json"`
-	ResponseJSON string    `json:"response_json"`
-	StatusCode   int       `json:"status_code"`
-	DurationMs   int64     `json:"duration_ms"`
-	TokensInput  *int      `json:"tokens_input,omitempty"`
-	TokensOutput *int      `json:"tokens_output,omitempty"`
-	TokensTotal  *int      `json:"tokens_total,omitempty"`
-	Cost         *float64  `json:"cost,omitempty"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID            int64     `json:"id"`
+	SessionID     string    `json:"session_id"`
+	UserID        string    `json:"user_id,omitempty"`
+	Provider      string    `json:"provider,omitempty"`
+	Model         string    `json:"model,omitempty"`
+	ReasoningMode string    `json:"reasoning_mode,omitempty"`
+	RequestJSON   string    `json:"request_json"`
+	ResponseJSON  string    `json:"response_json"`
+	StatusCode    int       `json:"status_code"`
+	DurationMs    int64     `json:"duration_ms"`
+	TokensInput   *int      `json:"tokens_input,omitempty"`
+	TokensOutput  *int      `json:"tokens_output,omitempty"`
+	TokensTotal   *int      `json:"tokens_total,omitempty"`
+	Cost          *float64  `json:"cost,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// NewRequestLog параметры сохраняемого лога запроса
+type NewRequestLog struct {
+	SessionID     string
+	UserID        string // пользователь, выполнивший запрос ("" — без авторизации)
+	Provider      string // провайдер, фактически выполнивший запрос
+	Model         string
+	ReasoningMode string
+	RequestJSON   string
+	ResponseJSON  string
+	StatusCode    int
+	DurationMs    int64
+	TokensInput   *int
+	TokensOutput  *int
+	TokensTotal   *int
+	Cost          *float64
+}
+
+// requestLog лог запроса из параметров сохранения
+func (l NewRequestLog) requestLog() *RequestLog {
+	return &RequestLog{
+		SessionID:     l.SessionID,
+		UserID:        l.UserID,
+		Provider:      l.Provider,
+		Model:         l.Model,
+		ReasoningMode: l.ReasoningMode,
+		RequestJSON:   l.RequestJSON,
+		ResponseJSON:  l.ResponseJSON,
+		StatusCode:    l.StatusCode,
+		DurationMs:    l.DurationMs,
+		TokensInput:   l.TokensInput,
+		TokensOutput:  l.TokensOutput,
+		TokensTotal:   l.TokensTotal,
+		Cost:          l.Cost,
+	}
+}
+
+// requestLogInsertSQL вставка лога запроса (аргументы — NewRequestLog.args)
+const requestLogInsertSQL = `INSERT INTO request_logs (session_id, user_id, provider, model, reasoning_mode, request_json, response_json, status_code, duration_ms, tokens_input, tokens_output, tokens_total, cost)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// args значения колонок requestLogInsertSQL
+func (l NewRequestLog) args() []interface{} {
+	return []interface{}{
+		l.SessionID, nullString(l.UserID), nullString(l.Provider), nullString(l.Model), nullString(l.ReasoningMode),
+		l.RequestJSON, l.ResponseJSON, l.StatusCode, l.DurationMs, l.TokensInput, l.TokensOutput, l.TokensTotal, l.Cost,
+	}
+}
+
+// RequestLogFilter отбор логов запросов
+type RequestLogFilter struct {
+	UserID    string // "" — логи всех пользователей
+	SessionID string // "" — все сессии
+}
+
+// conditions условия WHERE и их аргументы
+func (f RequestLogFilter) conditions() ([]string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if f.UserID != "" {
+		where = append(where, "user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if f.SessionID != "" {
+		where = append(where, "session_id = ?")
+		args = append(args, f.SessionID)
+	}
+	return where, args
 }
 
+// AgentStep шаг агента: ответ модели или вызов инструмента
+type AgentStep struct {
+	ID         int64     `json:"id"`
+	RunID      string    `json:"run_id"`
+	SessionID  string    `json:"session_id"`
+	Step       int       `json:"step"`
+	Kind       string    `json:"kind"` // model, tool
+	ToolName   string    `json:"tool_name,omitempty"`
+	ToolCallID string    `json:"tool_call_id,omitempty"`
+	Arguments  string    `json:"arguments,omitempty"`
+	Content    string    `json:"content"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+const (
+	AgentStepModel = "model"
+	AgentStepTool  = "tool"
+)
+
 // New создает новое хранилище
 func New(dbPath string) (*Storage, error) {
 	db, err := sql.Open("sqlite3", dbPath)
@@ -66,212 +202,162 @@ func New(dbPath string) (*Storage, error) {
 	return s, nil
 }
 
-// migrate создает необходимые таблицы
-func (s *Storage) migrate() error {
-	// Таблица сообщений
-	messagesSQL := `
-	CREATE TABLE IF NOT EXISTS messages (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		session_id TEXT NOT NULL,
-		role TEXT NOT NULL CHECK(role IN ('user', 'assistant', 'summary')),
-		content TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	CREATE INDEX IF NOT EXISTS idx_messages_session ON messages(session_id);
-	CREATE INDEX IF NOT EXISTS idx_messages_created ON messages(created_at);
-	`
+// SaveMessage сохраняет сообщение в конец активной ветки
+func (s *Storage) SaveMessage(sessionID, role, content string) (*Message, error) {
+	return s.AddMessage(NewMessage{SessionID: sessionID, Role: role, Content: content})
+}
 
-	// Таблица логов запросов/ответов
-	requestLogsSQL := `
-	CREATE TABLE IF NOT EXISTS request_logs (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		session_id TEXT,
-		request_json TEXT NOT NULL,
-		response_json TEXT,
-		status_code INTEGER,
-		duration_ms INTEGER,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	CREATE INDEX IF NOT EXISTS idx_request_logs_session ON request_logs(session_id);
-	CREATE INDEX IF NOT EXISTS idx_request_logs_created ON request_logs(created_at);
-	`
+// SaveMessageWithFinishReason сохраняет сообщение с причиной завершения ответа
+func (s *Storage) SaveMessageWithFinishReason(sessionID, role, content, finishReason string) (*Message, error) {
+	return s.AddMessage(NewMessage{SessionID: sessionID, Role: role, Content: content, FinishReason: finishReason})
+}
 
-	if _, err := s.db.Exec(messagesSQL); err != nil {
-		return fmt.Errorf("ошибка создания таблицы messages: %w", err)
+// AddMessage сохраняет сообщение. User/assistant сообщения становятся концом активной ветки
+// сессии; ответ модели — только если конец ветки все еще указывает на его родителя.
+func (s *Storage) AddMessage(m NewMessage) (*Message, error) {
+	if err := s.touchSession(m.SessionID); err != nil {
+		return nil, err
 	}
 
-	// Миграция: расширяем CHECK(role) для messages (старые БД могли иметь только user/assistant)
-	if err := s.migrateMessagesRoleConstraint(); err != nil {
-		return fmt.Errorf("ошибка миграции роли messages: %w", err)
+	// Summary не входит в дерево сообщений; без явного родителя сообщение продолжает активную ветку
+	inTree := m.Role != RoleSummary
+	parentExpr := "?"
+	var parentArg interface{}
+	switch {
+	case !inTree:
+	case m.ParentID != nil:
+		parentArg = nullInt64(*m.ParentID)
+	default:
+		parentExpr = "(SELECT active_leaf_id FROM sessions WHERE id = ?)"
+		parentArg = m.SessionID
 	}
 
-	if _, err := s.db.Exec(requestLogsSQL); err != nil {
-		return fmt.Errorf("ошибка создания таблицы request_logs: %w", err)
+	args := append([]interface{}{m.SessionID, m.Role, m.Content, nullString(m.FinishReason)}, m.Provenance.args()...)
+	args = append(args, parentArg)
+
+	var id, parentID int64
+	err := s.db.QueryRow(
+		"INSERT INTO messages (session_id, role, content, finish_reason, "+provenanceColumns+", parent_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, "+parentExpr+") RETURNING id, COALESCE(parent_id, 0)",
+		args...,
+	).Scan(&id, &parentID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка сохранения сообщения: %w", err)
 	}
 
-	// Миграция: добавляем новые поля для токенов и стоимости
-	if err := s.migrateTokensFields(); err != nil {
-		return fmt.Errorf("ошибка миграции полей токенов: %w", err)
+	if inTree {
+		query, args := moveLeafQuery(m.Role, m.SessionID, id, parentID)
+		if _, err := s.db.Exec(query, args...); err != nil {
+			return nil, fmt.Errorf("ошибка обновления активной ветки: %w", err)
+		}
 	}
 
-	return nil
+	return &Message{
+		ID:           id,
+		SessionID:    m.SessionID,
+		Role:         m.Role,
+		Content:      m.Content,
+		FinishReason: m.FinishReason,
+		ParentID:     parentID,
+		Provenance:   m.Provenance,
+		CreatedAt:    time.Now(),
+	}, nil
 }
 
-// migrateTokensFields добавляет поля для токенов и стоимости в существующую таблицу
-func (s *Storage) migrateTokensFields() error {
-	// Проверяем существование колонок и добавляем их, если их нет
-	// SQLite не поддерживает IF NOT EXISTS для ALTER TABLE, поэтому используем проверку через PRAGMA
-	columns := []struct {
-		name string
-		sql  string
-	}{
-		{"tokens_input", "ALTER TABLE request_logs ADD COLUMN tokens_input INTEGER"},
-		{"tokens_output", "ALTER TABLE request_logs ADD COLUMN tokens_output INTEGER"},
-		{"tokens_total", "ALTER TABLE request_logs ADD COLUMN tokens_total INTEGER"},
-		{"cost", "ALTER TABLE request_logs ADD COLUMN cost REAL"},
-	}
-
-	for _, col := range columns {
-		// Проверяем существование колонки
-		rows, err := s.db.Query("PRAGMA table_info(request_logs)")
-		if err != nil {
-			return fmt.Errorf("ошибка проверки структуры таблицы: %w", err)
-		}
+// provenanceColumns колонки происхождения ответа в порядке Provenance.args
+const provenanceColumns = "provider, model, reasoning_mode, temperature, tokens_input, tokens_output, cost, duration_ms, request_log_id"
 
-		columnExists := false
-		for rows.Next() {
-			var cid int
-			var name string
-			var dataType string
-			var notNull int
-			var defaultValue interface{}
-			var pk int
-
-			if err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk); err != nil {
-				rows.Close()
-				return fmt.Errorf("ошибка сканирования структуры таблицы: %w", err)
-			}
-
-			if name == col.name {
-				columnExists = true
-				break
-			}
-		}
-		rows.Close()
-
-		// Добавляем колонку, если её нет
-		if !columnExists {
-			if _, err := s.db.Exec(col.sql); err != nil {
-				// Игнорируем ошибку, если колонка уже существует (может быть race condition)
-				if !strings.Contains(err.Error(), "duplicate column") {
-					return fmt.Errorf("ошибка добавления колонки %s: %w", col.name, err)
-				}
-			}
-		}
+// args значения колонок provenanceColumns
+func (p Provenance) args() []interface{} {
+	return []interface{}{
+		nullString(p.Provider), nullString(p.Model), nullString(p.ReasoningMode),
+		p.Temperature, p.TokensInput, p.TokensOutput, p.Cost, p.DurationMs, p.RequestLogID,
 	}
-
-	return nil
 }
 
-// migrateMessagesRoleConstraint пересоздает таблицу messages, если CHECK(role) не допускает summary.
-func (s *Storage) migrateMessagesRoleConstraint() error {
-	// Быстрая проверка: пробуем вставить роль summary в транзакции и откатываем.
-	tx, err := s.db.Begin()
-	if err != nil {
-		return fmt.Errorf("ошибка начала транзакции: %w", err)
-	}
-	if _, err := tx.Exec("INSERT INTO messages (session_id, role, content) VALUES (?, ?, ?)", "_role_probe_", RoleSummary, "probe"); err == nil {
-		_ = tx.Rollback()
+// nullString пустую строку пишет как NULL
+func nullString(v string) interface{} {
+	if v == "" {
 		return nil
 	}
-	_ = tx.Rollback()
-
-	// Пересоздаем таблицу, сохраняя данные.
-	// SQLite не позволяет ALTER CHECK-constraint, поэтому делаем copy+swap.
-	_, err = s.db.Exec(`
-BEGIN;
-CREATE TABLE IF NOT EXISTS messages_new (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	session_id TEXT NOT NULL,
-	role TEXT NOT NULL CHECK(role IN ('user', 'assistant', 'summary')),
-	content TEXT NOT NULL,
-	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-);
-INSERT INTO messages_new (id, session_id, role, content, created_at)
-SELECT id, session_id, role, content, created_at FROM messages;
-DROP TABLE messages;
-ALTER TABLE messages_new RENAME TO messages;
-CREATE INDEX IF NOT EXISTS idx_messages_session ON messages(session_id);
-CREATE INDEX IF NOT EXISTS idx_messages_created ON messages(created_at);
-COMMIT;
-`)
-	if err != nil {
-		// На всякий случай откатываем, если BEGIN уже прошел
-		_, _ = s.db.Exec("ROLLBACK;")
-		return fmt.Errorf("ошибка пересоздания таблицы messages: %w", err)
-	}
-	return nil
+	return v
 }
 
-// SaveMessage сохраняет сообщение
-func (s *Storage) SaveMessage(sessionID, role, content string) (*Message, error) {
-	result, err := s.db.Exec(
-		"INSERT INTO messages (session_id, role, content) VALUES (?, ?, ?)",
-		sessionID, role, content,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("ошибка сохранения сообщения: %w", err)
+// nullInt64 ноль пишет как NULL
+func nullInt64(v int64) interface{} {
+	if v == 0 {
+		return nil
 	}
+	return v
+}
 
-	id, err := result.LastInsertId()
-	if err != nil {
-		return nil, fmt.Errorf("ошибка получения ID: %w", err)
-	}
+// GetMessages возвращает первые limit сообщений активной ветки сессии вместе с summary
+func (s *Storage) GetMessages(sessionID string, limit int) ([]Message, error) {
+	messages, _, err := s.GetMessagesPage(sessionID, Page{Limit: limit}, true)
+	return messages, err
+}
 
-	return &Message{
-		ID:        id,
-		SessionID: sessionID,
-		Role:      role,
-		Content:   content,
-		CreatedAt: time.Now(),
-	}, nil
+// messagesPageQuery запрос страницы GetMessagesPage без LIMIT (его значение добавляет вызывающий).
+// Summary не входит в дерево сообщений и фильтруется в SQL, чтобы не занимать места на странице.
+func messagesPageQuery(sessionID string, page Page, withSummary bool) (string, []interface{}) {
+	query := activeBranchCTE + "SELECT " + messageColumns + " FROM messages WHERE session_id = ? AND "
+	args := []interface{}{sessionID, sessionID}
+	if withSummary {
+		query += "(role = ? OR id IN (SELECT id FROM branch))"
+		args = append(args, RoleSummary)
+	} else {
+		query += "id IN (SELECT id FROM branch)"
+	}
+	where, pageArgs := page.conditions("id")
+	for _, w := range where {
+		query += " AND " + w
+	}
+	return query + " ORDER BY " + page.order("id") + " LIMIT ?", append(args, pageArgs...)
 }
 
-// GetMessages возвращает сообщения сессии
-func (s *Storage) GetMessages(sessionID string, limit int) ([]Message, error) {
-	if limit <= 0 {
-		limit = 100
-	}
+// GetMessagesPage возвращает страницу сообщений активной ветки сессии (с summary, если withSummary)
+// по возрастанию id и курсор следующей страницы (0 — страниц больше нет)
+func (s *Storage) GetMessagesPage(sessionID string, page Page, withSummary bool) ([]Message, int64, error) {
+	limit := page.size(100)
+	query, args := messagesPageQuery(sessionID, page, withSummary)
+	args = append(args, limit+1)
 
-	rows, err := s.db.Query(
-		"SELECT id, session_id, role, content, created_at FROM messages WHERE session_id = ? ORDER BY id ASC LIMIT ?",
-		sessionID, limit,
-	)
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка получения сообщений: %w", err)
+		return nil, 0, fmt.Errorf("ошибка получения сообщений: %w", err)
 	}
 	defer rows.Close()
 
 	var messages []Message
 	for rows.Next() {
-		var msg Message
-		if err := rows.Scan(&msg.ID, &msg.SessionID, &msg.Role, &msg.Content, &msg.CreatedAt); err != nil {
-			return nil, fmt.Errorf("ошибка сканирования сообщения: %w", err)
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("ошибка сканирования сообщения: %w", err)
 		}
 		messages = append(messages, msg)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ошибка чтения сообщений: %w", err)
+	}
 
-	return messages, rows.Err()
+	var next int64
+	if len(messages) > limit {
+		messages = messages[:limit]
+		next = messages[limit-1].ID
+	}
+	if page.Newest {
+		slices.Reverse(messages)
+	}
+	return messages, next, nil
 }
 
 // GetLatestSummary возвращает последнее summary для сессии (если есть).
 func (s *Storage) GetLatestSummary(sessionID string) (*Message, error) {
 	row := s.db.QueryRow(
-		"SELECT id, session_id, role, content, created_at FROM messages WHERE session_id = ? AND role = ? ORDER BY id DESC LIMIT 1",
+		"SELECT "+messageColumns+" FROM messages WHERE session_id = ? AND role = ? ORDER BY id DESC LIMIT 1",
 		sessionID, RoleSummary,
 	)
-	var msg Message
-	if err := row.Scan(&msg.ID, &msg.SessionID, &msg.Role, &msg.Content, &msg.CreatedAt); err != nil {
+	msg, err := scanMessage(row)
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -280,11 +366,11 @@ func (s *Storage) GetLatestSummary(sessionID string) (*Message, error) {
 	return &msg, nil
 }
 
-// CountNonSummaryMessages возвращает количество user/assistant сообщений в сессии.
+// CountNonSummaryMessages возвращает количество user/assistant сообщений активной ветки сессии.
 func (s *Storage) CountNonSummaryMessages(sessionID string) (int, error) {
 	row := s.db.QueryRow(
-		"SELECT COUNT(1) FROM messages WHERE session_id = ? AND role IN (?, ?)",
-		sessionID, RoleUser, RoleAssistant,
+		activeBranchCTE+"SELECT COUNT(1) FROM messages WHERE session_id = ? AND role IN (?, ?) AND id IN (SELECT id FROM branch)",
+		sessionID, sessionID, RoleUser, RoleAssistant,
 	)
 	var cnt int
 	if err := row.Scan(&cnt); err != nil {
@@ -293,96 +379,104 @@ func (s *Storage) CountNonSummaryMessages(sessionID string) (int, error) {
 	return cnt, nil
 }
 
-// GetOldestNonSummaryMessages возвращает самые ранние user/assistant сообщения, исключая keepLast последних.
-func (s *Storage) GetOldestNonSummaryMessages(sessionID string, batchSize int, keepLast int) ([]Message, error) {
-	if batchSize <= 0 {
-		return nil, fmt.Errorf("batchSize должен быть > 0")
+// UpsertSummary создает или обновляет summary сообщение.
+func (s *Storage) UpsertSummary(sessionID string, content string) (*Message, error) {
+	existing, err := s.GetLatestSummary(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return s.SaveMessage(sessionID, RoleSummary, content)
+	}
+	if _, err := s.db.Exec("UPDATE messages SET content = ?, created_at = CURRENT_TIMESTAMP WHERE id = ?", content, existing.ID); err != nil {
+		return nil, fmt.Errorf("ошибка обновления summary: %w", err)
 	}
-	if keepLast < 0 {
-		keepLast = 0
+	existing.Content = content
+	existing.CreatedAt = time.Now()
+	return existing, nil
+}
+
+// SearchMessages ищет user/assistant сообщения сессии по подстроке (без учета регистра ASCII),
+// самые новые первыми
+func (s *Storage) SearchMessages(sessionID, query string, limit int) ([]Message, error) {
+	if limit <= 0 {
+		limit = 10
 	}
 
-	// Выбираем самые ранние сообщения из "головы", исключив keepLast последних по id.
+	// Экранируем спецсимволы LIKE
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query)
 	rows, err := s.db.Query(
-		`
-SELECT id, session_id, role, content, created_at
-FROM messages
-WHERE session_id = ?
-  AND role IN (?, ?)
-  AND id NOT IN (
-    SELECT id FROM messages
-    WHERE session_id = ?
-      AND role IN (?, ?)
-    ORDER BY id DESC
-    LIMIT ?
-  )
-ORDER BY id ASC
-LIMIT ?
-`,
-		sessionID, RoleUser, RoleAssistant,
-		sessionID, RoleUser, RoleAssistant, keepLast,
-		batchSize,
+		`SELECT `+messageColumns+` FROM messages
+WHERE session_id = ? AND role IN (?, ?) AND content LIKE ? ESCAPE '\'
+ORDER BY id DESC LIMIT ?`,
+		sessionID, RoleUser, RoleAssistant, "%"+escaped+"%", limit,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка получения сообщений для компрессии: %w", err)
+		return nil, fmt.Errorf("ошибка поиска сообщений: %w", err)
 	}
 	defer rows.Close()
 
-	var msgs []Message
+	var messages []Message
 	for rows.Next() {
-		var msg Message
-		if err := rows.Scan(&msg.ID, &msg.SessionID, &msg.Role, &msg.Content, &msg.CreatedAt); err != nil {
+		msg, err := scanMessage(rows)
+		if err != nil {
 			return nil, fmt.Errorf("ошибка сканирования сообщения: %w", err)
 		}
-		msgs = append(msgs, msg)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("ошибка чтения сообщений: %w", err)
+		messages = append(messages, msg)
 	}
-	return msgs, nil
+
+	return messages, rows.Err()
 }
 
-// UpsertSummary создает или обновляет summary сообщение.
-func (s *Storage) UpsertSummary(sessionID string, content string) (*Message, error) {
-	existing, err := s.GetLatestSummary(sessionID)
+// SaveAgentStep сохраняет шаг агента
+func (s *Storage) SaveAgentStep(step *AgentStep) error {
+	result, err := s.db.Exec(
+		`INSERT INTO agent_steps (run_id, session_id, step, kind, tool_name, tool_call_id, arguments, content, error, duration_ms)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		step.RunID, step.SessionID, step.Step, step.Kind, step.ToolName, step.ToolCallID, step.Arguments, step.Content, step.Error, step.DurationMs,
+	)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("ошибка сохранения шага агента: %w", err)
 	}
-	if existing == nil {
-		return s.SaveMessage(sessionID, RoleSummary, content)
-	}
-	if _, err := s.db.Exec("UPDATE messages SET content = ?, created_at = CURRENT_TIMESTAMP WHERE id = ?", content, existing.ID); err != nil {
-		return nil, fmt.Errorf("ошибка обновления summary: %w", err)
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("ошибка получения ID: %w", err)
 	}
-	existing.Content = content
-	existing.CreatedAt = time.Now()
-	return existing, nil
+	step.ID = id
+	step.CreatedAt = time.Now()
+	return nil
 }
 
-// DeleteMessagesByIDs удаляет сообщения по списку id (в рамках сессии).
-func (s *Storage) DeleteMessagesByIDs(sessionID string, ids []int64) error {
-	if len(ids) == 0 {
-		return nil
-	}
-	placeholders := strings.TrimRight(strings.Repeat("?,", len(ids)), ",")
-	args := make([]interface{}, 0, len(ids)+1)
-	args = append(args, sessionID)
-	for _, id := range ids {
-		args = append(args, id)
+// GetAgentSteps возвращает шаги запуска агента по порядку
+func (s *Storage) GetAgentSteps(runID string) ([]AgentStep, error) {
+	rows, err := s.db.Query(
+		`SELECT id, run_id, session_id, step, kind, COALESCE(tool_name, ''), COALESCE(tool_call_id, ''),
+COALESCE(arguments, ''), content, COALESCE(error, ''), COALESCE(duration_ms, 0), created_at
+FROM agent_steps WHERE run_id = ? ORDER BY id ASC`,
+		runID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения шагов агента: %w", err)
 	}
-	query := fmt.Sprintf("DELETE FROM messages WHERE session_id = ? AND id IN (%s)", placeholders)
-	if _, err := s.db.Exec(query, args...); err != nil {
-		return fmt.Errorf("ошибка удаления сообщений: %w", err)
+	defer rows.Close()
+
+	var steps []AgentStep
+	for rows.Next() {
+		var st AgentStep
+		if err := rows.Scan(&st.ID, &st.RunID, &st.SessionID, &st.Step, &st.Kind, &st.ToolName, &st.ToolCallID,
+			&st.Arguments, &st.Content, &st.Error, &st.DurationMs, &st.CreatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования шага агента: %w", err)
+		}
+		steps = append(steps, st)
 	}
-	return nil
+
+	return steps, rows.Err()
 }
 
 // SaveRequestLog сохраняет лог запроса
-func (s *Storage) SaveRequestLog(sessionID, requestJSON, responseJSON string, statusCode int, durationMs int64, tokensInput, tokensOutput, tokensTotal *int, cost *float64) (*RequestLog, error) {
-	result, err := s.db.Exec(
-		"INSERT INTO request_logs (session_id, request_json, response_json, status_code, duration_ms, tokens_input, tokens_output, tokens_total, cost) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		sessionID, requestJSON, responseJSON, statusCode, durationMs, tokensInput, tokensOutput, tokensTotal, cost,
-	)
+func (s *Storage) SaveRequestLog(entry NewRequestLog) (*RequestLog, error) {
+	result, err := s.db.Exec(requestLogInsertSQL, entry.args()...)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка сохранения лога: %w", err)
 	}
@@ -392,40 +486,36 @@ func (s *Storage) SaveRequestLog(sessionID, requestJSON, responseJSON string, st
 		return nil, fmt.Errorf("ошибка получения ID: %w", err)
 	}
 
-	return &RequestLog{
-		ID:           id,
-		SessionID:    sessionID,
-		RequestJSON:  requestJSON,
-		ResponseJSON: responseJSON,
-		StatusCode:   statusCode,
-		DurationMs:   durationMs,
-		TokensInput:  tokensInput,
-		TokensOutput: tokensOutput,
-		TokensTotal:  tokensTotal,
-		Cost:         cost,
-		CreatedAt:    time.Now(),
-	}, nil
+	log := entry.requestLog()
+	log.ID = id
+	log.CreatedAt = time.Now()
+	return log, nil
 }
 
-// GetRequestLogs возвращает логи запросов
+// GetRequestLogs возвращает последние limit логов запросов, новые первыми
 func (s *Storage) GetRequestLogs(sessionID string, limit int) ([]RequestLog, error) {
-	if limit <= 0 {
-		limit = 100
-	}
+	logs, _, err := s.GetRequestLogsPage(RequestLogFilter{SessionID: sessionID}, Page{Limit: limit, Newest: true})
+	return logs, err
+}
 
-	query := "SELECT id, session_id, request_json, response_json, status_code, duration_ms, tokens_input, tokens_output, tokens_total, cost, created_at FROM request_logs"
-	args := []interface{}{}
+// GetRequestLogsPage возвращает страницу логов запросов (новые первыми)
+// и курсор следующей страницы (0 — страниц больше нет)
+func (s *Storage) GetRequestLogsPage(filter RequestLogFilter, page Page) ([]RequestLog, int64, error) {
+	limit := page.size(100)
+	query := "SELECT id, session_id, COALESCE(user_id, ''), COALESCE(provider, ''), COALESCE(model, ''), COALESCE(reasoning_mode, ''), request_json, response_json, status_code, duration_ms, tokens_input, tokens_output, tokens_total, cost, created_at FROM request_logs"
 
-	if sessionID != "" {
-		query += " WHERE session_id = ?"
-		args = append(args, sessionID)
+	where, args := filter.conditions()
+	pageWhere, pageArgs := page.conditions("id")
+	where, args = append(where, pageWhere...), append(args, pageArgs...)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
 	}
-	query += " ORDER BY created_at DESC LIMIT ?"
-	args = append(args, limit)
+	query += " ORDER BY " + page.order("id") + " LIMIT ?"
+	args = append(args, limit+1)
 
 	rows, err := s.db.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка получения логов: %w", err)
+		return nil, 0, fmt.Errorf("ошибка получения логов: %w", err)
 	}
 	defer rows.Close()
 
@@ -441,8 +531,8 @@ func (s *Storage) GetRequestLogs(sessionID string, limit int) ([]RequestLog, err
 		var tokensTotalNull sql.NullInt64
 		var costNull sql.NullFloat64
 
-		if err := rows.Scan(&log.ID, &sessionIDNull, &log.RequestJSON, &responseJSONNull, &statusCodeNull, &durationMsNull, &tokensInputNull, &tokensOutputNull, &tokensTotalNull, &costNull, &log.CreatedAt); err != nil {
-			return nil, fmt.Errorf("ошибка сканирования лога: %w", err)
+		if err := rows.Scan(&log.ID, &sessionIDNull, &log.UserID, &log.Provider, &log.Model, &log.ReasoningMode, &log.RequestJSON, &responseJSONNull, &statusCodeNull, &durationMsNull, &tokensInputNull, &tokensOutputNull, &tokensTotalNull, &costNull, &log.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("ошибка сканирования лога: %w", err)
 		}
 
 		if sessionIDNull.Valid {
@@ -476,8 +566,19 @@ func (s *Storage) GetRequestLogs(sessionID string, limit int) ([]RequestLog, err
 
 		logs = append(logs, log)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ошибка чтения логов: %w", err)
+	}
 
-	return logs, rows.Err()
+	var next int64
+	if len(logs) > limit {
+		logs = logs[:limit]
+		next = logs[limit-1].ID
+	}
+	if !page.Newest {
+		slices.Reverse(logs)
+	}
+	return logs, next, nil
 }
 
 // Close закрывает соединение с БД
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"strings"
+	"time"
+)
+
+// Store хранилище сообщений, summary, сессий, логов запросов, шагов агента и пользователей.
+// Реализации: Storage (SQLite) и PostgresStore.
+type Store interface {
+	// Сообщения
+	SaveMessage(sessionID, role, content string) (*Message, error)
+	SaveMessageWithFinishReason(sessionID, role, content, finishReason string) (*Message, error)
+	AddMessage(m NewMessage) (*Message, error)
+	GetMessage(sessionID string, id int64) (*Message, error)
+	GetMessages(sessionID string, limit int) ([]Message, error)
+	GetMessagesPage(sessionID string, page Page, withSummary bool) ([]Message, int64, error)
+	SearchMessages(sessionID, query string, limit int) ([]Message, error)
+	DeleteMessagesByIDs(sessionID string, ids []int64) error
+
+	// Ветки диалога
+	GetBranch(sessionID string, leafID int64, limit int) ([]Message, error)
+	GetMessageTree(sessionID string) ([]Message, int64, error)
+	ActiveLeafID(sessionID string) (int64, error)
+	SetActiveBranch(sessionID string, messageID int64) (int64, error)
+	SetActiveLeaf(sessionID string, leafID int64) error
+
+	// Summary и компрессия истории
+	GetLatestSummary(sessionID string) (*Message, error)
+	UpsertSummary(sessionID string, content string) (*Message, error)
+	CountNonSummaryMessages(sessionID string) (int, error)
+	GetOldestNonSummaryMessages(sessionID string, batchSize int, keepLast int) ([]Message, error)
+
+	// Сессии
+	GetSession(sessionID string) (*Session, error)
+	ListSessions(userID string, limit int) ([]Session, error)
+	UpdateSession(sessionID string, patch SessionPatch) (*Session, error)
+	SetSessionTitleIfEmpty(sessionID, title string) (bool, error)
+	DeleteSession(sessionID string) (bool, error)
+	ImportSession(sess Session, messages []Message) (*Session, error)
+	SessionOwner(sessionID string) (string, bool, error)
+	ClaimSession(sessionID, userID string) (string, error)
+
+	// Поиск по всем сессиям
+	Search(q SearchQuery) ([]SearchResult, int64, error)
+	SearchMode() string
+
+	// Логи запросов
+	SaveRequestLog(entry NewRequestLog) (*RequestLog, error)
+	GetRequestLogs(sessionID string, limit int) ([]RequestLog, error)
+	GetRequestLogsPage(filter RequestLogFilter, page Page) ([]RequestLog, int64, error)
+	RequestStats(q StatsQuery) (*Stats, error)
+
+	// Очистка по политике хранения
+	DeleteRequestLogsBefore(before time.Time) (int64, error)
+	TrimRequestLogs(keep int) (int64, error)
+	DeleteInactiveSessions(before time.Time, keep []string) (int64, int64, error)
+	Compact() error
+
+	// Пользователи и API-ключи
+	CreateUser(name string, isAdmin bool) (*User, error)
+	GetUser(id string) (*User, error)
+	ListUsers() ([]User, error)
+	CreateAPIKey(userID, name string) (*APIKey, string, error)
+	ListAPIKeys(userID string) ([]APIKey, error)
+	RevokeAPIKey(userID string, keyID int64) (bool, error)
+	UserByAPIKey(key string) (*User, error)
+
+	// Шаги агента
+	SaveAgentStep(step *AgentStep) error
+	GetAgentSteps(runID string) ([]AgentStep, error)
+
+	Close() error
+}
+
+var (
+	_ Store = (*Storage)(nil)
+	_ Store = (*PostgresStore)(nil)
+)
+
+// Open открывает хранилище: PostgreSQL, если задан databaseURL (postgres://...), иначе SQLite по пути dbPath
+func Open(databaseURL, dbPath string) (Store, error) {
+	if strings.TrimSpace(databaseURL) != "" {
+		return NewPostgres(databaseURL)
+	}
+	return New(dbPath)
+}
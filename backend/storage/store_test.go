@@ -0,0 +1,699 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postgresTestURLEnv DSN PostgreSQL для TestStoreConformance (без него PostgreSQL пропускается).
+// Таблицы хранилища очищаются перед каждым случаем — не указывайте рабочую базу.
+const postgresTestURLEnv = "STORAGE_TEST_DATABASE_URL"
+
+// testStores реализации Store, на которых проверяется одинаковое поведение
+var testStores = []struct {
+	name string
+	open func(t *testing.T) Store
+}{
+	{"sqlite", func(t *testing.T) Store {
+		s, err := New(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}},
+	{"postgres", func(t *testing.T) Store {
+		dsn := os.Getenv(postgresTestURLEnv)
+		if dsn == "" {
+			t.Skipf("%s не задан", postgresTestURLEnv)
+		}
+		p, err := NewPostgres(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.pool.Exec(context.Background(),
+			"TRUNCATE messages, sessions, request_logs, agent_steps, api_keys, users RESTART IDENTITY CASCADE"); err != nil {
+			p.Close()
+			t.Fatal(err)
+		}
+		return p
+	}},
+}
+
+// conformanceCases проверки контракта Store; каждая получает пустое хранилище
+var conformanceCases = []struct {
+	name string
+	run  func(t *testing.T, s Store)
+}{
+	{"ветки", testBranches},
+	{"происхождение ответа", testProvenance},
+	{"summary и страницы", testSummaryPages},
+	{"компрессия", testCompression},
+	{"сессии", testSessions},
+	{"импорт", testImport},
+	{"логи запросов", testRequestLogs},
+	{"пользователи и ключи", testUsers},
+	{"шаги агента", testAgentSteps},
+	{"поиск", testSearch},
+	{"очистка", testRetention},
+}
+
+func TestStoreConformance(t *testing.T) {
+	for _, backend := range testStores {
+		t.Run(backend.name, func(t *testing.T) {
+			for _, c := range conformanceCases {
+				t.Run(c.name, func(t *testing.T) {
+					s := backend.open(t)
+					defer s.Close()
+					c.run(t, s)
+				})
+			}
+		})
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT * FROM m WHERE a = ? AND b IN (?, ?)", "SELECT * FROM m WHERE a = $1 AND b IN ($2, $3)"},
+		{"UPDATE m SET a = '?' WHERE b = ?", "UPDATE m SET a = '?' WHERE b = $1"},
+		{"SELECT 'it''s ?', ?", "SELECT 'it''s ?', $1"},
+		{"SELECT 'привет?' || ?", "SELECT 'привет?' || $1"},
+		{activeBranchCTE + "SELECT id FROM branch WHERE id > ?", strings.Replace(strings.Replace(activeBranchCTE, "?", "$1", 1), "?", "$2", 1) + "SELECT id FROM branch WHERE id > $2"},
+	}
+	for _, tt := range tests {
+		if got := rebind(tt.in); got != tt.want {
+			t.Errorf("rebind(%q) = %q, ожидалось %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func add(t *testing.T, s Store, m NewMessage) *Message {
+	t.Helper()
+	msg, err := s.AddMessage(m)
+	check(t, err)
+	return msg
+}
+
+func messageContents(messages []Message) []string {
+	out := []string{}
+	for _, m := range messages {
+		out = append(out, m.Content)
+	}
+	return out
+}
+
+func expectContents(t *testing.T, what string, messages []Message, want ...string) {
+	t.Helper()
+	if got := messageContents(messages); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s = %v, ожидалось %v", what, got, want)
+	}
+}
+
+func testBranches(t *testing.T, s Store) {
+	q1 := add(t, s, NewMessage{SessionID: "s", Role: RoleUser, Content: "q1"})
+	a1, err := s.SaveMessageWithFinishReason("s", RoleAssistant, "a1", "stopped")
+	check(t, err)
+	if q1.ParentID != 0 || a1.ParentID != q1.ID || a1.FinishReason != "stopped" {
+		t.Errorf("q1 = %+v, a1 = %+v", q1, a1)
+	}
+
+	// Перегенерация: конец ветки переходит на вопрос, новый ответ на q1 — соседняя
+	// ветка, она становится активной
+	check(t, s.SetActiveLeaf("s", q1.ID))
+	b1 := add(t, s, NewMessage{SessionID: "s", Role: RoleAssistant, Content: "b1", ParentID: &q1.ID})
+	leaf, err := s.ActiveLeafID("s")
+	check(t, err)
+	if leaf != b1.ID {
+		t.Errorf("конец активной ветки %d, ожидался %d", leaf, b1.ID)
+	}
+	messages, err := s.GetMessages("s", 10)
+	check(t, err)
+	expectContents(t, "активная ветка", messages, "q1", "b1")
+
+	branch, err := s.GetBranch("s", a1.ID, 10)
+	check(t, err)
+	expectContents(t, "ветка a1", branch, "q1", "a1")
+	if branch[1].FinishReason != "stopped" {
+		t.Errorf("finish_reason = %q", branch[1].FinishReason)
+	}
+
+	// С развилки активной становится самая новая ветка, с ответа — его ветка
+	leaf, err = s.SetActiveBranch("s", q1.ID)
+	check(t, err)
+	if leaf != b1.ID {
+		t.Errorf("SetActiveBranch(q1) = %d, ожидался %d", leaf, b1.ID)
+	}
+	leaf, err = s.SetActiveBranch("s", a1.ID)
+	check(t, err)
+	if leaf != a1.ID {
+		t.Errorf("SetActiveBranch(a1) = %d, ожидался %d", leaf, a1.ID)
+	}
+	a2, err := s.SaveMessage("s", RoleUser, "q2")
+	check(t, err)
+	if a2.ParentID != a1.ID {
+		t.Errorf("продолжение ветки ссылается на %d, ожидался %d", a2.ParentID, a1.ID)
+	}
+
+	tree, leaf, err := s.GetMessageTree("s")
+	check(t, err)
+	expectContents(t, "дерево", tree, "q1", "a1", "b1", "q2")
+	if leaf != a2.ID {
+		t.Errorf("конец активной ветки %d, ожидался %d", leaf, a2.ID)
+	}
+
+	// Ответ, сохраненный после переключения на другую ветку, не возвращает пользователя назад
+	late := add(t, s, NewMessage{SessionID: "s", Role: RoleAssistant, Content: "поздний", ParentID: &q1.ID})
+	leaf, err = s.ActiveLeafID("s")
+	check(t, err)
+	if leaf != a2.ID {
+		t.Errorf("после позднего ответа конец ветки %d, ожидался %d", leaf, a2.ID)
+	}
+	// ...а ответ на текущий конец ветки продолжает ее
+	a3 := add(t, s, NewMessage{SessionID: "s", Role: RoleAssistant, Content: "a3", ParentID: &a2.ID})
+	if leaf, err = s.ActiveLeafID("s"); err != nil || leaf != a3.ID {
+		t.Errorf("конец ветки %d, %v, ожидался %d", leaf, err, a3.ID)
+	}
+	branch, err = s.GetBranch("s", late.ID, 10)
+	check(t, err)
+	expectContents(t, "ветка позднего ответа", branch, "q1", "поздний")
+
+	// Чужие и несуществующие сообщения не находятся
+	if m, err := s.GetMessage("other", q1.ID); err != nil || m != nil {
+		t.Errorf("GetMessage из чужой сессии = %+v, %v", m, err)
+	}
+	if leaf, err := s.SetActiveBranch("other", q1.ID); err != nil || leaf != 0 {
+		t.Errorf("SetActiveBranch из чужой сессии = %d, %v", leaf, err)
+	}
+	if branch, err := s.GetBranch("s", 0, 10); err != nil || len(branch) != 0 {
+		t.Errorf("GetBranch(0) = %v, %v", branch, err)
+	}
+	if leaf, err := s.ActiveLeafID("missing"); err != nil || leaf != 0 {
+		t.Errorf("ActiveLeafID несуществующей сессии = %d, %v", leaf, err)
+	}
+}
+
+func testProvenance(t *testing.T, s Store) {
+	tokensIn, tokensOut, total := 120, 9, 129
+	cost := 0.0004
+	log, err := s.SaveRequestLog(NewRequestLog{SessionID: "s", Provider: "groq", StatusCode: 200, TokensInput: &tokensIn, TokensOutput: &tokensOut, TokensTotal: &total, Cost: &cost})
+	check(t, err)
+
+	temperature := 0.7
+	duration := int64(812)
+	want := Provenance{
+		Provider:      "groq",
+		Model:         "llama",
+		ReasoningMode: "fast",
+		Temperature:   &temperature,
+		TokensInput:   &tokensIn,
+		TokensOutput:  &tokensOut,
+		Cost:          &cost,
+		DurationMs:    &duration,
+		RequestLogID:  &log.ID,
+	}
+	saved := add(t, s, NewMessage{SessionID: "s", Role: RoleAssistant, Content: "a", Provenance: want})
+	got, err := s.GetMessage("s", saved.ID)
+	check(t, err)
+	if !reflect.DeepEqual(got.Provenance, want) {
+		t.Errorf("происхождение = %+v, ожидалось %+v", got.Provenance, want)
+	}
+
+	user := add(t, s, NewMessage{SessionID: "s", Role: RoleUser, Content: "q"})
+	got, err = s.GetMessage("s", user.ID)
+	check(t, err)
+	if !reflect.DeepEqual(got.Provenance, Provenance{}) {
+		t.Errorf("происхождение сообщения пользователя = %+v", got.Provenance)
+	}
+}
+
+func testSummaryPages(t *testing.T, s Store) {
+	for _, c := range []string{"m1", "m2"} {
+		_, err := s.SaveMessage("s", RoleUser, c)
+		check(t, err)
+	}
+	first, err := s.UpsertSummary("s", "резюме 1")
+	check(t, err)
+	for _, c := range []string{"m3", "m4", "m5"} {
+		_, err := s.SaveMessage("s", RoleAssistant, c)
+		check(t, err)
+	}
+
+	// Summary одно на сессию: повторный вызов обновляет его
+	second, err := s.UpsertSummary("s", "резюме 2")
+	check(t, err)
+	if second.ID != first.ID {
+		t.Errorf("второе summary %d, ожидалось обновление %d", second.ID, first.ID)
+	}
+	latest, err := s.GetLatestSummary("s")
+	check(t, err)
+	if latest == nil || latest.Content != "резюме 2" || latest.ParentID != 0 {
+		t.Errorf("summary = %+v", latest)
+	}
+	if none, err := s.GetLatestSummary("other"); err != nil || none != nil {
+		t.Errorf("summary пустой сессии = %+v, %v", none, err)
+	}
+	count, err := s.CountNonSummaryMessages("s")
+	check(t, err)
+	if count != 5 {
+		t.Errorf("сообщений без summary %d, ожидалось 5", count)
+	}
+
+	all, err := s.GetMessages("s", 10)
+	check(t, err)
+	expectContents(t, "история с summary", all, "m1", "m2", "резюме 2", "m3", "m4", "m5")
+
+	// Страницы без summary полные; курсор — id последней записи страницы
+	page, next, err := s.GetMessagesPage("s", Page{Limit: 2}, false)
+	check(t, err)
+	expectContents(t, "первая страница", page, "m1", "m2")
+	page, next, err = s.GetMessagesPage("s", Page{Limit: 2, AfterID: next}, false)
+	check(t, err)
+	expectContents(t, "вторая страница", page, "m3", "m4")
+	page, next, err = s.GetMessagesPage("s", Page{Limit: 2, AfterID: next}, false)
+	check(t, err)
+	expectContents(t, "последняя страница", page, "m5")
+	if next != 0 {
+		t.Errorf("курсор после последней страницы %d", next)
+	}
+
+	// С Newest страница — самые новые записи окна, по возрастанию id
+	page, next, err = s.GetMessagesPage("s", Page{Limit: 3, Newest: true}, true)
+	check(t, err)
+	expectContents(t, "новые с summary", page, "m3", "m4", "m5")
+	page, _, err = s.GetMessagesPage("s", Page{Limit: 3, Newest: true, BeforeID: next}, true)
+	check(t, err)
+	expectContents(t, "старые с summary", page, "m1", "m2", "резюме 2")
+}
+
+func testCompression(t *testing.T, s Store) {
+	var ids []int64
+	for _, c := range []string{"m1", "m2", "m3", "m4", "m5", "m6"} {
+		m, err := s.SaveMessage("s", RoleUser, c)
+		check(t, err)
+		ids = append(ids, m.ID)
+	}
+	side := add(t, s, NewMessage{SessionID: "s", Role: RoleAssistant, Content: "b", ParentID: &ids[3]})
+	_, err := s.SetActiveBranch("s", ids[5])
+	check(t, err)
+
+	// Сжимать можно только общее начало веток (до развилки на m4) без keepLast последних
+	batch, err := s.GetOldestNonSummaryMessages("s", 10, 4)
+	check(t, err)
+	expectContents(t, "батч", batch, "m1", "m2")
+	batch, err = s.GetOldestNonSummaryMessages("s", 10, 0)
+	check(t, err)
+	expectContents(t, "батч без хвоста", batch, "m1", "m2", "m3", "m4")
+
+	check(t, s.DeleteMessagesByIDs("s", []int64{ids[0], ids[1]}))
+	m3, err := s.GetMessage("s", ids[2])
+	check(t, err)
+	if m3.ParentID != 0 {
+		t.Errorf("m3 ссылается на удаленное сообщение %d", m3.ParentID)
+	}
+
+	// Удаление середины переносит обе ветки к оставшемуся предку и сдвигает активную ветку
+	check(t, s.DeleteMessagesByIDs("s", []int64{ids[3], ids[4], ids[5]}))
+	got, err := s.GetMessage("s", side.ID)
+	check(t, err)
+	if got.ParentID != ids[2] {
+		t.Errorf("соседняя ветка ссылается на %d, ожидался %d", got.ParentID, ids[2])
+	}
+	leaf, err := s.ActiveLeafID("s")
+	check(t, err)
+	if leaf != ids[2] {
+		t.Errorf("конец активной ветки %d, ожидался %d", leaf, ids[2])
+	}
+	check(t, s.DeleteMessagesByIDs("s", nil))
+}
+
+func testSessions(t *testing.T, s Store) {
+	_, err := s.SaveMessage("s", RoleUser, "q")
+	check(t, err)
+	sess, err := s.GetSession("s")
+	check(t, err)
+	if sess == nil || sess.MessageCount != 1 || sess.ActiveLeafID == 0 || sess.Title != "" {
+		t.Fatalf("сессия = %+v", sess)
+	}
+	if none, err := s.GetSession("missing"); err != nil || none != nil {
+		t.Errorf("несуществующая сессия = %+v, %v", none, err)
+	}
+
+	title, model := "  Заметки  ", "llama"
+	updated, err := s.UpdateSession("s", SessionPatch{Title: &title, Model: &model})
+	check(t, err)
+	if updated.Title != "Заметки" || updated.Model != "llama" || updated.Provider != "" {
+		t.Errorf("обновленная сессия = %+v", updated)
+	}
+	if set, err := s.SetSessionTitleIfEmpty("s", "другое"); err != nil || set {
+		t.Errorf("название пользователя перезаписано: %v, %v", set, err)
+	}
+
+	owner, err := s.ClaimSession("owned", "u1")
+	check(t, err)
+	if owner != "u1" {
+		t.Errorf("владелец %q", owner)
+	}
+	if owner, err := s.ClaimSession("owned", "u2"); err != nil || owner != "u1" {
+		t.Errorf("чужой ClaimSession вернул %q, %v", owner, err)
+	}
+	if owner, found, err := s.SessionOwner("s"); err != nil || !found || owner != "" {
+		t.Errorf("владелец сессии без авторизации = %q, %v, %v", owner, found, err)
+	}
+	if _, found, err := s.SessionOwner("missing"); err != nil || found {
+		t.Errorf("SessionOwner несуществующей сессии: %v, %v", found, err)
+	}
+	if set, err := s.SetSessionTitleIfEmpty("owned", "Авто"); err != nil || !set {
+		t.Errorf("SetSessionTitleIfEmpty = %v, %v", set, err)
+	}
+
+	mine, err := s.ListSessions("u1", 10)
+	check(t, err)
+	if len(mine) != 1 || mine[0].ID != "owned" || mine[0].Title != "Авто" {
+		t.Errorf("сессии u1 = %+v", mine)
+	}
+	all, err := s.ListSessions("", 10)
+	check(t, err)
+	if len(all) != 2 {
+		t.Errorf("всего сессий %d, ожидалось 2", len(all))
+	}
+
+	check(t, s.SaveAgentStep(&AgentStep{RunID: "r", SessionID: "s", Step: 1, Kind: AgentStepModel}))
+	deleted, err := s.DeleteSession("s")
+	check(t, err)
+	if !deleted {
+		t.Error("сессия не удалена")
+	}
+	if messages, _ := s.GetMessages("s", 10); len(messages) != 0 {
+		t.Errorf("после удаления остались сообщения %v", messageContents(messages))
+	}
+	if steps, _ := s.GetAgentSteps("r"); len(steps) != 0 {
+		t.Errorf("после удаления остались шаги агента: %d", len(steps))
+	}
+	if deleted, err := s.DeleteSession("s"); err != nil || deleted {
+		t.Errorf("повторное удаление: %v, %v", deleted, err)
+	}
+}
+
+func testImport(t *testing.T, s Store) {
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	imported, err := s.ImportSession(
+		Session{ID: "imp", UserID: "u1", Title: "Импорт", Model: "llama", ActiveLeafID: 13, CreatedAt: created},
+		[]Message{
+			{ID: 13, Role: RoleUser, Content: "q2", ParentID: 10},
+			{ID: 10, Role: RoleUser, Content: "q1", CreatedAt: created},
+			{ID: 12, Role: RoleSummary, Content: "резюме"},
+			{ID: 11, Role: RoleAssistant, Content: "a1", ParentID: 10},
+		},
+	)
+	check(t, err)
+	if imported.Title != "Импорт" || imported.UserID != "u1" || imported.MessageCount != 3 || !imported.CreatedAt.Equal(created) {
+		t.Errorf("сессия = %+v", imported)
+	}
+
+	tree, leaf, err := s.GetMessageTree("imp")
+	check(t, err)
+	expectContents(t, "дерево", tree, "q1", "a1", "q2")
+	if tree[1].ParentID != tree[0].ID || tree[2].ParentID != tree[0].ID || leaf != tree[2].ID {
+		t.Errorf("связи после импорта: %+v, конец ветки %d", tree, leaf)
+	}
+	if !tree[0].CreatedAt.Equal(created) {
+		t.Errorf("created_at = %v, ожидалось %v", tree[0].CreatedAt, created)
+	}
+	if summary, _ := s.GetLatestSummary("imp"); summary == nil || summary.Content != "резюме" {
+		t.Errorf("summary = %+v", summary)
+	}
+
+	// Ошибка откатывает импорт целиком
+	if _, err := s.ImportSession(Session{ID: "bad"}, []Message{{ID: 1, Role: RoleUser, Content: "q", ParentID: 5}}); err == nil {
+		t.Error("импорт с неизвестным родителем прошел")
+	}
+	if sess, _ := s.GetSession("bad"); sess != nil {
+		t.Errorf("после ошибки импорта осталась сессия %+v", sess)
+	}
+}
+
+func testRequestLogs(t *testing.T, s Store) {
+	tokens := 10
+	cost := 0.5
+	entries := []NewRequestLog{
+		{SessionID: "s", UserID: "u1", Provider: "groq", Model: "llama", StatusCode: 200, DurationMs: 100, TokensTotal: &tokens, Cost: &cost},
+		{SessionID: "s", UserID: "u1", Provider: "groq", Model: "llama", StatusCode: 503, DurationMs: 300},
+		{SessionID: "other", UserID: "u2", Provider: "gigachat", StatusCode: 200, DurationMs: 200, TokensTotal: &tokens, Cost: &cost},
+	}
+	var ids []int64
+	for _, e := range entries {
+		log, err := s.SaveRequestLog(e)
+		check(t, err)
+		ids = append(ids, log.ID)
+	}
+
+	logs, err := s.GetRequestLogs("s", 10)
+	check(t, err)
+	if len(logs) != 2 || logs[0].ID != ids[1] || logs[1].ID != ids[0] {
+		t.Fatalf("логи сессии = %+v", logs)
+	}
+	if logs[1].UserID != "u1" || logs[1].Model != "llama" || logs[1].TokensTotal == nil || *logs[1].TokensTotal != 10 {
+		t.Errorf("лог = %+v", logs[1])
+	}
+
+	page, next, err := s.GetRequestLogsPage(RequestLogFilter{}, Page{Limit: 2, Newest: true})
+	check(t, err)
+	if len(page) != 2 || page[0].ID != ids[2] || next != ids[1] {
+		t.Errorf("первая страница %+v, курсор %d", page, next)
+	}
+	page, next, err = s.GetRequestLogsPage(RequestLogFilter{}, Page{Limit: 2, Newest: true, BeforeID: next})
+	check(t, err)
+	if len(page) != 1 || page[0].ID != ids[0] || next != 0 {
+		t.Errorf("последняя страница %+v, курсор %d", page, next)
+	}
+	page, _, err = s.GetRequestLogsPage(RequestLogFilter{UserID: "u2"}, Page{})
+	check(t, err)
+	if len(page) != 1 || page[0].SessionID != "other" {
+		t.Errorf("логи u2 = %+v", page)
+	}
+
+	stats, err := s.RequestStats(StatsQuery{GroupBy: []string{StatsByProvider}})
+	check(t, err)
+	if stats.Total.Requests != 3 || stats.Total.Errors != 1 || stats.Total.TokensTotal != 20 || stats.Total.Cost != 1 {
+		t.Errorf("итог = %+v", stats.Total)
+	}
+	if len(stats.Groups) != 2 {
+		t.Fatalf("группы = %+v", stats.Groups)
+	}
+	// Группы упорядочены по провайдеру; перцентиль — по ближайшему рангу
+	if g := stats.Groups[1]; g.Provider != "groq" || g.Model != "" || g.Requests != 2 || g.Errors != 1 ||
+		g.ErrorRate != 0.5 || g.LatencyP50Ms != 100 || g.LatencyP95Ms != 300 {
+		t.Errorf("группа groq = %+v", g)
+	}
+	if stats.Total.LatencyP50Ms != 200 || stats.Total.LatencyP95Ms != 300 {
+		t.Errorf("задержка итога = %+v", stats.Total)
+	}
+	stats, err = s.RequestStats(StatsQuery{GroupBy: []string{StatsByDay, StatsByModel}})
+	check(t, err)
+	today := time.Now().UTC().Format("2006-01-02")
+	if len(stats.Groups) != 2 || stats.Groups[0].Day != today || stats.Groups[0].Model != "" || stats.Groups[1].Model != "llama" {
+		t.Errorf("группы по дню и модели = %+v", stats.Groups)
+	}
+	stats, err = s.RequestStats(StatsQuery{Provider: "missing", GroupBy: []string{StatsByProvider}})
+	check(t, err)
+	if stats.Groups == nil || len(stats.Groups) != 0 || stats.Total != (StatsRow{}) {
+		t.Errorf("пустая выборка = %+v", stats)
+	}
+	stats, err = s.RequestStats(StatsQuery{UserID: "u1", From: time.Now().Add(-time.Hour), To: time.Now().Add(time.Hour)})
+	check(t, err)
+	if stats.Total.Requests != 2 {
+		t.Errorf("запросов u1 за час %d, ожидалось 2", stats.Total.Requests)
+	}
+
+	// Очистка отвязывает ответы от удаленных логов
+	answer := add(t, s, NewMessage{SessionID: "s", Role: RoleAssistant, Content: "a", Provenance: Provenance{RequestLogID: &ids[0]}})
+	n, err := s.TrimRequestLogs(2)
+	check(t, err)
+	if n != 1 {
+		t.Errorf("TrimRequestLogs удалил %d, ожидалось 1", n)
+	}
+	if got, _ := s.GetMessage("s", answer.ID); got.RequestLogID != nil {
+		t.Errorf("ответ ссылается на удаленный лог %d", *got.RequestLogID)
+	}
+	n, err = s.DeleteRequestLogsBefore(time.Now().Add(time.Hour))
+	check(t, err)
+	if n != 2 {
+		t.Errorf("DeleteRequestLogsBefore удалил %d, ожидалось 2", n)
+	}
+}
+
+func testUsers(t *testing.T, s Store) {
+	admin, err := s.CreateUser("admin", true)
+	check(t, err)
+	if admin == nil || !admin.IsAdmin {
+		t.Fatalf("пользователь = %+v", admin)
+	}
+	if dup, err := s.CreateUser("admin", false); err != nil || dup != nil {
+		t.Errorf("повторное имя: %+v, %v", dup, err)
+	}
+	bob, err := s.CreateUser("bob", false)
+	check(t, err)
+	users, err := s.ListUsers()
+	check(t, err)
+	ids := map[string]bool{}
+	for _, u := range users {
+		ids[u.ID] = true
+	}
+	if len(users) != 2 || !ids[admin.ID] || !ids[bob.ID] {
+		t.Errorf("пользователи = %+v", users)
+	}
+	if u, err := s.GetUser("missing"); err != nil || u != nil {
+		t.Errorf("GetUser несуществующего = %+v, %v", u, err)
+	}
+
+	key, secret, err := s.CreateAPIKey(bob.ID, "ноутбук")
+	check(t, err)
+	if !strings.HasPrefix(secret, APIKeyPrefix) || !strings.HasPrefix(secret, key.Prefix) {
+		t.Errorf("ключ %q с началом %q", secret, key.Prefix)
+	}
+	u, err := s.UserByAPIKey(secret)
+	check(t, err)
+	if u == nil || u.ID != bob.ID {
+		t.Errorf("UserByAPIKey = %+v", u)
+	}
+	if u, err := s.UserByAPIKey(secret + "x"); err != nil || u != nil {
+		t.Errorf("неверный ключ: %+v, %v", u, err)
+	}
+
+	// Чужой ключ не отзывается; отозванный не принимается
+	if revoked, err := s.RevokeAPIKey(admin.ID, key.ID); err != nil || revoked {
+		t.Errorf("отзыв чужого ключа: %v, %v", revoked, err)
+	}
+	if revoked, err := s.RevokeAPIKey(bob.ID, key.ID); err != nil || !revoked {
+		t.Errorf("отзыв ключа: %v, %v", revoked, err)
+	}
+	if u, err := s.UserByAPIKey(secret); err != nil || u != nil {
+		t.Errorf("отозванный ключ принят: %+v, %v", u, err)
+	}
+	keys, err := s.ListAPIKeys(bob.ID)
+	check(t, err)
+	if len(keys) != 1 || keys[0].RevokedAt == nil || keys[0].LastUsedAt == nil {
+		t.Errorf("ключи = %+v", keys)
+	}
+}
+
+func testAgentSteps(t *testing.T, s Store) {
+	steps := []*AgentStep{
+		{RunID: "r", SessionID: "s", Step: 1, Kind: AgentStepModel, Content: "думаю", DurationMs: 10},
+		{RunID: "r", SessionID: "s", Step: 1, Kind: AgentStepTool, ToolName: "calculator", ToolCallID: "c1", Arguments: `{"expression":"2+2"}`, Content: "4"},
+		{RunID: "other", SessionID: "s", Step: 1, Kind: AgentStepModel},
+		{RunID: "r", SessionID: "s", Step: 2, Kind: AgentStepTool, ToolName: "calculator", Error: "деление на ноль"},
+	}
+	for _, st := range steps {
+		check(t, s.SaveAgentStep(st))
+		if st.ID == 0 {
+			t.Fatal("шагу не назначен id")
+		}
+	}
+
+	got, err := s.GetAgentSteps("r")
+	check(t, err)
+	if len(got) != 3 {
+		t.Fatalf("шагов %d, ожидалось 3", len(got))
+	}
+	for i, want := range []*AgentStep{steps[0], steps[1], steps[3]} {
+		g := got[i]
+		g.CreatedAt = want.CreatedAt
+		if !reflect.DeepEqual(g, *want) {
+			t.Errorf("шаг %d = %+v, ожидался %+v", i, g, *want)
+		}
+	}
+}
+
+func testSearch(t *testing.T, s Store) {
+	if s.SearchMode() == "" {
+		t.Error("режим поиска не задан")
+	}
+	hello, err := s.SaveMessage("s", RoleUser, "Hello world")
+	check(t, err)
+	_, err = s.SaveMessage("s", RoleAssistant, "goodbye")
+	check(t, err)
+	_, err = s.SaveMessage("other", RoleUser, "hello again")
+	check(t, err)
+	_, err = s.UpsertSummary("s", "hello из summary")
+	check(t, err)
+
+	found, err := s.SearchMessages("s", "HELLO", 10)
+	check(t, err)
+	expectContents(t, "SearchMessages", found, "Hello world")
+
+	// Поиск по всем сессиям находит и summary
+	results, next, err := s.Search(SearchQuery{Query: "hello", Limit: 2})
+	check(t, err)
+	if len(results) != 2 || results[0].Role != RoleSummary || next != results[1].MessageID {
+		t.Fatalf("найдено %+v (курсор %d)", results, next)
+	}
+	results, next, err = s.Search(SearchQuery{Query: "hello", Limit: 2, BeforeID: next})
+	check(t, err)
+	if len(results) != 1 || results[0].MessageID != hello.ID || next != 0 {
+		t.Errorf("вторая страница = %+v (курсор %d)", results, next)
+	}
+	results, next, err = s.Search(SearchQuery{Query: "hello", SessionID: "s", Role: RoleUser, Limit: 1})
+	check(t, err)
+	if len(results) != 1 || results[0].MessageID != hello.ID || next != 0 {
+		t.Errorf("поиск в сессии = %+v (курсор %d)", results, next)
+	}
+	if results, _, err := s.Search(SearchQuery{Query: "   "}); err != nil || len(results) != 0 {
+		t.Errorf("пустой запрос = %+v, %v", results, err)
+	}
+
+	// Сниппет — HTML: разметка из сообщения экранируется, размечены только найденные слова
+	_, err = s.SaveMessage("xss", RoleUser, `<script>alert("xss")</script> & <img src=x onerror=alert(1)>`)
+	check(t, err)
+	results, _, err = s.Search(SearchQuery{Query: "alert", SessionID: "xss", Limit: 1})
+	check(t, err)
+	if len(results) != 1 {
+		t.Fatalf("найдено %+v", results)
+	}
+	snippet := results[0].Snippet
+	if strings.Contains(snippet, "<script>") || strings.Contains(snippet, "<img") ||
+		!strings.Contains(snippet, "&lt;script&gt;") || !strings.Contains(snippet, HighlightStart+"alert"+HighlightEnd) {
+		t.Errorf("сниппет = %q", snippet)
+	}
+}
+
+func testRetention(t *testing.T, s Store) {
+	for _, c := range []string{"q", "a"} {
+		_, err := s.SaveMessage("s", RoleUser, c)
+		check(t, err)
+	}
+	check(t, s.SaveAgentStep(&AgentStep{RunID: "r", SessionID: "s", Step: 1, Kind: AgentStepModel}))
+
+	_, err := s.SaveMessage("busy", RoleUser, "идет генерация")
+	check(t, err)
+
+	sessions, messages, err := s.DeleteInactiveSessions(time.Now().Add(-time.Hour), nil)
+	check(t, err)
+	if sessions != 0 || messages != 0 {
+		t.Errorf("удалены активные сессии: %d, %d", sessions, messages)
+	}
+	sessions, messages, err = s.DeleteInactiveSessions(time.Now().Add(time.Hour), []string{"busy", "missing"})
+	check(t, err)
+	if sessions != 1 || messages != 2 {
+		t.Errorf("удалено сессий %d и сообщений %d, ожидалось 1 и 2", sessions, messages)
+	}
+	if steps, _ := s.GetAgentSteps("r"); len(steps) != 0 {
+		t.Errorf("остались шаги агента: %d", len(steps))
+	}
+	if busy, err := s.GetMessages("busy", 10); err != nil || len(busy) != 1 {
+		t.Errorf("сессия из keep удалена: %+v, %v", busy, err)
+	}
+	check(t, s.Compact())
+}
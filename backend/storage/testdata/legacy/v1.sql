@@ -0,0 +1,33 @@
+-- БД до появления schema_migrations, этап 1: исходная схема: messages (только user/assistant) и request_logs.
+-- Схема — как ее создавал storage.migrate того времени; данные — для проверки переноса.
+
+CREATE TABLE messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_id TEXT NOT NULL,
+	role TEXT NOT NULL CHECK(role IN ('user', 'assistant')),
+	content TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_messages_session ON messages(session_id);
+CREATE INDEX idx_messages_created ON messages(created_at);
+
+CREATE TABLE request_logs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_id TEXT,
+	request_json TEXT NOT NULL,
+	response_json TEXT,
+	status_code INTEGER,
+	duration_ms INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_request_logs_session ON request_logs(session_id);
+CREATE INDEX idx_request_logs_created ON request_logs(created_at);
+
+INSERT INTO messages (id, session_id, role, content, created_at) VALUES (1, 's1', 'user', 'Привет', '2024-01-10 10:00:00');
+INSERT INTO messages (id, session_id, role, content, created_at) VALUES (2, 's1', 'assistant', 'Здравствуйте', '2024-01-10 10:00:05');
+INSERT INTO messages (id, session_id, role, content, created_at) VALUES (4, 's1', 'user', 'Как дела?', '2024-01-10 10:01:00');
+INSERT INTO messages (id, session_id, role, content, created_at) VALUES (5, 's1', 'assistant', 'Хорошо', '2024-01-10 10:01:04');
+INSERT INTO messages (id, session_id, role, content, created_at) VALUES (6, 's2', 'user', 'Вопрос', '2024-01-11 09:00:00');
+INSERT INTO messages (id, session_id, role, content, created_at) VALUES (7, 's2', 'assistant', 'Ответ', '2024-01-11 09:30:00');
+INSERT INTO request_logs (id, session_id, request_json, response_json, status_code, duration_ms, created_at)
+VALUES (1, 's1', '{"message":"Привет","provider":"groq","model":"llama"}', '{"content":"Здравствуйте"}', 200, 812, '2024-01-10 10:00:05');
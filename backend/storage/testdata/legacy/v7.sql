@@ -0,0 +1,76 @@
+-- БД до появления schema_migrations, этап 7: + дерево сообщений (parent_id, active_leaf_id).
+-- Схема — как ее создавал storage.migrate того времени; данные — для проверки переноса.
+
+CREATE TABLE messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_id TEXT NOT NULL,
+	role TEXT NOT NULL CHECK(role IN ('user', 'assistant', 'summary')),
+	content TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_messages_session ON messages(session_id);
+CREATE INDEX idx_messages_created ON messages(created_at);
+ALTER TABLE messages ADD COLUMN finish_reason TEXT;
+
+CREATE TABLE request_logs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_id TEXT,
+	request_json TEXT NOT NULL,
+	response_json TEXT,
+	status_code INTEGER,
+	duration_ms INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_request_logs_session ON request_logs(session_id);
+CREATE INDEX idx_request_logs_created ON request_logs(created_at);
+ALTER TABLE request_logs ADD COLUMN tokens_input INTEGER;
+ALTER TABLE request_logs ADD COLUMN tokens_output INTEGER;
+ALTER TABLE request_logs ADD COLUMN tokens_total INTEGER;
+ALTER TABLE request_logs ADD COLUMN cost REAL;
+
+CREATE TABLE agent_steps (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	run_id TEXT NOT NULL,
+	session_id TEXT NOT NULL,
+	step INTEGER NOT NULL,
+	kind TEXT NOT NULL CHECK(kind IN ('model', 'tool')),
+	tool_name TEXT,
+	tool_call_id TEXT,
+	arguments TEXT,
+	content TEXT NOT NULL DEFAULT '',
+	error TEXT,
+	duration_ms INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_agent_steps_run ON agent_steps(run_id);
+CREATE INDEX idx_agent_steps_session ON agent_steps(session_id);
+
+CREATE TABLE sessions (
+	id TEXT PRIMARY KEY,
+	title TEXT NOT NULL DEFAULT '',
+	provider TEXT,
+	model TEXT,
+	system_prompt TEXT,
+	reasoning_mode TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_sessions_updated ON sessions(updated_at);
+ALTER TABLE messages ADD COLUMN parent_id INTEGER;
+ALTER TABLE sessions ADD COLUMN active_leaf_id INTEGER;
+CREATE INDEX idx_messages_parent ON messages(parent_id);
+
+INSERT INTO messages (id, session_id, role, content, created_at) VALUES (1, 's1', 'user', 'Привет', '2024-01-10 10:00:00');
+INSERT INTO messages (id, session_id, role, content, created_at, parent_id) VALUES (2, 's1', 'assistant', 'Здравствуйте', '2024-01-10 10:00:05', 1);
+INSERT INTO messages (id, session_id, role, content, created_at) VALUES (3, 's1', 'summary', 'Резюме начала', '2024-01-10 10:00:06');
+INSERT INTO messages (id, session_id, role, content, created_at, parent_id) VALUES (4, 's1', 'user', 'Как дела?', '2024-01-10 10:01:00', 2);
+INSERT INTO messages (id, session_id, role, content, created_at, finish_reason, parent_id) VALUES (5, 's1', 'assistant', 'Хорошо', '2024-01-10 10:01:04', 'stopped', 4);
+INSERT INTO messages (id, session_id, role, content, created_at) VALUES (6, 's2', 'user', 'Вопрос', '2024-01-11 09:00:00');
+INSERT INTO messages (id, session_id, role, content, created_at, parent_id) VALUES (7, 's2', 'assistant', 'Ответ', '2024-01-11 09:30:00', 6);
+INSERT INTO messages (id, session_id, role, content, created_at, parent_id) VALUES (8, 's1', 'user', 'Другой вопрос', '2024-01-12 08:00:00', 2);
+INSERT INTO request_logs (id, session_id, request_json, response_json, status_code, duration_ms, created_at, tokens_input, tokens_output, tokens_total, cost)
+VALUES (1, 's1', '{"message":"Привет","provider":"groq","model":"llama"}', '{"content":"Здравствуйте"}', 200, 812, '2024-01-10 10:00:05', 7, 3, 10, 0.001);
+INSERT INTO agent_steps (run_id, session_id, step, kind, tool_name, arguments, content, created_at)
+VALUES ('run-1', 's1', 1, 'tool', 'calculator', '{"expression":"2+2"}', '4', '2024-01-10 10:01:02');
+INSERT INTO sessions (id, title, created_at, updated_at, active_leaf_id) VALUES ('s1', 'Старая сессия', '2024-01-10 10:00:00', '2024-01-12 08:00:00', 5);
+INSERT INTO sessions (id, created_at, updated_at, active_leaf_id) VALUES ('s2', '2024-01-11 09:00:00', '2024-01-11 09:30:00', 7);
@@ -0,0 +1,353 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// APIKeyPrefix начало всех API-ключей
+const APIKeyPrefix = "aic_"
+
+// apiKeyTouchInterval как часто обновлять last_used_at ключа
+const apiKeyTouchInterval = time.Minute
+
+// User пользователь API; владеет своими сессиями и логами запросов
+type User struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	IsAdmin   bool      `json:"is_admin"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKey API-ключ пользователя. Сам ключ показывается один раз при создании,
+// в БД хранится только его SHA-256.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // начало ключа, чтобы отличать ключи в списке
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+const (
+	userColumns   = "id, name, is_admin, created_at"
+	apiKeyColumns = "id, user_id, name, prefix, created_at, last_used_at, revoked_at"
+
+	insertUserSQL   = "INSERT INTO users (id, name, is_admin) VALUES (?, ?, ?) ON CONFLICT(name) DO NOTHING"
+	insertAPIKeySQL = "INSERT INTO api_keys (user_id, name, prefix, key_hash) VALUES (?, ?, ?, ?)"
+
+	// Пользователь по действующему ключу и id ключа
+	userByKeySQL = "SELECT k.id, u.id, u.name, u.is_admin, u.created_at FROM api_keys k JOIN users u ON u.id = k.user_id WHERE k.key_hash = ? AND k.revoked_at IS NULL"
+	touchKeySQL  = "UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)"
+	revokeKeySQL = "UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ? AND revoked_at IS NULL"
+
+	// Сессия создается за пользователем, если ее еще нет
+	claimSessionSQL = "INSERT INTO sessions (id, user_id) VALUES (?, ?) ON CONFLICT(id) DO NOTHING"
+	sessionOwnerSQL = "SELECT COALESCE(user_id, '') FROM sessions WHERE id = ?"
+)
+
+// newAPIKey генерирует ключ, его отображаемое начало и хеш для хранения
+func newAPIKey() (key, prefix, hash string, err error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", fmt.Errorf("ошибка генерации ключа: %w", err)
+	}
+	key = APIKeyPrefix + hex.EncodeToString(buf)
+	return key, key[:len(APIKeyPrefix)+8], hashAPIKey(key), nil
+}
+
+// hashAPIKey SHA-256 ключа в hex
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func scanUser(row interface{ Scan(...interface{}) error }) (User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Name, &u.IsAdmin, &u.CreatedAt)
+	return u, err
+}
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (APIKey, error) {
+	var k APIKey
+	err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+	return k, err
+}
+
+// CreateUser создает пользователя. Возвращает nil, если имя уже занято.
+func (s *Storage) CreateUser(name string, isAdmin bool) (*User, error) {
+	id := uuid.New().String()
+	result, err := s.db.Exec(insertUserSQL, id, name, isAdmin)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания пользователя: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания пользователя: %w", err)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	return s.GetUser(id)
+}
+
+// GetUser возвращает пользователя или nil, если его нет
+func (s *Storage) GetUser(id string) (*User, error) {
+	u, err := scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения пользователя: %w", err)
+	}
+	return &u, nil
+}
+
+// ListUsers возвращает пользователей в порядке создания
+func (s *Storage) ListUsers() ([]User, error) {
+	rows, err := s.db.Query("SELECT " + userColumns + " FROM users ORDER BY created_at, id")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения пользователей: %w", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования пользователя: %w", err)
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// CreateAPIKey выпускает ключ пользователю и возвращает его вместе с самим ключом
+func (s *Storage) CreateAPIKey(userID, name string) (*APIKey, string, error) {
+	key, prefix, hash, err := newAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	result, err := s.db.Exec(insertAPIKeySQL, userID, name, prefix, hash)
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка создания ключа: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка получения ID: %w", err)
+	}
+
+	k, err := scanAPIKey(s.db.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ?", id))
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка получения ключа: %w", err)
+	}
+	return &k, key, nil
+}
+
+// ListAPIKeys возвращает ключи пользователя, включая отозванные
+func (s *Storage) ListAPIKeys(userID string) ([]APIKey, error) {
+	rows, err := s.db.Query("SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения ключей: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования ключа: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey отзывает ключ пользователя. Возвращает false, если действующего ключа нет.
+func (s *Storage) RevokeAPIKey(userID string, keyID int64) (bool, error) {
+	result, err := s.db.Exec(revokeKeySQL, keyID, userID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка отзыва ключа: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("ошибка отзыва ключа: %w", err)
+	}
+	return n > 0, nil
+}
+
+// UserByAPIKey возвращает владельца действующего ключа или nil
+func (s *Storage) UserByAPIKey(key string) (*User, error) {
+	var keyID int64
+	var u User
+	err := s.db.QueryRow(userByKeySQL, hashAPIKey(key)).Scan(&keyID, &u.ID, &u.Name, &u.IsAdmin, &u.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка проверки ключа: %w", err)
+	}
+
+	cutoff := time.Now().Add(-apiKeyTouchInterval).UTC().Format(sqlTimeLayout)
+	if _, err := s.db.Exec(touchKeySQL, keyID, cutoff); err != nil {
+		return nil, fmt.Errorf("ошибка обновления ключа: %w", err)
+	}
+	return &u, nil
+}
+
+// ClaimSession создает сессию за пользователем, если ее еще нет, и возвращает владельца
+// ("" — сессия создана без авторизации)
+func (s *Storage) ClaimSession(sessionID, userID string) (string, error) {
+	if _, err := s.db.Exec(claimSessionSQL, sessionID, userID); err != nil {
+		return "", fmt.Errorf("ошибка создания сессии: %w", err)
+	}
+	owner, _, err := s.SessionOwner(sessionID)
+	return owner, err
+}
+
+// SessionOwner возвращает владельца сессии; found — false, если сессии нет
+func (s *Storage) SessionOwner(sessionID string) (string, bool, error) {
+	var owner string
+	if err := s.db.QueryRow(sessionOwnerSQL, sessionID).Scan(&owner); err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("ошибка получения владельца сессии: %w", err)
+	}
+	return owner, true, nil
+}
+
+// CreateUser создает пользователя. Возвращает nil, если имя уже занято.
+func (p *PostgresStore) CreateUser(name string, isAdmin bool) (*User, error) {
+	id := uuid.New().String()
+	tag, err := p.exec(insertUserSQL, id, name, isAdmin)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания пользователя: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, nil
+	}
+	return p.GetUser(id)
+}
+
+// GetUser возвращает пользователя или nil, если его нет
+func (p *PostgresStore) GetUser(id string) (*User, error) {
+	u, err := scanUser(p.queryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка получения пользователя: %w", err)
+	}
+	return &u, nil
+}
+
+// ListUsers возвращает пользователей в порядке создания
+func (p *PostgresStore) ListUsers() ([]User, error) {
+	rows, err := p.query("SELECT " + userColumns + " FROM users ORDER BY created_at, id")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения пользователей: %w", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования пользователя: %w", err)
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// CreateAPIKey выпускает ключ пользователю и возвращает его вместе с самим ключом
+func (p *PostgresStore) CreateAPIKey(userID, name string) (*APIKey, string, error) {
+	key, prefix, hash, err := newAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	k, err := scanAPIKey(p.queryRow(insertAPIKeySQL+" RETURNING "+apiKeyColumns, userID, name, prefix, hash))
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка создания ключа: %w", err)
+	}
+	return &k, key, nil
+}
+
+// ListAPIKeys возвращает ключи пользователя, включая отозванные
+func (p *PostgresStore) ListAPIKeys(userID string) ([]APIKey, error) {
+	rows, err := p.query("SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения ключей: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования ключа: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey отзывает ключ пользователя. Возвращает false, если действующего ключа нет.
+func (p *PostgresStore) RevokeAPIKey(userID string, keyID int64) (bool, error) {
+	tag, err := p.exec(revokeKeySQL, keyID, userID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка отзыва ключа: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// UserByAPIKey возвращает владельца действующего ключа или nil
+func (p *PostgresStore) UserByAPIKey(key string) (*User, error) {
+	var keyID int64
+	var u User
+	err := p.queryRow(userByKeySQL, hashAPIKey(key)).Scan(&keyID, &u.ID, &u.Name, &u.IsAdmin, &u.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка проверки ключа: %w", err)
+	}
+
+	if _, err := p.exec(touchKeySQL, keyID, time.Now().Add(-apiKeyTouchInterval)); err != nil {
+		return nil, fmt.Errorf("ошибка обновления ключа: %w", err)
+	}
+	return &u, nil
+}
+
+// ClaimSession создает сессию за пользователем, если ее еще нет, и возвращает владельца
+// ("" — сессия создана без авторизации)
+func (p *PostgresStore) ClaimSession(sessionID, userID string) (string, error) {
+	if _, err := p.exec(claimSessionSQL, sessionID, userID); err != nil {
+		return "", fmt.Errorf("ошибка создания сессии: %w", err)
+	}
+	owner, _, err := p.SessionOwner(sessionID)
+	return owner, err
+}
+
+// SessionOwner возвращает владельца сессии; found — false, если сессии нет
+func (p *PostgresStore) SessionOwner(sessionID string) (string, bool, error) {
+	var owner string
+	if err := p.queryRow(sessionOwnerSQL, sessionID).Scan(&owner); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("ошибка получения владельца сессии: %w", err)
+	}
+	return owner, true, nil
+}
@@ -0,0 +1,199 @@
+package tokenizer
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BPE byte-level BPE токенизатор (формат словаря tiktoken, например cl100k_base).
+// Текст разбивается претокенизатором на куски, каждый кусок кодируется слиянием
+// пар байтов по рангам из словаря.
+type BPE struct {
+	name    string
+	ranks   map[string]int // последовательность байтов -> ранг (он же id токена)
+	decoder map[int]string // id токена -> последовательность байтов
+}
+
+// LoadTiktoken загружает словарь в формате .tiktoken: в каждой строке
+// "<base64 токена> <ранг>". Имя токенизатора берется из имени файла.
+func LoadTiktoken(filePath string) (*BPE, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия словаря %s: %w", filePath, err)
+	}
+	defer f.Close()
+
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("некорректная строка %d в %s", lineNum, filePath)
+		}
+		token, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("ошибка декодирования токена в строке %d: %w", lineNum, err)
+		}
+		rank, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("некорректный ранг в строке %d: %w", lineNum, err)
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения словаря %s: %w", filePath, err)
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("словарь %s пуст", filePath)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	return NewBPE(name, ranks), nil
+}
+
+// NewBPE создает BPE токенизатор из готовой таблицы рангов
+func NewBPE(name string, ranks map[string]int) *BPE {
+	decoder := make(map[int]string, len(ranks))
+	for token, rank := range ranks {
+		decoder[rank] = token
+	}
+	return &BPE{
+		name:    name,
+		ranks:   ranks,
+		decoder: decoder,
+	}
+}
+
+// Name возвращает имя токенизатора
+func (b *BPE) Name() string {
+	return b.name
+}
+
+// Count возвращает количество токенов в тексте
+func (b *BPE) Count(text string) int {
+	count := 0
+	for _, piece := range splitCL100k(text) {
+		if _, ok := b.ranks[piece]; ok {
+			count++
+			continue
+		}
+		count += len(b.mergePiece(piece))
+	}
+	return count
+}
+
+// Encode кодирует текст в идентификаторы токенов
+func (b *BPE) Encode(text string) []int {
+	var ids []int
+	for _, piece := range splitCL100k(text) {
+		if rank, ok := b.ranks[piece]; ok {
+			ids = append(ids, rank)
+			continue
+		}
+		for _, part := range b.mergePiece(piece) {
+			if rank, ok := b.ranks[part]; ok {
+				ids = append(ids, rank)
+			}
+		}
+	}
+	return ids
+}
+
+// Decode восстанавливает текст по идентификаторам токенов
+func (b *BPE) Decode(ids []int) string {
+	var sb strings.Builder
+	for _, id := range ids {
+		sb.WriteString(b.decoder[id])
+	}
+	return sb.String()
+}
+
+// mergePiece разбивает кусок на отдельные байты и сливает соседние пары
+// с минимальным рангом (при равных рангах — самую левую), пока это возможно.
+// Части хранятся связным списком смещений в piece, пары — в куче по рангу:
+// каждое слияние стоит O(log n), а не проход по всем парам.
+func (b *BPE) mergePiece(piece string) []string {
+	n := len(piece)
+	if n <= 1 {
+		return []string{piece}
+	}
+
+	// Часть i — piece[i:end[i]]; next/prev — соседние части (-1 и n — границы)
+	end := make([]int, n)
+	prev := make([]int, n)
+	for i := range end {
+		end[i] = i + 1
+		prev[i] = i - 1
+	}
+
+	pairs := &mergeHeap{}
+	pushPair := func(i int) {
+		if j := end[i]; j < n {
+			if rank, ok := b.ranks[piece[i:end[j]]]; ok {
+				heap.Push(pairs, mergePair{rank: rank, left: i, right: j, end: end[j]})
+			}
+		}
+	}
+	for i := 0; i < n-1; i++ {
+		pushPair(i)
+	}
+
+	for pairs.Len() > 0 {
+		p := heap.Pop(pairs).(mergePair)
+		// Пара устарела, если одна из частей уже слилась с другим соседом
+		if end[p.left] != p.right || end[p.right] != p.end {
+			continue
+		}
+		end[p.left] = p.end
+		end[p.right] = -1 // часть поглощена
+		if p.end < n {
+			prev[p.end] = p.left
+		}
+		if prev[p.left] >= 0 {
+			pushPair(prev[p.left])
+		}
+		pushPair(p.left)
+	}
+
+	var parts []string
+	for i := 0; i < n; i = end[i] {
+		parts = append(parts, piece[i:end[i]])
+	}
+	return parts
+}
+
+// mergePair пара соседних частей piece[left:right] и piece[right:end] с рангом их слияния
+type mergePair struct {
+	rank, left, right, end int
+}
+
+// mergeHeap куча пар: сначала минимальный ранг, при равенстве — левая пара
+type mergeHeap []mergePair
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].rank != h[j].rank {
+		return h[i].rank < h[j].rank
+	}
+	return h[i].left < h[j].left
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(mergePair)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
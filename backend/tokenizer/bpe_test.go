@@ -0,0 +1,158 @@
+package tokenizer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testdata/mini.tiktoken — маленький словарь в формате tiktoken: 256 байтов и несколько
+// десятков слияний для английских и русских слов. Ожидаемые id посчитаны эталонной
+// реализацией byte-pair merge (как tiktoken._byte_pair_merge) по тем же кускам текста.
+func loadMiniBPE(t *testing.T) *BPE {
+	t.Helper()
+	bpe, err := LoadTiktoken("testdata/mini.tiktoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bpe
+}
+
+func TestBPEGoldenMini(t *testing.T) {
+	bpe := loadMiniBPE(t)
+	if bpe.Name() != "mini" {
+		t.Errorf("Name = %q", bpe.Name())
+	}
+
+	tests := []struct {
+		text string
+		ids  []int
+	}{
+		// Hello | , | " world" | ! | " It" | 's | " " | 202 | 4 | .
+		{"Hello, world! It's 2024.", []int{259, 44, 264, 33, 266, 267, 32, 269, 52, 46}},
+		// Привет | , | " мир" | ! | " Как" | " дела" (" д" + "ел" + "а") | ?
+		{"Привет, мир! Как дела?", []int{280, 44, 284, 33, 290, 293, 294, 287, 63}},
+		{"Hello мир", []int{259, 284}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		ids := bpe.Encode(tt.text)
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("Encode(%q) = %v, ожидалось %v", tt.text, ids, tt.ids)
+		}
+		if got := bpe.Count(tt.text); got != len(tt.ids) {
+			t.Errorf("Count(%q) = %d, ожидалось %d", tt.text, got, len(tt.ids))
+		}
+		if got := bpe.Decode(ids); got != tt.text {
+			t.Errorf("Decode(Encode(%q)) = %q", tt.text, got)
+		}
+	}
+}
+
+func TestSplitCL100k(t *testing.T) {
+	tests := []struct {
+		text   string
+		pieces []string
+	}{
+		{"Hello, world! It's 2024.", []string{"Hello", ",", " world", "!", " It", "'s", " ", "202", "4", "."}},
+		{"Привет, мир! Как дела?", []string{"Привет", ",", " мир", "!", " Как", " дела", "?"}},
+		{"a  b\n\nc", []string{"a", " ", " b", "\n\n", "c"}},
+		{"x = 12345;", []string{"x", " =", " ", "123", "45", ";"}},
+		{"WE'LL go", []string{"WE", "'LL", " go"}},
+		{"end   ", []string{"end", "   "}},
+	}
+	for _, tt := range tests {
+		if got := splitCL100k(tt.text); !reflect.DeepEqual(got, tt.pieces) {
+			t.Errorf("splitCL100k(%q) = %q, ожидалось %q", tt.text, got, tt.pieces)
+		}
+	}
+}
+
+// Эталонные выходы tiktoken для настоящего словаря cl100k_base (GPT-3.5/GPT-4).
+// testdata/cl100k_base.tiktoken — официальный файл OpenAI
+// (sha256 223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcffe865b2a7).
+func TestBPEGoldenCL100k(t *testing.T) {
+	bpe, err := LoadTiktoken("testdata/cl100k_base.tiktoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text string
+		ids  []int
+	}{
+		{"hello world", []int{15339, 1917}},
+		{"Hello, world!", []int{9906, 11, 1917, 0}},
+		{"tiktoken is great!", []int{83, 1609, 5963, 374, 2294, 0}},
+		{"antidisestablishmentarianism", []int{519, 85342, 34500, 479, 8997, 2191}},
+		{"2 + 2 = 4", []int{17, 489, 220, 17, 284, 220, 19}},
+		{"The quick brown fox jumps over the lazy dog.", []int{791, 4062, 14198, 39935, 35308, 927, 279, 16053, 5679, 13}},
+		{"お誕生日おめでとう", []int{33334, 45918, 243, 21990, 9080, 33334, 62004, 16556, 78699}},
+
+		// Кириллица: слова распадаются на многобайтовые куски, токенов заметно больше, чем слов
+		{"Привет, мир!", []int{54745, 28089, 8341, 11, 11562, 78746, 0}},
+		{"Привет, мир! Как дела?", []int{54745, 28089, 8341, 11, 11562, 78746, 0, 36479, 16248, 95369, 1506, 30}},
+		{"Съешь же ещё этих мягких французских булок, да выпей чаю.", []int{
+			19871, 34694, 47067, 4929, 58317, 1532, 22918, 14476, 45122, 21599, 23311, 10693,
+			11562, 4329, 15752, 17165, 10693, 18034, 35682, 10589, 3865, 9136, 80112, 10693,
+			14391, 3865, 3114, 15088, 11, 92910, 72060, 21708, 17756, 1506, 12182, 13,
+		}},
+		{"Контекстное окно модели ограничено: историю нужно обрезать.", []int{
+			27435, 97341, 15298, 6735, 48150, 72664, 13999, 71239, 61642, 8379, 15752, 2233,
+			14332, 6148, 73281, 25, 7740, 6735, 9239, 1840, 12182, 77083, 13999, 21923,
+			2233, 32985, 18482, 13,
+		}},
+	}
+	for _, tt := range tests {
+		if got := bpe.Encode(tt.text); !reflect.DeepEqual(got, tt.ids) {
+			t.Errorf("Encode(%q) = %v, ожидалось %v", tt.text, got, tt.ids)
+		}
+		if got := bpe.Count(tt.text); got != len(tt.ids) {
+			t.Errorf("Count(%q) = %d, ожидалось %d", tt.text, got, len(tt.ids))
+		}
+		if got := bpe.Decode(tt.ids); got != tt.text {
+			t.Errorf("Decode(%v) = %q, ожидалось %q", tt.ids, got, tt.text)
+		}
+	}
+}
+
+// longWord слово из size байтов без пробелов: претокенизатор отдает его одним куском
+func longWord(size int) string {
+	return strings.Repeat("Превосходно", size/len("Превосходно")+1)[:size-size%2]
+}
+
+// Слияние пар не должно быть квадратичным: кусок произвольной длины приходит
+// из тела запроса, а Count вызывается при подгонке истории несколько раз
+func TestBPELongWord(t *testing.T) {
+	bpe, err := LoadTiktoken("testdata/cl100k_base.tiktoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := longWord(100 << 10)
+
+	start := time.Now()
+	ids := bpe.Encode(text)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Encode 100 КБ заняло %s", elapsed)
+	}
+	if got := bpe.Count(text); got != len(ids) {
+		t.Errorf("Count = %d, ожидалось %d", got, len(ids))
+	}
+	if bpe.Decode(ids) != text {
+		t.Error("Decode(Encode(text)) != text")
+	}
+}
+
+func BenchmarkBPECountLongWord(b *testing.B) {
+	bpe, err := LoadTiktoken("testdata/cl100k_base.tiktoken")
+	if err != nil {
+		b.Fatal(err)
+	}
+	text := longWord(100 << 10)
+	b.SetBytes(int64(len(text)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bpe.Count(text)
+	}
+}
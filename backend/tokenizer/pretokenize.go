@@ -0,0 +1,123 @@
+package tokenizer
+
+import (
+	"unicode"
+)
+
+// splitCL100k разбивает текст на куски так же, как регулярное выражение cl100k_base:
+//
+//	(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+//
+// Стандартный regexp не поддерживает (?!\S), поэтому разбор написан вручную.
+func splitCL100k(text string) []string {
+	runes := []rune(text)
+	n := len(runes)
+	var pieces []string
+
+	for i := 0; i < n; {
+		end := matchCL100k(runes, i)
+		pieces = append(pieces, string(runes[i:end]))
+		i = end
+	}
+	return pieces
+}
+
+// matchCL100k возвращает конец куска, начинающегося с позиции i
+func matchCL100k(r []rune, i int) int {
+	n := len(r)
+	c := r[i]
+
+	// 's|'t|'re|'ve|'m|'ll|'d (без учета регистра)
+	if c == '\'' && i+1 < n {
+		next := unicode.ToLower(r[i+1])
+		if i+2 < n {
+			next2 := unicode.ToLower(r[i+2])
+			if (next == 'r' && next2 == 'e') || (next == 'v' && next2 == 'e') || (next == 'l' && next2 == 'l') {
+				// 's|'t идут раньше в альтернативе, но с 're/'ve/'ll не пересекаются
+				return i + 3
+			}
+		}
+		switch next {
+		case 's', 't', 'm', 'd':
+			return i + 2
+		}
+	}
+
+	// [^\r\n\p{L}\p{N}]?\p{L}+
+	if isLetter(c) {
+		return scanLetters(r, i)
+	}
+	if c != '\r' && c != '\n' && !isNumber(c) && i+1 < n && isLetter(r[i+1]) {
+		return scanLetters(r, i+1)
+	}
+
+	// \p{N}{1,3}
+	if isNumber(c) {
+		j := i
+		for j < n && j-i < 3 && isNumber(r[j]) {
+			j++
+		}
+		return j
+	}
+
+	// ' ?[^\s\p{L}\p{N}]+[\r\n]*'
+	start := i
+	if c == ' ' && i+1 < n && isOther(r[i+1]) {
+		start = i + 1
+	}
+	if isOther(r[start]) {
+		j := start
+		for j < n && isOther(r[j]) {
+			j++
+		}
+		for j < n && (r[j] == '\r' || r[j] == '\n') {
+			j++
+		}
+		return j
+	}
+
+	// Дальше только пробельные символы
+	wsEnd := i
+	lastNewline := -1
+	for wsEnd < n && unicode.IsSpace(r[wsEnd]) {
+		if r[wsEnd] == '\r' || r[wsEnd] == '\n' {
+			lastNewline = wsEnd
+		}
+		wsEnd++
+	}
+	if wsEnd == i {
+		// Символ не попал ни в одну категорию (не должно случаться)
+		return i + 1
+	}
+
+	// \s*[\r\n]+
+	if lastNewline >= 0 {
+		return lastNewline + 1
+	}
+
+	// \s+(?!\S): последний пробел остается для следующего слова
+	if wsEnd == n || wsEnd-i == 1 {
+		return wsEnd
+	}
+	return wsEnd - 1
+}
+
+func scanLetters(r []rune, j int) int {
+	for j < len(r) && isLetter(r[j]) {
+		j++
+	}
+	return j
+}
+
+func isLetter(c rune) bool {
+	return unicode.IsLetter(c)
+}
+
+func isNumber(c rune) bool {
+	return unicode.IsNumber(c)
+}
+
+// isOther символ, не являющийся пробелом, буквой или цифрой
+func isOther(c rune) bool {
+	return !unicode.IsSpace(c) && !isLetter(c) && !isNumber(c)
+}
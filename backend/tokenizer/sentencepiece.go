@@ -0,0 +1,230 @@
+package tokenizer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// spaceSymbol символ, которым SentencePiece заменяет пробел
+const spaceSymbol = "▁"
+
+// SentencePiece BPE токенизатор со словарем SentencePiece (модели Llama).
+// Пробелы заменяются на "▁", в начало текста добавляется "▁", затем соседние
+// символы сливаются по убыванию score. Неизвестные символы кодируются байтами
+// (<0xXX>), если такие токены есть в словаре.
+type SentencePiece struct {
+	name   string
+	pieces map[string]int // токен -> id
+	scores []float64      // id -> score
+	vocab  []string       // id -> токен
+	unkID  int
+}
+
+// LoadSentencePiece загружает словарь в формате .vocab (вывод spm_export_vocab):
+// в каждой строке "<токен>\t<score>", id токена равен номеру строки.
+func LoadSentencePiece(filePath string) (*SentencePiece, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия словаря %s: %w", filePath, err)
+	}
+	defer f.Close()
+
+	var vocab []string
+	var scores []float64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		piece, scoreText, ok := strings.Cut(line, "\t")
+		if !ok {
+			return nil, fmt.Errorf("некорректная строка %d в %s", lineNum, filePath)
+		}
+		score, err := strconv.ParseFloat(strings.TrimSpace(scoreText), 64)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный score в строке %d: %w", lineNum, err)
+		}
+		vocab = append(vocab, piece)
+		scores = append(scores, score)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения словаря %s: %w", filePath, err)
+	}
+	if len(vocab) == 0 {
+		return nil, fmt.Errorf("словарь %s пуст", filePath)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	return NewSentencePiece(name, vocab, scores), nil
+}
+
+// NewSentencePiece создает токенизатор из списка токенов и их score (индекс — id)
+func NewSentencePiece(name string, vocab []string, scores []float64) *SentencePiece {
+	pieces := make(map[string]int, len(vocab))
+	unkID := 0
+	for id, piece := range vocab {
+		if _, exists := pieces[piece]; !exists {
+			pieces[piece] = id
+		}
+		if piece == "<unk>" {
+			unkID = id
+		}
+	}
+	return &SentencePiece{
+		name:   name,
+		pieces: pieces,
+		scores: scores,
+		vocab:  vocab,
+		unkID:  unkID,
+	}
+}
+
+// Name возвращает имя токенизатора
+func (s *SentencePiece) Name() string {
+	return s.name
+}
+
+// Count возвращает количество токенов в тексте
+func (s *SentencePiece) Count(text string) int {
+	return len(s.Encode(text))
+}
+
+// Encode кодирует текст в идентификаторы токенов
+func (s *SentencePiece) Encode(text string) []int {
+	if text == "" {
+		return nil
+	}
+	normalized := spaceSymbol + strings.ReplaceAll(text, " ", spaceSymbol)
+
+	// Токены SentencePiece не пересекают границу слова: каждое слово
+	// (начинающееся с "▁") кодируется отдельно
+	var ids []int
+	for _, word := range splitWords(normalized) {
+		ids = s.encodeWord(word, ids)
+	}
+	return ids
+}
+
+// Decode восстанавливает текст по идентификаторам токенов
+func (s *SentencePiece) Decode(ids []int) string {
+	var sb strings.Builder
+	var pending []byte
+	flush := func() {
+		if len(pending) > 0 {
+			sb.Write(pending)
+			pending = pending[:0]
+		}
+	}
+	for _, id := range ids {
+		if id < 0 || id >= len(s.vocab) {
+			continue
+		}
+		piece := s.vocab[id]
+		if b, ok := parseByteToken(piece); ok {
+			pending = append(pending, b)
+			continue
+		}
+		flush()
+		sb.WriteString(piece)
+	}
+	flush()
+
+	text := strings.ReplaceAll(sb.String(), spaceSymbol, " ")
+	return strings.TrimPrefix(text, " ")
+}
+
+// encodeWord кодирует одно слово и дописывает id в ids
+func (s *SentencePiece) encodeWord(word string, ids []int) []int {
+	if id, ok := s.pieces[word]; ok {
+		return append(ids, id)
+	}
+
+	symbols := make([]string, 0, len(word))
+	for _, r := range word {
+		symbols = append(symbols, string(r))
+	}
+
+	for len(symbols) > 1 {
+		bestScore := 0.0
+		bestIdx := -1
+		for i := 0; i < len(symbols)-1; i++ {
+			id, ok := s.pieces[symbols[i]+symbols[i+1]]
+			if !ok {
+				continue
+			}
+			if bestIdx == -1 || s.scores[id] > bestScore {
+				bestScore = s.scores[id]
+				bestIdx = i
+			}
+		}
+		if bestIdx == -1 {
+			break
+		}
+		symbols[bestIdx] = symbols[bestIdx] + symbols[bestIdx+1]
+		symbols = append(symbols[:bestIdx+1], symbols[bestIdx+2:]...)
+	}
+
+	for _, sym := range symbols {
+		if id, ok := s.pieces[sym]; ok {
+			ids = append(ids, id)
+			continue
+		}
+		// Byte fallback: неизвестный символ кодируется по байтам UTF-8
+		fallback := true
+		for i := 0; i < len(sym); i++ {
+			if _, ok := s.pieces[byteToken(sym[i])]; !ok {
+				fallback = false
+				break
+			}
+		}
+		if !fallback {
+			ids = append(ids, s.unkID)
+			continue
+		}
+		for i := 0; i < len(sym); i++ {
+			ids = append(ids, s.pieces[byteToken(sym[i])])
+		}
+	}
+	return ids
+}
+
+// splitWords разбивает нормализованный текст перед каждым "▁"
+func splitWords(text string) []string {
+	var words []string
+	start := 0
+	for i := len(spaceSymbol); i < len(text); {
+		if strings.HasPrefix(text[i:], spaceSymbol) {
+			words = append(words, text[start:i])
+			start = i
+			i += len(spaceSymbol)
+			continue
+		}
+		i++
+	}
+	return append(words, text[start:])
+}
+
+// byteToken имя токена для байта в формате SentencePiece (<0x0A>)
+func byteToken(b byte) string {
+	return fmt.Sprintf("<0x%02X>", b)
+}
+
+// parseByteToken разбирает токен вида <0x0A>
+func parseByteToken(piece string) (byte, bool) {
+	if len(piece) != 6 || !strings.HasPrefix(piece, "<0x") || piece[5] != '>' {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(piece[3:5], 16, 8)
+	if err != nil {
+		return 0, false
+	}
+	return byte(v), true
+}
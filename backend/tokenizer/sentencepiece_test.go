@@ -0,0 +1,86 @@
+package tokenizer
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// testdata/mini.vocab — маленький словарь SentencePiece в формате spm_export_vocab:
+// <unk>, <s>, </s>, 256 байтовых токенов и слияния для английских и русских слов.
+// Ожидаемые id посчитаны эталонной реализацией BPE SentencePiece (слияние пары
+// с наибольшим score, byte fallback для неизвестных символов).
+func TestSentencePieceGoldenMini(t *testing.T) {
+	sp, err := LoadSentencePiece("testdata/mini.vocab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp.Name() != "mini" {
+		t.Errorf("Name = %q", sp.Name())
+	}
+
+	tests := []struct {
+		text string
+		ids  []int
+	}{
+		{"Hello world", []int{278, 282}},
+		{"Привет мир", []int{288, 291}},
+		{"Hello мир", []int{278, 291}},
+		// ▁Hello + w: слияния ll → He → llo → Hello → ▁Hello
+		{"Hellow Приве", []int{278, 264, 287, 271}},
+		// i и ё нет в словаре — кодируются байтами UTF-8
+		{"Hi ё", []int{259, 260, 108, 259, 212, 148}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		ids := sp.Encode(tt.text)
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("Encode(%q) = %v, ожидалось %v", tt.text, ids, tt.ids)
+		}
+		if got := sp.Count(tt.text); got != len(tt.ids) {
+			t.Errorf("Count(%q) = %d, ожидалось %d", tt.text, got, len(tt.ids))
+		}
+		if got := sp.Decode(ids); got != tt.text {
+			t.Errorf("Decode(Encode(%q)) = %q", tt.text, got)
+		}
+	}
+}
+
+func TestSentencePieceUnknownWithoutByteFallback(t *testing.T) {
+	sp := NewSentencePiece("tiny", []string{"<unk>", "▁", "a"}, []float64{0, -1, -2})
+	if got, want := sp.Encode("a ж"), []int{1, 2, 1, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode = %v, ожидалось %v", got, want)
+	}
+}
+
+// Эталонные выходы SentencePiece для словаря Llama 2 (без BOS).
+// Словарь в репозиторий не входит: задайте TOKENIZER_LLAMA_VOCAB=путь/к/tokenizer.vocab
+// (spm_export_vocab --model=tokenizer.model).
+func TestSentencePieceGoldenLlama(t *testing.T) {
+	path := os.Getenv("TOKENIZER_LLAMA_VOCAB")
+	if path == "" {
+		t.Skip("TOKENIZER_LLAMA_VOCAB не задан")
+	}
+	sp, err := LoadSentencePiece(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := sp.Encode("Hello world"), []int{15043, 3186}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode(%q) = %v, ожидалось %v", "Hello world", got, want)
+	}
+
+	// Русский текст: без <unk>, декодируется без потерь
+	for _, text := range []string{"Привет, мир!", "Съешь же ещё этих мягких французских булок, да выпей чаю."} {
+		ids := sp.Encode(text)
+		for _, id := range ids {
+			if id == sp.unkID {
+				t.Errorf("Encode(%q) содержит <unk>: %v", text, ids)
+				break
+			}
+		}
+		if got := sp.Decode(ids); got != text {
+			t.Errorf("Decode(Encode(%q)) = %q", text, got)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package tokenizer
+
+import (
+	"fmt"
+	"path"
+	"sync"
+	"unicode/utf8"
+)
+
+// Tokenizer считает токены в тексте
+type Tokenizer interface {
+	// Name возвращает имя токенизатора (например cl100k_base или approximate)
+	Name() string
+
+	// Count возвращает количество токенов в тексте
+	Count(text string) int
+}
+
+// Encoder токенизатор, умеющий кодировать текст в идентификаторы токенов и обратно
+type Encoder interface {
+	Tokenizer
+
+	// Encode кодирует текст в идентификаторы токенов
+	Encode(text string) []int
+
+	// Decode восстанавливает текст по идентификаторам токенов
+	Decode(ids []int) string
+}
+
+// Типы токенизаторов в конфигурации
+const (
+	TypeTiktoken      = "tiktoken"      // byte-level BPE в формате .tiktoken (cl100k_base и т.п.)
+	TypeSentencePiece = "sentencepiece" // BPE со словарем SentencePiece (.vocab), модели Llama
+)
+
+// Approximate приблизительный токенизатор: ~4 символа на токен.
+// Используется, когда для провайдера/модели не настроен словарь.
+type Approximate struct{}
+
+// Name возвращает имя токенизатора
+func (Approximate) Name() string {
+	return "approximate"
+}
+
+// Count приблизительно подсчитывает количество токенов (минимум 1 для непустого текста)
+func (Approximate) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+
+	charCount := utf8.RuneCountInString(text)
+	tokens := charCount / 4
+	if tokens < 1 && charCount > 0 {
+		tokens = 1
+	}
+	return tokens
+}
+
+// IsApproximate сообщает, является ли токенизатор приблизительным (или не задан)
+func IsApproximate(t Tokenizer) bool {
+	if t == nil {
+		return true
+	}
+	_, ok := t.(Approximate)
+	return ok
+}
+
+// Load загружает токенизатор указанного типа из локального файла
+func Load(typ, filePath string) (Encoder, error) {
+	switch typ {
+	case TypeTiktoken:
+		return LoadTiktoken(filePath)
+	case TypeSentencePiece:
+		return LoadSentencePiece(filePath)
+	default:
+		return nil, fmt.Errorf("неизвестный тип токенизатора: %s", typ)
+	}
+}
+
+// rule правило выбора токенизатора
+type rule struct {
+	provider string // имя провайдера или "*"
+	model    string // шаблон модели (path.Match), пусто — любая
+	tok      Tokenizer
+}
+
+// Registry выбирает токенизатор по провайдеру и модели.
+// Правила проверяются в порядке добавления, первое совпадение побеждает.
+type Registry struct {
+	mu    sync.RWMutex
+	rules []rule
+}
+
+// NewRegistry создает пустой реестр (всегда возвращает Approximate)
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Add добавляет правило: provider — имя провайдера или "*", model — шаблон модели
+// в синтаксисе path.Match (например "llama-3*"), пустой шаблон означает любую модель
+func (r *Registry) Add(provider, model string, tok Tokenizer) error {
+	if model != "" {
+		if _, err := path.Match(model, ""); err != nil {
+			return fmt.Errorf("некорректный шаблон модели %q: %w", model, err)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rules = append(r.rules, rule{provider: provider, model: model, tok: tok})
+	return nil
+}
+
+// For возвращает токенизатор для провайдера и модели, по умолчанию Approximate
+func (r *Registry) For(provider, model string) Tokenizer {
+	if r == nil {
+		return Approximate{}
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rl := range r.rules {
+		if rl.provider != "*" && rl.provider != provider {
+			continue
+		}
+		if rl.model != "" {
+			if ok, _ := path.Match(rl.model, model); !ok {
+				continue
+			}
+		}
+		return rl.tok
+	}
+	return Approximate{}
+}
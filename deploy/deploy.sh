@@ -25,7 +25,7 @@ cp -r frontend/dist/* backend/static/
 
 echo "Сборка backend..."
 cd backend
-go build -o server .
+go build -tags sqlite_fts5 -o server .
 cd ..
 
 echo "Копирование файлов на сервер..."
@@ -1,11 +1,128 @@
-export interface ChatMessage {
+// Происхождение ответа модели (есть у ответов, сохраненных через /api/v2/chat)
+export interface MessageProvenance {
+  provider?: string;
+  model?: string;
+  reasoning_mode?: string;
+  temperature?: number;
+  tokens_input?: number;
+  tokens_output?: number;
+  cost?: number;
+  duration_ms?: number;
+  request_log_id?: number;
+}
+
+// API-ключ сервера с авторизацией (auth.enabled): хранится в localStorage и добавляется ко всем запросам
+const API_KEY_STORAGE = 'api_key';
+
+export function getApiKey(): string | null {
+  return localStorage.getItem(API_KEY_STORAGE);
+}
+
+export function setApiKey(key: string | null): void {
+  if (key) {
+    localStorage.setItem(API_KEY_STORAGE, key);
+  } else {
+    localStorage.removeItem(API_KEY_STORAGE);
+  }
+}
+
+function apiFetch(input: string, init: RequestInit = {}): Promise<Response> {
+  const key = getApiKey();
+  if (!key) {
+    return fetch(input, init);
+  }
+  const headers = new Headers(init.headers);
+  headers.set('Authorization', `Bearer ${key}`);
+  return fetch(input, { ...init, headers });
+}
+
+export interface User {
+  id: string;
+  name: string;
+  is_admin: boolean;
+  created_at: string;
+}
+
+export interface MeResponse {
+  auth_enabled: boolean;
+  user: User | null;
+}
+
+// Текущий пользователь; null — нужен API-ключ (нет или недействителен)
+export async function fetchMe(): Promise<MeResponse | null> {
+  const response = await apiFetch('/api/v2/me');
+  if (response.status === 401) {
+    return null;
+  }
+  if (!response.ok) {
+    throw new Error(`HTTP error! status: ${response.status}`);
+  }
+  return await response.json();
+}
+
+export interface ChatMessage extends MessageProvenance {
+  id?: number;
   role: 'user' | 'assistant';
   content: string;
+  finish_reason?: string; // stopped — ответ остановлен пользователем
+  parent_id?: number; // предыдущее сообщение ветки (нет — корень)
+}
+
+// Переключение на резервный провайдер (fallback_chain на сервере)
+export interface FallbackEvent {
+  from: string;
+  to: string;
+  error: string;
+}
+
+// Что из истории попало в окно модели (отправляется перед ответом)
+export interface ContextReport {
+  context_limit: number;
+  reserved_output: number;
+  budget: number;
+  used_tokens: number;
+  history_total: number;
+  history_kept: number;
+  dropped_messages: number;
+  truncated_messages: number;
+  summary_truncated?: boolean;
+  summary_dropped?: boolean;
+  overflow?: boolean;
+}
+
+// Фрагмент вызова инструмента в стриме
+export interface ToolCallDelta {
+  index: number;
+  id?: string;
+  name?: string;
+  arguments_delta?: string;
+}
+
+export interface ToolCall {
+  id: string;
+  name: string;
+  arguments: string; // JSON-строка
+}
+
+// Итог строгого JSON-режима (json_format)
+export interface JSONResult {
+  valid: boolean;
+  validation: 'schema' | 'syntax'; // syntax — схема не задана, задана словами или не поддерживается
+  schema_error?: string; // почему JSON Schema не применялась
+  object?: unknown; // проверенное значение
+  errors?: string[]; // ошибки последней проверки
+  attempts: number; // 1 + число попыток исправления
 }
 
 export interface ChatResponse {
   content?: string;
   error?: string;
+  fallback?: FallbackEvent;
+  context?: ContextReport;
+  tool_call?: ToolCallDelta;
+  tool_calls?: ToolCall[];
+  json_repair?: { attempt: number; errors: string[] };
+  json_result?: JSONResult;
 }
 
 export interface JSONResponseConfig {
@@ -53,12 +170,23 @@ export interface ReasoningModeInfo {
   description: string;
 }
 
+// Состояние провайдера (circuit breaker)
+export interface ProviderHealth {
+  state: 'closed' | 'open' | 'half_open';
+  consecutive_failures: number;
+  last_error?: string;
+  last_error_at?: string;
+  latency_ms: number;
+  last_check_at?: string;
+}
+
 // Информация о провайдере
 export interface ProviderInfo {
   name: string;
   models: string[];
   current_model: string;
   is_default: boolean;
+  health: ProviderHealth;
 }
 
 // Ответ API /api/v2/providers
@@ -81,11 +209,17 @@ export interface ChatRequestV2 {
   json_schema?: string;
   max_tokens?: number;
   temperature?: number;
+  legacy_stream?: boolean; // старый формат потока (data-кадры без имен событий)
+  edit_message_id?: number; // новая версия прошлого сообщения пользователя
+  regenerate_message_id?: number; // заново сгенерировать этот ответ ассистента
 }
 
 export interface RequestLog {
   id: number;
   session_id: string;
+  provider?: string;
+  model?: string;
+  reasoning_mode?: string;
   request_json: string;
   response_json: string;
   status_code: number;
@@ -133,7 +267,7 @@ export async function* sendMessage(
     }
   }
 
-  const response = await fetch('/api/chat', {
+  const response = await apiFetch('/api/chat', {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
@@ -203,7 +337,7 @@ export async function sendCollectMessage(
     body.collect_config = collectConfig;
   }
 
-  const response = await fetch('/api/chat/collect', {
+  const response = await apiFetch('/api/chat/collect', {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
@@ -220,7 +354,7 @@ export async function sendCollectMessage(
 }
 
 export async function fetchLogs(limit: number = 50): Promise<RequestLog[]> {
-  const response = await fetch(`/api/logs?limit=${limit}`);
+  const response = await apiFetch(`/api/logs?limit=${limit}`);
   if (!response.ok) {
     throw new Error(`HTTP error! status: ${response.status}`);
   }
@@ -229,7 +363,7 @@ export async function fetchLogs(limit: number = 50): Promise<RequestLog[]> {
 }
 
 export async function fetchHistory(sessionId: string, limit: number = 100): Promise<ChatMessage[]> {
-  const response = await fetch(`/api/history?session_id=${sessionId}&limit=${limit}`);
+  const response = await apiFetch(`/api/history?session_id=${sessionId}&limit=${limit}`);
   if (!response.ok) {
     throw new Error(`HTTP error! status: ${response.status}`);
   }
@@ -237,11 +371,56 @@ export async function fetchHistory(sessionId: string, limit: number = 100): Prom
   return data || [];
 }
 
+// Страница keyset-пагинации: next_cursor передается в тот же параметр (before_id или after_id)
+export interface PageParams {
+  limit?: number;
+  before_id?: number; // 0 — с самых новых записей
+  after_id?: number; // 0 — с самых старых записей
+}
+
+export interface HistoryPage {
+  messages: ChatMessage[];
+  next_cursor?: number;
+}
+
+export interface LogsPage {
+  logs: RequestLog[];
+  next_cursor?: number;
+}
+
+function pageQuery(params: PageParams): URLSearchParams {
+  const query = new URLSearchParams();
+  if (params.limit !== undefined) query.set('limit', String(params.limit));
+  if (params.before_id !== undefined) query.set('before_id', String(params.before_id));
+  if (params.after_id !== undefined) query.set('after_id', String(params.after_id));
+  return query;
+}
+
+export async function fetchHistoryPage(sessionId: string, params: PageParams): Promise<HistoryPage> {
+  const query = pageQuery(params);
+  query.set('session_id', sessionId);
+  const response = await apiFetch(`/api/history?${query}`);
+  if (!response.ok) {
+    throw new Error(`HTTP error! status: ${response.status}`);
+  }
+  return await response.json();
+}
+
+export async function fetchLogsPage(params: PageParams, sessionId?: string): Promise<LogsPage> {
+  const query = pageQuery(params);
+  if (sessionId) query.set('session_id', sessionId);
+  const response = await apiFetch(`/api/logs?${query}`);
+  if (!response.ok) {
+    throw new Error(`HTTP error! status: ${response.status}`);
+  }
+  return await response.json();
+}
+
 // ===== API v2 функции =====
 
 // Получение списка провайдеров
 export async function fetchProviders(): Promise<ProvidersResponse> {
-  const response = await fetch('/api/v2/providers');
+  const response = await apiFetch('/api/v2/providers');
   if (!response.ok) {
     throw new Error(`HTTP error! status: ${response.status}`);
   }
@@ -266,6 +445,7 @@ export interface TokenTestResult {
   tokens_input: number;
   tokens_output: number;
   tokens_total: number;
+  tokens_source: 'provider' | 'tokenizer' | 'estimate';
   cost: number;
   duration_ms: number;
   success: boolean;
@@ -289,10 +469,73 @@ export interface TokenTestResponse {
   summary: TokenTestSummary;
 }
 
+// Версия протокола событий /api/v2/chat (заголовок X-SSE-Protocol)
+export const SSE_PROTOCOL_VERSION = 2;
+
+export interface StreamMetaEvent {
+  protocol: number;
+  generation_id: string; // для переподключения через resumeGeneration
+  session_id: string;
+  provider: string;
+  model: string;
+}
+
+export interface StreamUsageEvent {
+  input_tokens: number;
+  output_tokens: number;
+  total_tokens: number;
+  source: string;
+  cost: number;
+}
+
+export interface StreamDoneEvent {
+  finish_reason?: string;
+  provider: string;
+  model: string;
+  requested_provider: string;
+  duration_ms: number;
+}
+
+// Событие потока: имя, id и данные (JSON)
+export interface StreamEvent {
+  id?: string;
+  event: string;
+  data: any;
+}
+
+export interface StreamHandlers {
+  onMeta?: (meta: StreamMetaEvent) => void;
+  onUsage?: (usage: StreamUsageEvent) => void;
+  onDone?: (done: StreamDoneEvent) => void;
+  onEvent?: (event: StreamEvent) => void; // все события, включая context, fallback, tool_call и т.д.
+}
+
+// Разбор одного SSE-кадра (поля id, event, data); формат проверяется на эталонах
+// backend/api/testdata/sse (tests/sse.test.mjs)
+export function parseSSEFrame(frame: string): { id?: string; event: string; data: string } | null {
+  let id: string | undefined;
+  let event = 'message';
+  const dataLines: string[] = [];
+  for (const line of frame.split('\n')) {
+    if (line.startsWith('id:')) {
+      id = line.slice(3).trim();
+    } else if (line.startsWith('event:')) {
+      event = line.slice(6).trim();
+    } else if (line.startsWith('data:')) {
+      dataLines.push(line.slice(5).replace(/^ /, ''));
+    }
+  }
+  if (dataLines.length === 0) {
+    return null;
+  }
+  return { id, event, data: dataLines.join('\n') };
+}
+
 export async function* sendMessageV2(
-  request: ChatRequestV2
+  request: ChatRequestV2,
+  handlers: StreamHandlers = {}
 ): AsyncGenerator<string, void, unknown> {
-  const response = await fetch('/api/v2/chat', {
+  const response = await apiFetch('/api/v2/chat', {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
@@ -300,6 +543,29 @@ export async function* sendMessageV2(
     body: JSON.stringify(request),
   });
 
+  yield* readChatStream(response, handlers);
+}
+
+// Переподключение к генерации после обрыва: сервер досылает события после lastEventId
+// (id последнего полученного события, см. StreamEvent.id) и продолжает поток
+export async function* resumeGeneration(
+  generationId: string,
+  lastEventId?: string,
+  handlers: StreamHandlers = {}
+): AsyncGenerator<string, void, unknown> {
+  const headers: Record<string, string> = {};
+  if (lastEventId) {
+    headers['Last-Event-ID'] = lastEventId;
+  }
+  const response = await apiFetch(`/api/v2/generations/${encodeURIComponent(generationId)}/stream`, { headers });
+
+  yield* readChatStream(response, handlers);
+}
+
+async function* readChatStream(
+  response: Response,
+  handlers: StreamHandlers
+): AsyncGenerator<string, void, unknown> {
   if (!response.ok) {
     throw new Error(`HTTP error! status: ${response.status}`);
   }
@@ -316,35 +582,331 @@ export async function* sendMessageV2(
     const { done, value } = await reader.read();
     if (done) break;
 
-    buffer += decoder.decode(value, { stream: true });
-    const lines = buffer.split('\n');
-    buffer = lines.pop() || '';
+    // CRLF нормализуем во всем буфере: \r и \n могут прийти в разных кусках
+    buffer = (buffer + decoder.decode(value, { stream: true })).replace(/\r\n/g, '\n');
+    const frames = buffer.split('\n\n');
+    buffer = frames.pop() || '';
 
-    for (const line of lines) {
-      if (line.startsWith('data: ')) {
-        const data = line.slice(6).trim();
-        if (data === '[DONE]') {
+    for (const raw of frames) {
+      const frame = parseSSEFrame(raw);
+      if (!frame) continue;
+
+      // Старый формат: безымянные кадры и [DONE]
+      if (frame.event === 'message') {
+        if (frame.data.trim() === '[DONE]') {
           return;
         }
-
+        let parsed: ChatResponse;
         try {
-          const parsed: ChatResponse = JSON.parse(data);
-          if (parsed.content) {
-            yield parsed.content;
-          } else if (parsed.error) {
-            throw new Error(parsed.error);
-          }
+          parsed = JSON.parse(frame.data);
         } catch (e) {
           // Игнорируем ошибки парсинга отдельных чанков
+          continue;
         }
+        if (parsed.content) {
+          yield parsed.content;
+        } else if (parsed.error) {
+          throw new Error(parsed.error);
+        }
+        continue;
+      }
+
+      let data: any;
+      try {
+        data = JSON.parse(frame.data);
+      } catch (e) {
+        continue;
+      }
+      handlers.onEvent?.({ id: frame.id, event: frame.event, data });
+
+      switch (frame.event) {
+        case 'meta':
+          handlers.onMeta?.(data);
+          break;
+        case 'delta':
+          if (data.content) {
+            yield data.content;
+          }
+          break;
+        case 'usage':
+          handlers.onUsage?.(data);
+          break;
+        case 'error':
+          throw new Error(data.message);
+        case 'done':
+          handlers.onDone?.(data);
+          return;
       }
     }
   }
 }
 
+// Генерация ответа на сервере (/api/v2/generations)
+export interface GenerationInfo {
+  id: string;
+  session_id: string;
+  done: boolean;
+  stopped?: boolean;
+  events: number;
+  created_at: string;
+  finished_at?: string;
+}
+
+// Остановка генерации: частичный ответ сохраняется с finish_reason = stopped
+export async function cancelGeneration(generationId: string): Promise<GenerationInfo> {
+  const response = await apiFetch(`/api/v2/generations/${encodeURIComponent(generationId)}/cancel`, {
+    method: 'POST',
+  });
+  if (!response.ok) {
+    throw new Error(`HTTP error! status: ${response.status}`);
+  }
+  return await response.json();
+}
+
+// Сессия чата: название и настройки по умолчанию (/api/v2/sessions)
+export interface Session {
+  id: string;
+  title: string;
+  provider?: string;
+  model?: string;
+  system_prompt?: string;
+  reasoning_mode?: ReasoningMode;
+  message_count: number;
+  active_leaf_id?: number;
+  created_at: string;
+  updated_at: string;
+}
+
+export type SessionPatch = Partial<Pick<Session, 'title' | 'provider' | 'model' | 'system_prompt' | 'reasoning_mode'>>;
+
+export async function fetchSessions(limit: number = 100): Promise<Session[]> {
+  const response = await apiFetch(`/api/v2/sessions?limit=${limit}`);
+  if (!response.ok) {
+    throw new Error(`HTTP error! status: ${response.status}`);
+  }
+  const data = await response.json();
+  return data.sessions || [];
+}
+
+export async function fetchSession(sessionId: string): Promise<Session> {
+  const response = await apiFetch(`/api/v2/sessions/${encodeURIComponent(sessionId)}`);
+  if (!response.ok) {
+    throw new Error(`HTTP error! status: ${response.status}`);
+  }
+  return await response.json();
+}
+
+export async function updateSession(sessionId: string, patch: SessionPatch): Promise<Session> {
+  const response = await apiFetch(`/api/v2/sessions/${encodeURIComponent(sessionId)}`, {
+    method: 'PATCH',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify(patch),
+  });
+  if (!response.ok) {
+    const errorText = await response.text();
+    throw new Error(`HTTP error! status: ${response.status}, body: ${errorText}`);
+  }
+  return await response.json();
+}
+
+export async function deleteSession(sessionId: string): Promise<void> {
+  const response = await apiFetch(`/api/v2/sessions/${encodeURIComponent(sessionId)}`, {
+    method: 'DELETE',
+  });
+  if (!response.ok) {
+    throw new Error(`HTTP error! status: ${response.status}`);
+  }
+}
+
+// Дерево сообщений сессии: все ветки и конец активной
+export interface MessageTree {
+  active_leaf_id: number;
+  messages: ChatMessage[];
+}
+
+export async function fetchMessageTree(sessionId: string): Promise<MessageTree> {
+  const response = await apiFetch(`/api/v2/sessions/${encodeURIComponent(sessionId)}/messages`);
+  if (!response.ok) {
+    throw new Error(`HTTP error! status: ${response.status}`);
+  }
+  return await response.json();
+}
+
+// Переключение на ветку, проходящую через messageId; возвращает сообщения новой активной ветки
+export async function switchBranch(sessionId: string, messageId: number): Promise<MessageTree> {
+  const response = await apiFetch(`/api/v2/sessions/${encodeURIComponent(sessionId)}/branch`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({ message_id: messageId }),
+  });
+  if (!response.ok) {
+    const errorText = await response.text();
+    throw new Error(`HTTP error! status: ${response.status}, body: ${errorText}`);
+  }
+  return await response.json();
+}
+
+// Экспорт и импорт сессий
+export type SessionExportFormat = 'json' | 'md' | 'jsonl';
+
+export interface SessionExport {
+  version: number;
+  exported_at: string;
+  session: Session;
+  messages: (Omit<ChatMessage, 'role'> & { role: 'user' | 'assistant' | 'summary'; created_at: string })[];
+}
+
+// Ссылка на скачивание экспорта (ответ с Content-Disposition: attachment).
+// Ссылка не передает API-ключ — на сервере с авторизацией скачивайте через apiFetch.
+export function sessionExportUrl(sessionId: string, format: SessionExportFormat = 'json'): string {
+  return `/api/v2/sessions/${encodeURIComponent(sessionId)}/export?format=${format}`;
+}
+
+export async function importSession(data: SessionExport): Promise<Session> {
+  const response = await apiFetch('/api/v2/sessions/import', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify(data),
+  });
+  if (!response.ok) {
+    throw new Error(`HTTP error! status: ${response.status}`);
+  }
+  return await response.json();
+}
+
+// Поиск по сообщениям всех сессий (/api/v2/search)
+export interface SearchParams {
+  q: string;
+  session_id?: string;
+  role?: 'user' | 'assistant' | 'summary';
+  from?: string; // YYYY-MM-DD или RFC3339
+  to?: string;
+  limit?: number;
+  cursor?: string;
+}
+
+export interface SearchResult {
+  message_id: number;
+  session_id: string;
+  session_title: string;
+  role: 'user' | 'assistant' | 'summary';
+  snippet: string; // HTML: текст экранирован, найденные слова обрамлены <mark></mark>
+  created_at: string;
+}
+
+export interface SearchResponse {
+  mode: 'fts5' | 'like';
+  results: SearchResult[];
+  next_cursor?: string;
+}
+
+export async function searchMessages(params: SearchParams): Promise<SearchResponse> {
+  const query = new URLSearchParams();
+  for (const [key, value] of Object.entries(params)) {
+    if (value !== undefined && value !== '') {
+      query.set(key, String(value));
+    }
+  }
+  const response = await apiFetch(`/api/v2/search?${query}`);
+  if (!response.ok) {
+    throw new Error(`HTTP error! status: ${response.status}`);
+  }
+  return await response.json();
+}
+
+// Статистика логов запросов (/api/v2/stats)
+export interface StatsParams {
+  group_by?: string; // provider,model,day через запятую; '' — только итог
+  provider?: string;
+  model?: string;
+  from?: string; // YYYY-MM-DD или RFC3339
+  to?: string;
+}
+
+export interface StatsRow {
+  provider?: string;
+  model?: string;
+  day?: string;
+  requests: number;
+  errors: number;
+  error_rate: number;
+  latency_p50_ms: number;
+  latency_p95_ms: number;
+  tokens_input: number;
+  tokens_output: number;
+  tokens_total: number;
+  cost: number;
+}
+
+export interface StatsResponse {
+  groups: StatsRow[];
+  total: StatsRow;
+}
+
+export async function fetchStats(params: StatsParams = {}): Promise<StatsResponse> {
+  const query = new URLSearchParams();
+  for (const [key, value] of Object.entries(params)) {
+    if (value !== undefined) {
+      query.set(key, String(value));
+    }
+  }
+  const response = await apiFetch(`/api/v2/stats?${query}`);
+  if (!response.ok) {
+    throw new Error(`HTTP error! status: ${response.status}`);
+  }
+  return await response.json();
+}
+
+// Политика хранения данных
+export interface RetentionPolicy {
+  logs_max_age_days: number;
+  logs_max_rows: number;
+  sessions_max_idle_days: number;
+  interval_min: number;
+}
+
+export interface RetentionReport {
+  started_at: string;
+  duration_ms: number;
+  logs_deleted_by_age: number;
+  logs_deleted_by_count: number;
+  sessions_deleted: number;
+  messages_deleted: number;
+  compacted: boolean;
+  error?: string;
+}
+
+export interface RetentionStatus {
+  enabled: boolean;
+  policy: RetentionPolicy;
+  last_report: RetentionReport | null;
+}
+
+export async function fetchRetention(): Promise<RetentionStatus> {
+  const response = await apiFetch('/api/v2/admin/retention');
+  if (!response.ok) {
+    throw new Error(`HTTP error! status: ${response.status}`);
+  }
+  return await response.json();
+}
+
+export async function runRetention(): Promise<RetentionStatus> {
+  const response = await apiFetch('/api/v2/admin/retention', { method: 'POST' });
+  if (!response.ok) {
+    throw new Error(`HTTP error! status: ${response.status}`);
+  }
+  return await response.json();
+}
+
 // Тестирование токенов
 export async function testTokens(request: TokenTestRequest): Promise<TokenTestResponse> {
-  const response = await fetch('/api/v2/token-test', {
+  const response = await apiFetch('/api/v2/token-test', {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json',
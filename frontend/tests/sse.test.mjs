@@ -0,0 +1,159 @@
+// Разбор потоков /api/v2/chat клиентом на эталонах, которые пишет сервер
+// (backend/api/testdata/sse, проверяются в backend/api/sse_test.go).
+// Запуск: npm test (node --test, TypeScript транслируется пакетом typescript из devDependencies).
+import { test, before } from 'node:test';
+import assert from 'node:assert/strict';
+import { readFileSync, writeFileSync, mkdtempSync } from 'node:fs';
+import { tmpdir } from 'node:os';
+import path from 'node:path';
+import { fileURLToPath, pathToFileURL } from 'node:url';
+import ts from 'typescript';
+
+const here = path.dirname(fileURLToPath(import.meta.url));
+const fixturesDir = path.resolve(here, '../../backend/api/testdata/sse');
+
+function fixture(name) {
+  return readFileSync(path.join(fixturesDir, name), 'utf8');
+}
+
+// api.ts без сборщика: транслируем в ES-модуль во временный каталог
+async function loadApi() {
+  const source = readFileSync(path.resolve(here, '../src/lib/api.ts'), 'utf8');
+  const { outputText } = ts.transpileModule(source, {
+    compilerOptions: { module: ts.ModuleKind.ESNext, target: ts.ScriptTarget.ES2022 },
+  });
+  const file = path.join(mkdtempSync(path.join(tmpdir(), 'api-test-')), 'api.mjs');
+  writeFileSync(file, outputText);
+  return import(pathToFileURL(file).href);
+}
+
+// Ответ сервера, нарезанный на куски по chunkSize байт (куски режут и кадры, и UTF-8 символы)
+function streamResponse(body, chunkSize = 7) {
+  const bytes = new TextEncoder().encode(body);
+  const stream = new ReadableStream({
+    start(controller) {
+      for (let i = 0; i < bytes.length; i += chunkSize) {
+        controller.enqueue(bytes.slice(i, i + chunkSize));
+      }
+      controller.close();
+    },
+  });
+  return new Response(stream, { status: 200, headers: { 'Content-Type': 'text/event-stream' } });
+}
+
+let api;
+let requests;
+
+before(async () => {
+  globalThis.localStorage = { getItem: () => null, setItem() {}, removeItem() {} };
+  api = await loadApi();
+});
+
+function serve(body, chunkSize) {
+  requests = [];
+  globalThis.fetch = async (input, init) => {
+    requests.push({ input, init });
+    return streamResponse(body, chunkSize);
+  };
+}
+
+// Прочитать поток до конца: текст ответа, вызовы обработчиков и ошибка (если была)
+async function collect(body, chunkSize) {
+  serve(body, chunkSize);
+  const calls = { meta: [], usage: [], done: [], events: [] };
+  let text = '';
+  let error = null;
+  try {
+    for await (const chunk of api.sendMessageV2(
+      { message: 'Привет', session_id: 'session_1' },
+      {
+        onMeta: (m) => calls.meta.push(m),
+        onUsage: (u) => calls.usage.push(u),
+        onDone: (d) => calls.done.push(d),
+        onEvent: (e) => calls.events.push(e),
+      }
+    )) {
+      text += chunk;
+    }
+  } catch (e) {
+    error = e;
+  }
+  return { text, error, calls };
+}
+
+test('parseSSEFrame разбирает каждый кадр протокола 2', () => {
+  const frames = fixture('chat_v2.sse').split('\n\n').filter((f) => f !== '').map(api.parseSSEFrame);
+  assert.deepEqual(
+    frames.map((f) => f.event),
+    ['meta', 'context', 'fallback', 'delta', 'delta', 'tool_call', 'tool_calls', 'usage', 'done']
+  );
+  assert.deepEqual(
+    frames.map((f) => f.id),
+    ['1', '2', '3', '4', '5', '6', '7', '8', '9']
+  );
+  assert.deepEqual(JSON.parse(frames[4].data), { content: ', мир!\nКак дела?' });
+});
+
+test('parseSSEFrame: кадр без data пропускается, несколько data склеиваются', () => {
+  assert.equal(api.parseSSEFrame('id: 1\nevent: ping'), null);
+  assert.deepEqual(api.parseSSEFrame('event: delta\ndata: a\ndata: b'), { id: undefined, event: 'delta', data: 'a\nb' });
+  assert.deepEqual(api.parseSSEFrame('data: [DONE]'), { id: undefined, event: 'message', data: '[DONE]' });
+});
+
+for (const chunkSize of [1, 7, 4096]) {
+  test(`sendMessageV2: протокол 2, куски по ${chunkSize} байт`, async () => {
+    const { text, error, calls } = await collect(fixture('chat_v2.sse'), chunkSize);
+    assert.equal(error, null);
+    assert.equal(text, 'Привет, мир!\nКак дела?');
+
+    assert.equal(requests.length, 1);
+    assert.equal(requests[0].input, '/api/v2/chat');
+    assert.equal(requests[0].init.method, 'POST');
+
+    assert.deepEqual(calls.meta, [
+      { protocol: 2, generation_id: 'gen-1', session_id: 'session_1', provider: 'groq', model: 'llama-3.3-70b-versatile' },
+    ]);
+    assert.deepEqual(calls.usage, [{ input_tokens: 120, output_tokens: 9, total_tokens: 129, source: 'provider', cost: 0.0004 }]);
+    assert.deepEqual(calls.done, [
+      { finish_reason: 'stop', provider: 'groq', model: 'llama-3.3-70b-versatile', requested_provider: 'gigachat', duration_ms: 812 },
+    ]);
+
+    // onEvent получает все события с id; последний id — для resumeGeneration
+    assert.deepEqual(
+      calls.events.map((e) => `${e.id}:${e.event}`),
+      ['1:meta', '2:context', '3:fallback', '4:delta', '5:delta', '6:tool_call', '7:tool_calls', '8:usage', '9:done']
+    );
+    assert.deepEqual(calls.events[2].data, { from: 'gigachat', to: 'groq', error: 'connection refused' });
+    assert.equal(calls.events[5].data.arguments_delta, '{"expression":"2+2"}');
+  });
+}
+
+test('sendMessageV2: переводы строк CRLF', async () => {
+  const { text, error, calls } = await collect(fixture('chat_v2.sse').replace(/\n/g, '\r\n'), 5);
+  assert.equal(error, null);
+  assert.equal(text, 'Привет, мир!\nКак дела?');
+  assert.equal(calls.done.length, 1);
+});
+
+test('sendMessageV2: событие error прерывает поток', async () => {
+  const { text, error, calls } = await collect(fixture('chat_v2_error.sse'));
+  assert.equal(text, 'Нача');
+  assert.ok(error instanceof Error);
+  assert.equal(error.message, 'gigachat: status 503');
+  assert.equal(calls.meta.length, 1);
+  assert.equal(calls.done.length, 0);
+});
+
+test('sendMessageV2: старый протокол (без event, [DONE])', async () => {
+  const { text, error, calls } = await collect(fixture('chat_legacy.sse'));
+  assert.equal(error, null);
+  assert.equal(text, 'Привет, мир!\nКак дела?');
+  assert.equal(calls.events.length, 0);
+  assert.equal(calls.done.length, 0);
+});
+
+test('sendMessageV2: ошибка в старом протоколе', async () => {
+  const { text, error } = await collect(fixture('chat_legacy_error.sse'));
+  assert.equal(text, 'Нача');
+  assert.equal(error?.message, 'gigachat: status 503');
+});
@@ -14,7 +14,7 @@ if [ $? -eq 0 ] && [ -n "$HEALTH_RESPONSE" ]; then
     echo "$HEALTH_RESPONSE" | jq '.' 2>/dev/null || echo "$HEALTH_RESPONSE"
 else
     echo "❌ Сервер недоступен на $API_URL"
-    echo "Запустите сервер: cd backend && go run ."
+    echo "Запустите сервер: cd backend && go run -tags sqlite_fts5 ."
     exit 1
 fi
 echo ""
@@ -13,7 +13,7 @@ echo ""
 if ! curl -s "$API_URL/health" > /dev/null 2>&1; then
     echo "❌ API сервер недоступен на $API_URL"
     echo "Убедитесь, что сервер запущен:"
-    echo "  cd backend && go run ."
+    echo "  cd backend && go run -tags sqlite_fts5 ."
     exit 1
 fi
 